@@ -22,6 +22,7 @@ type PluginSetting struct {
 	LatestVersion string              `json:"latestVersion"`
 	HasUpdate     bool                `json:"hasUpdate"`
 	State         plugins.PluginState `json:"state"`
+	Deprecation   string              `json:"deprecation,omitempty"`
 }
 
 type PluginListItem struct {
@@ -36,6 +37,7 @@ type PluginListItem struct {
 	DefaultNavUrl string              `json:"defaultNavUrl"`
 	Category      string              `json:"category"`
 	State         plugins.PluginState `json:"state"`
+	Deprecation   string              `json:"deprecation,omitempty"`
 }
 
 type PluginList []PluginListItem
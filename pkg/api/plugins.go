@@ -53,6 +53,7 @@ func (hs *HTTPServer) GetPluginList(c *m.ReqContext) Response {
 			HasUpdate:     pluginDef.GrafanaNetHasUpdate,
 			DefaultNavUrl: pluginDef.DefaultNavUrl,
 			State:         pluginDef.State,
+			Deprecation:   pluginDef.GrafanaNetDeprecation,
 		}
 
 		if pluginSetting, exists := pluginSettingsMap[pluginDef.Id]; exists {
@@ -104,6 +105,7 @@ func GetPluginSettingByID(c *m.ReqContext) Response {
 		LatestVersion: def.GrafanaNetVersion,
 		HasUpdate:     def.GrafanaNetHasUpdate,
 		State:         def.State,
+		Deprecation:   def.GrafanaNetDeprecation,
 	}
 
 	query := m.GetPluginSettingByIdQuery{PluginId: pluginID, OrgId: c.OrgId}
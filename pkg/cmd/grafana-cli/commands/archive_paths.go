@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var (
+	ErrPathTraversal    = errors.New("archive entry escapes the plugin directory")
+	ErrReservedFilename = errors.New("archive entry uses a reserved Windows filename")
+	ErrPathTooLong      = errors.New("archive entry exceeds the maximum path length")
+	ErrPathCollision    = errors.New("archive entries collide on a case-insensitive filesystem")
+)
+
+// windowsMaxPath is MAX_PATH for applications that are not long path aware.
+const windowsMaxPath = 260
+
+var windowsReservedName = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[1-9]|lpt[1-9])(\..*)?$`)
+
+// ArchivePathError is returned when an archive entry cannot be extracted safely.
+type ArchivePathError struct {
+	Path string
+	Err  error
+}
+
+func (e *ArchivePathError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, e.Path)
+}
+
+func (e *ArchivePathError) Unwrap() error {
+	return e.Err
+}
+
+func validateArchivePaths(files []*zip.File, pluginName, filePath string) error {
+	return validateArchivePathsForOS(files, pluginName, filePath, runtime.GOOS)
+}
+
+// validateArchivePathsForOS checks every entry before anything is written so
+// a bad archive never leaves a half extracted plugin behind.
+func validateArchivePathsForOS(files []*zip.File, pluginName, filePath, goos string) error {
+	v := newArchivePathValidator(pluginName, filePath, goos)
+
+	for _, zf := range files {
+		if err := v.check(zf.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archivePathValidator checks archive entries one at a time, for archives
+// that are extracted while they are read.
+type archivePathValidator struct {
+	pluginName string
+	filePath   string
+	goos       string
+	seen       map[string]string
+}
+
+func newArchivePathValidator(pluginName, filePath, goos string) *archivePathValidator {
+	return &archivePathValidator{pluginName: pluginName, filePath: filePath, goos: goos, seen: map[string]string{}}
+}
+
+func (v *archivePathValidator) check(entry string) error {
+	name := RemoveGitBuildFromName(v.pluginName, entry)
+	cleaned := path.Clean("/" + strings.Replace(name, "\\", "/", -1))
+
+	if !strings.HasPrefix(cleaned, "/"+v.pluginName+"/") && cleaned != "/"+v.pluginName {
+		return &ArchivePathError{Path: entry, Err: ErrPathTraversal}
+	}
+
+	if v.goos == "windows" {
+		for _, segment := range strings.Split(cleaned, "/") {
+			if windowsReservedName.MatchString(strings.TrimRight(segment, ". ")) {
+				return &ArchivePathError{Path: entry, Err: ErrReservedFilename}
+			}
+		}
+
+		if len(filepath.Join(v.filePath, cleaned)) >= windowsMaxPath {
+			return &ArchivePathError{Path: entry, Err: ErrPathTooLong}
+		}
+	}
+
+	if v.goos == "windows" || v.goos == "darwin" {
+		key := strings.ToLower(strings.TrimSuffix(cleaned, "/"))
+		if other, ok := v.seen[key]; ok && other != cleaned {
+			return &ArchivePathError{Path: entry, Err: ErrPathCollision}
+		}
+		v.seen[key] = cleaned
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+const updateHistory = ".update-history.jsonl"
+
+func autoUpdateCommand(c utils.CommandLine) error {
+	pluginsDir := c.PluginDirectory()
+
+	updater, err := s.NewUpdater(c.String("schedule"), func(u s.Update) error {
+		return applyUpdate(u, c)
+	})
+	if err != nil {
+		return err
+	}
+
+	updater.RepoURL = c.RepoDirectory()
+	updater.PluginDir = pluginsDir
+	updater.HistoryFile = filepath.Join(pluginsDir, updateHistory)
+	updater.SafeUpdates = c.Bool("safe")
+
+	for _, value := range c.StringSlice("window") {
+		w, err := s.ParseMaintenanceWindow(value)
+		if err != nil {
+			return err
+		}
+		updater.Windows = append(updater.Windows, w)
+	}
+
+	updater.Pins = map[string]string{}
+	for _, pin := range c.StringSlice("pin") {
+		parts := strings.SplitN(pin, "@", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid pin %q, expected <plugin id>@<version>", pin)
+		}
+		updater.Pins[parts[0]] = parts[1]
+	}
+
+	shutdownTimeout, err := time.ParseDuration(c.String("shutdownTimeout"))
+	if err != nil {
+		return fmt.Errorf("invalid shutdownTimeout: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := stopOnSignal(ctx, cancel, shutdownTimeout)
+
+	if discovery := s.GetRepoDiscovery(); discovery != nil {
+		go discovery.Run(ctx)
+	}
+
+	logger.Infof("checking for plugin updates on schedule %q\n", c.String("schedule"))
+	err = updater.Run(ctx)
+	cancel()
+	<-stopped
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+// applyUpdate installs an update. A failed update keeps the installed
+// version, so the next check finds the update again and retries it.
+func applyUpdate(u s.Update, c utils.CommandLine) error {
+	logger.Infof("Updating %v from %v to %v\n", u.PluginID, u.InstalledVersion, u.Version)
+
+	return reinstallPlugin(c.PluginDirectory(), u.PluginID, func() error {
+		return InstallPlugin(u.PluginID, u.Version, c)
+	})
+}
+
+// stopOnSignal stops the updater on SIGINT or SIGTERM, letting the update in
+// progress finish for up to timeout so rolling restarts do not leave half
+// installed plugins behind. The returned channel is closed once
+// the service shut down, or right after ctx is done without a signal.
+func stopOnSignal(ctx context.Context, cancel context.CancelFunc, timeout time.Duration) <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer signal.Stop(signals)
+
+		select {
+		case sig := <-signals:
+			logger.Infof("received %v, waiting up to %v for plugin updates in progress\n", sig, timeout)
+
+			// stop the updater only once the update in progress finished or
+			// the deadline passed, Shutdown already refuses to start new ones
+			drainCtx, cancelDrain := context.WithTimeout(context.Background(), timeout)
+			defer cancelDrain()
+			if err := s.Shutdown(drainCtx); err != nil {
+				logger.Warnf("cancelled plugin updates still in progress: %v\n", err)
+			}
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return stopped
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/commands/commandstest"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplyUpdate(t *testing.T) {
+	Convey("Failed updates keep the installed version", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.MkdirAll(filepath.Join(dir, "updated-panel"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "updated-panel", "plugin.json"), []byte(`{"id": "updated-panel", "info": {"version": "1.0.0"}}`), 0644), ShouldBeNil)
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": dir}},
+		}
+		err = applyUpdate(s.Update{PluginID: "updated-panel", InstalledVersion: "1.0.0", Version: "1.1.0"}, c)
+		So(err, ShouldNotBeNil)
+
+		installed, err := s.ReadPlugin(dir, "updated-panel")
+		So(err, ShouldBeNil)
+		So(installed.Info.Version, ShouldEqual, "1.0.0")
+	})
+}
@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"golang.org/x/xerrors"
+)
+
+var (
+	ErrBundleEntryMissing    = errors.New("plugin archive missing from bundle")
+	ErrBundledPluginMismatch = errors.New("bundled archive does not contain the plugin the bundle lists")
+)
+
+// bundledArchive is the verified archive of one plugin of a bundle.
+type bundledArchive struct {
+	m.BundledPlugin
+	body   []byte
+	plugin m.InstalledPlugin
+}
+
+// installBundle installs every plugin of a bundle archive, downloading it
+// from url unless body is the archive already. All plugin archives are checked
+// against their digests and their plugin.json before the first one is
+// extracted, so a bad bundle leaves nothing behind, and plugins already
+// installed are restored when installing the bundle fails.
+func installBundle(bundleId, url string, body []byte, bundle []m.BundledPlugin, report *s.VerificationReport, c utils.CommandLine) error {
+	pluginFolder := c.PluginDirectory()
+
+	if body == nil {
+		var err error
+		if _, statErr := os.Stat(url); statErr == nil {
+			body, err = ioutil.ReadFile(url)
+		} else {
+			body, err = s.DownloadArchive(bundleId, url)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.ReportProgress(bundleId, s.StageVerifying)
+	if report.ExpectedChecksum != "" {
+		if err := s.VerifyChecksum(body, report.ExpectedChecksum); err != nil {
+			return err
+		}
+	}
+	report.RecordArchive(url, body)
+
+	archives, err := readBundle(body, bundle)
+	if err != nil {
+		return xerrors.Errorf("%s: %w", bundleId, err)
+	}
+
+	s.ReportProgress(bundleId, s.StageExtracting)
+	// installed versions are moved aside rather than removed, so a failure
+	// restores every plugin of the bundle as it was before
+	var previous []*pluginBackup
+	restore := func(err error) error {
+		steps := make([]func() error, 0, len(previous))
+		for i := len(previous) - 1; i >= 0; i-- {
+			steps = append(steps, previous[i].restore)
+		}
+		return rollback(err, steps...)
+	}
+	for _, archive := range archives {
+		if err := s.ValidatePluginID(archive.Id); err != nil {
+			return restore(err)
+		}
+		backup, err := backupPlugin(pluginFolder, archive.Id)
+		if err != nil {
+			return restore(err)
+		}
+		previous = append(previous, backup)
+
+		logger.Infof("installing %v @ %v from bundle %v\n", archive.Id, archive.plugin.Info.Version, bundleId)
+		if err := extractFiles(archive.body, archive.Id, pluginFolder); err != nil {
+			return restore(fmt.Errorf("failed to extract %s from bundle %s: %v", archive.Id, bundleId, err))
+		}
+	}
+
+	for _, archive := range archives {
+		version := archive.plugin.Info.Version
+		if err := s.StoreArchive(archive.Id, version, archive.body); err != nil {
+			return restore(err)
+		}
+
+		pluginReport := s.NewVerificationReport(archive.Id, version, archive.Sha256)
+		pluginReport.License = report.License
+		pluginReport.RecordArchive(url+"#"+archive.Path, archive.body)
+		if err := finishInstall(archive.Id, pluginReport, c); err != nil {
+			return restore(err)
+		}
+	}
+
+	for _, backup := range previous {
+		if err := backup.discard(); err != nil {
+			logger.Infof("failed to remove %v: %v\n", backup.backup, err)
+		}
+	}
+
+	s.ReportProgress(bundleId, s.StageDone)
+	return nil
+}
+
+// readBundle reads and verifies the plugin archives bundle lists.
+func readBundle(body []byte, bundle []m.BundledPlugin) ([]bundledArchive, error) {
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]*zip.File{}
+	for _, zf := range r.File {
+		entries[path.Clean(zf.Name)] = zf
+	}
+
+	var archives []bundledArchive
+	for _, p := range bundle {
+		zf, ok := entries[path.Clean(p.Path)]
+		if !ok {
+			return nil, xerrors.Errorf("%s: %w", p.Path, ErrBundleEntryMissing)
+		}
+
+		archive := bundledArchive{BundledPlugin: p}
+		if archive.body, err = readZipFile(zf); err != nil {
+			return nil, err
+		}
+		if err := s.VerifyChecksum(archive.body, p.Sha256); err != nil {
+			return nil, xerrors.Errorf("%s: %w", p.Id, err)
+		}
+		if archive.plugin, err = bundledPluginJson(archive.body); err != nil {
+			return nil, xerrors.Errorf("%s: %w", p.Path, err)
+		}
+		if archive.plugin.Id != p.Id {
+			return nil, xerrors.Errorf("%s holds %q instead of %q: %w", p.Path, archive.plugin.Id, p.Id, ErrBundledPluginMismatch)
+		}
+
+		archives = append(archives, archive)
+	}
+
+	return archives, nil
+}
+
+// bundledPluginJson reads the plugin.json of a plugin archive.
+func bundledPluginJson(body []byte) (m.InstalledPlugin, error) {
+	var plugin m.InstalledPlugin
+
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return plugin, err
+	}
+
+	for _, zf := range r.File {
+		if path.Base(zf.Name) != "plugin.json" || path.Dir(path.Dir(zf.Name)) != "." {
+			continue
+		}
+
+		data, err := readZipFile(zf)
+		if err != nil {
+			return plugin, err
+		}
+		err = json.Unmarshal(data, &plugin)
+		return plugin, err
+	}
+
+	return plugin, xerrors.Errorf("no plugin.json: %w", ErrBundledPluginMismatch)
+}
+
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(s.NewExtractLimiter().Reader(zf.Name, rc, int64(zf.CompressedSize64)))
+}
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/commands/commandstest"
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func bundleZip(t *testing.T, archives map[string][]byte) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for name, body := range archives {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipWith(t *testing.T, files map[string]string) []byte {
+	archives := map[string][]byte{}
+	for name, content := range files {
+		archives[name] = []byte(content)
+	}
+	return bundleZip(t, archives)
+}
+
+func TestInstallBundle(t *testing.T) {
+	app := pluginZip(t, `{"id": "suite-app", "type": "app", "info": {"version": "2.0.0"}}`)
+	datasource := pluginZip(t, `{"id": "suite-datasource", "type": "datasource", "info": {"version": "2.0.0"}}`)
+	bundle := []m.BundledPlugin{
+		{Id: "suite-app", Path: "suite-app.zip", Sha256: fmt.Sprintf("%x", sha256.Sum256(app))},
+		{Id: "suite-datasource", Path: "suite-datasource.zip", Sha256: fmt.Sprintf("%x", sha256.Sum256(datasource))},
+	}
+	body := bundleZip(t, map[string][]byte{"suite-app.zip": app, "suite-datasource.zip": datasource})
+
+	Convey("Every plugin of a bundle is verified and installed on its own", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/suite":
+				fmt.Fprintf(w, `{"id": "suite", "versions": [{"version": "2.0.0", "bundle": [
+					{"id": "suite-app", "path": "suite-app.zip", "sha256": "%s"},
+					{"id": "suite-datasource", "path": "suite-datasource.zip", "sha256": "%s"}
+				], "arch": {"any": {"url": "%s/cdn/suite.zip", "sha256": "%x"}}}]}`, bundle[0].Sha256, bundle[1].Sha256, server.URL, sha256.Sum256(body))
+			case "/cdn/suite.zip":
+				w.Write(body)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": dir}},
+		}
+		So(InstallPlugin("suite", "", c), ShouldBeNil)
+
+		for _, id := range []string{"suite-app", "suite-datasource"} {
+			installed, err := s.ReadPlugin(dir, id)
+			So(err, ShouldBeNil)
+			So(installed.Info.Version, ShouldEqual, "2.0.0")
+		}
+		_, err = os.Stat(filepath.Join(dir, "suite"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Plugins already installed are kept when a bundle fails to extract", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		broken := zipWith(t, map[string]string{
+			"suite-datasource/plugin.json":      `{"id": "suite-datasource", "type": "datasource", "info": {"version": "2.0.0"}}`,
+			"suite-datasource/../../escape.txt": "outside",
+		})
+		brokenBundle := []m.BundledPlugin{bundle[0], {Id: "suite-datasource", Path: "suite-datasource.zip", Sha256: fmt.Sprintf("%x", sha256.Sum256(broken))}}
+		body := bundleZip(t, map[string][]byte{"suite-app.zip": app, "suite-datasource.zip": broken})
+
+		root, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		dir := filepath.Join(root, "plugins")
+		for _, id := range []string{"suite-app", "suite-datasource"} {
+			So(os.MkdirAll(filepath.Join(dir, id), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, id, "plugin.json"), []byte(`{"id": "`+id+`", "info": {"version": "1.0.0"}}`), 0644), ShouldBeNil)
+		}
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"pluginsDir": dir}},
+		}
+		report := s.NewVerificationReport("suite", "2.0.0", "")
+		So(installBundle("suite", "suite.zip", body, brokenBundle, report, c), ShouldNotBeNil)
+
+		for _, id := range []string{"suite-app", "suite-datasource"} {
+			installed, err := s.ReadPlugin(dir, id)
+			So(err, ShouldBeNil)
+			So(installed.Info.Version, ShouldEqual, "1.0.0")
+		}
+		_, err = os.Stat(filepath.Join(root, "escape.txt"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Bundles are refused as a whole when one plugin does not match", t, func() {
+		_, err := readBundle(body, bundle)
+		So(err, ShouldBeNil)
+
+		tampered := append([]m.BundledPlugin{}, bundle...)
+		tampered[1].Sha256 = bundle[0].Sha256
+		_, err = readBundle(body, tampered)
+		So(xerrors.Is(err, s.ErrChecksumMismatch), ShouldBeTrue)
+
+		renamed := append([]m.BundledPlugin{}, bundle...)
+		renamed[0].Id = "other-app"
+		_, err = readBundle(body, renamed)
+		So(xerrors.Is(err, ErrBundledPluginMismatch), ShouldBeTrue)
+
+		missing := append([]m.BundledPlugin{}, bundle...)
+		missing[1].Path = "suite-panel.zip"
+		_, err = readBundle(body, missing)
+		So(xerrors.Is(err, ErrBundleEntryMissing), ShouldBeTrue)
+	})
+}
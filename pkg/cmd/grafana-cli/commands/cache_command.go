@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+// cacheStatsCommand prints the statistics of the caches, the archive store
+// being the one outliving a single grafana-cli run.
+func cacheStatsCommand(c utils.CommandLine) error {
+	stats, err := s.CacheStats()
+	if err != nil {
+		return err
+	}
+
+	scopes := make([]string, 0, len(stats))
+	for scope := range stats {
+		scopes = append(scopes, string(scope))
+	}
+	sort.Strings(scopes)
+
+	for _, scope := range scopes {
+		st := stats[s.CacheScope(scope)]
+		oldest := "none"
+		if !st.Oldest.IsZero() {
+			oldest = st.Oldest.Local().Format(time.RFC3339)
+		}
+		logger.Infof("%s entries=%d bytes=%d hits=%d misses=%d oldest=%s\n", scope, st.Entries, st.Bytes, st.Hits, st.Misses, oldest)
+	}
+	return nil
+}
+
+func cachePurgeCommand(c utils.CommandLine) error {
+	if c.Args().First() == "" {
+		return errors.New("missing cache scope, one of metadata, archive, proxy, archive-store or all")
+	}
+
+	scope, err := s.ParseCacheScope(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	purged, err := s.PurgeCache(scope)
+	logger.Infof("purged %d cache entries\n", purged)
+	return err
+}
@@ -68,12 +68,56 @@ func runPluginCommand(command func(commandLine utils.CommandLine) error) func(co
 var pluginCommands = []cli.Command{
 	{
 		Name:   "install",
-		Usage:  "install <plugin id> <plugin version (optional)>",
+		Usage:  "install <plugin id> <plugin version (optional)>, or install <path to plugin zip>",
 		Action: runPluginCommand(installCommand),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "allowYanked",
+				Usage: "allow installing a version that has been yanked from the repository",
+			},
+			cli.StringFlag{
+				Name:  "asOf",
+				Usage: "install the newest version published before this date (2006-01-02) or RFC3339 timestamp",
+			},
+			cli.StringFlag{
+				Name:  "extras",
+				Usage: "comma separated optional components to install alongside the plugin, * for all",
+			},
+			cli.StringFlag{
+				Name:  "compatibleWith",
+				Usage: "comma separated Grafana versions the installed version has to support, e.g. 6.3.0,6.4.2",
+			},
+			cli.StringFlag{
+				Name:  "build",
+				Usage: "install the version built from this commit sha instead of a version number",
+			},
+			cli.BoolFlag{
+				Name:  "explain",
+				Usage: "print why the installed version was chosen over the others",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "ignore cached metadata, stored archives and the installed copy, fetching everything again",
+			},
+		},
 	}, {
 		Name:   "list-remote",
 		Usage:  "list remote available plugins",
 		Action: runPluginCommand(listremoteCommand),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "sort",
+				Usage: "sort by id, downloads, popularity, rating or updated",
+			},
+			cli.BoolFlag{
+				Name:  "signed",
+				Usage: "only list signed plugins",
+			},
+			cli.IntFlag{
+				Name:  "minDownloads",
+				Usage: "only list plugins downloaded at least this many times",
+			},
+		},
 	}, {
 		Name:   "list-versions",
 		Usage:  "list-versions <plugin id>",
@@ -88,10 +132,99 @@ var pluginCommands = []cli.Command{
 		Aliases: []string{"upgrade-all"},
 		Usage:   "update all your installed plugins",
 		Action:  runPluginCommand(upgradeAllCommand),
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "parallel",
+				Usage: "number of archives to download and verify concurrently",
+				Value: 4,
+			},
+		},
+	}, {
+		Name:   "outdated",
+		Usage:  "list installed plugins with a newer version",
+		Action: runPluginCommand(outdatedCommand),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "statusFile",
+				Usage: "read the updates found by the update checker of a running Grafana from this file",
+			},
+		},
+	}, {
+		Name:   "state",
+		Usage:  "state [plugin id], shows when plugins were last checked for updates and installed, and the last error",
+		Action: runPluginCommand(stateCommand),
+	}, {
+		Name:   "downgrade",
+		Usage:  "downgrade <plugin id> [version constraint], installs the newest version older than the installed one",
+		Action: runPluginCommand(downgradeCommand),
+	}, {
+		Name:   "replace",
+		Usage:  "replace <plugin id>, installs the successor of a deprecated plugin and removes it",
+		Action: runPluginCommand(replaceCommand),
+	}, {
+		Name:   "auto-update",
+		Usage:  "keep installed plugins updated, applying updates inside maintenance windows",
+		Action: runPluginCommand(autoUpdateCommand),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "schedule",
+				Usage: "cron schedule to check for updates on",
+				Value: "0 3 * * *",
+			},
+			cli.StringSliceFlag{
+				Name:  "window",
+				Usage: "maintenance window to apply updates in, e.g. \"Sat,Sun 02:00-05:00\", can be repeated",
+			},
+			cli.StringSliceFlag{
+				Name:  "pin",
+				Usage: "keep a plugin at a version, e.g. grafana-clock-panel@1.0.1, can be repeated",
+			},
+			cli.BoolFlag{
+				Name:  "safe",
+				Usage: "only apply patch releases of the installed major.minor versions, minor and major updates are left to be installed manually",
+			},
+			cli.StringFlag{
+				Name:  "shutdownTimeout",
+				Usage: "how long to wait for plugin downloads in flight when stopped with SIGINT or SIGTERM",
+				Value: "30s",
+			},
+		},
 	}, {
 		Name:   "ls",
 		Usage:  "list all installed plugins",
 		Action: runPluginCommand(lsCommand),
+	}, {
+		Name:   "verify",
+		Usage:  "verify installed plugins against the manifests published by the repository",
+		Action: runPluginCommand(verifyCommand),
+	}, {
+		Name:   "check-config",
+		Usage:  "validate the plugin repository settings without contacting the repository",
+		Action: runPluginCommand(configCheckCommand),
+	}, {
+		Name:   "prune-archives",
+		Usage:  "remove archives from the archive store according to archiveMaxAge and archiveKeepVersions",
+		Action: runPluginCommand(pruneArchivesCommand),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dryRun",
+				Usage: "only report what would be removed",
+			},
+		},
+	}, {
+		Name:  "cache",
+		Usage: "inspect and purge the plugin caches and the archive store",
+		Subcommands: []cli.Command{
+			{
+				Name:   "stats",
+				Usage:  "print entries, sizes and lookups per cache scope",
+				Action: runPluginCommand(cacheStatsCommand),
+			}, {
+				Name:   "purge",
+				Usage:  "purge <metadata|archive|proxy|archive-store|all>",
+				Action: runPluginCommand(cachePurgeCommand),
+			},
+		},
 	}, {
 		Name:    "uninstall",
 		Aliases: []string{"remove"},
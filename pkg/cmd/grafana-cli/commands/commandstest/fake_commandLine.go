@@ -65,6 +65,20 @@ func (fcli *FakeCommandLine) GlobalString(key string) string {
 	return fcli.GlobalFlags.String(key)
 }
 
+func (fcli *FakeCommandLine) GlobalBool(key string) bool {
+	if fcli.GlobalFlags == nil {
+		return false
+	}
+	return fcli.GlobalFlags.Bool(key)
+}
+
+func (fcli *FakeCommandLine) GlobalInt(key string) int {
+	if fcli.GlobalFlags == nil {
+		return 0
+	}
+	return fcli.GlobalFlags.Int(key)
+}
+
 func (fcli *FakeCommandLine) Generic(name string) interface{} {
 	return fcli.LocalFlags.Data[name]
 }
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+func configCheckCommand(c utils.CommandLine) error {
+	cfg := s.RepositoryConfig{
+		RepoURL:            c.GlobalString("repo"),
+		Mirrors:            splitList(c.GlobalString("repoMirrors")),
+		Fixtures:           c.GlobalString("repoFixtures"),
+		Token:              c.GlobalString("repoToken"),
+		InsecureSkipVerify: c.GlobalBool("insecure"),
+		TLSMinVersion:      c.GlobalString("repoTLSMinVersion"),
+		TLSCipherSuites:    splitList(c.GlobalString("repoTLSCipherSuites")),
+		ArchiveStore:       c.GlobalString("archiveStore"),
+		PluginDir:          c.PluginDirectory(),
+		QuotaLimit:         int64(c.GlobalInt("pluginsDirQuota")) * 1024 * 1024,
+		QuotaPolicy:        s.QuotaPolicy(c.GlobalString("pluginsDirQuotaPolicy")),
+		TrustedPublishers:  splitList(c.GlobalString("trustedPublishers")),
+		AllowUnverified:    c.GlobalBool("allowUnverified"),
+		ChecksumPinned:     c.GlobalString("pluginChecksum") != "",
+	}
+	errs := 0
+	for _, p := range s.ValidateConfig(cfg) {
+		if p.Severity == s.SeverityError {
+			errs++
+			logger.Infof("%s %s: %s\n", color.RedString("✗"), p.Field, p.Message)
+		} else {
+			logger.Infof("%s %s: %s\n", color.YellowString("!"), p.Field, p.Message)
+		}
+	}
+
+	if errs > 0 {
+		return fmt.Errorf("found %d problems in the repository configuration", errs)
+	}
+
+	logger.Infof("%s repository configuration is valid\n", color.GreenString("✔"))
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+func downgradeCommand(c utils.CommandLine) error {
+	pluginsDir := c.PluginDirectory()
+	pluginName := c.Args().First()
+	if pluginName == "" {
+		return errors.New("please specify plugin to downgrade")
+	}
+
+	localPlugin, err := s.ReadPlugin(pluginsDir, pluginName)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.ResolveDowngrade(context.Background(), c.RepoDirectory(), pluginName, localPlugin.Info.Version, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("downgrading %v from %v to %v\n", pluginName, localPlugin.Info.Version, res.Version.Version)
+	// a failed downgrade keeps the installed version
+	return reinstallPlugin(pluginsDir, pluginName, func() error {
+		return InstallPlugin(pluginName, res.Version.Version, c)
+	})
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/commands/commandstest"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDowngradeCommand(t *testing.T) {
+	Convey("Failed downgrades keep the installed version", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/repo/rollback-panel" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"id": "rollback-panel", "versions": [
+				{"version": "2.0.0"},
+				{"version": "1.0.0", "arch": {"any": {"url": "%s/missing.zip", "sha256": "%064d"}}}]}`, server.URL, 0)
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.MkdirAll(filepath.Join(dir, "rollback-panel"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "rollback-panel", "plugin.json"), []byte(`{"id": "rollback-panel", "info": {"version": "2.0.0"}}`), 0644), ShouldBeNil)
+
+		c := &commandstest.FakeCommandLine{
+			CliArgs:     []string{"rollback-panel"},
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": dir}},
+		}
+		So(downgradeCommand(c), ShouldNotBeNil)
+
+		installed, err := s.ReadPlugin(dir, "rollback-panel")
+		So(err, ShouldBeNil)
+		So(installed.Info.Version, ShouldEqual, "2.0.0")
+	})
+}
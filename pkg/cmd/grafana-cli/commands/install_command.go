@@ -3,18 +3,21 @@ package commands
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"golang.org/x/xerrors"
 
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
 	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
@@ -56,67 +59,378 @@ func installCommand(c utils.CommandLine) error {
 	pluginToInstall := c.Args().First()
 	version := c.Args().Get(1)
 
+	if isLocalArchive(pluginToInstall) && c.PluginURL() == "" {
+		return InstallFromFile(context.Background(), pluginToInstall, fileInstallOptions(c))
+	}
+
 	return InstallPlugin(pluginToInstall, version, c)
 }
 
 // InstallPlugin downloads the plugin code as a zip file from the Grafana.com API
 // and then extracts the zip into the plugins directory.
 func InstallPlugin(pluginName, version string, c utils.CommandLine) error {
-	pluginFolder := c.PluginDirectory()
+	err := installPlugin(pluginName, version, c)
+	if err != nil {
+		s.ReportProgressError(pluginName, err)
+	}
+	recordInstall(pluginName, c.PluginDirectory(), err)
+	return err
+}
+
+// recordInstall records the install in the plugin state, with the version
+// that got installed.
+func recordInstall(pluginName, pluginDir string, err error) {
+	store := s.NewPluginStateStore()
+	if store.Path == "" {
+		return
+	}
+	_, pluginId := s.SplitPluginRef(pluginName)
+
+	var version string
+	if err == nil {
+		if plugin, readErr := s.ReadPlugin(pluginDir, pluginId); readErr == nil {
+			version = plugin.Info.Version
+		}
+	}
+	if saveErr := store.RecordInstall(pluginId, version, err); saveErr != nil {
+		logger.Errorf("failed to save plugin state: %v\n", saveErr)
+	}
+}
+
+func installPlugin(pluginName, version string, c utils.CommandLine) error {
+	s.ReportProgress(pluginName, s.StageResolving)
+	// plugins from a namespaced repository are installed under their plain id
+	namespace, pluginName := s.SplitPluginRef(pluginName)
+
 	downloadURL := c.PluginURL()
-	if downloadURL == "" {
-		plugin, err := s.GetPlugin(pluginName, c.RepoDirectory())
-		if err != nil {
+	force := c.Bool("force")
+
+	if downloadURL == "" && version != "" && !force {
+		if pinned := c.GlobalString("pluginChecksum"); pinned != "" {
+			checksum, err := s.ParseChecksum(pinned)
+			if err != nil {
+				return err
+			}
+			if body, ok := s.CachedArchive(pluginName, version, checksum); ok {
+				// the repository is not asked to resolve the version, but
+				// still to publish whom the plugin is from
+				if err := s.CheckPublishedPolicies(context.Background(), pluginName, c.RepoDirectory()); err != nil {
+					return err
+				}
+				return installCachedArchive(pluginName, version, checksum, body, nil, c)
+			}
+		}
+	}
+
+	if downloadURL != "" {
+		if err := s.CheckPublishedPolicies(context.Background(), pluginName, c.RepoDirectory()); err != nil {
 			return err
 		}
+		target := pluginInstall{pluginName: pluginName, version: version, downloadURL: downloadURL}
+		if pinned := c.GlobalString("pluginChecksum"); pinned != "" {
+			var err error
+			if target.checksum, err = s.ParseChecksum(pinned); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			target.checksum, err = s.GetChecksum(pluginName, m.Version{}, downloadURL)
+			if err != nil && err != s.ErrChecksumNotFound {
+				return err
+			}
+		}
+		return target.install(c)
+	}
 
-		v, err := SelectVersion(plugin, version)
+	req := s.PluginRequest{PluginID: pluginName, Namespace: namespace, Version: version, AllowYanked: c.Bool("allowYanked"), Force: force, Build: c.String("build")}
+	req.GrafanaVersions = splitList(c.String("compatibleWith"))
+	req.Digest = c.GlobalString("pluginChecksum")
+	if names := c.String("extras"); names != "" {
+		req.Extras = strings.Split(names, ",")
+	}
+	if asOf := c.String("asOf"); asOf != "" {
+		t, err := parseAsOf(asOf)
 		if err != nil {
 			return err
 		}
+		req.AsOf = t
+	}
 
-		if version == "" {
-			version = v.Version
+	res, err := s.Resolve(c.RepoDirectory(), req)
+	if err != nil {
+		suggestAlternatives(pluginName, err)
+		return err
+	}
+
+	if res.Stale {
+		logger.Warnf("%s the plugin repository is unreachable, using the last known metadata of %v fetched %v ago\n", color.YellowString("!"), pluginName, res.MetadataAge().Round(time.Second))
+	} else if res.FromCache && res.MetadataAge() > 0 {
+		logger.Infof("using plugin metadata fetched %v ago\n", res.MetadataAge().Round(time.Second))
+	}
+
+	if res.NewestWithoutArch != "" {
+		logger.Warnf("%s %v %v has no build for this platform, installing %v instead\n", color.YellowString("!"), pluginName, res.NewestWithoutArch, res.Version.Version)
+	}
+
+	if c.Bool("explain") {
+		logger.Infof("versions of %v considered:\n%s\n", pluginName, res.Explain())
+	}
+
+	if notice := s.DeprecationNotice(res.Plugin); notice != "" {
+		logger.Warnf("%s %s\n", color.YellowString("!"), notice)
+	}
+
+	return resolvedInstall(req, res, c).install(c)
+}
+
+// pluginInstall is a plugin version to install, resolved from the repository
+// or given by url.
+type pluginInstall struct {
+	pluginName  string
+	version     string
+	downloadURL string
+	checksum    string
+	mirrorURLs  []string
+	extras      []s.ResolvedExtra
+	license     string
+	bundle      []m.BundledPlugin
+	// resolved is the request of resolved plugins, to resolve expired urls again
+	resolved *s.PluginRequest
+	// body is the archive when it was downloaded and verified ahead
+	body []byte
+}
+
+// resolvedInstall returns the install of a resolution of req.
+func resolvedInstall(req s.PluginRequest, res s.Resolution, c utils.CommandLine) pluginInstall {
+	target := pluginInstall{
+		pluginName:  req.PluginID,
+		version:     res.Version.Version,
+		downloadURL: res.URL,
+		checksum:    res.Checksum,
+		extras:      res.Extras,
+		license:     res.Plugin.License,
+		bundle:      res.Version.Bundle,
+		resolved:    &req,
+	}
+	if req.Namespace == "" {
+		repos := append([]string{c.RepoDirectory()}, s.FailoverRepos()...)
+		for _, mirror := range s.OrderByHealth(repos) {
+			if mirror != res.RepoURL {
+				target.mirrorURLs = append(target.mirrorURLs, s.DownloadURL(mirror, req.PluginID, target.version))
+			}
 		}
-		downloadURL = fmt.Sprintf("%s/%s/versions/%s/download",
-			c.GlobalString("repo"),
-			pluginName,
-			version)
 	}
+	return target
+}
 
-	logger.Infof("installing %v @ %v\n", pluginName, version)
-	logger.Infof("from: %v\n", downloadURL)
+func (p pluginInstall) install(c utils.CommandLine) error {
+	pluginName, pluginFolder := p.pluginName, c.PluginDirectory()
+	force := c.Bool("force")
+
+	logger.Infof("installing %v @ %v\n", pluginName, p.version)
+	logger.Infof("from: %v\n", p.downloadURL)
 	logger.Infof("into: %v\n", pluginFolder)
 	logger.Info("\n")
 
-	err := downloadFile(pluginName, pluginFolder, downloadURL)
+	if p.checksum == "" {
+		if !c.GlobalBool("allowUnverified") {
+			return fmt.Errorf("%v for %s. Use --allowUnverified to install it without verification", s.ErrChecksumNotFound, pluginName)
+		}
+		logger.Infof("%s No checksum found for %s, installing unverified\n", color.YellowString("!"), pluginName)
+	}
+
+	report := s.NewVerificationReport(pluginName, p.version, p.checksum)
+	report.License = p.license
+
+	// the plugins of a bundle are installed, and stored, one by one
+	if len(p.bundle) > 0 {
+		return installBundle(pluginName, p.downloadURL, p.body, p.bundle, report, c)
+	}
+
+	if force {
+		s.InvalidateArchive(p.downloadURL)
+		for _, mirror := range p.mirrorURLs {
+			s.InvalidateArchive(mirror)
+		}
+		// the installed copy is only replaced once the fresh one is verified and extracted
+		return reinstallPlugin(pluginFolder, pluginName, func() error {
+			return p.extract(report, c)
+		})
+	}
+
+	if p.body == nil {
+		if body, ok := s.CachedArchive(pluginName, p.version, p.checksum); ok {
+			return installCachedArchive(pluginName, p.version, p.checksum, body, p.extras, c)
+		}
+	}
+	return p.extract(report, c)
+}
+
+// extract installs the archive, downloading it unless it was fetched ahead,
+// and the selected extras.
+func (p pluginInstall) extract(report *s.VerificationReport, c utils.CommandLine) error {
+	pluginFolder := c.PluginDirectory()
+
+	var err error
+	if p.body != nil {
+		err = installArchive(p.pluginName, pluginFolder, p.downloadURL, p.body, report)
+	} else {
+		err = p.download(pluginFolder, report, c)
+	}
 	if err != nil {
 		return err
 	}
 
-	logger.Infof("%s Installed %s successfully \n", color.GreenString("✔"), pluginName)
+	if err := installExtras(p.pluginName, pluginFolder, p.extras, c); err != nil {
+		return err
+	}
 
-	res, _ := s.ReadPlugin(pluginFolder, pluginName)
-	for _, v := range res.Dependencies.Plugins {
-		InstallPlugin(v.Id, "", c)
-		logger.Infof("Installed dependency: %v ✔\n", v.Id)
+	return finishInstall(p.pluginName, report, c)
+}
+
+// download downloads, verifies and extracts the archive, from the mirrors in
+// turn when the one downloaded does not match the checksum.
+func (p pluginInstall) download(pluginFolder string, report *s.VerificationReport, c utils.CommandLine) error {
+	downloadURL := p.downloadURL
+	err := downloadFile(p.pluginName, pluginFolder, downloadURL, p.checksum, report)
+	if s.IsURLExpired(err) && p.resolved != nil {
+		logger.Infof("%s Download of %s was refused, resolving a fresh url\n", color.YellowString("!"), downloadURL)
+		downloadURL, err = downloadRefreshed(*p.resolved, p.version, downloadURL, pluginFolder, p.checksum, report, c)
+	}
+	if !xerrors.Is(err, s.ErrChecksumMismatch) || len(p.mirrorURLs) == 0 {
+		return err
 	}
 
-	return err
+	// mirrors have served truncated archives before, give the others a chance
+	mismatch := &s.ChecksumMismatchError{Failures: []s.DownloadFailure{{URL: downloadURL, Err: err}}}
+	for _, mirror := range p.mirrorURLs {
+		logger.Infof("%s Checksum mismatch for %s, retrying from %s\n", color.YellowString("!"), downloadURL, mirror)
+
+		if err := downloadFile(p.pluginName, pluginFolder, mirror, p.checksum, report); err != nil {
+			mismatch.Failures = append(mismatch.Failures, s.DownloadFailure{URL: mirror, Err: err})
+			downloadURL = mirror
+			continue
+		}
+		return nil
+	}
+	return mismatch
 }
 
-func SelectVersion(plugin m.Plugin, version string) (m.Version, error) {
-	if version == "" {
-		return plugin.Versions[0], nil
+// downloadRefreshed resolves the version again, skipping cached metadata, so
+// a pre-signed url that expired since resolution is replaced by a fresh one.
+// The request is pinned to the version resolved first, which checksum is of,
+// even when latest or a build was requested.
+func downloadRefreshed(req s.PluginRequest, version, url, pluginFolder, checksum string, report *s.VerificationReport, c utils.CommandLine) (string, error) {
+	req.Force = true
+	req.Version, req.Build = version, ""
+
+	res, err := s.Resolve(c.RepoDirectory(), req)
+	if err != nil {
+		return url, err
+	}
+	if res.Version.Version != version {
+		return url, fmt.Errorf("resolved %v@%v again as %v", req.PluginID, version, res.Version.Version)
+	}
+
+	return res.URL, downloadFile(req.PluginID, pluginFolder, res.URL, checksum, report)
+}
+
+// installExtras installs the selected extras into the installed plugin.
+func installExtras(pluginName, pluginFolder string, extras []s.ResolvedExtra, c utils.CommandLine) error {
+	for _, extra := range extras {
+		if err := installExtra(pluginName, pluginFolder, extra, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func installExtra(pluginName, pluginFolder string, extra s.ResolvedExtra, c utils.CommandLine) error {
+	logger.Infof("installing extra %v of %v\n", extra.Name, pluginName)
+
+	if extra.Checksum == "" && !c.GlobalBool("allowUnverified") {
+		return fmt.Errorf("%v for extra %s of %s. Use --allowUnverified to install it without verification", s.ErrChecksumNotFound, extra.Name, pluginName)
+	}
+
+	body, err := s.DownloadArchive(pluginName, extra.URL)
+	if err != nil {
+		return fmt.Errorf("failed to download extra %s: %v", extra.Name, err)
 	}
 
-	for _, v := range plugin.Versions {
-		if v.Version == version {
-			return v, nil
+	if extra.Checksum != "" {
+		if err := s.VerifyChecksum(body, extra.Checksum); err != nil {
+			return xerrors.Errorf("extra %s: %w", extra.Name, err)
 		}
 	}
 
-	return m.Version{}, errors.New("Could not find the version you're looking for")
+	// extras add files to the installed plugin rather than replacing it
+	return extractArchive(body, pluginName, pluginFolder, mergeDir)
+}
+
+// installCachedArchive installs a verified archive from the archive store,
+// and the selected extras, without downloading the archive again.
+func installCachedArchive(pluginName, version, checksum string, body []byte, extras []s.ResolvedExtra, c utils.CommandLine) error {
+	logger.Infof("installing %v @ %v from archive store\n", pluginName, version)
+	s.ReportProgress(pluginName, s.StageExtracting)
+
+	if err := extractFiles(body, pluginName, c.PluginDirectory()); err != nil {
+		return err
+	}
+	if err := installExtras(pluginName, c.PluginDirectory(), extras, c); err != nil {
+		return err
+	}
+
+	report := s.NewVerificationReport(pluginName, version, checksum)
+	report.RecordArchive(c.GlobalString("archiveStore"), body)
+	report.FromCache = true
+
+	return finishInstall(pluginName, report, c)
+}
+
+// installPrefetchedArchive installs an archive the download pipeline has
+// already verified, resolved as res, the same way InstallPlugin would.
+func installPrefetchedArchive(archive s.VerifiedArchive, req s.PluginRequest, res s.Resolution, c utils.CommandLine) error {
+	target := resolvedInstall(req, res, c)
+	target.body = archive.Body
+
+	s.ReportProgress(archive.PluginID, s.StageExtracting)
+	err := target.install(c)
+	if err != nil {
+		s.ReportProgressError(archive.PluginID, err)
+	}
+	return err
+}
+
+func finishInstall(pluginName string, report *s.VerificationReport, c utils.CommandLine) error {
+	if sink := reportSink(c); sink != nil {
+		if err := sink.WriteReport(report); err != nil {
+			return fmt.Errorf("failed to write verification report: %v", err)
+		}
+	}
+	if dir := c.GlobalString("sbom"); dir != "" {
+		format, err := s.ParseSBOMFormat(c.GlobalString("sbomFormat"))
+		if err != nil {
+			return err
+		}
+		if err := (s.SBOMSink{Dir: dir, Format: format}).WriteComponent(s.NewSBOMComponent(report)); err != nil {
+			return fmt.Errorf("failed to write SBOM: %v", err)
+		}
+	}
+
+	if err := s.RunInstallHooks(c.PluginDirectory(), pluginName); err != nil {
+		return err
+	}
+
+	logger.Infof("%s Installed %s successfully \n", color.GreenString("✔"), pluginName)
+	s.ReportProgress(pluginName, s.StageDone)
+
+	res, _ := s.ReadPlugin(c.PluginDirectory(), pluginName)
+	for _, v := range res.Dependencies.Plugins {
+		InstallPlugin(v.Id, "", c)
+		logger.Infof("Installed dependency: %v ✔\n", v.Id)
+	}
+
+	return nil
 }
 
 func RemoveGitBuildFromName(pluginName, filename string) string {
@@ -127,13 +441,39 @@ func RemoveGitBuildFromName(pluginName, filename string) string {
 var retryCount = 0
 var permissionsDeniedMessage = "Could not create %s. Permission denied. Make sure you have write access to plugindir"
 
-func downloadFile(pluginName, filePath, url string) (err error) {
+// parseAsOf accepts RFC3339 timestamps or plain dates, which are read as
+// the end of that day in UTC.
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid asOf %q, expected a date (2006-01-02) or RFC3339 timestamp", value)
+	}
+
+	return t.AddDate(0, 0, 1), nil
+}
+
+func reportSink(c utils.CommandLine) s.ReportSink {
+	switch target := c.GlobalString("verificationReport"); target {
+	case "":
+		return nil
+	case "plugin":
+		return s.PluginDirReportSink{PluginDir: c.PluginDirectory()}
+	default:
+		return s.DirReportSink{Dir: target}
+	}
+}
+
+func downloadFile(pluginName, filePath, url, checksum string, report *s.VerificationReport) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			retryCount++
 			if retryCount < 3 {
 				fmt.Println("Failed downloading. Will retry once.")
-				err = downloadFile(pluginName, filePath, url)
+				err = downloadFile(pluginName, filePath, url, checksum, report)
 			} else {
 				failure := fmt.Sprintf("%v", r)
 				if failure == "runtime error: makeslice: len out of range" {
@@ -145,6 +485,10 @@ func downloadFile(pluginName, filePath, url string) (err error) {
 		}
 	}()
 
+	if isTarArchive(url) {
+		return downloadTarStream(pluginName, filePath, url, checksum, report)
+	}
+
 	var bytes []byte
 
 	if _, err := os.Stat(url); err == nil {
@@ -153,28 +497,77 @@ func downloadFile(pluginName, filePath, url string) (err error) {
 			return err
 		}
 	} else {
-		resp, err := http.Get(url) // #nosec
+		bytes, err = s.DownloadArchive(pluginName, url)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
+	}
 
-		bytes, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
+	s.ReportProgress(pluginName, s.StageVerifying)
+	if checksum != "" {
+		if err := s.VerifyChecksum(bytes, checksum); err != nil {
 			return err
 		}
 	}
 
-	return extractFiles(bytes, pluginName, filePath)
+	return installArchive(pluginName, filePath, url, bytes, report)
+}
+
+// installArchive extracts a verified archive and adds it to the archive store.
+func installArchive(pluginName, filePath, url string, body []byte, report *s.VerificationReport) error {
+	s.ReportProgress(pluginName, s.StageExtracting)
+	if err := extractFiles(body, pluginName, filePath); err != nil {
+		return err
+	}
+
+	report.RecordArchive(url, body)
+	return s.StoreArchive(pluginName, report.Version, body)
 }
 
 func extractFiles(body []byte, pluginName string, filePath string) error {
+	return extractArchive(body, pluginName, filePath, replaceDir)
+}
+
+// extractArchive extracts body aside and hands the extracted plugin directory
+// to place to move it to its target in filePath.
+func extractArchive(body []byte, pluginName string, filePath string, place func(extracted, target string) error) error {
 	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
 	if err != nil {
 		return err
 	}
+
+	if err := validateArchivePaths(r.File, pluginName, filePath); err != nil {
+		return err
+	}
+
+	limiter := s.NewExtractLimiter()
+	var size int64
+	for _, zf := range r.File {
+		if err := limiter.Declare(zf.Name, int64(zf.CompressedSize64), int64(zf.UncompressedSize64)); err != nil {
+			return err
+		}
+		size += int64(zf.UncompressedSize64)
+	}
+	if err := s.CheckQuota(filePath, pluginName, size); err != nil {
+		return err
+	}
+
+	// the archive is extracted aside and swapped in once complete, so a
+	// failed extraction leaves an installed copy as it was
+	staging := path.Join(filePath, "."+pluginName+".staging")
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.Mkdir(staging, 0755); err != nil {
+		if permissionsError(err) {
+			return fmt.Errorf(permissionsDeniedMessage, staging)
+		}
+		return err
+	}
+	defer os.RemoveAll(staging)
+
 	for _, zf := range r.File {
-		newFile := path.Join(filePath, RemoveGitBuildFromName(pluginName, zf.Name))
+		newFile := path.Join(staging, RemoveGitBuildFromName(pluginName, zf.Name))
 
 		if zf.FileInfo().IsDir() {
 			err := os.Mkdir(newFile, 0755)
@@ -188,6 +581,11 @@ func extractFiles(body []byte, pluginName string, filePath string) error {
 				fileMode = os.FileMode(0755)
 			}
 
+			// archives do not necessarily list the directories of their files
+			if err := os.MkdirAll(path.Dir(newFile), 0755); permissionsError(err) {
+				return fmt.Errorf(permissionsDeniedMessage, newFile)
+			}
+
 			dst, err := os.OpenFile(newFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode)
 			if permissionsError(err) {
 				return fmt.Errorf(permissionsDeniedMessage, newFile)
@@ -198,15 +596,63 @@ func extractFiles(body []byte, pluginName string, filePath string) error {
 				logger.Errorf("Failed to extract file: %v", err)
 			}
 
-			io.Copy(dst, src)
+			_, err = io.Copy(dst, limiter.Reader(zf.Name, src, int64(zf.CompressedSize64)))
 			dst.Close()
 			src.Close()
+			if xerrors.As(err, new(*s.ExtractLimitError)) {
+				// the headers understated the entry
+				return err
+			}
 		}
 	}
 
-	return nil
+	extracted := path.Join(staging, pluginName)
+	if _, err := os.Stat(extracted); os.IsNotExist(err) {
+		return nil
+	}
+	return place(extracted, path.Join(filePath, pluginName))
+}
+
+// replaceDir replaces target with the extracted directory.
+func replaceDir(extracted, target string) error {
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	return os.Rename(extracted, target)
+}
+
+// mergeDir moves the files of the extracted directory into target, replacing
+// the files of the same name and keeping the others.
+func mergeDir(extracted, target string) error {
+	return filepath.Walk(extracted, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(extracted, name)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(target, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		return os.Rename(name, dst)
+	})
 }
 
 func permissionsError(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "permission denied")
 }
+
+// suggestAlternatives prints install commands for the versions closest to an
+// unavailable requested version.
+func suggestAlternatives(pluginName string, err error) {
+	var alt interface{ Alternatives() []string }
+	if !xerrors.As(err, &alt) {
+		return
+	}
+
+	for _, v := range alt.Alternatives() {
+		logger.Infof("available instead: grafana-cli plugins install %s %s\n", pluginName, v)
+	}
+}
@@ -1,11 +1,26 @@
 package commands
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/commands/commandstest"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
 )
 
 func TestFoldernameReplacement(t *testing.T) {
@@ -77,4 +92,644 @@ func TestExtractFiles(t *testing.T) {
 		err = os.RemoveAll("testdata/fake-plugins-dir")
 		So(err, ShouldBeNil)
 	})
+
+	Convey("Archives decompressing beyond the extract limits are refused", t, func() {
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		f, err := zw.Create("bomb-panel/zeros.bin")
+		So(err, ShouldBeNil)
+		_, err = f.Write(make([]byte, 8<<20))
+		So(err, ShouldBeNil)
+		So(zw.Close(), ShouldBeNil)
+
+		err = extractFiles(buf.Bytes(), "bomb-panel", dir)
+		So(xerrors.Is(err, s.ErrCompressionRatioExceeded), ShouldBeTrue)
+		_, err = os.Stat(filepath.Join(dir, "bomb-panel"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		s.SetExtractLimits(s.ExtractLimits{MaxTotalSize: 4 << 20})
+		defer s.SetExtractLimits(s.DefaultExtractLimits)
+
+		err = extractFiles(buf.Bytes(), "bomb-panel", dir)
+		var limitErr *s.ExtractLimitError
+		So(xerrors.As(err, &limitErr), ShouldBeTrue)
+		So(limitErr.Err, ShouldEqual, s.ErrExtractedSizeExceeded)
+		So(limitErr.Path, ShouldEqual, "bomb-panel/zeros.bin")
+
+		Convey("keeping an installed copy as it was", func() {
+			So(os.MkdirAll(filepath.Join(dir, "bomb-panel"), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "bomb-panel", "plugin.json"), []byte(`{"id": "bomb-panel"}`), 0644), ShouldBeNil)
+
+			err := extractFiles(buf.Bytes(), "bomb-panel", dir)
+			So(xerrors.As(err, &limitErr), ShouldBeTrue)
+
+			installed, err := ioutil.ReadFile(filepath.Join(dir, "bomb-panel", "plugin.json"))
+			So(err, ShouldBeNil)
+			So(string(installed), ShouldEqual, `{"id": "bomb-panel"}`)
+			files, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(files, ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestValidateArchivePaths(t *testing.T) {
+	archive := func(names ...string) []*zip.File {
+		buf := new(bytes.Buffer)
+		w := zip.NewWriter(buf)
+		for _, name := range names {
+			_, err := w.Create(name)
+			So(err, ShouldBeNil)
+		}
+		So(w.Close(), ShouldBeNil)
+
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		So(err, ShouldBeNil)
+		return r.File
+	}
+
+	Convey("Archive entries are validated before extraction", t, func() {
+		files := archive("plugin-sha/", "plugin-sha/module.js", "plugin-sha/img/logo.svg")
+		So(validateArchivePathsForOS(files, "my-plugin", "data/plugins", "windows"), ShouldBeNil)
+
+		err := validateArchivePathsForOS(archive("plugin-sha/../../etc/passwd"), "my-plugin", "data/plugins", "linux")
+		So(xerrors.Is(err, ErrPathTraversal), ShouldBeTrue)
+
+		files = archive("plugin-sha/aux.js")
+		So(validateArchivePathsForOS(files, "my-plugin", "data/plugins", "linux"), ShouldBeNil)
+		err = validateArchivePathsForOS(files, "my-plugin", "data/plugins", "windows")
+		So(xerrors.Is(err, ErrReservedFilename), ShouldBeTrue)
+
+		err = validateArchivePathsForOS(archive("plugin-sha/"+strings.Repeat("a", 250)+".js"), "my-plugin", "data/plugins", "windows")
+		So(xerrors.Is(err, ErrPathTooLong), ShouldBeTrue)
+
+		files = archive("plugin-sha/README.md", "plugin-sha/readme.md")
+		So(validateArchivePathsForOS(files, "my-plugin", "data/plugins", "linux"), ShouldBeNil)
+		err = validateArchivePathsForOS(files, "my-plugin", "data/plugins", "darwin")
+		So(xerrors.Is(err, ErrPathCollision), ShouldBeTrue)
+	})
+}
+
+func TestDownloadTarStream(t *testing.T) {
+	tarball := func() []byte {
+		buf := new(bytes.Buffer)
+		gz := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gz)
+		for name, content := range map[string]string{"plugin-sha/plugin.json": `{"id": "tar-plugin"}`, "plugin-sha/module.js": "module"} {
+			So(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}), ShouldBeNil)
+			_, err := tw.Write([]byte(content))
+			So(err, ShouldBeNil)
+		}
+		So(tw.Close(), ShouldBeNil)
+		So(gz.Close(), ShouldBeNil)
+		return buf.Bytes()
+	}
+
+	Convey("Tar archives are extracted while verifying their checksum", t, func() {
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		body := tarball()
+		archive := filepath.Join(dir, "tar-plugin.tar.gz")
+		So(ioutil.WriteFile(archive, body, 0644), ShouldBeNil)
+		checksum := fmt.Sprintf("%x", sha256.Sum256(body))
+
+		report := s.NewVerificationReport("tar-plugin", "1.0.0", checksum)
+		So(downloadTarStream("tar-plugin", dir, archive, checksum, report), ShouldBeNil)
+		So(report.Sha256, ShouldEqual, checksum)
+
+		module, err := ioutil.ReadFile(filepath.Join(dir, "tar-plugin", "module.js"))
+		So(err, ShouldBeNil)
+		So(string(module), ShouldEqual, "module")
+
+		Convey("Nothing is installed when the checksum does not match", func() {
+			So(os.RemoveAll(filepath.Join(dir, "tar-plugin")), ShouldBeNil)
+
+			err := downloadTarStream("tar-plugin", dir, archive, strings.Repeat("0", 64), report)
+			So(xerrors.Is(err, s.ErrChecksumMismatch), ShouldBeTrue)
+
+			_, err = os.Stat(filepath.Join(dir, "tar-plugin"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			entries, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestInstallExpiredURL(t *testing.T) {
+	Convey("Refused downloads are retried from a freshly resolved url", t, func() {
+		archive := pluginZip(t, `{"id": "signed-panel", "info": {"version": "1.0.0"}}`)
+		newerArchive := pluginZip(t, `{"id": "signed-panel", "info": {"version": "1.1.0"}}`)
+
+		var server *httptest.Server
+		signatures := 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/signed-panel":
+				signatures++
+				// a newer version was published since the first resolution
+				newer := ""
+				if signatures > 1 {
+					newer = fmt.Sprintf(`{"version": "1.1.0", "arch": {"any": {"url": "%s/cdn/newer-panel.zip", "sha256": "%x"}}},`, server.URL, sha256.Sum256(newerArchive))
+				}
+				fmt.Fprintf(w, `{"id": "signed-panel", "versions": [%s{"version": "1.0.0", "arch": {"any": {"url": "%s/cdn/signed-panel.zip?sig=%d", "sha256": "%x"}}}]}`,
+					newer, server.URL, signatures, sha256.Sum256(archive))
+			case "/cdn/newer-panel.zip":
+				w.Write(newerArchive)
+			case "/cdn/signed-panel.zip":
+				// only the latest signature is valid
+				if r.URL.Query().Get("sig") != fmt.Sprint(signatures) || signatures < 2 {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Write(archive)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": dir}},
+		}
+		So(InstallPlugin("signed-panel", "", c), ShouldBeNil)
+		So(signatures, ShouldEqual, 2)
+
+		// the fresh url is of the version resolved first
+		pluginJSON, err := ioutil.ReadFile(filepath.Join(dir, "signed-panel", "plugin.json"))
+		So(err, ShouldBeNil)
+		So(string(pluginJSON), ShouldContainSubstring, `"1.0.0"`)
+	})
+}
+
+func TestInstallMirrorFallback(t *testing.T) {
+	Convey("Archives not matching the checksum are downloaded from the mirrors in turn", t, func() {
+		archive := pluginZip(t, `{"id": "mirrored-panel", "info": {"version": "1.0.0"}}`)
+		truncated := archive[:len(archive)/2]
+
+		var repo *httptest.Server
+		repo = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/mirrored-panel":
+				fmt.Fprintf(w, `{"id": "mirrored-panel", "versions": [{"version": "1.0.0", "arch": {"any": {"url": "%s/mirrored-panel.zip", "sha256": "%x"}}}]}`, repo.URL, sha256.Sum256(archive))
+			case "/mirrored-panel.zip":
+				w.Write(truncated)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer repo.Close()
+
+		mirror := func(body []byte) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/mirrored-panel/versions/1.0.0/download" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write(body)
+			}))
+		}
+		corrupted := mirror(truncated)
+		defer corrupted.Close()
+		s.SetRetryPolicy(s.RetryPolicy{MaxAttempts: 1})
+		defer s.SetRetryPolicy(s.DefaultRetryPolicy)
+		defer s.SetFailoverRepos(nil)
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": repo.URL, "pluginsDir": dir}},
+		}
+
+		Convey("until one serves the expected archive", func() {
+			healthy := mirror(archive)
+			defer healthy.Close()
+			s.SetFailoverRepos([]string{corrupted.URL, healthy.URL})
+
+			So(InstallPlugin("mirrored-panel", "", c), ShouldBeNil)
+			_, err := os.Stat(filepath.Join(dir, "mirrored-panel", "plugin.json"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("recording the failure of each of them", func() {
+			other := mirror(truncated)
+			defer other.Close()
+			s.SetFailoverRepos([]string{corrupted.URL, other.URL})
+
+			err := InstallPlugin("mirrored-panel", "", c)
+			So(xerrors.Is(err, s.ErrChecksumMismatch), ShouldBeTrue)
+
+			var mismatch *s.ChecksumMismatchError
+			So(xerrors.As(err, &mismatch), ShouldBeTrue)
+			So(mismatch.Failures, ShouldHaveLength, 3)
+			So(mismatch.Failures[0].URL, ShouldEqual, repo.URL+"/mirrored-panel.zip")
+		})
+	})
+}
+
+func TestInstallCachedExtras(t *testing.T) {
+	Convey("Archives installed from the archive store get their extras", t, func() {
+		archive := pluginZip(t, `{"id": "extras-panel", "info": {"version": "1.0.0"}}`)
+
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		f, err := zw.Create("extras-sha/dashboards/home.json")
+		So(err, ShouldBeNil)
+		_, err = f.Write([]byte(`{}`))
+		So(err, ShouldBeNil)
+		So(zw.Close(), ShouldBeNil)
+		extra := buf.Bytes()
+
+		downloads := 0
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/extras-panel":
+				fmt.Fprintf(w, `{"id": "extras-panel", "versions": [{"version": "1.0.0",
+					"arch": {"any": {"url": "%s/extras-panel.zip", "sha256": "%x"}},
+					"extras": {"dashboards": {"url": "%s/dashboards.zip", "sha256": "%x"}}}]}`,
+					server.URL, sha256.Sum256(archive), server.URL, sha256.Sum256(extra))
+			case "/extras-panel.zip":
+				downloads++
+				w.Write(archive)
+			case "/dashboards.zip":
+				w.Write(extra)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		pluginsDir := filepath.Join(dir, "plugins")
+		So(os.Mkdir(pluginsDir, 0755), ShouldBeNil)
+
+		s.SetArchiveStore(filepath.Join(dir, "archives"))
+		defer s.SetArchiveStore("")
+		So(s.StoreArchive("extras-panel", "1.0.0", archive), ShouldBeNil)
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{"extras": "dashboards"}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": pluginsDir}},
+		}
+		So(InstallPlugin("extras-panel", "", c), ShouldBeNil)
+		So(downloads, ShouldEqual, 0)
+
+		for _, name := range []string{"plugin.json", filepath.Join("dashboards", "home.json")} {
+			_, err := os.Stat(filepath.Join(pluginsDir, "extras-panel", name))
+			So(err, ShouldBeNil)
+		}
+	})
+}
+
+func TestInstallVerificationReport(t *testing.T) {
+	Convey("Installs write a verification report when asked to", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		archive := pluginZip(t, `{"id": "reported-panel", "info": {"version": "1.0.0"}}`)
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/reported-panel":
+				fmt.Fprintf(w, `{"id": "reported-panel", "versions": [{"version": "1.0.0", "arch": {"any": {"url": "%s/reported-panel.zip", "sha256": "%x"}}}]}`, server.URL, sha256.Sum256(archive))
+			case "/reported-panel.zip":
+				w.Write(archive)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		pluginsDir := filepath.Join(dir, "plugins")
+		So(os.Mkdir(pluginsDir, 0755), ShouldBeNil)
+		install := func(target, path string) s.VerificationReport {
+			c := &commandstest.FakeCommandLine{
+				LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+				GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": pluginsDir, "verificationReport": target}},
+			}
+			So(InstallPlugin("reported-panel", "", c), ShouldBeNil)
+
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+
+			var report s.VerificationReport
+			So(json.Unmarshal(data, &report), ShouldBeNil)
+			return report
+		}
+
+		Convey("next to the plugin", func() {
+			report := install("plugin", filepath.Join(pluginsDir, "reported-panel", "verification-report.json"))
+			So(report.PluginID, ShouldEqual, "reported-panel")
+			So(report.Version, ShouldEqual, "1.0.0")
+			So(report.Source, ShouldEqual, server.URL+"/reported-panel.zip")
+			So(report.Sha256, ShouldEqual, fmt.Sprintf("%x", sha256.Sum256(archive)))
+			So(report.ChecksumVerified, ShouldBeTrue)
+		})
+
+		Convey("or to a directory collecting them", func() {
+			audit := filepath.Join(dir, "audit")
+			report := install(audit, filepath.Join(audit, "reported-panel-1.0.0.json"))
+			So(report.Sha256, ShouldEqual, fmt.Sprintf("%x", sha256.Sum256(archive)))
+			So(report.ChecksumVerified, ShouldBeTrue)
+		})
+	})
+}
+
+func TestInstallForce(t *testing.T) {
+	Convey("Forced installs replace the installed copy", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		archive := pluginZip(t, `{"id": "forced-panel", "info": {"version": "1.0.0"}}`)
+		available := true
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/forced-panel":
+				fmt.Fprintf(w, `{"id": "forced-panel", "versions": [{"version": "1.0.0", "arch": {"any": {"url": "%s/forced-panel.zip", "sha256": "%x"}}}]}`, server.URL, sha256.Sum256(archive))
+			case "/forced-panel.zip":
+				if !available {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write(archive)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		s.SetRetryPolicy(s.RetryPolicy{MaxAttempts: 1})
+		defer s.SetRetryPolicy(s.DefaultRetryPolicy)
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		// a corrupted local copy
+		So(os.MkdirAll(filepath.Join(dir, "forced-panel"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "forced-panel", "corrupted.js"), []byte("garbage"), 0644), ShouldBeNil)
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{"force": true}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": dir}},
+		}
+
+		Convey("once the fresh archive is installed", func() {
+			So(InstallPlugin("forced-panel", "", c), ShouldBeNil)
+
+			_, err := os.Stat(filepath.Join(dir, "forced-panel", "plugin.json"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, "forced-panel", "corrupted.js"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("keeping it when the download fails", func() {
+			available = false
+			So(InstallPlugin("forced-panel", "", c), ShouldNotBeNil)
+
+			_, err := os.Stat(filepath.Join(dir, "forced-panel", "corrupted.js"))
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestInstallFromFile(t *testing.T) {
+	archive := pluginZip(t, `{"id": "local-panel", "info": {"version": "1.2.0"}}`)
+	checksum := fmt.Sprintf("%x", sha256.Sum256(archive))
+
+	published := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repo/local-panel" || published == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"id": "local-panel", "versions": [{"version": "1.2.0", "arch": {"any": {"sha256": "%s"}}}]}`, published)
+	}))
+	defer server.Close()
+	s.Init("", false, s.WithRepoURL(server.URL))
+	defer s.Init("", false)
+
+	// installArchive installs body from a plugins directory prepared by prepare
+	installArchive := func(body []byte, globals map[string]interface{}, prepare func(dir, path string)) (string, error) {
+		root, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		dir := filepath.Join(root, "plugins")
+		So(os.Mkdir(dir, 0755), ShouldBeNil)
+
+		path := filepath.Join(dir, "local-panel.zip")
+		So(ioutil.WriteFile(path, body, 0644), ShouldBeNil)
+		if prepare != nil {
+			prepare(dir, path)
+		}
+
+		flags := map[string]interface{}{"repo": server.URL, "pluginsDir": dir}
+		for k, v := range globals {
+			flags[k] = v
+		}
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: flags},
+		}
+
+		s.InvalidateMetadata("local-panel")
+		return dir, InstallFromFile(context.Background(), path, fileInstallOptions(c))
+	}
+	install := func(globals map[string]interface{}) (string, error) {
+		return installArchive(archive, globals, nil)
+	}
+	cleanup := func(dir string) { os.RemoveAll(filepath.Dir(dir)) }
+
+	Convey("Local archives are verified against the checksum the repository publishes", t, func() {
+		published = checksum
+		dir, err := install(nil)
+		defer cleanup(dir)
+		So(err, ShouldBeNil)
+
+		installed, err := s.ReadPlugin(dir, "local-panel")
+		So(err, ShouldBeNil)
+		So(installed.Info.Version, ShouldEqual, "1.2.0")
+
+		Convey("and refused when they do not match", func() {
+			published = strings.Repeat("0", 64)
+			dir, err := install(nil)
+			defer cleanup(dir)
+			So(xerrors.Is(err, s.ErrChecksumMismatch), ShouldBeTrue)
+		})
+
+		Convey("restoring the installed version when the install fails", func() {
+			s.SetExtractLimits(s.ExtractLimits{MaxTotalSize: 1})
+			defer s.SetExtractLimits(s.DefaultExtractLimits)
+
+			dir, err := installArchive(archive, nil, func(dir, path string) {
+				So(os.MkdirAll(filepath.Join(dir, "local-panel"), 0755), ShouldBeNil)
+				So(ioutil.WriteFile(filepath.Join(dir, "local-panel", "plugin.json"), []byte(`{"id": "local-panel", "info": {"version": "1.1.0"}}`), 0644), ShouldBeNil)
+			})
+			defer cleanup(dir)
+			So(xerrors.Is(err, s.ErrExtractedSizeExceeded), ShouldBeTrue)
+
+			installed, err := s.ReadPlugin(dir, "local-panel")
+			So(err, ShouldBeNil)
+			So(installed.Info.Version, ShouldEqual, "1.1.0")
+		})
+	})
+
+	Convey("Local archives must name a plugin directory", t, func() {
+		for _, id := range []string{"..", ".", "../escape", `..\\escape`} {
+			dir, err := installArchive(pluginZip(t, `{"id": "`+id+`", "info": {"version": "1.0.0"}}`), map[string]interface{}{"allowUnverified": true}, nil)
+			defer cleanup(dir)
+			So(xerrors.Is(err, s.ErrInvalidPluginID), ShouldBeTrue)
+
+			_, err = os.Stat(dir)
+			So(err, ShouldBeNil)
+		}
+	})
+
+	Convey("Local archives of unpublished versions need a checksum of their own", t, func() {
+		published = ""
+
+		dir, err := install(nil)
+		defer cleanup(dir)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, s.ErrChecksumNotFound.Error())
+
+		dir, err = install(map[string]interface{}{"pluginChecksum": checksum})
+		defer cleanup(dir)
+		So(err, ShouldBeNil)
+
+		Convey("as the checksum file next to them comes from the same source", func() {
+			sidecar := func(dir, path string) {
+				So(ioutil.WriteFile(path+".sha256", []byte(checksum+"  local-panel.zip\n"), 0644), ShouldBeNil)
+			}
+
+			dir, err := installArchive(archive, nil, sidecar)
+			defer cleanup(dir)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, s.ErrChecksumNotFound.Error())
+
+			dir, err = installArchive(archive, map[string]interface{}{"allowUnverified": true}, sidecar)
+			defer cleanup(dir)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("and still pass the trust policy", func() {
+			s.SetTrustPolicy(s.TrustPolicy{TrustedPublishers: []string{"grafana"}})
+			defer s.SetTrustPolicy(s.TrustPolicy{})
+
+			dir, err := install(map[string]interface{}{"pluginChecksum": checksum})
+			defer cleanup(dir)
+			So(xerrors.Is(err, s.ErrUntrustedPublisher), ShouldBeTrue)
+		})
+	})
+}
+
+func TestInstallTrustPolicy(t *testing.T) {
+	Convey("The trust policy applies to every install path", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+		s.SetRetryPolicy(s.RetryPolicy{MaxAttempts: 1})
+		defer s.SetRetryPolicy(s.DefaultRetryPolicy)
+		s.SetTrustPolicy(s.TrustPolicy{TrustedPublishers: []string{"grafana"}})
+		defer s.SetTrustPolicy(s.TrustPolicy{})
+
+		archive := pluginZip(t, `{"id": "community-panel", "info": {"version": "1.0.0"}}`)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo":
+				w.Write([]byte(`{"plugins": [{"id": "community-panel", "orgSlug": "someone"}, {"id": "grafana-panel", "orgSlug": "grafana"}]}`))
+			case "/repo/community-panel":
+				w.Write([]byte(`{"id": "community-panel", "orgSlug": "someone", "versions": [{"version": "1.0.0"}]}`))
+			case "/community-panel.zip":
+				w.Write(archive)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		Convey("including installs from a url", func() {
+			c := &commandstest.FakeCommandLine{
+				LocalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+				GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{
+					"repo": server.URL, "pluginsDir": dir, "pluginUrl": server.URL + "/community-panel.zip", "allowUnverified": true,
+				}},
+			}
+			err := InstallPlugin("community-panel", "", c)
+			So(xerrors.Is(err, s.ErrUntrustedPublisher), ShouldBeTrue)
+
+			_, err = os.Stat(filepath.Join(dir, "community-panel"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("including installs of pinned archives from the archive store", func() {
+			s.SetArchiveStore(filepath.Join(dir, "archives"))
+			defer s.SetArchiveStore("")
+			So(s.StoreArchive("community-panel", "1.0.0", archive), ShouldBeNil)
+
+			c := &commandstest.FakeCommandLine{
+				LocalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+				GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{
+					"repo": server.URL, "pluginsDir": dir, "pluginChecksum": fmt.Sprintf("sha256:%x", sha256.Sum256(archive)),
+				}},
+			}
+			err := InstallPlugin("community-panel", "1.0.0", c)
+			So(xerrors.Is(err, s.ErrUntrustedPublisher), ShouldBeTrue)
+
+			_, err = os.Stat(filepath.Join(dir, "community-panel"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("and to listings", func() {
+			listing, err := s.ListAllPlugins(server.URL)
+			So(err, ShouldBeNil)
+			So(listing.Plugins, ShouldHaveLength, 1)
+			So(listing.Plugins[0].Id, ShouldEqual, "grafana-panel")
+		})
+	})
+}
+
+func pluginZip(t *testing.T, pluginJSON string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	if _, err := zw.Create("plugin-sha/"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := zw.Create("plugin-sha/plugin.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(pluginJSON)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
 }
@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"golang.org/x/xerrors"
+)
+
+var ErrNoPluginJSON = s.ErrNoPluginJSON
+
+// isLocalArchive reports whether arg names a zip file on disk rather than a plugin id.
+func isLocalArchive(arg string) bool {
+	if !strings.HasSuffix(strings.ToLower(arg), ".zip") {
+		return false
+	}
+	fi, err := os.Stat(arg)
+	return err == nil && !fi.IsDir()
+}
+
+// InstallFileOptions configures InstallFromFile.
+type InstallFileOptions struct {
+	// Checksum is the digest the archive must match, required for versions
+	// the repository does not publish.
+	Checksum string
+	// AllowUnverified installs archives of unpublished versions without a
+	// Checksum, verified by the .sha256 file next to them if there is one.
+	AllowUnverified bool
+	AllowYanked     bool
+	// CommandLine supplies the plugins directory, the repository and where
+	// reports are written.
+	CommandLine utils.CommandLine
+}
+
+func fileInstallOptions(c utils.CommandLine) InstallFileOptions {
+	return InstallFileOptions{
+		Checksum:        c.GlobalString("pluginChecksum"),
+		AllowUnverified: c.GlobalBool("allowUnverified"),
+		AllowYanked:     c.Bool("allowYanked"),
+		CommandLine:     c,
+	}
+}
+
+// InstallFromFile installs a local plugin archive with the same checks as a
+// download. The plugin id and version are read from its plugin.json, the
+// checksum is the one the repository publishes for that version, and the
+// repository policies like trusted publishers or licenses apply, also to
+// versions the repository does not know. Those are verified against the
+// Checksum of opts instead, or installed with AllowUnverified. A previously
+// installed version of the plugin is restored when the install fails.
+func InstallFromFile(ctx context.Context, path string, opts InstallFileOptions) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := readArchiveManifest(body)
+	if err != nil {
+		return xerrors.Errorf("%s: %w", path, err)
+	}
+
+	err = installFromFile(ctx, path, manifest.Id, manifest.Info.Version, body, opts)
+	if err != nil {
+		s.ReportProgressError(manifest.Id, err)
+	}
+	return err
+}
+
+func installFromFile(ctx context.Context, path, pluginName, version string, body []byte, opts InstallFileOptions) error {
+	c := opts.CommandLine
+	s.ReportProgress(pluginName, s.StageResolving)
+
+	checksum, license, err := localArchiveChecksum(ctx, path, pluginName, version, opts)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("installing %v @ %v\n", pluginName, version)
+	logger.Infof("from: %v\n", path)
+	logger.Infof("into: %v\n", c.PluginDirectory())
+	logger.Info("\n")
+
+	s.ReportProgress(pluginName, s.StageVerifying)
+	if checksum == "" {
+		if !opts.AllowUnverified {
+			return fmt.Errorf("%v for %s. Use --allowUnverified to install it without verification", s.ErrChecksumNotFound, path)
+		}
+		logger.Infof("%s No checksum found for %s, installing unverified\n", color.YellowString("!"), path)
+	} else if err := s.VerifyChecksum(body, checksum); err != nil {
+		return err
+	}
+
+	return reinstallPlugin(c.PluginDirectory(), pluginName, func() error {
+		s.ReportProgress(pluginName, s.StageExtracting)
+		if err := extractFiles(body, pluginName, c.PluginDirectory()); err != nil {
+			return err
+		}
+
+		report := s.NewVerificationReport(pluginName, version, checksum)
+		report.License = license
+		report.RecordArchive(path, body)
+		if checksum != "" {
+			if err := s.StoreArchive(pluginName, version, body); err != nil {
+				return err
+			}
+		}
+
+		return finishInstall(pluginName, report, c)
+	})
+}
+
+// localArchiveChecksum returns the checksum a local archive has to match and
+// the license of the plugin, if the repository publishes the version. The
+// repository is asked for pinned checksums too, so its policies apply.
+func localArchiveChecksum(ctx context.Context, path, pluginName, version string, opts InstallFileOptions) (string, string, error) {
+	req := s.PluginRequest{PluginID: pluginName, Version: version, AllowYanked: opts.AllowYanked, Digest: opts.Checksum}
+	res, err := s.ResolveWithContext(ctx, opts.CommandLine.RepoDirectory(), req)
+	switch {
+	case err == nil && res.Checksum != "":
+		return res.Checksum, res.Plugin.License, nil
+	case err != nil && !xerrors.Is(err, s.ErrNotFoundError) && !xerrors.Is(err, s.ErrVersionNotFound):
+		return "", "", err
+	case err != nil:
+		logger.Infof("%s %s @ %s is not published by the repository\n", color.YellowString("!"), pluginName, version)
+		if err := s.CheckPolicies(m.Plugin{Id: pluginName}); err != nil {
+			return "", "", err
+		}
+	}
+
+	if opts.Checksum != "" {
+		checksum, err := s.ParseChecksum(opts.Checksum)
+		return checksum, res.Plugin.License, err
+	}
+
+	// whoever supplied the archive also supplied the checksum file next to it
+	if !opts.AllowUnverified {
+		return "", res.Plugin.License, nil
+	}
+	checksum, err := s.GetChecksum(pluginName, m.Version{}, path)
+	if err != nil && err != s.ErrChecksumNotFound {
+		return "", "", err
+	}
+	if checksum != "" {
+		logger.Infof("%s Verifying %s only against the checksum file next to it\n", color.YellowString("!"), path)
+	}
+	return checksum, res.Plugin.License, nil
+}
+
+// readArchiveManifest reads the plugin.json in the dist directory of the
+// archive, or at its top.
+func readArchiveManifest(body []byte) (m.InstalledPlugin, error) {
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return m.InstalledPlugin{}, err
+	}
+	return s.ReadManifest(r)
+}
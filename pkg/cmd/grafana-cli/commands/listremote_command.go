@@ -1,26 +1,58 @@
 package commands
 
 import (
+	"fmt"
+
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
 	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
 )
 
 func listremoteCommand(c utils.CommandLine) error {
+	sortBy, err := s.ParsePluginSort(c.String("sort"))
+	if err != nil {
+		return err
+	}
+
 	plugin, err := s.ListAllPlugins(c.RepoDirectory())
 
 	if err != nil {
 		return err
 	}
 
-	for _, i := range plugin.Plugins {
+	plugins := s.FilterPlugins(plugin.Plugins, s.PluginFilter{
+		SignedOnly:   c.Bool("signed"),
+		MinDownloads: int64(c.Int("minDownloads")),
+	})
+	s.SortPlugins(plugins, sortBy)
+
+	for _, i := range plugins {
 		pluginVersion := ""
 		if len(i.Versions) > 0 {
 			pluginVersion = i.Versions[0].Version
 		}
 
-		logger.Infof("id: %v version: %s\n", i.Id, pluginVersion)
+		logger.Infof("id: %v version: %s%s\n", i.Id, pluginVersion, pluginSignals(i))
 	}
 
 	return nil
 }
+
+// pluginSignals formats the catalog signals the repository publishes.
+func pluginSignals(p m.Plugin) string {
+	var signals string
+	if p.Downloads > 0 {
+		signals += fmt.Sprintf(" downloads: %d", p.Downloads)
+	}
+	if p.Rating > 0 {
+		signals += fmt.Sprintf(" rating: %.1f", p.Rating)
+	}
+	if !p.UpdatedAt.IsZero() {
+		signals += fmt.Sprintf(" updated: %s", p.UpdatedAt.Format("2006-01-02"))
+	}
+	if p.SignatureType != "" {
+		signals += fmt.Sprintf(" signature: %s", p.SignatureType)
+	}
+	return signals
+}
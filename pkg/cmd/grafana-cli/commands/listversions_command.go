@@ -2,6 +2,7 @@ package commands
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
 	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
@@ -30,6 +31,10 @@ func listversionsCommand(c utils.CommandLine) error {
 	}
 
 	for _, i := range plugin.Versions {
+		if len(i.Editions) > 0 {
+			logger.Infof("%v (%s only)\n", i.Version, strings.Join(i.Editions, ", "))
+			continue
+		}
 		logger.Infof("%v\n", i.Version)
 	}
 
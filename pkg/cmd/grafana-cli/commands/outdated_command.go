@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+// outdatedCommand lists installed plugins with a newer version. With a
+// status file it prints what the update checker of a running Grafana found
+// instead of polling the repository again.
+func outdatedCommand(c utils.CommandLine) error {
+	var status s.UpdateStatus
+	if path := c.String("statusFile"); path != "" {
+		var err error
+		if status, err = (s.FileUpdateStore{Path: path}).Load(); err != nil {
+			return err
+		}
+	}
+
+	if status.CheckedAt.IsZero() {
+		updates, err := s.CheckForUpdates(c.RepoDirectory(), c.PluginDirectory(), nil)
+		if err != nil {
+			return err
+		}
+		status = s.UpdateStatus{CheckedAt: time.Now().UTC(), Updates: updates}
+	} else {
+		logger.Infof("updates found at %v\n", status.CheckedAt.Local().Format(time.RFC3339))
+		if status.Error != "" {
+			logger.Warnf("the last check failed: %v\n", status.Error)
+		}
+	}
+
+	if len(status.Updates) == 0 {
+		logger.Info("all plugins are up to date\n")
+		return nil
+	}
+	for _, u := range status.Updates {
+		logger.Infof("%s %s -> %s\n", u.PluginID, u.InstalledVersion, u.Version)
+	}
+	return nil
+}
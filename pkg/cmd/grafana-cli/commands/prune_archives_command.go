@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+func pruneArchivesCommand(c utils.CommandLine) error {
+	report, err := s.PruneArchives(c.Bool("dryRun"))
+	if err != nil {
+		return err
+	}
+
+	action := "removed"
+	if report.DryRun {
+		action = "would remove"
+	}
+
+	for _, a := range report.Archives {
+		logger.Infof("%s %s @ %s: %s\n", action, a.PluginID, a.Version, a.Reason)
+	}
+	for _, digest := range report.Blobs {
+		logger.Infof("%s unreferenced archive %s\n", action, digest)
+	}
+	logger.Infof("%s %d archives, freeing %d bytes\n", action, len(report.Archives), report.FreedBytes)
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"golang.org/x/xerrors"
+)
+
+var ErrNoSuccessor = errors.New("the repository names no successor for the plugin")
+
+// MigrateFunc moves references like dashboards and data sources from the
+// replaced plugin from to its successor to. The returned revert undoes the
+// migration when a later step of the replacement fails, it may be nil.
+type MigrateFunc func(from, to string) (revert func() error, err error)
+
+func replaceCommand(c utils.CommandLine) error {
+	pluginName := c.Args().First()
+	if pluginName == "" {
+		return errors.New("please specify plugin to replace")
+	}
+
+	_, err := ReplacePlugin(pluginName, c, nil)
+	return err
+}
+
+// ReplacePlugin replaces the installed plugin from with the successor the
+// repository names for it in one step: it installs the successor, migrates
+// references to it with migrate and removes from. When any step fails, the
+// plugin directory is restored to its previous state and the migration is
+// reverted. It returns the id of the successor.
+func ReplacePlugin(from string, c utils.CommandLine, migrate MigrateFunc) (string, error) {
+	pluginsDir := c.PluginDirectory()
+	if err := s.ValidatePluginID(from); err != nil {
+		return "", err
+	}
+	if _, err := s.ReadPlugin(pluginsDir, from); err != nil {
+		return "", err
+	}
+
+	plugin, err := s.GetPlugin(from, c.RepoDirectory())
+	if err != nil {
+		return "", err
+	}
+	to := plugin.ReplacedBy
+	if to == "" {
+		return "", fmt.Errorf("%s: %v", from, ErrNoSuccessor)
+	}
+	// the successor comes from the repository, it must not name a path
+	if err := s.ValidatePluginID(to); err != nil {
+		return "", xerrors.Errorf("invalid successor of %s: %w", from, err)
+	}
+
+	logger.Infof("replacing %v with %v\n", from, to)
+
+	// a previously installed successor is set aside, the fresh install replaces it
+	successor, err := backupPlugin(pluginsDir, to)
+	if err != nil {
+		return "", err
+	}
+
+	if err := InstallPlugin(to, "", c); err != nil {
+		return "", rollback(err, successor.restore)
+	}
+
+	var revert func() error
+	if migrate != nil {
+		if revert, err = migrate(from, to); err != nil {
+			return "", rollback(fmt.Errorf("failed to migrate references from %s to %s: %v", from, to, err), successor.restore)
+		}
+	}
+
+	replaced, err := backupPlugin(pluginsDir, from)
+	if err != nil {
+		return "", rollback(err, revert, successor.restore)
+	}
+
+	for _, b := range []*pluginBackup{replaced, successor} {
+		if err := b.discard(); err != nil {
+			logger.Infof("failed to remove %v: %v\n", b.backup, err)
+		}
+	}
+
+	logger.Infof("replaced %v with %v\n", from, to)
+	return to, nil
+}
+
+// rollback runs the undo steps in order and adds their failures to err.
+func rollback(err error, steps ...func() error) error {
+	for _, step := range steps {
+		if step == nil {
+			continue
+		}
+		if rbErr := step(); rbErr != nil {
+			err = fmt.Errorf("%v, rollback failed: %v", err, rbErr)
+		}
+	}
+	return err
+}
+
+// reinstallPlugin runs install with the installed pluginId moved aside, and
+// moves it back when install fails, so a failed update or reinstall does not
+// lose the plugin.
+func reinstallPlugin(pluginsDir, pluginId string, install func() error) error {
+	if err := s.ValidatePluginID(pluginId); err != nil {
+		return err
+	}
+
+	previous, err := backupPlugin(pluginsDir, pluginId)
+	if err != nil {
+		return err
+	}
+
+	if err := install(); err != nil {
+		return rollback(err, previous.restore)
+	}
+
+	if err := previous.discard(); err != nil {
+		logger.Infof("failed to remove %v: %v\n", previous.backup, err)
+	}
+	return nil
+}
+
+// pluginBackup is an installed plugin moved aside, so it can be restored
+// when replacing it fails.
+type pluginBackup struct {
+	path    string
+	backup  string
+	existed bool
+}
+
+func backupPlugin(pluginsDir, pluginId string) (*pluginBackup, error) {
+	b := &pluginBackup{
+		path:   filepath.Join(pluginsDir, pluginId),
+		backup: filepath.Join(pluginsDir, "."+pluginId+".replaced"),
+	}
+
+	if _, err := os.Stat(b.path); os.IsNotExist(err) {
+		// an interrupted run left the installed plugin moved aside
+		if _, err := os.Stat(b.backup); err != nil {
+			return b, nil
+		}
+		if err := os.Rename(b.backup, b.path); err != nil {
+			return nil, err
+		}
+	}
+
+	// left behind by an interrupted replacement
+	if err := os.RemoveAll(b.backup); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(b.path, b.backup); err != nil {
+		return nil, err
+	}
+	b.existed = true
+	return b, nil
+}
+
+// restore removes whatever was installed in place of the plugin and moves
+// the backup back.
+func (b *pluginBackup) restore() error {
+	if err := os.RemoveAll(b.path); err != nil {
+		return err
+	}
+	if !b.existed {
+		return nil
+	}
+	return os.Rename(b.backup, b.path)
+}
+
+func (b *pluginBackup) discard() error {
+	if !b.existed {
+		return nil
+	}
+	return os.RemoveAll(b.backup)
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/commands/commandstest"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestReplacePlugin(t *testing.T) {
+	archive := pluginZip(t, `{"id": "new-panel", "info": {"version": "2.0.0"}}`)
+
+	Convey("Replacing a plugin with its successor", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/old-panel":
+				fmt.Fprint(w, `{"id": "old-panel", "deprecated": true, "replacedBy": "new-panel", "versions": [{"version": "1.0.0"}]}`)
+			case "/repo/new-panel":
+				fmt.Fprintf(w, `{"id": "new-panel", "versions": [{"version": "2.0.0", "arch": {"any": {"url": "%s/new-panel.zip", "sha256": "%x"}}}]}`, server.URL, sha256.Sum256(archive))
+			case "/repo/escaping-panel":
+				fmt.Fprint(w, `{"id": "escaping-panel", "replacedBy": "../outside", "versions": [{"version": "1.0.0"}]}`)
+			case "/new-panel.zip":
+				w.Write(archive)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		writePlugin := func(id, version string) {
+			So(os.MkdirAll(filepath.Join(dir, id), 0755), ShouldBeNil)
+			manifest := fmt.Sprintf(`{"id": "%s", "info": {"version": "%s"}}`, id, version)
+			So(ioutil.WriteFile(filepath.Join(dir, id, "plugin.json"), []byte(manifest), 0644), ShouldBeNil)
+		}
+		installedVersion := func(id string) string {
+			p, err := s.ReadPlugin(dir, id)
+			if err != nil {
+				return ""
+			}
+			return p.Info.Version
+		}
+		writePlugin("old-panel", "1.0.0")
+
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"repo": server.URL, "pluginsDir": dir}},
+		}
+
+		Convey("installs the successor, migrates references and removes the plugin", func() {
+			var migrated []string
+			to, err := ReplacePlugin("old-panel", c, func(from, to string) (func() error, error) {
+				migrated = append(migrated, from+"->"+to)
+				So(installedVersion("new-panel"), ShouldEqual, "2.0.0")
+				return nil, nil
+			})
+			So(err, ShouldBeNil)
+			So(to, ShouldEqual, "new-panel")
+			So(migrated, ShouldResemble, []string{"old-panel->new-panel"})
+
+			So(installedVersion("old-panel"), ShouldEqual, "")
+			So(installedVersion("new-panel"), ShouldEqual, "2.0.0")
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldHaveLength, 1)
+		})
+
+		Convey("restores both plugins when migrating fails", func() {
+			writePlugin("new-panel", "1.5.0")
+
+			_, err := ReplacePlugin("old-panel", c, func(from, to string) (func() error, error) {
+				return nil, errors.New("dashboards are read only")
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "dashboards are read only")
+
+			So(installedVersion("old-panel"), ShouldEqual, "1.0.0")
+			So(installedVersion("new-panel"), ShouldEqual, "1.5.0")
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldHaveLength, 2)
+		})
+
+		Convey("fails for plugins without a successor", func() {
+			_, err := ReplacePlugin("new-panel", c, nil)
+			So(err, ShouldNotBeNil)
+
+			writePlugin("new-panel", "2.0.0")
+			_, err = ReplacePlugin("new-panel", c, nil)
+			So(err.Error(), ShouldContainSubstring, ErrNoSuccessor.Error())
+		})
+
+		Convey("rejects ids naming paths before touching the plugin directory", func() {
+			_, err := ReplacePlugin("../old-panel", c, nil)
+			So(xerrors.Is(err, s.ErrInvalidPluginID), ShouldBeTrue)
+
+			writePlugin("escaping-panel", "1.0.0")
+			_, err = ReplacePlugin("escaping-panel", c, nil)
+			So(xerrors.Is(err, s.ErrInvalidPluginID), ShouldBeTrue)
+			So(installedVersion("escaping-panel"), ShouldEqual, "1.0.0")
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldHaveLength, 2)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"errors"
+	"time"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+// stateCommand prints when the plugins, or the given one, were last checked
+// for updates and installed on this instance.
+func stateCommand(c utils.CommandLine) error {
+	store := s.NewPluginStateStore()
+	if store.Path == "" {
+		return errors.New("no plugin state file configured, see --pluginStateFile")
+	}
+
+	var states []s.PluginState
+	if pluginId := c.Args().First(); pluginId != "" {
+		state, err := store.Get(pluginId)
+		if err != nil {
+			return err
+		}
+		states = append(states, state)
+	} else {
+		var err error
+		if states, err = store.Load(); err != nil {
+			return err
+		}
+	}
+
+	if len(states) == 0 {
+		logger.Info("no plugin checks or installs recorded\n")
+		return nil
+	}
+	for _, state := range states {
+		logger.Infof("%s version=%s last_check=%s last_install=%s\n", state.PluginID, orUnknown(state.InstalledVersion), formatStateTime(state.LastCheck), formatStateTime(state.LastInstall))
+		if state.LastError != "" {
+			logger.Warnf("  last error at %s: %s\n", formatStateTime(state.LastErrorAt), state.LastError)
+		}
+	}
+	return nil
+}
+
+func formatStateTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+)
+
+func isTarArchive(url string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(strings.ToLower(url), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadTarStream extracts a tar archive while it is downloaded, verifying
+// its checksum on the fly, so the archive is never held in memory or written
+// to disk. Entries are extracted into a staging directory next to the plugin
+// and only moved into place once the checksum matched.
+func downloadTarStream(pluginName, filePath, url, checksum string, report *s.VerificationReport) error {
+	stream, err := s.OpenArchive(pluginName, url)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	vr, err := s.NewVerifyingReader(stream, checksum)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = vr
+	var compressed *countingReader
+	if !strings.HasSuffix(strings.ToLower(url), ".tar") {
+		compressed = &countingReader{Reader: vr}
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	staging, err := ioutil.TempDir(filePath, "."+pluginName+"-")
+	if permissionsError(err) {
+		return fmt.Errorf(permissionsDeniedMessage, filePath)
+	}
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	s.ReportProgress(pluginName, s.StageExtracting)
+	size, err := extractTar(r, compressed, pluginName, filePath, staging)
+	if err != nil {
+		return err
+	}
+
+	s.ReportProgress(pluginName, s.StageVerifying)
+	if err := vr.Verify(); err != nil {
+		return err
+	}
+
+	if err := s.CheckQuota(filePath, pluginName, size); err != nil {
+		return err
+	}
+
+	target := filepath.Join(filePath, pluginName)
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(staging, pluginName), target); err != nil {
+		return err
+	}
+
+	report.RecordStream(url, vr.Sha256())
+	return nil
+}
+
+// extractTar extracts the tar stream r into staging. compressed counts the
+// bytes a compressed stream was read from, the compression ratio is checked
+// for the stream as a whole as its entries are not compressed one by one.
+func extractTar(r io.Reader, compressed *countingReader, pluginName, filePath, staging string) (int64, error) {
+	validator := newArchivePathValidator(pluginName, filePath, runtime.GOOS)
+	limiter := s.NewExtractLimiter()
+	tr := tar.NewReader(r)
+
+	var size int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+
+		if err := validator.check(hdr.Name); err != nil {
+			return size, err
+		}
+		name := path.Clean(RemoveGitBuildFromName(pluginName, hdr.Name))
+		dest := filepath.Join(staging, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return size, err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return size, err
+			}
+
+			fileMode := hdr.FileInfo().Mode().Perm()
+			if strings.HasSuffix(dest, "_linux_amd64") || strings.HasSuffix(dest, "_darwin_amd64") {
+				fileMode = os.FileMode(0755)
+			}
+
+			dst, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode)
+			if err != nil {
+				return size, err
+			}
+			n, err := io.Copy(dst, limiter.Reader(hdr.Name, tr, -1))
+			dst.Close()
+			if err != nil {
+				return size, err
+			}
+			size += n
+			if compressed != nil {
+				if err := limiter.CheckRatio(hdr.Name, compressed.n, size); err != nil {
+					return size, err
+				}
+			}
+		default:
+			logger.Debugf("skipping unsupported archive entry %v\n", hdr.Name)
+		}
+	}
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -1,6 +1,10 @@
 package commands
 
 import (
+	"context"
+	"path/filepath"
+
+	"github.com/fatih/color"
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
 	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
 	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
@@ -8,6 +12,8 @@ import (
 	"github.com/hashicorp/go-version"
 )
 
+const upgradeAllJournal = ".upgrade-all-journal.json"
+
 func ShouldUpgrade(installed string, remote m.Plugin) bool {
 	installedVersion, err1 := version.NewVersion(installed)
 
@@ -16,6 +22,11 @@ func ShouldUpgrade(installed string, remote m.Plugin) bool {
 	}
 
 	for _, v := range remote.Versions {
+		// versions the install would not pick are no reason to upgrade
+		if !s.IsUpgradeCandidate(remote.Id, v) {
+			continue
+		}
+
 		remoteVersion, err2 := version.NewVersion(v.Version)
 
 		if err2 == nil {
@@ -39,31 +50,103 @@ func upgradeAllCommand(c utils.CommandLine) error {
 		return err
 	}
 
-	pluginsToUpgrade := make([]m.InstalledPlugin, 0)
+	journal, err := s.OpenJournal(filepath.Join(pluginsDir, upgradeAllJournal))
+	if err != nil {
+		return err
+	}
+
+	pluginsToUpgrade := make([]string, 0)
 
 	for _, localPlugin := range localPlugins {
 		for _, remotePlugin := range remotePlugins.Plugins {
 			if localPlugin.Id == remotePlugin.Id {
+				if notice := s.DeprecationNotice(remotePlugin); notice != "" {
+					logger.Warnf("%s %s\n", color.YellowString("!"), notice)
+				}
 				if ShouldUpgrade(localPlugin.Info.Version, remotePlugin) {
-					pluginsToUpgrade = append(pluginsToUpgrade, localPlugin)
+					pluginsToUpgrade = append(pluginsToUpgrade, localPlugin.Id)
 				}
 			}
 		}
 	}
 
-	for _, p := range pluginsToUpgrade {
-		logger.Infof("Updating %v \n", p.Id)
+	// plugins planned by an interrupted run may have been moved aside already
+	planned, err := journal.Plan(pluginsToUpgrade)
+	if err != nil {
+		return err
+	}
+
+	// archives are downloaded and verified ahead while earlier plugins are
+	// installed, the rest is installed the regular way
+	var prefetch []s.ArchiveRequest
+	resolved := map[string]s.Resolution{}
+	var sequential []string
+	for _, pluginId := range planned {
+		if installed, err := s.ReadPlugin(pluginsDir, pluginId); err == nil && journal.Done(pluginId, installed.Info.Version) {
+			logger.Infof("%v was already updated, skipping\n", pluginId)
+			continue
+		}
+
+		if req, res, ok := archiveRequest(pluginId, c); ok {
+			prefetch = append(prefetch, req)
+			resolved[pluginId] = res
+		} else {
+			sequential = append(sequential, pluginId)
+		}
+	}
 
-		err := s.RemoveInstalledPlugin(pluginsDir, p.Id)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pipeline := s.DownloadPipeline{DownloadWorkers: c.Int("parallel"), VerifyWorkers: c.Int("parallel")}
+	for archive := range pipeline.Run(ctx, prefetch) {
+		archive := archive
+		err := upgradePlugin(archive.PluginID, journal, c, func() error {
+			if archive.Err != nil {
+				// the regular install retries mirrors and reports the failure
+				logger.Debugf("prefetching %v failed, installing it directly: %v\n", archive.PluginID, archive.Err)
+				return InstallPlugin(archive.PluginID, "", c)
+			}
+			return installPrefetchedArchive(archive, s.PluginRequest{PluginID: archive.PluginID}, resolved[archive.PluginID], c)
+		})
 		if err != nil {
 			return err
 		}
+	}
 
-		err = InstallPlugin(p.Id, "", c)
-		if err != nil {
+	for _, pluginId := range sequential {
+		pluginId := pluginId
+		if err := upgradePlugin(pluginId, journal, c, func() error { return InstallPlugin(pluginId, "", c) }); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return journal.Remove()
+}
+
+func upgradePlugin(pluginId string, journal *s.Journal, c utils.CommandLine, install func() error) error {
+	pluginsDir := c.PluginDirectory()
+	logger.Infof("Updating %v \n", pluginId)
+
+	// a failed install restores the previous version, which stays planned
+	if err := reinstallPlugin(pluginsDir, pluginId, install); err != nil {
+		return err
+	}
+
+	installed, err := s.ReadPlugin(pluginsDir, pluginId)
+	if err != nil {
+		return err
+	}
+	return journal.Complete(pluginId, installed.Info.Version)
+}
+
+// archiveRequest resolves the latest version of a plugin for the download
+// pipeline. Plugins it can not verify up front are left to the regular install.
+func archiveRequest(pluginId string, c utils.CommandLine) (s.ArchiveRequest, s.Resolution, bool) {
+	res, err := s.Resolve(c.RepoDirectory(), s.PluginRequest{PluginID: pluginId})
+	if err != nil || res.Checksum == "" || isTarArchive(res.URL) {
+		return s.ArchiveRequest{}, s.Resolution{}, false
+	}
+
+	return s.ArchiveRequest{PluginID: pluginId, Version: res.Version.Version, URL: res.URL, Checksum: res.Checksum}, res, true
 }
@@ -1,9 +1,17 @@
 package commands
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/commands/commandstest"
 	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -43,4 +51,69 @@ func TestVersionComparsion(t *testing.T) {
 			}
 		})
 	})
+
+	Convey("Validate that yanked and excluded versions are ignored", t, func() {
+		s.SetVersionExclusions(map[string][]string{"excluded-panel": {"2.0.0"}})
+		defer s.SetVersionExclusions(nil)
+
+		So(ShouldUpgrade("1.1.1", m.Plugin{Id: "yanked-panel", Versions: []m.Version{{Version: "2.0.0", Yanked: true}, {Version: "1.1.1"}}}), ShouldBeFalse)
+		So(ShouldUpgrade("1.1.1", m.Plugin{Id: "excluded-panel", Versions: []m.Version{{Version: "2.0.0"}, {Version: "1.1.1"}}}), ShouldBeFalse)
+		So(ShouldUpgrade("1.1.1", m.Plugin{Id: "other-panel", Versions: []m.Version{{Version: "2.0.0"}, {Version: "1.1.1"}}}), ShouldBeTrue)
+	})
+}
+
+func TestUpgradePlugin(t *testing.T) {
+	Convey("Upgrading a plugin", t, func() {
+		prevIoHelper := s.IoHelper
+		s.IoHelper = s.IoUtilImp{}
+		defer func() { s.IoHelper = prevIoHelper }()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		So(os.MkdirAll(filepath.Join(dir, "old-panel"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "old-panel", "plugin.json"), []byte(`{"id": "old-panel", "info": {"version": "1.0.0"}}`), 0644), ShouldBeNil)
+
+		journal, err := s.OpenJournal(filepath.Join(dir, upgradeAllJournal))
+		So(err, ShouldBeNil)
+		_, err = journal.Plan([]string{"old-panel"})
+		So(err, ShouldBeNil)
+		c := &commandstest.FakeCommandLine{
+			LocalFlags:  &commandstest.FakeFlagger{Data: map[string]interface{}{}},
+			GlobalFlags: &commandstest.FakeFlagger{Data: map[string]interface{}{"pluginsDir": dir}},
+		}
+
+		Convey("keeps the installed version when the install fails", func() {
+			err := upgradePlugin("old-panel", journal, c, func() error {
+				So(os.MkdirAll(filepath.Join(dir, "old-panel"), 0755), ShouldBeNil)
+				return errors.New("download failed")
+			})
+			So(err, ShouldNotBeNil)
+
+			installed, err := s.ReadPlugin(dir, "old-panel")
+			So(err, ShouldBeNil)
+			So(installed.Info.Version, ShouldEqual, "1.0.0")
+			So(journal.Done("old-panel", "1.0.0"), ShouldBeFalse)
+		})
+
+		Convey("installs bundles fetched ahead plugin by plugin", func() {
+			app := pluginZip(t, `{"id": "suite-app", "info": {"version": "2.0.0"}}`)
+			body := bundleZip(t, map[string][]byte{"suite-app.zip": app})
+			res := s.Resolution{
+				Plugin: m.Plugin{Id: "suite", License: "MIT"},
+				Version: m.Version{Version: "2.0.0", Bundle: []m.BundledPlugin{
+					{Id: "suite-app", Path: "suite-app.zip", Sha256: fmt.Sprintf("%x", sha256.Sum256(app))},
+				}},
+				URL:      "https://example.com/suite.zip",
+				Checksum: fmt.Sprintf("%x", sha256.Sum256(body)),
+			}
+			archive := s.VerifiedArchive{ArchiveRequest: s.ArchiveRequest{PluginID: "suite", Version: "2.0.0", URL: res.URL, Checksum: res.Checksum}, Body: body}
+
+			So(installPrefetchedArchive(archive, s.PluginRequest{PluginID: "suite"}, res, c), ShouldBeNil)
+			installed, err := s.ReadPlugin(dir, "suite-app")
+			So(err, ShouldBeNil)
+			So(installed.Info.Version, ShouldEqual, "2.0.0")
+		})
+	})
 }
@@ -23,6 +23,10 @@ func upgradeCommand(c utils.CommandLine) error {
 		return err2
 	}
 
+	if notice := s.DeprecationNotice(v); notice != "" {
+		logger.Warnf("%s %s\n", color.YellowString("!"), notice)
+	}
+
 	if ShouldUpgrade(localPlugin.Info.Version, v) {
 		s.RemoveInstalledPlugin(pluginsDir, pluginName)
 		return InstallPlugin(pluginName, "", c)
@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	s "github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+)
+
+func verifyCommand(c utils.CommandLine) error {
+	pluginDir := c.PluginDirectory()
+	if err := validateLsCommand(pluginDir); err != nil {
+		return err
+	}
+
+	reports, err := s.VerifyInstalled(context.Background(), c.RepoDirectory(), pluginDir)
+	if err != nil {
+		return err
+	}
+
+	drifted := 0
+	for _, r := range reports {
+		switch {
+		case r.Err != nil:
+			logger.Infof("%s %s @ %s could not be verified: %v\n", color.YellowString("?"), r.PluginID, r.InstalledVersion, r.Err)
+		case r.UnknownVersion:
+			drifted++
+			logger.Infof("%s %s @ %s is not a version published by the repository\n", color.RedString("✗"), r.PluginID, r.InstalledVersion)
+		case r.HasDrift():
+			drifted++
+			logger.Infof("%s %s @ %s differs from the repository\n", color.RedString("✗"), r.PluginID, r.InstalledVersion)
+			printDrift("modified", r.Modified)
+			printDrift("missing", r.Missing)
+			printDrift("added", r.Added)
+		default:
+			logger.Infof("%s %s @ %s\n", color.GreenString("✔"), r.PluginID, r.InstalledVersion)
+		}
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d installed plugins do not match the repository", drifted)
+	}
+
+	return nil
+}
+
+func printDrift(kind string, files []string) {
+	if len(files) > 0 {
+		logger.Infof("    %s: %s\n", kind, strings.Join(files, ", "))
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/grafana/grafana/pkg/cmd/grafana-cli/commands"
@@ -31,22 +35,306 @@ func main() {
 			Value:  utils.GetGrafanaPluginDir(runtime.GOOS),
 			EnvVar: "GF_PLUGIN_DIR",
 		},
+		cli.StringFlag{
+			Name:   "pluginStateFile",
+			Usage:  "file to record when plugins were last checked for updates and installed in, defaults to plugin-state.json next to the plugin directory",
+			EnvVar: "GF_PLUGIN_STATE_FILE",
+		},
 		cli.StringFlag{
 			Name:   "repo",
-			Usage:  "url to the plugin repository",
-			Value:  "https://grafana.com/api/plugins",
+			Usage:  "url to the plugin repository, or to the index.json of a static repository served as plain files",
+			Value:  services.DefaultRepoURL,
 			EnvVar: "GF_PLUGIN_REPO",
 		},
+		cli.StringFlag{
+			Name:   "repoToken",
+			Usage:  "bearer token to authenticate to the plugin repository with, or a reference to it like file:/run/secrets/token or env:NAME",
+			EnvVar: "GF_PLUGIN_REPO_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "repoTokenUrl",
+			Usage:  "OAuth2 token endpoint to obtain repository access tokens from with the client credentials grant, instead of using repoToken",
+			EnvVar: "GF_PLUGIN_REPO_TOKEN_URL",
+		},
+		cli.StringFlag{
+			Name:   "repoClientId",
+			Usage:  "OAuth2 client id used with repoTokenUrl",
+			EnvVar: "GF_PLUGIN_REPO_CLIENT_ID",
+		},
+		cli.StringFlag{
+			Name:   "repoClientSecret",
+			Usage:  "OAuth2 client secret used with repoTokenUrl",
+			EnvVar: "GF_PLUGIN_REPO_CLIENT_SECRET",
+		},
+		cli.StringFlag{
+			Name:   "repoHostTokens",
+			Usage:  "comma separated host=token pairs authenticating mirrors and namespace repositories by host, tokens can be references like file:/run/secrets/token",
+			EnvVar: "GF_PLUGIN_REPO_HOST_TOKENS",
+		},
+		cli.StringFlag{
+			Name:   "repoMirrors",
+			Usage:  "comma separated list of alternate plugin repository urls, preferred when the repository is unhealthy and used when it fails or an archive fails verification",
+			EnvVar: "GF_PLUGIN_REPO_MIRRORS",
+		},
+		cli.StringFlag{
+			Name:   "repoSigV4Region",
+			Usage:  "sign repository requests with AWS Signature Version 4 for this region, for S3-compatible mirrors of private buckets. Credentials are resolved like the AWS SDKs do",
+			EnvVar: "GF_PLUGIN_REPO_SIGV4_REGION",
+		},
+		cli.StringFlag{
+			Name:   "repoSigV4Service",
+			Value:  "s3",
+			Usage:  "service name requests are signed for with repoSigV4Region",
+			EnvVar: "GF_PLUGIN_REPO_SIGV4_SERVICE",
+		},
+		cli.StringFlag{
+			Name:   "repoSigV4Hosts",
+			Usage:  "comma separated glob patterns of hosts requests are signed for, the hosts of repoMirrors by default",
+			EnvVar: "GF_PLUGIN_REPO_SIGV4_HOSTS",
+		},
+		cli.StringFlag{
+			Name:   "repoDiscovery",
+			Usage:  "discover further mirrors, refreshed while auto-updating, e.g. srv:_grafana-plugins._tcp.example.com for the targets of its DNS SRV records",
+			EnvVar: "GF_PLUGIN_REPO_DISCOVERY",
+		},
+		cli.StringFlag{
+			Name:   "repoNamespaces",
+			Usage:  "comma separated list of name=url repositories plugins can be installed from as <name>/<plugin id>",
+			EnvVar: "GF_PLUGIN_REPO_NAMESPACES",
+		},
+		cli.StringFlag{
+			Name:   "trustedPublishers",
+			Usage:  "comma separated list of grafana.com organizations whose plugins may be installed, empty trusts all",
+			EnvVar: "GF_PLUGIN_TRUSTED_PUBLISHERS",
+		},
+		cli.StringFlag{
+			Name:   "allowedLicenses",
+			Usage:  "comma separated list of SPDX license ids plugins may be installed under, empty allows all",
+			EnvVar: "GF_PLUGIN_ALLOWED_LICENSES",
+		},
+		cli.BoolFlag{
+			Name:  "allowUnknownLicense",
+			Usage: "install plugins without license information when allowedLicenses is set",
+		},
+		cli.StringSliceFlag{
+			Name:  "excludeVersion",
+			Usage: "never install a plugin version, e.g. grafana-clock-panel@1.0.1 or \"grafana-clock-panel@>=1.0.0, <1.0.3\", can be repeated",
+		},
+		cli.StringFlag{
+			Name:   "downloadInstanceId",
+			Usage:  "opaque id sent with archive downloads so mirrors can deduplicate install analytics, never sent to grafana.com unless listed in downloadInstanceIdHosts",
+			EnvVar: "GF_PLUGIN_DOWNLOAD_INSTANCE_ID",
+		},
+		cli.StringFlag{
+			Name:   "downloadInstanceIdHosts",
+			Usage:  "comma separated host patterns, e.g. *.example.com, to send downloadInstanceId to",
+			EnvVar: "GF_PLUGIN_DOWNLOAD_INSTANCE_ID_HOSTS",
+		},
+		cli.StringFlag{
+			Name:   "downloadRedirectHosts",
+			Usage:  "comma separated host patterns, e.g. *.grafana.com, archives may be downloaded from, including redirects",
+			EnvVar: "GF_PLUGIN_DOWNLOAD_REDIRECT_HOSTS",
+		},
+		cli.IntFlag{
+			Name:   "downloadMaxRedirects",
+			Usage:  "maximum number of redirects followed by archive downloads, 0 uses the default of 10",
+			EnvVar: "GF_PLUGIN_DOWNLOAD_MAX_REDIRECTS",
+		},
+		cli.BoolFlag{
+			Name:   "downloadSameSchemeRedirects",
+			Usage:  "reject archive download redirects changing the scheme, e.g. from https to http",
+			EnvVar: "GF_PLUGIN_DOWNLOAD_SAME_SCHEME_REDIRECTS",
+		},
+		cli.StringFlag{
+			Name:   "repoFixtures",
+			Usage:  "serve plugin repository requests from a fixture directory instead of the network, for testing",
+			EnvVar: "GF_PLUGIN_REPO_FIXTURES",
+		},
 		cli.StringFlag{
 			Name:   "pluginUrl",
 			Usage:  "Full url to the plugin zip file instead of downloading the plugin from grafana.com/api",
 			Value:  "",
 			EnvVar: "GF_PLUGIN_URL",
 		},
+		cli.IntFlag{
+			Name:   "pluginsDirQuota",
+			Usage:  "maximum size of the plugin directory in megabytes, 0 disables the quota",
+			EnvVar: "GF_PLUGIN_DIR_QUOTA",
+		},
+		cli.IntFlag{
+			Name:   "maxExtractedSize",
+			Value:  int(services.DefaultExtractLimits.MaxTotalSize >> 20),
+			Usage:  "maximum size a plugin archive may decompress to in megabytes, 0 disables the limit",
+			EnvVar: "GF_PLUGIN_MAX_EXTRACTED_SIZE",
+		},
+		cli.Float64Flag{
+			Name:   "maxCompressionRatio",
+			Value:  services.DefaultExtractLimits.MaxRatio,
+			Usage:  "maximum ratio an archive entry may decompress by, against zip bombs, 0 disables the limit",
+			EnvVar: "GF_PLUGIN_MAX_COMPRESSION_RATIO",
+		},
+		cli.StringFlag{
+			Name:  "pluginsDirQuotaPolicy",
+			Usage: "what to do when an install exceeds the plugin directory quota: refuse or evict-oldest",
+			Value: "refuse",
+		},
+		cli.StringFlag{
+			Name:   "repoTLSMinVersion",
+			Usage:  "lowest TLS version accepted from the repository and download hosts, e.g. 1.2 or 1.3",
+			EnvVar: "GF_PLUGIN_REPO_TLS_MIN_VERSION",
+		},
+		cli.StringFlag{
+			Name:   "repoTLSCipherSuites",
+			Usage:  "comma separated list of accepted TLS 1.2 cipher suites, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			EnvVar: "GF_PLUGIN_REPO_TLS_CIPHER_SUITES",
+		},
+		cli.BoolFlag{
+			Name:  "repoStrict",
+			Usage: "reject repository responses with unknown or missing fields, to test mirrors",
+		},
 		cli.BoolFlag{
 			Name:  "insecure",
 			Usage: "Skip TLS verification (insecure)",
 		},
+		cli.StringSliceFlag{
+			Name:  "repoTLS",
+			Usage: "TLS settings of one repository host, e.g. \"https://mirror.example.com/api/plugins,ca=/etc/ssl/mirror-ca.pem,cert=client.pem,key=client-key.pem\" or \"<url>,insecure\", can be repeated",
+		},
+		cli.StringFlag{
+			Name:   "pluginChecksum",
+			Usage:  "expected sha256 checksum of the archive given by pluginUrl, or of the plugin version being installed. Installs fail if the repository publishes another one",
+			EnvVar: "GF_PLUGIN_CHECKSUM",
+		},
+		cli.BoolFlag{
+			Name:  "allowUnverified",
+			Usage: "Allow installing plugins that have no checksum to verify the archive against (insecure)",
+		},
+		cli.IntFlag{
+			Name:   "repoMaxConcurrent",
+			Usage:  "maximum number of concurrent repository requests, serving installs before mirror syncs, prefetches and update checks. 0 disables the limit",
+			Value:  4,
+			EnvVar: "GF_PLUGIN_REPO_MAX_CONCURRENT",
+		},
+		cli.IntFlag{
+			Name:   "retryAttempts",
+			Usage:  "how often repository requests are sent at most, 1 disables retries",
+			Value:  services.DefaultRetryPolicy.MaxAttempts,
+			EnvVar: "GF_PLUGIN_RETRY_ATTEMPTS",
+		},
+		cli.StringFlag{
+			Name:   "retryStatuses",
+			Usage:  "comma separated HTTP statuses repository requests are retried on, e.g. \"409,429,5xx\". Defaults to 408, 429, 502, 503 and 504",
+			EnvVar: "GF_PLUGIN_RETRY_STATUSES",
+		},
+		cli.StringFlag{
+			Name:   "retryErrors",
+			Usage:  "comma separated classes of transport errors repository requests are retried on: dns, tls, timeout, network. Defaults to timeout and network",
+			EnvVar: "GF_PLUGIN_RETRY_ERRORS",
+		},
+		cli.StringFlag{
+			Name:   "logLevels",
+			Usage:  "comma separated least severe levels logged per operation, e.g. \"download=warn,mirror-sync=info\". Errors are always logged",
+			EnvVar: "GF_PLUGIN_LOG_LEVELS",
+		},
+		cli.StringFlag{
+			Name:   "logSampling",
+			Usage:  "comma separated sample rates of debug and info lines per operation, e.g. \"mirror-sync=100\" logs every 100th mirrored version. Warnings and errors are never sampled",
+			EnvVar: "GF_PLUGIN_LOG_SAMPLING",
+		},
+		cli.IntFlag{
+			Name:   "repoVersionsPageSize",
+			Usage:  "fetch plugin versions in pages of this size, newest first, and older pages only when needed. 0 fetches all versions at once",
+			EnvVar: "GF_PLUGIN_REPO_VERSIONS_PAGE_SIZE",
+		},
+		cli.BoolFlag{
+			Name:  "refuseDeprecated",
+			Usage: "Refuse to install plugins that are deprecated or end of life",
+		},
+		cli.StringFlag{
+			Name:   "archiveMaxSize",
+			Usage:  "maximum size of plugin archives, e.g. 512MiB, 0 for unlimited. Plugins shipping heavy artifacts like the image renderer are allowed 4GiB",
+			Value:  "512MiB",
+			EnvVar: "GF_PLUGIN_ARCHIVE_MAX_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "archiveTimeout",
+			Usage:  "maximum duration of plugin archive downloads, e.g. 10m, 0 for unlimited. Plugins shipping heavy artifacts are allowed 1h",
+			Value:  "10m",
+			EnvVar: "GF_PLUGIN_ARCHIVE_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "staleMetadataMaxAge",
+			Usage:  "serve cached plugin metadata up to this old, e.g. 24h or 7d, while the plugin repository is unreachable",
+			EnvVar: "GF_PLUGIN_STALE_METADATA_MAX_AGE",
+		},
+		cli.StringFlag{
+			Name:   "repoIndexKeys",
+			Usage:  "comma separated base64 ed25519 public keys, or files holding them, the repository index must be signed with. For file and S3 mirrors",
+			EnvVar: "GF_PLUGIN_REPO_INDEX_KEYS",
+		},
+		cli.StringFlag{
+			Name:   "pluginArch",
+			Usage:  "comma separated arch keys to install backend binaries for, most specific first, e.g. linux-arm64-musl,linux-arm64. Detected by default",
+			EnvVar: "GF_PLUGIN_ARCH",
+		},
+		cli.BoolFlag{
+			Name:  "frontendOnly",
+			Usage: "Prefer the frontend-only archive of a plugin when it publishes one",
+		},
+		cli.BoolFlag{
+			Name:   "slimArtifacts",
+			Usage:  "Prefer the slim archive of plugins bundling heavy dependencies, e.g. the image renderer without Chromium, when one is published",
+			EnvVar: "GF_PLUGIN_SLIM_ARTIFACTS",
+		},
+		cli.StringFlag{
+			Name:   "grafanaEdition",
+			Usage:  "edition of the Grafana instance, oss, enterprise or cloud. Versions restricted to other editions are not installed",
+			EnvVar: "GF_EDITION",
+		},
+		cli.StringFlag{
+			Name:  "entitlements",
+			Usage: "comma separated licensed features of the Grafana instance, required by some plugin versions",
+		},
+		cli.StringFlag{
+			Name:   "archiveStore",
+			Usage:  "directory to keep verified plugin archives in, stored once per unique archive",
+			EnvVar: "GF_PLUGIN_ARCHIVE_STORE",
+		},
+		cli.StringFlag{
+			Name:   "archiveEncryptionKey",
+			Usage:  "base64 AES key to encrypt stored archives with, or a secret reference to it like \"file:/run/secrets/archive-key\"",
+			EnvVar: "GF_PLUGIN_ARCHIVE_ENCRYPTION_KEY",
+		},
+		cli.BoolFlag{
+			Name:   "verifyArchiveWrites",
+			Usage:  "read stored archives back after writing them and compare their digest, for unreliable storage",
+			EnvVar: "GF_PLUGIN_VERIFY_ARCHIVE_WRITES",
+		},
+		cli.StringFlag{
+			Name:  "archiveMaxAge",
+			Usage: "prune archives from the archive store that were last stored longer ago than this, e.g. 90d",
+		},
+		cli.IntFlag{
+			Name:  "archiveKeepVersions",
+			Usage: "number of versions per plugin to keep in the archive store, older versions are pruned",
+		},
+		cli.StringFlag{
+			Name:  "sbom",
+			Usage: "directory to write a software bill of materials document for every installed plugin into",
+		},
+		cli.StringFlag{
+			Name:  "sbomFormat",
+			Usage: "format of the SBOM documents, cyclonedx or spdx",
+			Value: string(services.SBOMCycloneDX),
+		},
+		cli.StringFlag{
+			Name:  "verificationReport",
+			Usage: "write a JSON verification report for each install, either \"plugin\" to store it in the plugin directory or a directory to collect them in",
+		},
+		cli.StringFlag{
+			Name:  "requestId",
+			Usage: "correlation id sent with every repository request of this run, random by default",
+		},
 		cli.BoolFlag{
 			Name:  "debug, d",
 			Usage: "enable debug logging",
@@ -66,8 +354,220 @@ func main() {
 	}
 
 	app.Before = func(c *cli.Context) error {
-		services.Init(version, c.GlobalBool("insecure"))
-		return nil
+		opts := []services.Option{services.WithRepoURL(c.GlobalString("repo"))}
+		if c.GlobalString("repoTokenUrl") != "" && c.GlobalString("repoToken") != "" {
+			return fmt.Errorf("repoToken and repoTokenUrl cannot be used together, tokens are obtained from repoTokenUrl")
+		}
+		if tokenURL := c.GlobalString("repoTokenUrl"); tokenURL != "" {
+			creds := services.NewClientCredentials(c.GlobalString("repo"), tokenURL, c.GlobalString("repoClientId"), c.GlobalString("repoClientSecret"))
+			opts = append(opts, services.WithCredentialsProvider(creds))
+		} else if token := c.GlobalString("repoToken"); services.IsSecretRef(token) {
+			creds := &services.SecretCredentials{RepoURL: c.GlobalString("repo"), Token: token}
+			opts = append(opts, services.WithCredentialsProvider(creds))
+		}
+		if list := c.GlobalString("repoHostTokens"); list != "" {
+			tokens, err := services.ParseHostCredentials(strings.Split(list, ","))
+			if err != nil {
+				return err
+			}
+			opts = append(opts, services.WithHostCredentials(tokens))
+		}
+		if min := c.GlobalString("repoTLSMinVersion"); min != "" || c.GlobalString("repoTLSCipherSuites") != "" {
+			var policy services.TLSPolicy
+			if min != "" {
+				v, err := services.ParseTLSVersion(min)
+				if err != nil {
+					return err
+				}
+				policy.MinVersion = v
+			}
+			if list := c.GlobalString("repoTLSCipherSuites"); list != "" {
+				suites, err := services.ParseCipherSuites(strings.Split(list, ","))
+				if err != nil {
+					return err
+				}
+				policy.CipherSuites = suites
+			}
+			opts = append(opts, services.WithTLSPolicy(policy))
+		}
+		if c.GlobalBool("repoStrict") {
+			opts = append(opts, services.WithStrictDecoding())
+		}
+		if dir := c.GlobalString("repoFixtures"); dir != "" {
+			fixtures, err := services.WithFixtures(dir)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, fixtures)
+		}
+
+		services.Init(version, c.GlobalBool("insecure"), opts...)
+		requestId := c.GlobalString("requestId")
+		if requestId == "" {
+			requestId = services.NewRequestID()
+		}
+		logger.Debugf("repository request id: %v\n", requestId)
+		services.SetRequestID(requestId)
+		repoTLS, err := services.ParseRepoTLS(c.GlobalStringSlice("repoTLS"))
+		if err != nil {
+			return err
+		}
+		if err := services.SetRepoTLS(repoTLS); err != nil {
+			return err
+		}
+		if token := c.GlobalString("repoToken"); token != "" && !services.IsSecretRef(token) {
+			services.UpdateCredentials(c.GlobalString("repo"), services.Credentials{Token: token})
+		}
+		if limit := c.GlobalInt("repoMaxConcurrent"); limit > 0 {
+			services.Use(services.NewScheduler(limit).Middleware())
+		}
+		if id := c.GlobalString("downloadInstanceId"); id != "" {
+			var hosts []string
+			if list := c.GlobalString("downloadInstanceIdHosts"); list != "" {
+				hosts = splitList(list)
+			}
+			services.Use(services.InstanceIDMiddleware(id, hosts))
+		}
+		redirects := services.RedirectPolicy{MaxRedirects: c.GlobalInt("downloadMaxRedirects"), SameScheme: c.GlobalBool("downloadSameSchemeRedirects")}
+		if hosts := c.GlobalString("downloadRedirectHosts"); hosts != "" {
+			redirects.AllowedHosts = splitList(hosts)
+		}
+		if redirects.MaxRedirects < 0 {
+			return fmt.Errorf("invalid download max redirects %d", redirects.MaxRedirects)
+		}
+		if redirects.MaxRedirects != 0 || redirects.SameScheme || len(redirects.AllowedHosts) > 0 {
+			services.SetRedirectPolicy(redirects)
+		}
+		if region := c.GlobalString("repoSigV4Region"); region != "" {
+			hosts, err := sigV4Hosts(c)
+			if err != nil {
+				return err
+			}
+			creds, err := services.NewAWSCredentials(region)
+			if err != nil {
+				return err
+			}
+			services.Use(services.SigV4Middleware(creds, region, c.GlobalString("repoSigV4Service"), hosts))
+		}
+		services.SetPreferFrontendOnly(c.GlobalBool("frontendOnly"))
+		services.SetPreferSlimArtifacts(c.GlobalBool("slimArtifacts"))
+		indexKeys, err := services.ParseIndexKeys(c.GlobalString("repoIndexKeys"))
+		if err != nil {
+			return err
+		}
+		services.SetIndexKeys(indexKeys)
+		if arch := c.GlobalString("pluginArch"); arch != "" {
+			services.SetArchOverride(strings.Split(arch, ","))
+		}
+		if name := c.GlobalString("grafanaEdition"); name != "" {
+			edition, err := services.ParseEdition(name)
+			if err != nil {
+				return err
+			}
+			var entitlements []string
+			if list := c.GlobalString("entitlements"); list != "" {
+				entitlements = strings.Split(list, ",")
+			}
+			services.SetEdition(edition, entitlements)
+		}
+		if value := c.GlobalString("archiveEncryptionKey"); value != "" {
+			key, err := services.ParseArchiveKey(value)
+			if err != nil {
+				return err
+			}
+			if err := services.SetArchiveEncryptionKey(key); err != nil {
+				return err
+			}
+		}
+		services.SetArchiveStore(c.GlobalString("archiveStore"))
+		stateFile := c.GlobalString("pluginStateFile")
+		if stateFile == "" {
+			// the plugin directory is in the data path by default, like the
+			// state file of the server
+			stateFile = filepath.Join(filepath.Dir(filepath.Clean(c.GlobalString("pluginsDir"))), services.DefaultPluginStateFile)
+		}
+		services.SetPluginStateFile(stateFile)
+		services.SetVerifyWrites(c.GlobalBool("verifyArchiveWrites"))
+		retention := services.RetentionPolicy{KeepVersions: c.GlobalInt("archiveKeepVersions")}
+		if age := c.GlobalString("archiveMaxAge"); age != "" {
+			maxAge, err := services.ParseRetentionAge(age)
+			if err != nil {
+				return err
+			}
+			retention.MaxAge = maxAge
+		}
+		services.SetArchiveRetention(retention)
+		services.SetRefuseDeprecated(c.GlobalBool("refuseDeprecated"))
+		services.SetVersionsPageSize(c.GlobalInt("repoVersionsPageSize"))
+		maxSize, err := services.ParseArchiveSize(c.GlobalString("archiveMaxSize"))
+		if err != nil {
+			return err
+		}
+		timeout, err := time.ParseDuration(c.GlobalString("archiveTimeout"))
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("invalid archive timeout %q", c.GlobalString("archiveTimeout"))
+		}
+		services.SetDefaultArchiveLimits(services.ArchiveLimits{MaxSize: maxSize, Timeout: timeout})
+		if age := c.GlobalString("staleMetadataMaxAge"); age != "" {
+			maxAge, err := services.ParseRetentionAge(age)
+			if err != nil {
+				return err
+			}
+			services.SetStaleMetadataMaxAge(maxAge)
+		}
+		exclusions := map[string][]string{}
+		for _, value := range c.GlobalStringSlice("excludeVersion") {
+			id, exclusion, err := services.ParseVersionExclusion(value)
+			if err != nil {
+				return err
+			}
+			exclusions[id] = append(exclusions[id], exclusion)
+		}
+		services.SetVersionExclusions(exclusions)
+		ns, err := services.ParseNamespaces(c.GlobalString("repoNamespaces"))
+		if err != nil {
+			return err
+		}
+		services.SetNamespaces(ns)
+		retries := services.DefaultRetryPolicy
+		retries.MaxAttempts = c.GlobalInt("retryAttempts")
+		if value := c.GlobalString("retryStatuses"); value != "" {
+			if retries.Statuses, err = services.ParseRetryStatuses(value); err != nil {
+				return err
+			}
+		}
+		if value := c.GlobalString("retryErrors"); value != "" {
+			if retries.Classes, err = services.ParseErrorClasses(value); err != nil {
+				return err
+			}
+		}
+		services.SetRetryPolicy(retries)
+		opLogging, err := services.ParseOperationLogging(c.GlobalString("logLevels"), c.GlobalString("logSampling"))
+		if err != nil {
+			return err
+		}
+		for op, cfg := range opLogging {
+			services.SetOperationLogging(op, cfg)
+		}
+		services.SetFailoverRepos(strings.Split(c.GlobalString("repoMirrors"), ","))
+		if value := c.GlobalString("repoDiscovery"); value != "" {
+			provider, err := services.ParseRepoProvider(value)
+			if err != nil {
+				return err
+			}
+			services.SetRepoDiscovery(services.NewRepoDiscovery(provider, strings.Split(c.GlobalString("repoMirrors"), ",")))
+		}
+		if licenses := c.GlobalString("allowedLicenses"); licenses != "" {
+			services.SetLicensePolicy(services.LicensePolicy{Allowed: strings.Split(licenses, ","), AllowUnknown: c.GlobalBool("allowUnknownLicense")})
+		}
+		if publishers := c.GlobalString("trustedPublishers"); publishers != "" {
+			services.SetTrustPolicy(services.TrustPolicy{TrustedPublishers: strings.Split(publishers, ",")})
+		}
+		services.SetExtractLimits(services.ExtractLimits{
+			MaxTotalSize: int64(c.GlobalInt("maxExtractedSize")) * 1024 * 1024,
+			MaxRatio:     c.GlobalFloat64("maxCompressionRatio"),
+		})
+		return services.SetPluginDirQuota(int64(c.GlobalInt("pluginsDirQuota"))*1024*1024, services.QuotaPolicy(c.GlobalString("pluginsDirQuotaPolicy")))
 	}
 	app.Commands = commands.Commands
 	app.CommandNotFound = cmdNotFound
@@ -95,3 +595,36 @@ func cmdNotFound(c *cli.Context, command string) {
 	)
 	os.Exit(1)
 }
+
+// sigV4Hosts returns the hosts repository requests are signed for. The repo
+// is grafana.com unless configured otherwise, so only the mirrors are signed
+// for by default and a signed repo has to be listed in repoSigV4Hosts.
+func sigV4Hosts(c *cli.Context) ([]string, error) {
+	if hosts := splitList(c.GlobalString("repoSigV4Hosts")); len(hosts) > 0 {
+		return hosts, nil
+	}
+
+	var hosts []string
+	for _, repo := range splitList(c.GlobalString("repoMirrors")) {
+		u, err := url.Parse(repo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository url %q: %v", repo, err)
+		}
+		hosts = append(hosts, u.Hostname())
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("repoSigV4Region needs repoSigV4Hosts or repoMirrors to sign requests for")
+	}
+	return hosts, nil
+}
+
+// splitList splits a comma separated flag value, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/json"
 	"os"
+	"time"
 )
 
 type InstalledPlugin struct {
@@ -9,6 +11,10 @@ type InstalledPlugin struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
 
+	// Backend plugins ship Executable, built as <executable>_<os>_<arch>.
+	Backend    bool   `json:"backend"`
+	Executable string `json:"executable"`
+
 	Info         PluginInfo   `json:"info"`
 	Dependencies Dependencies `json:"dependencies"`
 }
@@ -24,20 +30,89 @@ type PluginInfo struct {
 }
 
 type Plugin struct {
-	Id       string    `json:"id"`
-	Category string    `json:"category"`
-	Versions []Version `json:"versions"`
+	Id                 string    `json:"id"`
+	Category           string    `json:"category"`
+	OrgSlug            string    `json:"orgSlug"`
+	Versions           []Version `json:"versions"`
+	Deprecated         bool      `json:"deprecated"`
+	EndOfLife          bool      `json:"endOfLife"`
+	DeprecationMessage string    `json:"deprecationMessage"`
+	// ReplacedBy is the id of the plugin succeeding a deprecated one.
+	ReplacedBy string `json:"replacedBy"`
+	// License is the SPDX license expression of the plugin, e.g. "Apache-2.0".
+	License string `json:"license"`
+
+	// Downloads, Popularity, Rating and UpdatedAt are published by
+	// repositories for catalogs to sort and filter by, zero when unknown.
+	Downloads  int64     `json:"downloads"`
+	Popularity float64   `json:"popularity"`
+	Rating     float64   `json:"rating"`
+	UpdatedAt  Timestamp `json:"updatedAt"`
+	// SignatureType is who signed the plugin, e.g. "grafana", "commercial"
+	// or "community". Empty for unsigned plugins.
+	SignatureType string `json:"signatureType"`
+
+	// NextVersionsPage links the next, older, page of versions when the
+	// repository returned them paginated.
+	NextVersionsPage string `json:"nextVersionsPage,omitempty"`
 }
 
 type Version struct {
-	Commit  string `json:"commit"`
-	Url     string `json:"url"`
-	Version string `json:"version"`
+	Commit     string              `json:"commit"`
+	Url        string              `json:"url"`
+	Version    string              `json:"version"`
+	Arch       map[string]ArchMeta `json:"arch"`
+	Yanked     bool                `json:"yanked"`
+	YankReason string              `json:"yankReason"`
+	CreatedAt  Timestamp           `json:"createdAt"`
+	Extras     map[string]Extra    `json:"extras"`
+	// GrafanaDependency is the version constraint on Grafana, e.g. ">=6.3.0".
+	GrafanaDependency string `json:"grafanaDependency"`
+	// Editions restricts the version to Grafana editions, e.g. "enterprise".
+	// Empty means it is available for all of them.
+	Editions []string `json:"editions,omitempty"`
+	// Entitlements are the licensed features the version requires.
+	Entitlements []string `json:"entitlements,omitempty"`
+	// Backend is set when the version ships a backend binary.
+	Backend bool `json:"backend"`
+	// Permissions are the Grafana permissions the plugin requires, e.g.
+	// "datasources:read".
+	Permissions []string `json:"permissions,omitempty"`
+	// Bundle lists the plugins of a suite shipped as one archive. The
+	// archive of such a version holds an archive per plugin.
+	Bundle []BundledPlugin `json:"bundle,omitempty"`
+}
+
+// BundledPlugin is a plugin archive inside a bundle archive.
+type BundledPlugin struct {
+	Id string `json:"id"`
+	// Path is the name of the plugin archive within the bundle.
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+}
+
+// Extra is an optional archive published alongside a plugin version, e.g.
+// sample dashboards, extracted into the plugin directory when selected.
+type Extra struct {
+	Description string `json:"description"`
+	Url         string `json:"url"`
+	Sha256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+}
+
+type ArchMeta struct {
+	Md5    string `json:"md5"`
+	Sha256 string `json:"sha256"`
+	Url    string `json:"url"`
+	Size   int64  `json:"size"`
 }
 
 type PluginRepo struct {
 	Plugins []Plugin `json:"plugins"`
 	Version string   `json:"version"`
+	// Stale is set when the listing expired but was served anyway because
+	// the repository was unreachable.
+	Stale bool `json:"-"`
 }
 
 type IoUtil interface {
@@ -46,3 +121,35 @@ type IoUtil interface {
 	ReadDir(path string) ([]os.FileInfo, error)
 	ReadFile(filename string) ([]byte, error)
 }
+
+// Timestamp is a time published by the repository. Repositories publish
+// empty strings or other formats than RFC3339 for unknown or imported dates,
+// the values that cannot be parsed are zero rather than failing the whole
+// response.
+type Timestamp struct {
+	time.Time
+}
+
+// timestampLayouts are tried in order, after RFC3339.
+var timestampLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	t.Time = time.Time{}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil || value == "" {
+		return nil
+	}
+
+	if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		t.Time = parsed
+		return nil
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// ClientApiVersion is the newest plugin repository API version this
+	// client understands. The client only uses the endpoints of the legacy
+	// API, newer versions are announced once it uses their endpoints.
+	ClientApiVersion = apiVersionLegacy
+
+	apiVersionHeader = "X-Grafana-Plugins-Api-Version"
+	apiVersionLegacy = 1
+)
+
+var apiVersions = struct {
+	sync.RWMutex
+	byRepo map[string]int
+}{byRepo: map[string]int{}}
+
+// ApiVersion returns the API version negotiated with the repository. Repositories
+// that have not been contacted yet, or that do not announce a version, are
+// treated as serving the legacy API.
+func ApiVersion(repoUrl string) int {
+	apiVersions.RLock()
+	defer apiVersions.RUnlock()
+
+	if v, ok := apiVersions.byRepo[repoKey(repoUrl)]; ok {
+		return v
+	}
+	return apiVersionLegacy
+}
+
+// doNegotiated sends an API request to the repository at repoUrl, its base
+// url. It announces the client API version and records the version the
+// repository answers with. Older mirrors that reject the announced version
+// with 406 Not Acceptable are retried once without it.
+func doNegotiated(repoUrl string, req *RepoRequest) (*http.Response, error) {
+	req.Request.Header.Set("Accept", "application/json")
+	req.Request.Header.Set(apiVersionHeader, strconv.Itoa(ClientApiVersion))
+
+	start := getClock().Now()
+	res, err := do(&HttpClient, req)
+	if err == nil && res.StatusCode == http.StatusNotAcceptable {
+		res.Body.Close()
+		req.Request.Header.Del(apiVersionHeader)
+		res, err = do(&HttpClient, req)
+	}
+	recordHealth(repoUrl, getClock().Since(start), res, err)
+
+	if err != nil {
+		return res, err
+	}
+
+	version := apiVersionLegacy
+	if v, parseErr := strconv.Atoi(res.Header.Get(apiVersionHeader)); parseErr == nil && v > 0 {
+		version = v
+		if version > ClientApiVersion {
+			version = ClientApiVersion
+		}
+	}
+
+	apiVersions.Lock()
+	apiVersions.byRepo[repoKey(repoUrl)] = version
+	apiVersions.Unlock()
+
+	return res, nil
+}
+
+// doRepo sends a request for a file of the repository at repoUrl that is not
+// part of its API, like an index signature, without negotiating.
+func doRepo(repoUrl string, req *RepoRequest) (*http.Response, error) {
+	start := getClock().Now()
+	res, err := do(&HttpClient, req)
+	recordHealth(repoUrl, getClock().Since(start), res, err)
+	return res, err
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApiVersionNegotiation(t *testing.T) {
+	Convey("The API version is negotiated with the repository", t, func() {
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+		defer ResetRepoHealth()
+
+		var mtx sync.Mutex
+		headers := map[string]http.Header{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mtx.Lock()
+			headers[r.URL.Path] = r.Header
+			mtx.Unlock()
+
+			switch r.URL.Path {
+			case "/repo/negotiated-panel":
+				w.Header().Set(apiVersionHeader, strconv.Itoa(ClientApiVersion+1))
+				w.Write([]byte(`{"id": "negotiated-panel", "versions": [{"version": "1.0.0"}]}`))
+			case "/legacy/repo/legacy-panel":
+				if r.Header.Get(apiVersionHeader) != "" {
+					w.WriteHeader(http.StatusNotAcceptable)
+					return
+				}
+				w.Write([]byte(`{"id": "legacy-panel", "versions": [{"version": "1.0.0"}]}`))
+			case "/download/SHA256SUMS":
+				w.Write([]byte("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  negotiated-panel.zip\n"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		_, err := GetPluginWithContext(context.Background(), "negotiated-panel", server.URL+"/")
+		So(err, ShouldBeNil)
+		So(headers["/repo/negotiated-panel"].Get("Accept"), ShouldEqual, "application/json")
+
+		Convey("per repository base url, capped at the client version", func() {
+			So(ApiVersion(server.URL), ShouldEqual, ClientApiVersion)
+			So(ApiVersion(server.URL+"/"), ShouldEqual, ClientApiVersion)
+		})
+
+		Convey("retrying without the version for older mirrors", func() {
+			_, err := GetPluginWithContext(context.Background(), "legacy-panel", server.URL+"/legacy")
+			So(err, ShouldBeNil)
+			So(ApiVersion(server.URL+"/legacy"), ShouldEqual, apiVersionLegacy)
+		})
+
+		Convey("but not for files next to the archives", func() {
+			checksum, err := getSidecarChecksum(context.Background(), "negotiated-panel", server.URL+"/download/negotiated-panel.zip")
+			So(err, ShouldBeNil)
+			So(checksum, ShouldStartWith, "e3b0c442")
+
+			sums := headers["/download/SHA256SUMS"]
+			So(sums, ShouldNotBeNil)
+			So(sums.Get("Accept"), ShouldNotEqual, "application/json")
+			So(sums.Get(apiVersionHeader), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+var ErrWriteVerification = errors.New("archive read back from disk differs from the one written")
+
+// ArchiveStore keeps downloaded archives content-addressed by their sha256
+// digest. Every plugin version links to its blob, so repeated upgrades or
+// several plugin directories referencing the same bytes share disk usage.
+//
+//	<dir>/blobs/sha256/<digest>.zip
+//	<dir>/<plugin id>/<version>.zip -> blobs/sha256/<digest>.zip
+type ArchiveStore struct {
+	Dir string
+	// Key, if set, encrypts the blobs, see SetArchiveEncryptionKey. Blobs
+	// keep the name of the digest of the plain archive.
+	Key []byte
+}
+
+var archiveStore *ArchiveStore
+
+// SetArchiveStore enables storing verified archives in dir, an empty dir disables it.
+func SetArchiveStore(dir string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	if dir == "" {
+		archiveStore = nil
+		return
+	}
+
+	archiveStore = &ArchiveStore{Dir: dir, Key: archiveKey}
+}
+
+var verifyWrites bool
+
+// SetVerifyWrites makes archive writes read the archive back after the final
+// rename and compare its digest, for storage like NFS or SD cards that was
+// seen corrupting archives after they were verified. Writes are synced to
+// disk either way.
+func SetVerifyWrites(enabled bool) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	verifyWrites = enabled
+}
+
+func getVerifyWrites() bool {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return verifyWrites
+}
+
+func getArchiveStore() *ArchiveStore {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return archiveStore
+}
+
+// StoreArchive adds the archive to the configured archive store, if any.
+func StoreArchive(pluginId, version string, body []byte) error {
+	store := getArchiveStore()
+	if store == nil || version == "" {
+		return nil
+	}
+
+	if _, err := store.Put(pluginId, version, body); err != nil {
+		return err
+	}
+
+	policy := getArchiveRetention()
+	if !policy.enabled() {
+		return nil
+	}
+
+	report, err := store.Prune(policy, false)
+	if len(report.Archives) > 0 || len(report.Blobs) > 0 {
+		log.Debugf("pruned %d archives and %d blobs from the archive store\n", len(report.Archives), len(report.Blobs))
+	}
+	return err
+}
+
+// Put stores body and links it to the plugin version, returning the digest.
+func (s *ArchiveStore) Put(pluginId, version string, body []byte) (string, error) {
+	digest := fmt.Sprintf("%x", sha256.Sum256(body))
+	blob := s.blobPath(digest)
+
+	if existing, err := s.readBlob(blob); err != nil || fmt.Sprintf("%x", sha256.Sum256(existing)) != digest {
+		// missing, or corrupted since it was stored
+		sealed, err := s.seal(body)
+		if err != nil {
+			return "", err
+		}
+		if err := writeArchive(blob, sealed); err != nil {
+			return "", err
+		}
+	} else {
+		// the modification time tells the janitor when an archive was last stored
+		now := getClock().Now()
+		os.Chtimes(blob, now, now)
+	}
+
+	link := s.versionPath(pluginId, version)
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return "", err
+	}
+
+	os.Remove(link)
+	if err := os.Link(blob, link); err != nil {
+		// hardlinks fail across devices and on some filesystems
+		rel, relErr := filepath.Rel(filepath.Dir(link), blob)
+		if relErr != nil {
+			return "", relErr
+		}
+		if err := os.Symlink(rel, link); err != nil {
+			return "", err
+		}
+	}
+
+	return digest, nil
+}
+
+// Get returns the stored archive of a plugin version. The content is
+// checked against the digest of the blob it links to, archives torn by a
+// crash, corrupted on disk or not decrypting with the key of the store are
+// discarded and reported as missing.
+func (s *ArchiveStore) Get(pluginId, version string) ([]byte, bool) {
+	link := s.versionPath(pluginId, version)
+	data, err := ioutil.ReadFile(link)
+	if err != nil {
+		return nil, false
+	}
+
+	body, err := s.open(data)
+	if err != nil || !s.intact(link, body) {
+		log.Warnf("discarding corrupt stored archive of %v@%v\n", pluginId, version)
+		if err := s.discard(link); err != nil {
+			log.Debugf("failed to discard %v: %v\n", link, err)
+		}
+		return nil, false
+	}
+
+	return body, true
+}
+
+// intact reports whether body, read from link, still is the blob named
+// after its digest.
+func (s *ArchiveStore) intact(link string, body []byte) bool {
+	blob, err := os.Stat(s.blobPath(fmt.Sprintf("%x", sha256.Sum256(body))))
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(link)
+	return err == nil && os.SameFile(blob, fi)
+}
+
+// discard removes a corrupt version link together with the blob it links to.
+func (s *ArchiveStore) discard(link string) error {
+	fi, err := os.Stat(link)
+	if err != nil {
+		return os.Remove(link)
+	}
+
+	blobDir := filepath.Join(s.Dir, "blobs", "sha256")
+	blobs, _ := ioutil.ReadDir(blobDir)
+	for _, blob := range blobs {
+		if os.SameFile(blob, fi) {
+			os.Remove(filepath.Join(blobDir, blob.Name()))
+		}
+	}
+
+	return os.Remove(link)
+}
+
+// readBlob reads and decrypts a blob.
+func (s *ArchiveStore) readBlob(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(data)
+}
+
+func (s *ArchiveStore) blobPath(digest string) string {
+	return filepath.Join(s.Dir, "blobs", "sha256", digest+".zip")
+}
+
+func (s *ArchiveStore) versionPath(pluginId, version string) string {
+	return filepath.Join(s.Dir, pluginId, version+".zip")
+}
+
+// writeArchive writes an archive atomically and, with SetVerifyWrites,
+// removes it again when it does not read back as written.
+func writeArchive(path string, body []byte) error {
+	if err := writeFileAtomic(path, body); err != nil {
+		return err
+	}
+	if !getVerifyWrites() {
+		return nil
+	}
+
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(written) != sha256.Sum256(body) {
+		os.Remove(path)
+		return xerrors.Errorf("%s: %w", path, ErrWriteVerification)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// the data has to be on disk before the rename is, or a crash can leave
+	// an empty or partial file under the final name
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir persists renames in dir. Not all platforms support syncing
+// directories, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// CachedArchive returns the stored archive of a plugin version if it matches
+// checksum, so installs can skip the repository entirely.
+func CachedArchive(pluginId, version, checksum string) ([]byte, bool) {
+	store := getArchiveStore()
+	if store == nil || version == "" || checksum == "" {
+		return nil, false
+	}
+
+	body, ok := store.Get(pluginId, version)
+	if !ok {
+		return nil, false
+	}
+
+	if err := VerifyChecksum(body, checksum); err != nil {
+		log.Debugf("ignoring stored archive of %v@%v: %v\n", pluginId, version, err)
+		return nil, false
+	}
+
+	return body, true
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArchiveStore(t *testing.T) {
+	Convey("Archives are stored once per digest", t, func() {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := &ArchiveStore{Dir: dir}
+		digest, err := store.Put("test-plugin", "1.0.0", []byte("plugin archive"))
+		So(err, ShouldBeNil)
+		So(digest, ShouldEqual, "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353")
+
+		_, err = store.Put("test-plugin", "1.0.1", []byte("plugin archive"))
+		So(err, ShouldBeNil)
+
+		blobs, err := ioutil.ReadDir(filepath.Join(dir, "blobs", "sha256"))
+		So(err, ShouldBeNil)
+		So(blobs, ShouldHaveLength, 1)
+
+		body, ok := store.Get("test-plugin", "1.0.1")
+		So(ok, ShouldBeTrue)
+		So(string(body), ShouldEqual, "plugin archive")
+
+		_, ok = store.Get("test-plugin", "2.0.0")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Stored archives are only reused when they match the checksum", t, func() {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		SetArchiveStore(dir)
+		defer SetArchiveStore("")
+
+		So(StoreArchive("test-plugin", "1.0.0", []byte("plugin archive")), ShouldBeNil)
+
+		body, ok := CachedArchive("test-plugin", "1.0.0", "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353")
+		So(ok, ShouldBeTrue)
+		So(string(body), ShouldEqual, "plugin archive")
+
+		_, ok = CachedArchive("test-plugin", "1.0.0", "0000000000000000000000000000000000000000000000000000000000000000")
+		So(ok, ShouldBeFalse)
+
+		_, ok = CachedArchive("test-plugin", "1.0.0", "")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Corrupt archives are discarded when they are loaded", t, func() {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := &ArchiveStore{Dir: dir}
+		digest, err := store.Put("test-plugin", "1.0.0", []byte("plugin archive"))
+		So(err, ShouldBeNil)
+
+		// a torn write, the link and its blob share the truncated content
+		So(ioutil.WriteFile(store.blobPath(digest), []byte("plugin"), 0644), ShouldBeNil)
+
+		_, ok := store.Get("test-plugin", "1.0.0")
+		So(ok, ShouldBeFalse)
+		_, err = os.Stat(store.versionPath("test-plugin", "1.0.0"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(store.blobPath(digest))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		Convey("and replaced by the next store", func() {
+			_, err := store.Put("test-plugin", "1.0.0", []byte("plugin archive"))
+			So(err, ShouldBeNil)
+
+			body, ok := store.Get("test-plugin", "1.0.0")
+			So(ok, ShouldBeTrue)
+			So(string(body), ShouldEqual, "plugin archive")
+		})
+	})
+
+	Convey("Blobs corrupted since they were stored are rewritten", t, func() {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := &ArchiveStore{Dir: dir}
+		digest, err := store.Put("test-plugin", "1.0.0", []byte("plugin archive"))
+		So(err, ShouldBeNil)
+		So(os.Remove(store.versionPath("test-plugin", "1.0.0")), ShouldBeNil)
+		So(ioutil.WriteFile(store.blobPath(digest), []byte("garbage"), 0644), ShouldBeNil)
+
+		_, err = store.Put("test-plugin", "1.0.1", []byte("plugin archive"))
+		So(err, ShouldBeNil)
+
+		body, ok := store.Get("test-plugin", "1.0.1")
+		So(ok, ShouldBeTrue)
+		So(string(body), ShouldEqual, "plugin archive")
+	})
+}
+
+func TestVerifyWrites(t *testing.T) {
+	Convey("Archives are read back after writing them", t, func() {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		SetVerifyWrites(true)
+		defer SetVerifyWrites(false)
+
+		path := filepath.Join(dir, "test-plugin.zip")
+		So(writeArchive(path, []byte("plugin archive")), ShouldBeNil)
+
+		body, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "plugin archive")
+	})
+}
+
+func TestArchiveEncryption(t *testing.T) {
+	Convey("Archives are encrypted at rest", t, func() {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		key := bytes.Repeat([]byte{7}, 32)
+		store := &ArchiveStore{Dir: dir, Key: key}
+		digest, err := store.Put("test-plugin", "1.0.0", []byte("plugin archive"))
+		So(err, ShouldBeNil)
+		So(digest, ShouldEqual, "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353")
+
+		raw, err := ioutil.ReadFile(store.blobPath(digest))
+		So(err, ShouldBeNil)
+		So(bytes.Contains(raw, []byte("plugin archive")), ShouldBeFalse)
+
+		body, ok := store.Get("test-plugin", "1.0.0")
+		So(ok, ShouldBeTrue)
+		So(string(body), ShouldEqual, "plugin archive")
+
+		Convey("and discarded when the key changed", func() {
+			other := &ArchiveStore{Dir: dir, Key: bytes.Repeat([]byte{8}, 32)}
+			_, ok := other.Get("test-plugin", "1.0.0")
+			So(ok, ShouldBeFalse)
+			_, err := os.Stat(store.blobPath(digest))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("and stored again when encryption is enabled later", func() {
+			plain := &ArchiveStore{Dir: dir}
+			So(os.Remove(plain.versionPath("test-plugin", "1.0.0")), ShouldBeNil)
+			So(ioutil.WriteFile(plain.blobPath(digest), []byte("plugin archive"), 0644), ShouldBeNil)
+
+			_, err := store.Put("test-plugin", "1.0.0", []byte("plugin archive"))
+			So(err, ShouldBeNil)
+			body, ok := store.Get("test-plugin", "1.0.0")
+			So(ok, ShouldBeTrue)
+			So(string(body), ShouldEqual, "plugin archive")
+		})
+	})
+
+	Convey("Encryption keys are resolved and validated", t, func() {
+		os.Setenv("TEST_ARCHIVE_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+		defer os.Unsetenv("TEST_ARCHIVE_KEY")
+
+		key, err := ParseArchiveKey("env:TEST_ARCHIVE_KEY")
+		So(err, ShouldBeNil)
+		So(key, ShouldHaveLength, 32)
+
+		So(SetArchiveEncryptionKey([]byte("short")), ShouldNotBeNil)
+		So(SetArchiveEncryptionKey(key), ShouldBeNil)
+		defer SetArchiveEncryptionKey(nil)
+
+		SetArchiveStore("/tmp/archives")
+		defer SetArchiveStore("")
+		So(getArchiveStore().Key, ShouldResemble, key)
+	})
+}
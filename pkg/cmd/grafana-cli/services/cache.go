@@ -0,0 +1,262 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cache is a read-through cache for repository responses. Deployments running
+// several Grafana instances can back it with Redis or memcached so all
+// instances resolve the same plugin versions.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+}
+
+var (
+	cache Cache = newMemoryCache()
+
+	// MetadataCacheTTL is how long plugin metadata fetched from the repo is reused.
+	MetadataCacheTTL = 5 * time.Minute
+	// ArchiveCacheTTL is how long downloaded archives are kept in the cache, 0 disables archive caching.
+	ArchiveCacheTTL     time.Duration
+	staleMetadataMaxAge time.Duration
+)
+
+// SetStaleMetadataMaxAge sets how old expired metadata may be to still be
+// served, flagged as stale, while the repository is unreachable. 0 disables
+// serving stale metadata.
+func SetStaleMetadataMaxAge(maxAge time.Duration) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	staleMetadataMaxAge = maxAge
+}
+
+func getStaleMetadataMaxAge() time.Duration {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return staleMetadataMaxAge
+}
+
+// StaleCache is implemented by caches that keep expired entries around, so
+// last known good metadata can be served during repository outages.
+type StaleCache interface {
+	Cache
+	// GetStale returns the entry of key even if it expired.
+	GetStale(key string) ([]byte, bool)
+}
+
+// SetCache replaces the default in-memory cache.
+func SetCache(c Cache) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	cache = c
+}
+
+func getCache() Cache {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return cache
+}
+
+// memoryCacheMaxBytes bounds the values kept by the default cache, which
+// holds archives too when ArchiveCacheTTL is set.
+const memoryCacheMaxBytes = 256 << 20
+
+type memoryCacheEntry struct {
+	value   []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// memoryCache drops entries once they expired longer ago than the stale
+// metadata max age, when they are looked up or on writes, and the oldest
+// entries when the values exceed maxBytes.
+type memoryCache struct {
+	sync.RWMutex
+	entries  map[string]memoryCacheEntry
+	size     int64
+	maxBytes int64
+
+	countersMtx sync.Mutex
+	hits        map[CacheScope]uint64
+	misses      map[CacheScope]uint64
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{
+		entries:  map[string]memoryCacheEntry{},
+		maxBytes: memoryCacheMaxBytes,
+		hits:     map[CacheScope]uint64{},
+		misses:   map[CacheScope]uint64{},
+	}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.RLock()
+	entry, ok := c.entries[key]
+	c.RUnlock()
+
+	now := getClock().Now()
+	if ok && evictable(entry, now) {
+		c.Lock()
+		if current, ok := c.entries[key]; ok && evictable(current, now) {
+			c.remove(key)
+		}
+		c.Unlock()
+	}
+
+	ok = ok && !now.After(entry.expires)
+	c.count(cacheScope(key), ok)
+	if !ok {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// GetStale returns the entry of key even if it expired, as long as it was not
+// evicted yet.
+func (c *memoryCache) GetStale(key string) ([]byte, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.entries[key]
+	return entry.value, ok
+}
+
+func (c *memoryCache) count(scope CacheScope, hit bool) {
+	c.countersMtx.Lock()
+	defer c.countersMtx.Unlock()
+
+	if hit {
+		c.hits[scope]++
+	} else {
+		c.misses[scope]++
+	}
+}
+
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(key)
+	if int64(len(value)) > c.maxBytes {
+		return
+	}
+
+	now := getClock().Now()
+	c.entries[key] = memoryCacheEntry{value: value, stored: now, expires: now.Add(ttl)}
+	c.size += int64(len(value))
+	c.evict(now)
+}
+
+func (c *memoryCache) Delete(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(key)
+}
+
+// remove deletes the entry of key, it must be called holding the write lock.
+func (c *memoryCache) remove(key string) {
+	if entry, ok := c.entries[key]; ok {
+		c.size -= int64(len(entry.value))
+		delete(c.entries, key)
+	}
+}
+
+// evict drops the entries no longer served, even as stale, and then the
+// oldest ones until the values fit into maxBytes. It must be called holding
+// the write lock.
+func (c *memoryCache) evict(now time.Time) {
+	for key, entry := range c.entries {
+		if evictable(entry, now) {
+			c.remove(key)
+		}
+	}
+	if c.size <= c.maxBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return c.entries[keys[i]].stored.Before(c.entries[keys[j]].stored) })
+	for _, key := range keys {
+		if c.size <= c.maxBytes {
+			return
+		}
+		c.remove(key)
+	}
+}
+
+// evictable reports whether entry expired too long ago to be served stale.
+func evictable(entry memoryCacheEntry, now time.Time) bool {
+	return now.After(entry.expires.Add(getStaleMetadataMaxAge()))
+}
+
+// Stats returns the live entries and the hit and miss counts per scope.
+// Expired entries kept to be served stale are not counted.
+func (c *memoryCache) Stats() map[CacheScope]ScopeStats {
+	stats := map[CacheScope]ScopeStats{}
+	now := getClock().Now()
+
+	c.RLock()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			continue
+		}
+		scope := cacheScope(key)
+		st := stats[scope]
+		st.Entries++
+		st.Bytes += int64(len(entry.value))
+		if st.Oldest.IsZero() || entry.stored.Before(st.Oldest) {
+			st.Oldest = entry.stored
+		}
+		stats[scope] = st
+	}
+	c.RUnlock()
+
+	c.countersMtx.Lock()
+	defer c.countersMtx.Unlock()
+
+	for scope, hits := range c.hits {
+		st := stats[scope]
+		st.Hits = hits
+		stats[scope] = st
+	}
+	for scope, misses := range c.misses {
+		st := stats[scope]
+		st.Misses = misses
+		stats[scope] = st
+	}
+	return stats
+}
+
+// Purge removes the entries of scope, expired ones included, and returns how
+// many live entries were removed. CacheScopeAll empties the cache.
+func (c *memoryCache) Purge(scope CacheScope) int {
+	c.Lock()
+	defer c.Unlock()
+
+	now := getClock().Now()
+	purged := 0
+	for key, entry := range c.entries {
+		if scope != CacheScopeAll && cacheScope(key) != scope {
+			continue
+		}
+		if !now.After(entry.expires) {
+			purged++
+		}
+		c.remove(key)
+	}
+	return purged
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheScope groups cache entries by what they hold.
+type CacheScope string
+
+const (
+	CacheScopeAll      CacheScope = ""
+	CacheScopeMetadata CacheScope = "metadata"
+	CacheScopeArchive  CacheScope = "archive"
+	CacheScopeProxy    CacheScope = "proxy"
+	// CacheScopeArchiveStore is the archive store on disk, see SetArchiveStore.
+	CacheScopeArchiveStore CacheScope = "archive-store"
+)
+
+var ErrCacheNotInspectable = errors.New("the configured cache does not report statistics")
+
+// ParseCacheScope parses a scope name, "all" selects every scope. An empty
+// name is an error so purging everything is always asked for explicitly.
+func ParseCacheScope(value string) (CacheScope, error) {
+	switch scope := CacheScope(strings.ToLower(value)); scope {
+	case CacheScopeMetadata, CacheScopeArchive, CacheScopeProxy, CacheScopeArchiveStore:
+		return scope, nil
+	case "all":
+		return CacheScopeAll, nil
+	}
+	return "", fmt.Errorf("unknown cache scope %q, expected metadata, archive, proxy, archive-store or all", value)
+}
+
+// ScopeStats describes the live entries of a cache scope. Hits and misses
+// are counted since the cache was created.
+type ScopeStats struct {
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	// Oldest is when the oldest live entry was stored, zero without entries.
+	Oldest time.Time `json:"oldest,omitempty"`
+}
+
+// InspectableCache is implemented by caches that can report statistics and
+// purge entries by scope, like the default in-memory cache.
+type InspectableCache interface {
+	Cache
+	Stats() map[CacheScope]ScopeStats
+	// Purge removes the entries of scope and returns how many were removed.
+	Purge(scope CacheScope) int
+}
+
+// CacheStats returns the statistics of the configured cache per scope, and of
+// the archive store as CacheScopeArchiveStore when one is configured.
+func CacheStats() (map[CacheScope]ScopeStats, error) {
+	c, inspectable := getCache().(InspectableCache)
+	store := getArchiveStore()
+	if !inspectable && store == nil {
+		return nil, ErrCacheNotInspectable
+	}
+
+	stats := map[CacheScope]ScopeStats{}
+	if inspectable {
+		stats = c.Stats()
+	}
+	if store != nil {
+		storeStats, err := store.Stats()
+		if err != nil {
+			return nil, err
+		}
+		stats[CacheScopeArchiveStore] = storeStats
+	}
+	return stats, nil
+}
+
+// PurgeCache removes every entry of scope from the configured cache, and the
+// stored archives for CacheScopeArchiveStore and CacheScopeAll.
+func PurgeCache(scope CacheScope) (int, error) {
+	purged := 0
+	if scope == CacheScopeArchiveStore || scope == CacheScopeAll {
+		store := getArchiveStore()
+		if store == nil && scope == CacheScopeArchiveStore {
+			return 0, ErrNoArchiveStore
+		}
+		if store != nil {
+			n, err := store.Purge()
+			purged += n
+			if err != nil {
+				return purged, err
+			}
+		}
+		if scope == CacheScopeArchiveStore {
+			return purged, nil
+		}
+	}
+
+	c, ok := getCache().(InspectableCache)
+	if !ok {
+		return purged, ErrCacheNotInspectable
+	}
+	return purged + c.Purge(scope), nil
+}
+
+// Stats returns the stored plugin versions and the size of their blobs. The
+// store does not count lookups.
+func (s *ArchiveStore) Stats() (ScopeStats, error) {
+	stored, err := s.versions()
+	if err != nil {
+		return ScopeStats{}, err
+	}
+
+	var stats ScopeStats
+	for _, versions := range stored {
+		stats.Entries += len(versions)
+	}
+
+	blobs, err := ioutil.ReadDir(filepath.Join(s.Dir, "blobs", "sha256"))
+	if err != nil && !os.IsNotExist(err) {
+		return ScopeStats{}, err
+	}
+	for _, blob := range blobs {
+		if blob.IsDir() || !strings.HasSuffix(blob.Name(), ".zip") {
+			continue
+		}
+		stats.Bytes += blob.Size()
+		if stats.Oldest.IsZero() || blob.ModTime().Before(stats.Oldest) {
+			stats.Oldest = blob.ModTime()
+		}
+	}
+	return stats, nil
+}
+
+// Purge removes every stored version and blob, returning how many versions
+// were removed.
+func (s *ArchiveStore) Purge() (int, error) {
+	stored, err := s.versions()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for pluginId, versions := range stored {
+		if err := os.RemoveAll(filepath.Join(s.Dir, pluginId)); err != nil {
+			return purged, err
+		}
+		purged += len(versions)
+	}
+	return purged, os.RemoveAll(filepath.Join(s.Dir, "blobs"))
+}
+
+// cacheScope returns the scope of a cache key, keys are prefixed with it.
+func cacheScope(key string) CacheScope {
+	switch {
+	case strings.HasPrefix(key, "metadata"):
+		// includes the metadata-fetched timestamps
+		return CacheScopeMetadata
+	case strings.HasPrefix(key, "archive:"):
+		return CacheScopeArchive
+	case strings.HasPrefix(key, "proxy:"):
+		return CacheScopeProxy
+	}
+	return CacheScope(strings.SplitN(key, ":", 2)[0])
+}
+
+// CacheAdminHandler serves the cache statistics as JSON on GET and purges a
+// scope on DELETE, e.g. "DELETE ?scope=archive" or "DELETE ?scope=all", for
+// mounting on an admin route next to a RepoProxy.
+func CacheAdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result interface{}
+		var err error
+
+		switch r.Method {
+		case http.MethodGet:
+			result, err = CacheStats()
+		case http.MethodDelete:
+			scope, parseErr := ParseCacheScope(r.URL.Query().Get("scope"))
+			if parseErr != nil {
+				http.Error(w, parseErr.Error(), http.StatusBadRequest)
+				return
+			}
+			var purged int
+			purged, err = PurgeCache(scope)
+			result = map[string]int{"purged": purged}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCacheStats(t *testing.T) {
+	Convey("Cache statistics and purging", t, func() {
+		previous := getCache()
+		SetCache(newMemoryCache())
+		defer SetCache(previous)
+
+		getCache().Set("metadata:https://repo/a", []byte("1234"), time.Hour)
+		getCache().Set("metadata-fetched:https://repo/a", []byte("ts"), time.Hour)
+		getCache().Set("archive:https://repo/a.zip", []byte("zipzip"), time.Hour)
+		getCache().Set("archive:https://repo/expired.zip", []byte("old"), -time.Second)
+		getCache().Get("metadata:https://repo/a")
+		getCache().Get("metadata:https://repo/b")
+		getCache().Get("archive:https://repo/expired.zip")
+
+		Convey("counts live entries, sizes and lookups per scope", func() {
+			stats, err := CacheStats()
+			So(err, ShouldBeNil)
+
+			So(stats[CacheScopeMetadata].Entries, ShouldEqual, 2)
+			So(stats[CacheScopeMetadata].Bytes, ShouldEqual, 6)
+			So(stats[CacheScopeMetadata].Hits, ShouldEqual, 1)
+			So(stats[CacheScopeMetadata].Misses, ShouldEqual, 1)
+			So(stats[CacheScopeMetadata].Oldest.IsZero(), ShouldBeFalse)
+
+			So(stats[CacheScopeArchive].Entries, ShouldEqual, 1)
+			So(stats[CacheScopeArchive].Bytes, ShouldEqual, 6)
+			So(stats[CacheScopeArchive].Misses, ShouldEqual, 1)
+		})
+
+		Convey("purges a single scope", func() {
+			purged, err := PurgeCache(CacheScopeArchive)
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 1)
+
+			stats, _ := CacheStats()
+			So(stats[CacheScopeArchive].Entries, ShouldEqual, 0)
+			So(stats[CacheScopeMetadata].Entries, ShouldEqual, 2)
+		})
+
+		Convey("purges every scope", func() {
+			purged, err := PurgeCache(CacheScopeAll)
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 3)
+		})
+
+		Convey("serves stats and purges over http", func() {
+			srv := httptest.NewServer(CacheAdminHandler())
+			defer srv.Close()
+
+			res, err := http.Get(srv.URL)
+			So(err, ShouldBeNil)
+			var stats map[CacheScope]ScopeStats
+			So(json.NewDecoder(res.Body).Decode(&stats), ShouldBeNil)
+			res.Body.Close()
+			So(stats[CacheScopeArchive].Entries, ShouldEqual, 1)
+
+			req, _ := http.NewRequest(http.MethodDelete, srv.URL+"?scope=metadata", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			var purged map[string]int
+			So(json.NewDecoder(res.Body).Decode(&purged), ShouldBeNil)
+			res.Body.Close()
+			So(purged["purged"], ShouldEqual, 2)
+
+			req, _ = http.NewRequest(http.MethodDelete, srv.URL+"?scope=plugins", nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+			req, _ = http.NewRequest(http.MethodDelete, srv.URL, nil)
+			res, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			stats, _ = CacheStats()
+			So(stats[CacheScopeArchive].Entries, ShouldEqual, 1)
+		})
+
+		Convey("includes the archive store", func() {
+			dir, err := ioutil.TempDir("", "archive-store")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			SetArchiveStore(dir)
+			defer SetArchiveStore("")
+
+			So(StoreArchive("store-panel", "1.0.0", []byte("archive")), ShouldBeNil)
+			So(StoreArchive("store-panel", "1.1.0", []byte("archive")), ShouldBeNil)
+			So(StoreArchive("other-panel", "1.0.0", []byte("other")), ShouldBeNil)
+
+			stats, err := CacheStats()
+			So(err, ShouldBeNil)
+			So(stats[CacheScopeArchiveStore].Entries, ShouldEqual, 3)
+			So(stats[CacheScopeArchiveStore].Bytes, ShouldEqual, len("archive")+len("other"))
+			So(stats[CacheScopeArchiveStore].Oldest.IsZero(), ShouldBeFalse)
+
+			purged, err := PurgeCache(CacheScopeArchiveStore)
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 3)
+			So(getCache().(InspectableCache).Stats()[CacheScopeArchive].Entries, ShouldEqual, 1)
+
+			stats, err = CacheStats()
+			So(err, ShouldBeNil)
+			So(stats[CacheScopeArchiveStore].Entries, ShouldEqual, 0)
+			So(stats[CacheScopeArchiveStore].Bytes, ShouldEqual, 0)
+		})
+
+		Convey("requires a scope to purge", func() {
+			_, err := ParseCacheScope("")
+			So(err, ShouldNotBeNil)
+
+			scope, err := ParseCacheScope("all")
+			So(err, ShouldBeNil)
+			So(scope, ShouldEqual, CacheScopeAll)
+		})
+
+		Convey("reports caches without statistics", func() {
+			SetCache(noStatsCache{})
+			_, err := CacheStats()
+			So(err, ShouldEqual, ErrCacheNotInspectable)
+		})
+	})
+}
+
+type noStatsCache struct{}
+
+func (noStatsCache) Get(string) ([]byte, bool)         { return nil, false }
+func (noStatsCache) Set(string, []byte, time.Duration) {}
+func (noStatsCache) Delete(string)                     {}
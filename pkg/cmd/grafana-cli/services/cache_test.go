@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryCache(t *testing.T) {
+	Convey("The memory cache", t, func() {
+		mock := clock.NewMock()
+		SetClock(mock)
+		defer SetClock(nil)
+
+		c := newMemoryCache()
+
+		Convey("evicts expired entries when they are looked up", func() {
+			c.Set("metadata:expired", []byte("cached"), time.Minute)
+			mock.Add(2 * time.Minute)
+
+			_, ok := c.Get("metadata:expired")
+			So(ok, ShouldBeFalse)
+			So(c.entries, ShouldNotContainKey, "metadata:expired")
+		})
+
+		Convey("evicts expired entries on writes", func() {
+			c.Set("metadata:expired", []byte("cached"), time.Minute)
+			mock.Add(2 * time.Minute)
+			c.Set("metadata:fresh", []byte("cached"), time.Minute)
+
+			So(c.entries, ShouldNotContainKey, "metadata:expired")
+			So(c.size, ShouldEqual, len("cached"))
+		})
+
+		Convey("keeps expired entries to serve them stale within the max age", func() {
+			SetStaleMetadataMaxAge(time.Hour)
+			defer SetStaleMetadataMaxAge(0)
+
+			c.Set("metadata:expired", []byte("cached"), time.Minute)
+			mock.Add(2 * time.Minute)
+			_, ok := c.Get("metadata:expired")
+			So(ok, ShouldBeFalse)
+			value, ok := c.GetStale("metadata:expired")
+			So(ok, ShouldBeTrue)
+			So(string(value), ShouldEqual, "cached")
+
+			mock.Add(time.Hour)
+			c.Get("metadata:expired")
+			_, ok = c.GetStale("metadata:expired")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("drops the oldest entries beyond its size", func() {
+			c.maxBytes = 10
+			c.Set("archive:old", []byte("12345"), time.Hour)
+			mock.Add(time.Second)
+			c.Set("archive:new", []byte("12345"), time.Hour)
+			mock.Add(time.Second)
+			c.Set("metadata:newest", []byte("123"), time.Hour)
+
+			_, ok := c.Get("archive:old")
+			So(ok, ShouldBeFalse)
+			_, ok = c.Get("archive:new")
+			So(ok, ShouldBeTrue)
+			_, ok = c.Get("metadata:newest")
+			So(ok, ShouldBeTrue)
+			So(c.size, ShouldEqual, 8)
+
+			c.Set("archive:huge", make([]byte, 11), time.Hour)
+			_, ok = c.Get("archive:huge")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
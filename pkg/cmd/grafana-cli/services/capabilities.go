@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+)
+
+// RepoCapabilities are the optional features a plugin repository supports on
+// top of the basic index, plugin and download endpoints.
+type RepoCapabilities struct {
+	// BatchMetadata is the versioncheck endpoint returning the latest
+	// versions of several plugins in one request.
+	BatchMetadata bool `json:"batchMetadata"`
+	// DeltaIndex is the repo/changes endpoint listing the plugins changed
+	// since a given time, instead of the full index.
+	DeltaIndex bool `json:"deltaIndex"`
+	// Signatures are detached signatures served next to the indexes, of the
+	// listing or, for mirrors signed without one, of the plugins.
+	Signatures bool `json:"signatures"`
+	// Search is the repo/search endpoint.
+	Search bool `json:"search"`
+	// ApiVersion is the API version negotiated while probing.
+	ApiVersion int `json:"apiVersion"`
+}
+
+var capabilities = struct {
+	sync.RWMutex
+	byRepo map[string]RepoCapabilities
+}{byRepo: map[string]RepoCapabilities{}}
+
+// capabilityProbe tells whether a repository supports a capability, by
+// requesting url or, if set, with probe.
+type capabilityProbe struct {
+	url   string
+	probe func(ctx context.Context, repoUrl string) (bool, error)
+	set   func(c *RepoCapabilities)
+}
+
+func capabilityProbes(repoUrl string) []capabilityProbe {
+	since := url.Values{"since": {time.Now().UTC().Format(time.RFC3339)}}
+	batch := url.Values{"slugIn": {""}, "grafanaVersion": {grafanaVersion}}
+
+	signatures := capabilityProbe{probe: probeSignatures, set: func(c *RepoCapabilities) { c.Signatures = true }}
+	if IsStaticRepo(repoUrl) {
+		// a static repository serves nothing but its index and its signature
+		return []capabilityProbe{signatures}
+	}
+
+	return []capabilityProbe{
+		{url: repoPath(repoUrl, "versioncheck") + "?" + batch.Encode(), set: func(c *RepoCapabilities) { c.BatchMetadata = true }},
+		{url: repoPath(repoUrl, "repo", "changes") + "?" + since.Encode(), set: func(c *RepoCapabilities) { c.DeltaIndex = true }},
+		signatures,
+		{url: repoPath(repoUrl, "repo", "search") + "?query=", set: func(c *RepoCapabilities) { c.Search = true }},
+	}
+}
+
+// probeSignatures looks for the signature of the listing, and when there is
+// none for that of the first plugin listed, as mirrors signed with SignMirror
+// may have no signed listing.
+func probeSignatures(ctx context.Context, repoUrl string) (bool, error) {
+	listing := repoPath(repoUrl, "repo")
+	supported, err := probeCapability(ctx, repoUrl, indexSignatureURL(listing))
+	if err != nil || supported || IsStaticRepo(repoUrl) {
+		return supported, err
+	}
+
+	// not through sendRequest, which verifies the listing against the
+	// signature probed for
+	req, err := newRequest(listing)
+	if err != nil {
+		return false, err
+	}
+	body, err := readResponse(doNegotiated(repoUrl, &RepoRequest{Op: OpCapabilities, Request: req.WithContext(ctx)}))
+	if err != nil {
+		return false, nil
+	}
+	var plugins m.PluginRepo
+	if err := json.Unmarshal(body, &plugins); err != nil || len(plugins.Plugins) == 0 {
+		return false, nil
+	}
+
+	return probeCapability(ctx, repoUrl, indexSignatureURL(repoPath(repoUrl, "repo", plugins.Plugins[0].Id)))
+}
+
+// Capabilities probes which optional features repoUrl supports, so callers
+// can fall back to the basic endpoints on mirrors that only implement those.
+// Results are remembered per repository. A probe that fails for a reason
+// other than the endpoint being absent, like a network error or a 5xx, is
+// returned as error and nothing is remembered, so a flaky repository is not
+// mistaken for a basic one.
+func Capabilities(ctx context.Context, repoUrl string) (RepoCapabilities, error) {
+	capabilities.RLock()
+	caps, ok := capabilities.byRepo[repoUrl]
+	capabilities.RUnlock()
+	if ok {
+		return caps, nil
+	}
+
+	for _, probe := range capabilityProbes(repoUrl) {
+		var supported bool
+		var err error
+		if probe.probe != nil {
+			supported, err = probe.probe(ctx, repoUrl)
+		} else {
+			supported, err = probeCapability(ctx, repoUrl, probe.url)
+		}
+		if err != nil {
+			return RepoCapabilities{}, err
+		}
+		if supported {
+			probe.set(&caps)
+		}
+	}
+	caps.ApiVersion = ApiVersion(repoUrl)
+
+	capabilities.Lock()
+	capabilities.byRepo[repoUrl] = caps
+	capabilities.Unlock()
+
+	return caps, nil
+}
+
+// ForgetCapabilities drops the remembered capabilities of repoUrl, e.g. after
+// the mirror was upgraded.
+func ForgetCapabilities(repoUrl string) {
+	capabilities.Lock()
+	defer capabilities.Unlock()
+
+	delete(capabilities.byRepo, repoUrl)
+}
+
+// probeCapability reports whether the endpoint at u exists. Endpoints that
+// exist but reject the empty probe parameters with 400 count as supported.
+func probeCapability(ctx context.Context, repoUrl, u string) (bool, error) {
+	req, err := newRequest(u)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+
+	res, err := doNegotiated(repoUrl, &RepoRequest{Op: OpCapabilities, Request: req})
+	if err != nil {
+		return false, repoError(OpCapabilities, "", u, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode/100 == 2, res.StatusCode == http.StatusBadRequest:
+		return true, nil
+	case res.StatusCode == http.StatusNotFound, res.StatusCode == http.StatusMethodNotAllowed,
+		res.StatusCode == http.StatusGone, res.StatusCode == http.StatusNotImplemented:
+		return false, nil
+	}
+
+	statusErr := withRequestID(responseRequestID(res), gcom.NewStatusError(res))
+	return false, repoError(OpCapabilities, "", u, statusErr)
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCapabilities(t *testing.T) {
+	Convey("Capabilities are probed and remembered per repository", t, func() {
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		var requests int
+		failing := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if failing {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			switch r.URL.Path {
+			case "/versioncheck":
+				w.WriteHeader(http.StatusBadRequest)
+			case "/repo.sig":
+				w.Write([]byte("signature\n"))
+			case "/repo/search":
+				w.WriteHeader(http.StatusNotImplemented)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		defer ForgetCapabilities(server.URL)
+
+		Convey("missing endpoints are reported as unsupported", func() {
+			caps, err := Capabilities(context.Background(), server.URL)
+			So(err, ShouldBeNil)
+			So(caps.BatchMetadata, ShouldBeTrue)
+			So(caps.Signatures, ShouldBeTrue)
+			So(caps.DeltaIndex, ShouldBeFalse)
+			So(caps.Search, ShouldBeFalse)
+			So(requests, ShouldEqual, 4)
+
+			_, err = Capabilities(context.Background(), server.URL)
+			So(err, ShouldBeNil)
+			So(requests, ShouldEqual, 4)
+		})
+
+		Convey("server errors are returned and not remembered", func() {
+			failing = true
+			_, err := Capabilities(context.Background(), server.URL)
+			So(err, ShouldNotBeNil)
+
+			failing = false
+			caps, err := Capabilities(context.Background(), server.URL)
+			So(err, ShouldBeNil)
+			So(caps.Signatures, ShouldBeTrue)
+		})
+	})
+}
+
+func TestSignatureCapability(t *testing.T) {
+	Convey("Mirrors signing only their plugin indexes serve signatures", t, func() {
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		var requested []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.Path)
+			switch r.URL.Path {
+			case "/repo":
+				w.Write([]byte(`{"plugins": [{"id": "signed-panel"}]}`))
+			case "/repo/signed-panel.sig":
+				w.Write([]byte("signature\n"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		defer ForgetCapabilities(server.URL)
+
+		caps, err := Capabilities(context.Background(), server.URL)
+		So(err, ShouldBeNil)
+		So(caps.Signatures, ShouldBeTrue)
+		So(requested, ShouldContain, "/repo.sig")
+		So(requested, ShouldContain, "/repo/signed-panel.sig")
+	})
+}
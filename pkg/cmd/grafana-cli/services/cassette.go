@@ -0,0 +1,223 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+	"golang.org/x/xerrors"
+)
+
+// ErrNoInteraction is returned by replaying clients for requests their
+// cassette has no recording of.
+var ErrNoInteraction = xerrors.New("no recorded interaction")
+
+// Cassette is a recording of repository interactions, replayed by
+// NewReplayClient to run integration tests against real response shapes
+// without network access. Cassettes are recorded with NewRecorder.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response.
+type Interaction struct {
+	Method string `json:"method"`
+	// URL is the request url, without user info and with credentials
+	// redacted.
+	URL             string      `json:"url"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	// Body is the response body, base64 encoded when BodyEncoding is
+	// "base64", like for archives.
+	Body         string `json:"body"`
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+}
+
+// sensitiveHeaders are never recorded.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Amz-Security-Token"}
+
+// LoadCassette reads a cassette written by Recorder.Save.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, xerrors.Errorf("invalid cassette %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// WithCassette serves all repository requests from the cassette at path,
+// failing those it has no recording of.
+func WithCassette(path string) (Option, error) {
+	client, err := NewReplayClient(path)
+	if err != nil {
+		return nil, err
+	}
+	return WithClient(client), nil
+}
+
+// NewReplayClient returns a http client replaying the cassette at path.
+// Requests are matched by method and redacted url. Repeated requests are
+// answered by their recordings in order, the last one being replayed once
+// they run out.
+func NewReplayClient(path string) (*http.Client, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &replay{cassette: c, replayed: map[int]bool{}}
+	return &http.Client{Transport: &cannedTransport{respond: r.respond}}, nil
+}
+
+// replay answers requests with the recordings of a cassette.
+type replay struct {
+	mtx      sync.Mutex
+	cassette *Cassette
+	replayed map[int]bool
+}
+
+func (t *replay) respond(req *http.Request) (*http.Response, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	interactions := t.cassette.Interactions
+
+	u := gcom.RedactURL(req.URL)
+	match := -1
+	for i, in := range interactions {
+		if in.Method != req.Method || in.URL != u {
+			continue
+		}
+		match = i
+		if !t.replayed[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, xerrors.Errorf("%s %s: %w", req.Method, u, ErrNoInteraction)
+	}
+	t.replayed[match] = true
+
+	in := interactions[match]
+	body := []byte(in.Body)
+	if in.BodyEncoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(in.Body); err != nil {
+			return nil, xerrors.Errorf("%s %s: invalid recorded body: %w", req.Method, u, err)
+		}
+	}
+
+	res := fixtureResponse(req, in.Status, body)
+	for name, values := range in.ResponseHeaders {
+		res.Header[name] = append([]string(nil), values...)
+	}
+	return res, nil
+}
+
+// Recorder records the interactions of a http client into a cassette.
+type Recorder struct {
+	// Sanitize, if set, is applied to every interaction before it is
+	// recorded, e.g. to scrub account details from response bodies.
+	// Credentials in urls and headers are removed regardless.
+	Sanitize func(*Interaction)
+
+	next         http.RoundTripper
+	mtx          sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns a recorder sending requests through next, or the
+// default transport if nil.
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{next: next}
+}
+
+// Client returns a http client recording its interactions.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	in := Interaction{
+		Method:          req.Method,
+		URL:             gcom.RedactURL(req.URL),
+		Status:          res.StatusCode,
+		ResponseHeaders: http.Header{},
+		Body:            string(body),
+	}
+	if !utf8.Valid(body) {
+		in.Body = base64.StdEncoding.EncodeToString(body)
+		in.BodyEncoding = "base64"
+	}
+	for name, values := range res.Header {
+		in.ResponseHeaders[name] = append([]string(nil), values...)
+	}
+	for _, name := range sensitiveHeaders {
+		in.ResponseHeaders.Del(name)
+	}
+	if r.Sanitize != nil {
+		r.Sanitize(&in)
+	}
+
+	r.mtx.Lock()
+	r.interactions = append(r.interactions, in)
+	r.mtx.Unlock()
+	return res, nil
+}
+
+// Cassette returns the interactions recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return &Cassette{Interactions: append([]Interaction(nil), r.interactions...)}
+}
+
+// Save writes the interactions recorded so far to path.
+func (r *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(r.Cassette(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// RedactBody returns a sanitizer replacing every occurrence of the given
+// values in recorded bodies, e.g. account names or emails.
+func RedactBody(values ...string) func(*Interaction) {
+	return func(in *Interaction) {
+		if in.BodyEncoding != "" {
+			return
+		}
+		for _, v := range values {
+			if v != "" {
+				in.Body = strings.Replace(in.Body, v, "REDACTED", -1)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestCassettes(t *testing.T) {
+	Convey("Recorded interactions are sanitized and replayed", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Set-Cookie", "session=secret")
+			switch r.URL.Path {
+			case "/repo/recorded-panel":
+				w.Write([]byte(`{"id": "recorded-panel", "orgSlug": "jane@example.com"}`))
+			default:
+				w.Write([]byte{0x50, 0x4b, 0x03, 0x04, 0xff})
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "cassettes")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		recorder := NewRecorder(nil)
+		recorder.Sanitize = RedactBody("jane@example.com")
+		client := recorder.Client()
+
+		res, err := client.Get(server.URL + "/repo/recorded-panel?token=abc")
+		So(err, ShouldBeNil)
+		body, err := ioutil.ReadAll(res.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, "jane@example.com")
+
+		_, err = client.Get(server.URL + "/recorded-panel/versions/1.0.0/download")
+		So(err, ShouldBeNil)
+
+		path := filepath.Join(dir, "cassette.json")
+		So(recorder.Save(path), ShouldBeNil)
+
+		c, err := LoadCassette(path)
+		So(err, ShouldBeNil)
+		So(c.Interactions, ShouldHaveLength, 2)
+		So(c.Interactions[0].URL, ShouldEqual, server.URL+"/repo/recorded-panel?token=REDACTED")
+		So(c.Interactions[0].Body, ShouldEqual, `{"id": "recorded-panel", "orgSlug": "REDACTED"}`)
+		So(c.Interactions[0].ResponseHeaders.Get("Set-Cookie"), ShouldBeEmpty)
+		So(c.Interactions[1].BodyEncoding, ShouldEqual, "base64")
+
+		replay, err := NewReplayClient(path)
+		So(err, ShouldBeNil)
+
+		res, err = replay.Get(server.URL + "/repo/recorded-panel?token=other")
+		So(err, ShouldBeNil)
+		body, err = ioutil.ReadAll(res.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, `{"id": "recorded-panel", "orgSlug": "REDACTED"}`)
+
+		res, err = replay.Get(server.URL + "/recorded-panel/versions/1.0.0/download")
+		So(err, ShouldBeNil)
+		body, err = ioutil.ReadAll(res.Body)
+		So(err, ShouldBeNil)
+		So(body, ShouldResemble, []byte{0x50, 0x4b, 0x03, 0x04, 0xff})
+
+		_, err = replay.Get(server.URL + "/repo/unrecorded-panel")
+		So(xerrors.Is(err, ErrNoInteraction), ShouldBeTrue)
+
+		Convey("failing to replay cassettes that cannot be loaded", func() {
+			_, err := WithCassette(filepath.Join(dir, "missing.json"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Resolution runs against recorded grafana.com responses", t, func() {
+		client, err := NewReplayClient("testdata/cassettes/grafana-com.json")
+		So(err, ShouldBeNil)
+		prevClient := HttpClient
+		HttpClient = *client
+		defer func() { HttpClient = prevClient }()
+
+		repoUrl := "https://grafana.com/api/plugins"
+		plugin, err := GetPlugin("grafana-clock-panel", repoUrl)
+		So(err, ShouldBeNil)
+		So(plugin.Versions, ShouldHaveLength, 2)
+		So(plugin.SignatureType, ShouldEqual, "grafana")
+
+		res, err := Resolve(repoUrl, PluginRequest{PluginID: "grafana-clock-panel", Version: "2.1.2"})
+		So(err, ShouldBeNil)
+		So(res.URL, ShouldEqual, repoUrl+"/grafana-clock-panel/versions/2.1.2/download")
+		So(res.Checksum, ShouldEqual, "0d6e7f2b8c1a4e9d3f5b7a2c6e8d0f1a3b5c7e9d2f4a6b8c0e1d3f5a7b9c2e4d")
+
+		_, err = GetPlugin("missing-panel", repoUrl)
+		So(xerrors.Is(err, ErrNotFoundError), ShouldBeTrue)
+	})
+}
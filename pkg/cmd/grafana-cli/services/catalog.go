@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+)
+
+// PluginSort orders catalog listings.
+type PluginSort string
+
+const (
+	SortByID         PluginSort = "id"
+	SortByDownloads  PluginSort = "downloads"
+	SortByPopularity PluginSort = "popularity"
+	SortByRating     PluginSort = "rating"
+	SortByUpdated    PluginSort = "updated"
+)
+
+// ParsePluginSort parses the name of a sort order, empty sorts by id.
+func ParsePluginSort(value string) (PluginSort, error) {
+	switch s := PluginSort(strings.ToLower(value)); s {
+	case "":
+		return SortByID, nil
+	case SortByID, SortByDownloads, SortByPopularity, SortByRating, SortByUpdated:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown sort order %q, expected id, downloads, popularity, rating or updated", value)
+}
+
+// SortPlugins sorts plugins in place, by id ascending and by every other
+// signal descending, so the most downloaded or most recently updated come
+// first. Ties are broken by id.
+func SortPlugins(plugins []m.Plugin, by PluginSort) {
+	less := func(a, b m.Plugin) bool { return false }
+	switch by {
+	case SortByDownloads:
+		less = func(a, b m.Plugin) bool { return a.Downloads > b.Downloads }
+	case SortByPopularity:
+		less = func(a, b m.Plugin) bool { return a.Popularity > b.Popularity }
+	case SortByRating:
+		less = func(a, b m.Plugin) bool { return a.Rating > b.Rating }
+	case SortByUpdated:
+		less = func(a, b m.Plugin) bool { return a.UpdatedAt.After(b.UpdatedAt.Time) }
+	}
+
+	sort.SliceStable(plugins, func(i, j int) bool {
+		a, b := plugins[i], plugins[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Id < b.Id
+	})
+}
+
+// PluginFilter selects plugins of a catalog listing by their signals. The
+// zero value selects every plugin.
+type PluginFilter struct {
+	// SignedOnly drops unsigned plugins.
+	SignedOnly   bool
+	MinDownloads int64
+	MinRating    float64
+}
+
+// FilterPlugins returns the plugins matching f.
+func FilterPlugins(plugins []m.Plugin, f PluginFilter) []m.Plugin {
+	result := make([]m.Plugin, 0, len(plugins))
+	for _, p := range plugins {
+		if f.SignedOnly && p.SignatureType == "" || p.Downloads < f.MinDownloads || p.Rating < f.MinRating {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCatalogSignals(t *testing.T) {
+	var repo m.PluginRepo
+	err := json.Unmarshal([]byte(`{"plugins": [
+		{"id": "b-panel", "downloads": 500, "rating": 4.5, "updatedAt": "2019-05-01T00:00:00Z", "signatureType": "grafana"},
+		{"id": "a-panel", "downloads": 500, "rating": 3, "updatedAt": "2019-06-01T00:00:00Z"},
+		{"id": "c-panel", "downloads": 20, "rating": 5, "signatureType": "community"}
+	]}`), &repo)
+
+	ids := func(plugins []m.Plugin) []string {
+		var result []string
+		for _, p := range plugins {
+			result = append(result, p.Id)
+		}
+		return result
+	}
+
+	Convey("Catalog signals are decoded from listings", t, func() {
+		So(err, ShouldBeNil)
+		So(repo.Plugins[0].Downloads, ShouldEqual, 500)
+		So(repo.Plugins[0].UpdatedAt.Time, ShouldResemble, time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC))
+		So(repo.Plugins[0].SignatureType, ShouldEqual, "grafana")
+	})
+
+	Convey("Listings are sorted by signal with ties broken by id", t, func() {
+		plugins := append([]m.Plugin{}, repo.Plugins...)
+
+		SortPlugins(plugins, SortByDownloads)
+		So(ids(plugins), ShouldResemble, []string{"a-panel", "b-panel", "c-panel"})
+
+		SortPlugins(plugins, SortByRating)
+		So(ids(plugins), ShouldResemble, []string{"c-panel", "b-panel", "a-panel"})
+
+		SortPlugins(plugins, SortByUpdated)
+		So(ids(plugins), ShouldResemble, []string{"a-panel", "b-panel", "c-panel"})
+
+		_, err := ParsePluginSort("stars")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Listings are filtered by signal", t, func() {
+		So(ids(FilterPlugins(repo.Plugins, PluginFilter{SignedOnly: true})), ShouldResemble, []string{"b-panel", "c-panel"})
+		So(ids(FilterPlugins(repo.Plugins, PluginFilter{MinDownloads: 100, MinRating: 4})), ShouldResemble, []string{"b-panel"})
+		So(FilterPlugins(repo.Plugins, PluginFilter{}), ShouldHaveLength, 3)
+	})
+}
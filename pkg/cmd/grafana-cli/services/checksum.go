@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var (
+	ErrChecksumNotFound = errors.New("no checksum available for plugin archive")
+	ErrChecksumMismatch = errors.New("checksum of the downloaded archive does not match the expected checksum")
+	ErrInvalidChecksum  = errors.New("checksum must be a hex encoded sha256 or md5 digest")
+	ErrDigestMismatch   = errors.New("the repository publishes a different digest than the pinned one")
+)
+
+// DigestMismatchError is returned when a version is resolved with a pinned
+// digest but the repository publishes another one for it, e.g. because the
+// version was republished with different bytes.
+type DigestMismatchError struct {
+	PluginID  string
+	Version   string
+	Expected  string
+	Published string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("%s@%s is published with digest %s, pinned %s: %v", e.PluginID, e.Version, e.Published, e.Expected, ErrDigestMismatch)
+}
+
+func (e *DigestMismatchError) Unwrap() error {
+	return ErrDigestMismatch
+}
+
+// pinnedChecksum returns the checksum to verify the archive of v against
+// when req pins a digest. Digests of another algorithm than the published
+// one cannot be compared, the pinned digest is verified on download then.
+func pinnedChecksum(req PluginRequest, v m.Version, published string) (string, error) {
+	if req.Digest == "" {
+		return published, nil
+	}
+
+	pinned, err := ParseChecksum(req.Digest)
+	if err != nil {
+		return "", err
+	}
+	if published != "" && len(published) == len(pinned) && !strings.EqualFold(published, pinned) {
+		return "", &DigestMismatchError{PluginID: req.PluginID, Version: v.Version, Expected: pinned, Published: strings.ToLower(published)}
+	}
+	return pinned, nil
+}
+
+// DownloadFailure is a failed attempt to fetch an archive from a single source.
+type DownloadFailure struct {
+	URL string
+	Err error
+}
+
+// ChecksumMismatchError records every source that served an archive not
+// matching the expected checksum.
+type ChecksumMismatchError struct {
+	Failures []DownloadFailure
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", f.URL, f.Err))
+	}
+	return fmt.Sprintf("%v (%s)", ErrChecksumMismatch, strings.Join(msgs, "; "))
+}
+
+func (e *ChecksumMismatchError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// GetChecksum returns the checksum published by the repository for the current
+// os and arch. When the version has no arch metadata, as is the case for plugins
+// built from GitHub zipballs, it falls back to a sidecar checksum file located
+// next to the download url, unless indexes are verified with SetIndexKeys as
+// the signature does not cover sidecar files.
+func GetChecksum(pluginId string, v m.Version, downloadURL string) (string, error) {
+	return GetChecksumWithContext(context.Background(), pluginId, v, downloadURL)
+}
+
+// GetChecksumWithContext is GetChecksum bound to ctx.
+func GetChecksumWithContext(ctx context.Context, pluginId string, v m.Version, downloadURL string) (string, error) {
+	if _, meta, ok := SelectArchive(v); ok {
+		if meta.Sha256 != "" {
+			return meta.Sha256, nil
+		}
+		if meta.Md5 != "" {
+			if fipsMode {
+				return "", xerrors.Errorf("%s@%s only publishes an md5 checksum: %w", pluginId, v.Version, ErrDigestNotApproved)
+			}
+			return meta.Md5, nil
+		}
+	}
+
+	if len(getIndexKeys()) > 0 {
+		// sidecar files are not covered by the index signature
+		opLog(OpChecksum).Debugf("not trusting sidecar checksums of %s@%s from a signed repository\n", pluginId, v.Version)
+		return "", ErrChecksumNotFound
+	}
+
+	return getSidecarChecksum(ctx, pluginId, downloadURL)
+}
+
+func getSidecarChecksum(ctx context.Context, pluginId, downloadURL string) (string, error) {
+	base := path.Base(downloadURL)
+
+	if _, err := IoHelper.Stat(downloadURL); err == nil {
+		data, err := IoHelper.ReadFile(downloadURL + ".sha256")
+		if err != nil {
+			return "", ErrChecksumNotFound
+		}
+		return parseChecksumFile(data, base)
+	}
+
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", err
+	}
+
+	dir := *u
+	dir.Path = path.Dir(u.Path)
+
+	candidates := []string{
+		downloadURL + ".sha256",
+		dir.String() + "/SHA256SUMS",
+	}
+
+	for _, candidate := range candidates {
+		opLog(OpChecksum).Debugf("looking for checksum file at: %v\n", candidate)
+		body, err := fetchSidecar(ctx, pluginId, candidate)
+		if err != nil {
+			continue
+		}
+
+		if checksum, err := parseChecksumFile(body, base); err == nil {
+			return checksum, nil
+		}
+	}
+
+	return "", ErrChecksumNotFound
+}
+
+// fetchSidecar fetches a checksum file published next to an archive, which
+// may be served by a CDN rather than the repository, so neither the API
+// version is negotiated nor the health of a repository recorded.
+func fetchSidecar(ctx context.Context, pluginId, url string) ([]byte, error) {
+	req, err := newRequest(url)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	body, err := readResponse(do(&HttpClient, &RepoRequest{Op: OpChecksum, PluginID: pluginId, Request: req}))
+	if err != nil {
+		return body, repoError(OpChecksum, pluginId, url, err)
+	}
+	return body, nil
+}
+
+// parseChecksumFile reads the output of sha256sum. Files listing several
+// archives are matched on filename, single entry files are used as is.
+func parseChecksumFile(data []byte, filename string) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !isHexDigest(fields[0]) {
+			continue
+		}
+		if fipsMode && len(fields[0]) != sha256.Size*2 {
+			continue
+		}
+
+		if len(lines) == 1 || len(fields) > 1 && strings.TrimPrefix(fields[1], "*") == filename {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", ErrChecksumNotFound
+}
+
+// ParseChecksum normalizes a checksum supplied by the caller, e.g. from a
+// provisioning file. An optional "sha256:" or "md5:" prefix is accepted.
+func ParseChecksum(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, prefix := range []string{"sha256:", "md5:"} {
+		if strings.HasPrefix(strings.ToLower(value), prefix) {
+			value = value[len(prefix):]
+		}
+	}
+
+	if !isHexDigest(value) {
+		return "", ErrInvalidChecksum
+	}
+	if fipsMode && len(value) != sha256.Size*2 {
+		return "", ErrDigestNotApproved
+	}
+
+	return strings.ToLower(value), nil
+}
+
+func isHexDigest(value string) bool {
+	if len(value) != md5.Size*2 && len(value) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// VerifyChecksum compares the archive against the expected checksum. The
+// algorithm is derived from the length of the expected digest.
+func VerifyChecksum(body []byte, expected string) error {
+	var actual string
+
+	switch len(expected) {
+	case md5.Size * 2:
+		if fipsMode {
+			return ErrDigestNotApproved
+		}
+		actual = fmt.Sprintf("%x", md5.Sum(body))
+	case sha256.Size * 2:
+		actual = fmt.Sprintf("%x", sha256.Sum256(body))
+	default:
+		return fmt.Errorf("unsupported checksum format: %v", expected)
+	}
+
+	if !strings.EqualFold(actual, expected) {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/xerrors"
+)
+
+func TestChecksum(t *testing.T) {
+	body := []byte("plugin archive")
+	sha := "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353"
+
+	Convey("Should prefer arch metadata published by the repo", t, func() {
+		v := m.Version{Arch: map[string]m.ArchMeta{"any": {Md5: "d41d8cd98f00b204e9800998ecf8427e"}}}
+
+		checksum, err := GetChecksum("test-plugin", v, "")
+		So(err, ShouldBeNil)
+		So(checksum, ShouldEqual, "d41d8cd98f00b204e9800998ecf8427e")
+	})
+
+	Convey("Parse sidecar checksum files", t, func() {
+		Convey("single entry file", func() {
+			checksum, err := parseChecksumFile([]byte(sha+"\n"), "download")
+			So(err, ShouldBeNil)
+			So(checksum, ShouldEqual, sha)
+		})
+
+		Convey("multi entry file is matched on filename", func() {
+			data := "0000000000000000000000000000000000000000000000000000000000000000  other.zip\n" + sha + " *plugin.zip\n"
+			checksum, err := parseChecksumFile([]byte(data), "plugin.zip")
+			So(err, ShouldBeNil)
+			So(checksum, ShouldEqual, sha)
+		})
+
+		Convey("file without digest", func() {
+			_, err := parseChecksumFile([]byte("<html>not found</html>"), "plugin.zip")
+			So(err, ShouldEqual, ErrChecksumNotFound)
+		})
+	})
+
+	Convey("Sidecar checksums are not trusted from signed repositories", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(sha + "\n"))
+		}))
+		defer server.Close()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		checksum, err := GetChecksum("test-plugin", m.Version{Version: "1.0.0"}, server.URL+"/plugin.zip")
+		So(err, ShouldBeNil)
+		So(checksum, ShouldEqual, sha)
+
+		public, _, err := ed25519.GenerateKey(nil)
+		So(err, ShouldBeNil)
+		SetIndexKeys([]ed25519.PublicKey{public})
+		defer SetIndexKeys(nil)
+
+		_, err = GetChecksum("test-plugin", m.Version{Version: "1.0.0"}, server.URL+"/plugin.zip")
+		So(err, ShouldEqual, ErrChecksumNotFound)
+	})
+
+	Convey("Parse checksums supplied by the caller", t, func() {
+		checksum, err := ParseChecksum(" sha256:" + strings.ToUpper(sha))
+		So(err, ShouldBeNil)
+		So(checksum, ShouldEqual, sha)
+
+		_, err = ParseChecksum("not-a-checksum")
+		So(err, ShouldEqual, ErrInvalidChecksum)
+	})
+
+	Convey("Verify archive checksum", t, func() {
+		So(VerifyChecksum(body, sha), ShouldBeNil)
+		So(VerifyChecksum([]byte("tampered"), sha), ShouldEqual, ErrChecksumMismatch)
+		So(VerifyChecksum(body, "abc"), ShouldNotBeNil)
+	})
+}
+
+func TestFIPSMode(t *testing.T) {
+	Convey("FIPS mode only accepts approved digests", t, func() {
+		fipsMode = true
+		defer func() { fipsMode = fipsBuild || systemFIPSEnabled() }()
+
+		v := m.Version{Version: "1.0.0", Arch: map[string]m.ArchMeta{"any": {Md5: "d41d8cd98f00b204e9800998ecf8427e"}}}
+		_, err := GetChecksum("test-plugin", v, "")
+		So(xerrors.Is(err, ErrDigestNotApproved), ShouldBeTrue)
+
+		So(VerifyChecksum([]byte{}, "d41d8cd98f00b204e9800998ecf8427e"), ShouldEqual, ErrDigestNotApproved)
+
+		_, err = ParseChecksum("md5:d41d8cd98f00b204e9800998ecf8427e")
+		So(err, ShouldEqual, ErrDigestNotApproved)
+	})
+}
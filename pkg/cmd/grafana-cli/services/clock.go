@@ -0,0 +1,29 @@
+package services
+
+import (
+	"github.com/benbjohnson/clock"
+)
+
+var clk = clock.New()
+
+// SetClock replaces the clock cache expiry, stale metadata, retries, backoff,
+// health probes and archive retention are measured with, so tests can
+// advance time deterministically with a clock.Mock. nil restores the wall
+// clock.
+func SetClock(c clock.Clock) {
+	if c == nil {
+		c = clock.New()
+	}
+
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	clk = c
+}
+
+func getClock() clock.Clock {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return clk
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClock(t *testing.T) {
+	Convey("Cache entries expire on the configured clock", t, func() {
+		mock := clock.NewMock()
+		SetClock(mock)
+		defer SetClock(nil)
+
+		c := newMemoryCache()
+		c.Set("metadata:test", []byte("cached"), time.Minute)
+
+		_, ok := c.Get("metadata:test")
+		So(ok, ShouldBeTrue)
+
+		mock.Add(2 * time.Minute)
+		_, ok = c.Get("metadata:test")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Retries wait on the configured clock", t, func() {
+		mock := clock.NewMock()
+		SetClock(mock)
+		defer SetClock(nil)
+
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.Header().Set("Retry-After", "5")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"id": "clock-panel", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer server.Close()
+
+		done := make(chan error)
+		go func() {
+			_, err := GetPlugin("clock-panel", server.URL)
+			done <- err
+		}()
+
+		var err error
+	wait:
+		for {
+			select {
+			case err = <-done:
+				break wait
+			default:
+				mock.Add(time.Second)
+			}
+		}
+		So(err, ShouldBeNil)
+		So(requests, ShouldEqual, 2)
+		So(mock.Now().Sub(time.Unix(0, 0)), ShouldBeGreaterThanOrEqualTo, 5*time.Second)
+	})
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// These tests are most useful with go test -race.
+func TestConcurrentUse(t *testing.T) {
+	Convey("Resolving and downloading while the configuration changes", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if path.Base(r.URL.Path) == "download" {
+				w.Write([]byte("archive"))
+				return
+			}
+			w.Write([]byte(`{"id": "` + path.Base(r.URL.Path) + `", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer server.Close()
+
+		defer func() {
+			middlewares = nil
+			credentialStore = &CredentialStore{}
+			credentials = credentialStore
+			SetCache(newMemoryCache())
+			SetFetcher(nil)
+			SetProgressHandler(nil)
+			SetTrustPolicy(TrustPolicy{})
+			SetStaleMetadataMaxAge(0)
+			SetVersionsPageSize(0)
+			SetPreferFrontendOnly(false)
+			SetPreferSlimArtifacts(false)
+			SetRefuseDeprecated(false)
+			redirectPolicy = nil
+		}()
+
+		ids := []string{"plugin-a", "plugin-b", "plugin-c", "plugin-d"}
+		errs := make(chan error, len(ids)*20)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			for _, id := range ids {
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+
+					if _, err := Resolve(server.URL, PluginRequest{PluginID: id}); err != nil {
+						errs <- err
+						return
+					}
+					if _, err := DownloadArchive(id, server.URL+"/"+id+"/versions/1.0.0/download"); err != nil {
+						errs <- err
+					}
+				}(id)
+			}
+		}
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				Use(func(next RepoHandler) RepoHandler { return next })
+				UpdateCredentials(server.URL, Credentials{Token: "token"})
+				SetCache(newMemoryCache())
+				SetFetcher(HTTPFetcher{})
+				SetProgressHandler(func(ProgressEvent) {})
+				SetTrustPolicy(TrustPolicy{})
+				SetStaleMetadataMaxAge(time.Hour)
+				SetVersionsPageSize(0)
+				SetPreferFrontendOnly(false)
+				SetPreferSlimArtifacts(false)
+				SetRefuseDeprecated(false)
+				SetRedirectPolicy(RedirectPolicy{})
+				invalidateMetadata("plugin-a", server.URL)
+			}()
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+	})
+
+	Convey("Prefetching from several goroutines", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "` + path.Base(r.URL.Path) + `", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer server.Close()
+		prevRepoURL := RepoURL()
+		setRepoURL(server.URL)
+		defer setRepoURL(prevRepoURL)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- PrefetchMetadata(context.Background(), []string{"plugin-a", "plugin-b"}, 2)
+			}()
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RepositoryConfig is the complete repository configuration of an instance,
+// as read from flags, grafana.ini or provisioning files.
+type RepositoryConfig struct {
+	RepoURL  string
+	Mirrors  []string
+	Fixtures string
+
+	Token    string
+	Username string
+	Password string
+
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	TLSMinVersion      string
+	TLSCipherSuites    []string
+
+	ArchiveStore string
+	PluginDir    string
+	QuotaLimit   int64
+	QuotaPolicy  QuotaPolicy
+
+	TrustedPublishers []string
+	AllowUnverified   bool
+	ChecksumPinned    bool
+}
+
+// ProblemSeverity tells whether a problem prevents the configuration from working.
+type ProblemSeverity string
+
+const (
+	SeverityError   ProblemSeverity = "error"
+	SeverityWarning ProblemSeverity = "warning"
+)
+
+// Problem is an issue found in a RepositoryConfig.
+type Problem struct {
+	Field    string
+	Severity ProblemSeverity
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Severity, p.Field, p.Message)
+}
+
+// ValidateConfig checks a repository configuration without contacting the
+// repository, so bad settings are caught before the first install.
+func ValidateConfig(cfg RepositoryConfig) []Problem {
+	var problems []Problem
+	add := func(field string, severity ProblemSeverity, format string, args ...interface{}) {
+		problems = append(problems, Problem{Field: field, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	repo := validateRepoURL("repo", cfg.RepoURL, add)
+	for i, mirror := range cfg.Mirrors {
+		validateRepoURL(fmt.Sprintf("repoMirrors[%d]", i), mirror, add)
+	}
+
+	if cfg.Fixtures != "" {
+		validateDir("repoFixtures", cfg.Fixtures, false, add)
+	}
+
+	switch {
+	case cfg.Username != "" && cfg.Password == "":
+		add("password", SeverityError, "username is set without a password")
+	case cfg.Username == "" && cfg.Password != "":
+		add("username", SeverityError, "password is set without a username")
+	}
+	if cfg.Token != "" && cfg.Username != "" {
+		add("token", SeverityWarning, "both a token and basic auth are configured, the token is used")
+	}
+	if repo != nil && repo.Scheme == "http" && (cfg.Token != "" || cfg.Password != "") && !isLoopback(repo.Hostname()) {
+		add("repo", SeverityError, "credentials would be sent unencrypted over http")
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		add("certFile", SeverityError, "a client certificate requires both certFile and keyFile")
+	} else if cfg.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			add("certFile", SeverityError, "failed to load client certificate: %v", err)
+		}
+	}
+	if cfg.CAFile != "" {
+		if pem, err := ioutil.ReadFile(cfg.CAFile); err != nil {
+			add("caFile", SeverityError, "%v", err)
+		} else if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			add("caFile", SeverityError, "no PEM encoded certificates found")
+		}
+	}
+	if cfg.InsecureSkipVerify {
+		add("insecure", SeverityWarning, "TLS certificates of the repository are not verified")
+		if cfg.CAFile != "" {
+			add("caFile", SeverityWarning, "the CA is ignored since TLS verification is disabled")
+		}
+	}
+	if cfg.TLSMinVersion != "" {
+		if v, err := ParseTLSVersion(cfg.TLSMinVersion); err != nil {
+			add("repoTLSMinVersion", SeverityError, "%v", err)
+		} else if v < tls.VersionTLS12 {
+			add("repoTLSMinVersion", SeverityWarning, "TLS versions below 1.2 are considered weak")
+		}
+	}
+	if _, err := ParseCipherSuites(cfg.TLSCipherSuites); err != nil {
+		add("repoTLSCipherSuites", SeverityError, "%v", err)
+	}
+	if repo != nil && repo.Scheme == "http" && (cfg.TLSMinVersion != "" || len(cfg.TLSCipherSuites) > 0) {
+		add("repo", SeverityWarning, "the TLS policy does not apply to a repository served over http")
+	}
+
+	if cfg.ArchiveStore != "" {
+		validateDir("archiveStore", cfg.ArchiveStore, true, add)
+	}
+	if cfg.PluginDir != "" {
+		validateDir("pluginsDir", cfg.PluginDir, true, add)
+	}
+
+	if cfg.QuotaLimit < 0 {
+		add("pluginsDirQuota", SeverityError, "quota can not be negative")
+	}
+	if cfg.QuotaLimit > 0 && !cfg.QuotaPolicy.valid() {
+		add("pluginsDirQuotaPolicy", SeverityError, "unknown policy %q, expected %s or %s", cfg.QuotaPolicy, QuotaRefuse, QuotaEvictOldest)
+	}
+
+	for i, publisher := range cfg.TrustedPublishers {
+		if strings.TrimSpace(publisher) == "" {
+			add(fmt.Sprintf("trustedPublishers[%d]", i), SeverityError, "empty publisher")
+		}
+	}
+
+	if cfg.AllowUnverified && fipsMode {
+		add("allowUnverified", SeverityWarning, "installing unverified archives on a FIPS enabled host")
+	}
+	if cfg.AllowUnverified && cfg.ChecksumPinned {
+		add("allowUnverified", SeverityWarning, "has no effect while a checksum is pinned")
+	}
+
+	return problems
+}
+
+func validateRepoURL(field, value string, add func(string, ProblemSeverity, string, ...interface{})) *url.URL {
+	if value == "" {
+		add(field, SeverityError, "repository url is empty")
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		add(field, SeverityError, "invalid url: %v", err)
+		return nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		add(field, SeverityError, "%q is not an absolute http or https url", value)
+		return nil
+	}
+	if u.Scheme == "http" && !isLoopback(u.Hostname()) {
+		add(field, SeverityWarning, "plugin metadata is fetched over unencrypted http")
+	}
+
+	return u
+}
+
+func validateDir(field, dir string, writable bool, add func(string, ProblemSeverity, string, ...interface{})) {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) && writable {
+		// created on first use, the closest existing parent has to be writable
+		parent := filepath.Dir(dir)
+		for parent != filepath.Dir(parent) {
+			if _, err := os.Stat(parent); err == nil {
+				break
+			}
+			parent = filepath.Dir(parent)
+		}
+		dir = parent
+		info, err = os.Stat(dir)
+	}
+	if err != nil {
+		add(field, SeverityError, "%v", err)
+		return
+	}
+	if !info.IsDir() {
+		add(field, SeverityError, "%s is not a directory", dir)
+		return
+	}
+
+	if writable {
+		f, err := ioutil.TempFile(dir, ".config-check-")
+		if err != nil {
+			add(field, SeverityError, "%s is not writable", dir)
+			return
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
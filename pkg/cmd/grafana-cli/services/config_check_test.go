@@ -0,0 +1,68 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateConfig(t *testing.T) {
+	fields := func(problems []Problem) []string {
+		var result []string
+		for _, p := range problems {
+			result = append(result, string(p.Severity)+" "+p.Field)
+		}
+		return result
+	}
+
+	Convey("A complete configuration has no problems", t, func() {
+		dir, err := ioutil.TempDir("", "config-check")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		problems := ValidateConfig(RepositoryConfig{
+			RepoURL:      DefaultRepoURL,
+			Mirrors:      []string{"https://mirror.example.com/api/plugins"},
+			Token:        "secret",
+			ArchiveStore: filepath.Join(dir, "archives"),
+			PluginDir:    dir,
+			QuotaLimit:   1024,
+			QuotaPolicy:  QuotaEvictOldest,
+		})
+		So(problems, ShouldBeEmpty)
+	})
+
+	Convey("Inconsistent configurations are reported", t, func() {
+		problems := ValidateConfig(RepositoryConfig{
+			RepoURL:     "http://plugins.example.com",
+			Mirrors:     []string{"mirror.example.com"},
+			Username:    "admin",
+			CertFile:    "client.crt",
+			QuotaLimit:  1024,
+			QuotaPolicy: "random",
+		})
+		So(fields(problems), ShouldResemble, []string{
+			"warning repo",
+			"error repoMirrors[0]",
+			"error password",
+			"error certFile",
+			"error pluginsDirQuotaPolicy",
+		})
+	})
+
+	Convey("Credentials are only sent over http to loopback addresses", t, func() {
+		So(fields(ValidateConfig(RepositoryConfig{RepoURL: "http://repo.example.com", Token: "secret"})), ShouldContain, "error repo")
+		So(ValidateConfig(RepositoryConfig{RepoURL: "http://127.0.0.1:3000", Token: "secret"}), ShouldBeEmpty)
+	})
+
+	Convey("TLS policies are validated", t, func() {
+		So(ValidateConfig(RepositoryConfig{RepoURL: DefaultRepoURL, TLSMinVersion: "1.3"}), ShouldBeEmpty)
+		So(fields(ValidateConfig(RepositoryConfig{RepoURL: DefaultRepoURL, TLSMinVersion: "1.1", TLSCipherSuites: []string{"TLS_NULL"}})), ShouldResemble, []string{
+			"warning repoTLSMinVersion",
+			"error repoTLSCipherSuites",
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// parseConstraint parses version constraints as plugins declare them, which
+// besides the comma separated constraints of go-version include the npm
+// style caret (^7.0.0), tilde (~7.1) and wildcard (7.x, *) forms and space
+// separated ranges (>=6.5 <8).
+func parseConstraint(constraint string) (version.Constraints, error) {
+	var parts []string
+	var operator string
+	for _, field := range strings.FieldsFunc(constraint, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		// operators separated from their version, like ">= 6.5"
+		if strings.TrimLeft(field, "<>=!~^") == "" {
+			operator += field
+			continue
+		}
+
+		normalized, err := normalizeConstraint(operator + field)
+		if err != nil {
+			return nil, fmt.Errorf("malformed constraint: %s", constraint)
+		}
+		parts = append(parts, normalized...)
+		operator = ""
+	}
+	if operator != "" || len(parts) == 0 {
+		return nil, fmt.Errorf("malformed constraint: %s", constraint)
+	}
+
+	return version.NewConstraint(strings.Join(parts, ","))
+}
+
+// normalizeConstraint rewrites a single npm style constraint to go-version
+// ones, leaving the others as they are.
+func normalizeConstraint(c string) ([]string, error) {
+	switch {
+	case c == "*" || c == "x" || c == "X":
+		return []string{">=0.0.0"}, nil
+	case strings.HasPrefix(c, "^"):
+		segments, err := constraintSegments(c[1:])
+		if err != nil {
+			return nil, err
+		}
+		// the first non-zero segment must not change, ^0.2.3 is >=0.2.3 <0.3.0
+		i := 0
+		for i < len(segments)-1 && segments[i] == 0 {
+			i++
+		}
+		return []string{">=" + joinSegments(segments), "<" + joinSegments(bump(segments, i))}, nil
+	case strings.HasPrefix(c, "~") && !strings.HasPrefix(c, "~>"):
+		segments, err := constraintSegments(c[1:])
+		if err != nil {
+			return nil, err
+		}
+		// ~7 is >=7.0.0 <8.0.0, ~7.1 and ~7.1.2 allow patch releases of 7.1
+		i := 0
+		if len(segments) > 1 {
+			i = 1
+		}
+		return []string{">=" + joinSegments(segments), "<" + joinSegments(bump(segments, i))}, nil
+	case strings.HasSuffix(c, ".x") || strings.HasSuffix(c, ".X") || strings.HasSuffix(c, ".*"):
+		segments, err := constraintSegments(c[:len(c)-2])
+		if err != nil {
+			return nil, err
+		}
+		return []string{">=" + joinSegments(segments), "<" + joinSegments(bump(segments, len(segments)-1))}, nil
+	}
+	return []string{c}, nil
+}
+
+// constraintSegments parses the numeric segments of a version like "7.1".
+func constraintSegments(v string) ([]int, error) {
+	var segments []int
+	for _, s := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("malformed version: %s", v)
+		}
+		segments = append(segments, n)
+	}
+	return segments, nil
+}
+
+// bump increments segment i and drops those after it.
+func bump(segments []int, i int) []int {
+	bumped := append([]int{}, segments[:i+1]...)
+	bumped[i]++
+	return bumped
+}
+
+func joinSegments(segments []int) string {
+	parts := make([]string, 0, 3)
+	for _, s := range segments {
+		parts = append(parts, strconv.Itoa(s))
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/hashicorp/go-version"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseConstraint(t *testing.T) {
+	Convey("Constraints are parsed in the forms plugins declare them", t, func() {
+		check := func(constraint, v string) bool {
+			c, err := parseConstraint(constraint)
+			So(err, ShouldBeNil)
+			return c.Check(version.Must(version.NewVersion(v)))
+		}
+
+		So(check(">=6.5.0, <8", "7.0.0"), ShouldBeTrue)
+		So(check(">=6.5 <8", "7.0.0"), ShouldBeTrue)
+		So(check(">=6.5 <8", "8.0.0"), ShouldBeFalse)
+		So(check(">= 6.5", "6.5.0"), ShouldBeTrue)
+
+		So(check("^7.0.0", "7.4.1"), ShouldBeTrue)
+		So(check("^7.0.0", "8.0.0"), ShouldBeFalse)
+		So(check("^0.2.3", "0.2.9"), ShouldBeTrue)
+		So(check("^0.2.3", "0.3.0"), ShouldBeFalse)
+
+		So(check("~7.1", "7.1.5"), ShouldBeTrue)
+		So(check("~7.1.2", "7.2.0"), ShouldBeFalse)
+		So(check("~7", "7.9.0"), ShouldBeTrue)
+		So(check("~> 7.1", "7.9.0"), ShouldBeTrue)
+
+		So(check("7.x", "7.3.0"), ShouldBeTrue)
+		So(check("7.x", "8.0.0"), ShouldBeFalse)
+		So(check("*", "6.0.0"), ShouldBeTrue)
+
+		Convey("rejecting anything else", func() {
+			for _, constraint := range []string{"", ">=", "^seven", "latest", "7.0.0 ||"} {
+				_, err := parseConstraint(constraint)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("Unparseable Grafana dependencies are of unknown compatibility", t, func() {
+		v := m.Version{Version: "1.0.0", GrafanaDependency: "latest"}
+
+		_, err := IsCompatible(v, "7.0.0")
+		So(err, ShouldNotBeNil)
+		So(compatibleWithAll(v, []string{"7.0.0"}), ShouldBeTrue)
+		So(compatibleWithAll(m.Version{Version: "1.0.0", GrafanaDependency: "^7.0.0"}, []string{"8.0.0"}), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Credentials authenticate requests to a plugin repository. A Token is sent
+// as a bearer token and takes precedence over basic auth.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// CredentialsProvider is asked for credentials on every repository request,
+// so rotated secrets take effect without re-initializing the package.
+type CredentialsProvider interface {
+	// Credentials returns the credentials for the request url, ok is false
+	// when the request should be sent unauthenticated.
+	Credentials(url string) (creds Credentials, ok bool)
+}
+
+// CredentialStore is the default CredentialsProvider. Credentials are
+// registered per repository url and used for every request below it.
+type CredentialStore struct {
+	mtx   sync.RWMutex
+	repos map[string]Credentials
+}
+
+// Update replaces the credentials of a repository.
+func (s *CredentialStore) Update(repoUrl string, creds Credentials) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.repos == nil {
+		s.repos = map[string]Credentials{}
+	}
+	s.repos[strings.TrimSuffix(resolveRepoURL(repoUrl), "/")] = creds
+}
+
+func (s *CredentialStore) Credentials(url string) (Credentials, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	// the longest matching repository url wins, so a mirror below the
+	// main repository can have its own credentials
+	var match string
+	for repo := range s.repos {
+		if (url == repo || strings.HasPrefix(url, repo+"/")) && len(repo) > len(match) {
+			match = repo
+		}
+	}
+
+	creds, ok := s.repos[match]
+	return creds, ok
+}
+
+var (
+	credentialStore                     = &CredentialStore{}
+	credentials     CredentialsProvider = credentialStore
+)
+
+// WithCredentialsProvider replaces the built in credential store, e.g. with
+// one reading secrets from a vault.
+func WithCredentialsProvider(p CredentialsProvider) Option {
+	return func(o *options) { o.credentials = p }
+}
+
+// WithHostCredentials authenticates requests to the hosts of tokens, like
+// mirrors and namespace repositories, with the token of their host instead of
+// asking the credentials provider. Tokens can be secret references, resolved
+// on every request like SecretCredentials does.
+func WithHostCredentials(tokens map[string]string) Option {
+	return func(o *options) { o.hostTokens = tokens }
+}
+
+// ParseHostCredentials parses "<host>=<token>" entries, the host may include
+// a port.
+func ParseHostCredentials(entries []string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || parts[1] == "" || strings.Contains(parts[0], "/") {
+			return nil, fmt.Errorf("invalid host credentials %q, expected <host>=<token>", entry)
+		}
+		tokens[strings.ToLower(strings.TrimSpace(parts[0]))] = parts[1]
+	}
+	return tokens, nil
+}
+
+// hostCredentials looks up the token of the host of a request, falling back
+// to next for other hosts.
+type hostCredentials struct {
+	tokens map[string]string
+	next   CredentialsProvider
+}
+
+func (c hostCredentials) Credentials(rawurl string) (Credentials, bool) {
+	if u, err := url.Parse(rawurl); err == nil {
+		if ref, ok := c.tokens[strings.ToLower(u.Host)]; ok {
+			token, err := ResolveSecret(ref)
+			if err != nil {
+				log.Warnf("%v\n", err)
+				return Credentials{}, false
+			}
+			return Credentials{Token: token}, true
+		}
+	}
+	return c.next.Credentials(rawurl)
+}
+
+// UpdateCredentials replaces the credentials used for a repository, taking
+// effect from the next request. It has no effect when a custom provider was
+// configured with WithCredentialsProvider.
+func UpdateCredentials(repoUrl string, creds Credentials) {
+	stateMtx.RLock()
+	store := credentialStore
+	stateMtx.RUnlock()
+
+	store.Update(repoUrl, creds)
+}
+
+func getCredentials() CredentialsProvider {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return credentials
+}
+
+func authenticate(req *http.Request) bool {
+	provider := getCredentials()
+	if provider == nil {
+		return false
+	}
+
+	creds, ok := provider.Credentials(req.URL.String())
+	if !ok {
+		return false
+	}
+
+	switch {
+	case creds.Token != "":
+		req.Header.Set("Authorization", "Bearer "+creds.Token)
+	case creds.Username != "":
+		req.SetBasicAuth(creds.Username, creds.Password)
+	default:
+		return false
+	}
+	return true
+}
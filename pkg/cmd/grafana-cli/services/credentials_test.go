@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCredentials(t *testing.T) {
+	Convey("Rotated credentials are used from the next request", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Header.Get("Authorization")))
+		}))
+		defer server.Close()
+
+		defer func() { credentialStore = &CredentialStore{}; credentials = credentialStore }()
+		credentialStore = &CredentialStore{}
+		credentials = credentialStore
+
+		body, err := sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL+"/repo", "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldBeEmpty)
+
+		UpdateCredentials(server.URL+"/repo", Credentials{Token: "first"})
+		body, err = sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL+"/repo", "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "Bearer first")
+
+		UpdateCredentials(server.URL+"/repo", Credentials{Token: "second"})
+		body, err = sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL+"/repo", "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "Bearer second")
+
+		Convey("Requests outside the repository are sent unauthenticated", func() {
+			body, err := sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL+"/repository", "test-plugin")
+			So(err, ShouldBeNil)
+			So(string(body), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestHostCredentials(t *testing.T) {
+	Convey("Mirrors are authenticated with the token of their host", t, func() {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Header.Get("Authorization")))
+		})
+		repo := httptest.NewServer(handler)
+		defer repo.Close()
+		mirror := httptest.NewServer(handler)
+		defer mirror.Close()
+
+		defer Init("", false)
+		tokens, err := ParseHostCredentials([]string{strings.TrimPrefix(mirror.URL, "http://") + "=env:MIRROR_TOKEN", ""})
+		So(err, ShouldBeNil)
+		os.Setenv("MIRROR_TOKEN", "mirror")
+		defer os.Unsetenv("MIRROR_TOKEN")
+		Init("", false, WithHostCredentials(tokens))
+		UpdateCredentials(repo.URL, Credentials{Token: "repo"})
+
+		body, err := sendRequest(context.Background(), OpGetPlugin, "test-plugin", repo.URL, "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "Bearer repo")
+
+		body, err = sendRequest(context.Background(), OpGetPlugin, "test-plugin", mirror.URL, "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "Bearer mirror")
+
+		Convey("rejecting entries without a host or token", func() {
+			for _, entry := range []string{"mirror.example.com", "=token", "mirror.example.com=", "https://mirror.example.com/repo=token"} {
+				_, err := ParseHostCredentials([]string{entry})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
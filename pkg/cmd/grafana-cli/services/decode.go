@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var ErrMalformedResponse = errors.New("malformed repository response")
+
+// DecodeError is returned for repository responses that can not be decoded,
+// or in strict mode lack required fields. Field is the JSON path of the
+// offending value when known, e.g. "versions[2].version".
+type DecodeError struct {
+	URL   string
+	Field string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%v from %s: %s: %v", ErrMalformedResponse, e.URL, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%v from %s: %v", ErrMalformedResponse, e.URL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrMalformedResponse
+}
+
+var errFieldRequired = errors.New("required field is missing")
+
+var strictDecoding bool
+
+// WithStrictDecoding rejects repository responses with unknown fields or
+// missing required fields, so mirror implementers find mistakes in their index
+// instead of getting zero values. Responses of grafana.com carry more fields
+// than grafana-cli knows, so it is meant for testing mirrors.
+func WithStrictDecoding() Option {
+	return func(o *options) { o.strictDecoding = true }
+}
+
+// decodeResponse decodes a repository response into v.
+func decodeResponse(url string, body []byte, v interface{}) error {
+	stateMtx.RLock()
+	strict := strictDecoding
+	stateMtx.RUnlock()
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(v); err != nil {
+		e := &DecodeError{URL: url, Err: err}
+		var typeErr *json.UnmarshalTypeError
+		if xerrors.As(err, &typeErr) {
+			e.Field = typeErr.Field
+		} else if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			e.Field = strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		}
+		return e
+	}
+
+	if !strict {
+		return nil
+	}
+
+	if field := missingField(v); field != "" {
+		return &DecodeError{URL: url, Field: field, Err: errFieldRequired}
+	}
+	return nil
+}
+
+// missingField returns the first required field missing in a decoded response.
+func missingField(v interface{}) string {
+	switch r := v.(type) {
+	case *m.PluginRepo:
+		for i, p := range r.Plugins {
+			if field := missingPluginField(p); field != "" {
+				return fmt.Sprintf("plugins[%d].%s", i, field)
+			}
+		}
+	case *m.Plugin:
+		return missingPluginField(*r)
+	case *PluginManifest:
+		switch {
+		case r.Plugin == "":
+			return "plugin"
+		case r.Version == "":
+			return "version"
+		case r.Files == nil:
+			return "files"
+		}
+	}
+	return ""
+}
+
+func missingPluginField(p m.Plugin) string {
+	if p.Id == "" {
+		return "id"
+	}
+	for i, v := range p.Versions {
+		if v.Version == "" {
+			return fmt.Sprintf("versions[%d].version", i)
+		}
+	}
+	return ""
+}
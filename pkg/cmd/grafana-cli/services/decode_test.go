@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	getPlugin := func() error {
+		invalidateMetadata("decode-panel", server.URL)
+		_, err := GetPluginWithContext(context.Background(), "decode-panel", server.URL)
+		return err
+	}
+
+	Convey("Unknown and missing fields are accepted by default", t, func() {
+		body = `{"id": "decode-panel", "installs": 10, "versions": [{"url": ""}]}`
+		So(getPlugin(), ShouldBeNil)
+	})
+
+	Convey("Strict decoding reports the offending field", t, func() {
+		defer Init("", false)
+		Init("", false, WithStrictDecoding())
+
+		body = `{"id": "decode-panel", "installs": 10}`
+		err := getPlugin()
+		So(xerrors.Is(err, ErrMalformedResponse), ShouldBeTrue)
+		So(ClassifyError(err), ShouldEqual, ErrorClassDecode)
+
+		var decodeErr *DecodeError
+		So(xerrors.As(err, &decodeErr), ShouldBeTrue)
+		So(decodeErr.Field, ShouldEqual, "installs")
+		So(decodeErr.URL, ShouldEqual, server.URL+"/repo/decode-panel")
+
+		body = `{"id": "decode-panel", "versions": [{"version": "1.0.0"}, {"url": ""}]}`
+		err = getPlugin()
+		So(xerrors.As(err, &decodeErr), ShouldBeTrue)
+		So(decodeErr.Field, ShouldEqual, "versions[1].version")
+
+		body = `{"id": "decode-panel", "versions": "1.0.0"}`
+		err = getPlugin()
+		So(xerrors.As(err, &decodeErr), ShouldBeTrue)
+		So(decodeErr.Field, ShouldEqual, "versions")
+	})
+}
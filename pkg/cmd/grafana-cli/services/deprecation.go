@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var ErrPluginDeprecated = errors.New("plugin is deprecated")
+
+// DeprecationNotice returns a human readable notice when the repository flags
+// the plugin as deprecated or end of life, and an empty string otherwise.
+func DeprecationNotice(plugin m.Plugin) string {
+	if !plugin.Deprecated && !plugin.EndOfLife {
+		return ""
+	}
+
+	state := "deprecated"
+	if plugin.EndOfLife {
+		state = "end of life"
+	}
+
+	notice := fmt.Sprintf("%s is %s", plugin.Id, state)
+	if plugin.DeprecationMessage != "" {
+		notice += ": " + plugin.DeprecationMessage
+	}
+	if plugin.ReplacedBy != "" {
+		notice += fmt.Sprintf(" (replaced by %s)", plugin.ReplacedBy)
+	}
+
+	return notice
+}
+
+// CheckDeprecation returns ErrPluginDeprecated for deprecated or end of life
+// plugins when refuseDeprecated is set.
+func CheckDeprecation(plugin m.Plugin, refuseDeprecated bool) error {
+	notice := DeprecationNotice(plugin)
+	if notice == "" || !refuseDeprecated {
+		return nil
+	}
+
+	return xerrors.Errorf("%s: %w", notice, ErrPluginDeprecated)
+}
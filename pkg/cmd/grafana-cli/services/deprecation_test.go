@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestDeprecation(t *testing.T) {
+	Convey("Deprecated and end of life plugins are noticed", t, func() {
+		So(DeprecationNotice(m.Plugin{Id: "current-panel"}), ShouldBeEmpty)
+		So(DeprecationNotice(m.Plugin{Id: "old-panel", Deprecated: true}), ShouldEqual, "old-panel is deprecated")
+		So(DeprecationNotice(m.Plugin{Id: "old-panel", Deprecated: true, EndOfLife: true, DeprecationMessage: "use new-panel"}),
+			ShouldEqual, "old-panel is end of life: use new-panel")
+
+		So(CheckDeprecation(m.Plugin{Id: "old-panel", Deprecated: true}, false), ShouldBeNil)
+		So(CheckDeprecation(m.Plugin{Id: "current-panel"}, true), ShouldBeNil)
+		So(xerrors.Is(CheckDeprecation(m.Plugin{Id: "old-panel", Deprecated: true}, true), ErrPluginDeprecated), ShouldBeTrue)
+	})
+
+	Convey("Deprecated plugins", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo":
+				w.Write([]byte(`{"plugins": [
+					{"id": "old-panel", "deprecated": true, "deprecationMessage": "use new-panel", "versions": [{"version": "1.0.0"}]},
+					{"id": "current-panel", "versions": [{"version": "1.0.0"}]}]}`))
+			case "/repo/old-panel":
+				w.Write([]byte(`{"id": "old-panel", "deprecated": true, "deprecationMessage": "use new-panel", "versions": [{"version": "1.0.0"}]}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		Convey("are only refused when asked to", func() {
+			defer SetRefuseDeprecated(false)
+
+			_, err := Resolve(server.URL, PluginRequest{PluginID: "old-panel"})
+			So(err, ShouldBeNil)
+
+			SetRefuseDeprecated(true)
+			_, err = Resolve(server.URL, PluginRequest{PluginID: "old-panel", Force: true})
+			So(xerrors.Is(err, ErrPluginDeprecated), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "use new-panel")
+		})
+
+		Convey("are reported by update checks when installed", func() {
+			dir, err := ioutil.TempDir("", "plugins")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			for _, id := range []string{"old-panel", "current-panel"} {
+				So(os.MkdirAll(filepath.Join(dir, id), 0755), ShouldBeNil)
+				json := `{"id": "` + id + `", "info": {"version": "1.0.0"}}`
+				So(ioutil.WriteFile(filepath.Join(dir, id, "plugin.json"), []byte(json), 0644), ShouldBeNil)
+			}
+
+			store := FileUpdateStore{Path: filepath.Join(dir, "updates.json")}
+			status, err := NewUpdateChecker(server.URL, dir, store).CheckOnce(context.Background())
+			So(err, ShouldBeNil)
+			So(status.Updates, ShouldBeEmpty)
+			So(status.Deprecations, ShouldResemble, []Deprecation{{PluginID: "old-panel", Notice: "old-panel is deprecated: use new-panel"}})
+
+			saved, err := store.Load()
+			So(err, ShouldBeNil)
+			So(saved.Deprecations, ShouldResemble, status.Deprecations)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DownloadTransportRetry is how long archive downloads from a host skip the
+// transport set with WithDownloadTransport after it failed for that host.
+var DownloadTransportRetry = 10 * time.Minute
+
+// WithDownloadTransport makes archive downloads try the round tripper
+// newTransport returns first, e.g. an HTTP/3 one for lossy links. It is passed
+// the TLS config of the default transport, with the TLS policy applied, so
+// both connect alike. Downloads it fails to connect for fall back to the
+// default transport, which negotiates HTTP/2 or HTTP/1.1. Metadata requests
+// are not affected.
+func WithDownloadTransport(newTransport func(tlsConfig *tls.Config) http.RoundTripper) Option {
+	return func(o *options) { o.downloadTransport = newTransport }
+}
+
+// fallbackTransport sends requests with primary and retries them with
+// fallback when primary returns an error, remembering failing hosts for
+// DownloadTransportRetry.
+type fallbackTransport struct {
+	primary  http.RoundTripper
+	fallback http.RoundTripper
+
+	mtx    sync.Mutex
+	failed map[string]time.Time
+}
+
+func newFallbackTransport(primary, fallback http.RoundTripper) *fallbackTransport {
+	if fallback == nil {
+		fallback = http.DefaultTransport
+	}
+	return &fallbackTransport{primary: primary, fallback: fallback, failed: map[string]time.Time{}}
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// only requests without a body can be replayed on the fallback
+	if req.Body != nil && req.Body != http.NoBody || t.skipPrimary(req.URL.Host) {
+		return t.fallback.RoundTrip(req)
+	}
+
+	res, err := t.primary.RoundTrip(req)
+	if err == nil {
+		return res, nil
+	}
+
+	if req.Context().Err() != nil {
+		return nil, err
+	}
+
+	log.Debugf("download transport failed for %v, falling back: %v\n", req.URL.Host, err)
+	t.mtx.Lock()
+	t.failed[req.URL.Host] = getClock().Now()
+	t.mtx.Unlock()
+
+	return t.fallback.RoundTrip(req)
+}
+
+func (t *fallbackTransport) skipPrimary(host string) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	failedAt, ok := t.failed[host]
+	if !ok {
+		return false
+	}
+	if getClock().Since(failedAt) >= DownloadTransportRetry {
+		delete(t.failed, host)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFallbackTransport(t *testing.T) {
+	Convey("Downloads fall back when the preferred transport fails", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("archive"))
+		}))
+		defer server.Close()
+
+		var attempts int32
+		primary := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&attempts, 1)
+			return nil, errors.New("no UDP connectivity")
+		})
+
+		client := http.Client{Transport: newFallbackTransport(primary, http.DefaultTransport)}
+		for i := 0; i < 2; i++ {
+			res, err := client.Get(server.URL + "/download")
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			So(string(body), ShouldEqual, "archive")
+		}
+
+		// the failing host is remembered so later downloads skip the primary
+		So(atomic.LoadInt32(&attempts), ShouldEqual, 1)
+	})
+
+	Convey("Init only wraps the download client", t, func() {
+		defer Init("", false)
+
+		var primaryTLS *tls.Config
+		primary := func(tlsConfig *tls.Config) http.RoundTripper {
+			primaryTLS = tlsConfig
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return nil, errors.New("unused")
+			})
+		}
+		Init("", false, WithDownloadTransport(primary), WithTLSPolicy(TLSPolicy{MinVersion: tls.VersionTLS13}))
+
+		_, ok := DownloadClient.Transport.(*fallbackTransport)
+		So(ok, ShouldBeTrue)
+		_, ok = HttpClient.Transport.(*fallbackTransport)
+		So(ok, ShouldBeFalse)
+
+		Convey("building the download transport with the TLS policy", func() {
+			So(primaryTLS, ShouldNotBeNil)
+			So(primaryTLS.MinVersion, ShouldEqual, tls.VersionTLS13)
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnexpectedContentType = errors.New("download is not a plugin archive")
+	ErrIncompleteDownload    = errors.New("download is incomplete")
+)
+
+// pageContentTypes are the media types of web pages and API responses that
+// get served in place of an archive by captive portals, proxies and
+// misconfigured mirrors. Archives themselves are served with many types,
+// from application/zip to sniffed or generic ones, so only these are refused.
+var pageContentTypes = map[string]bool{
+	"text/html":                true,
+	"application/xhtml+xml":    true,
+	"text/xml":                 true,
+	"application/xml":          true,
+	"application/json":         true,
+	"application/problem+json": true,
+	"text/javascript":          true,
+	"application/javascript":   true,
+	"text/css":                 true,
+}
+
+// ContentTypeError is returned when a download is served with a media type
+// that is not an archive, most often an HTML page of a captive portal or a
+// proxy in place of the plugin.
+type ContentTypeError struct {
+	URL         string
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	msg := fmt.Sprintf("%s was served as %q: %v", e.URL, e.ContentType, ErrUnexpectedContentType)
+	if strings.HasPrefix(e.ContentType, "text/html") {
+		msg += ", a proxy or captive portal may be intercepting the download"
+	}
+	return msg
+}
+
+func (e *ContentTypeError) Unwrap() error {
+	return ErrUnexpectedContentType
+}
+
+// IncompleteDownloadError is returned when the connection ended before the
+// announced Content-Length was received.
+type IncompleteDownloadError struct {
+	URL      string
+	Expected int64
+	Received int64
+}
+
+func (e *IncompleteDownloadError) Error() string {
+	return fmt.Sprintf("%s: received %d of %d bytes: %v", e.URL, e.Received, e.Expected, ErrIncompleteDownload)
+}
+
+func (e *IncompleteDownloadError) Unwrap() error {
+	return ErrIncompleteDownload
+}
+
+// checkDownload rejects successful archive responses that are web pages and
+// makes reading the body fail with an IncompleteDownloadError when it is
+// shorter than its Content-Length. Error statuses are left to the caller.
+func checkDownload(url string, res *http.Response, err error) (*http.Response, error) {
+	if err != nil || res.StatusCode/100 != 2 {
+		return res, err
+	}
+
+	if header := res.Header.Get("Content-Type"); header != "" {
+		mediaType, _, parseErr := mime.ParseMediaType(header)
+		if parseErr == nil && pageContentTypes[strings.ToLower(mediaType)] {
+			res.Body.Close()
+			return nil, &ContentTypeError{URL: url, ContentType: header}
+		}
+	}
+
+	if res.ContentLength >= 0 {
+		res.Body = &lengthCheckingReader{ReadCloser: res.Body, url: url, expected: res.ContentLength}
+	}
+	return res, nil
+}
+
+type lengthCheckingReader struct {
+	io.ReadCloser
+	url      string
+	expected int64
+	received int64
+}
+
+func (r *lengthCheckingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.received += int64(n)
+
+	if (err == io.EOF || err == io.ErrUnexpectedEOF) && r.received != r.expected {
+		return n, &IncompleteDownloadError{URL: r.url, Expected: r.expected, Received: r.received}
+	}
+	return n, err
+}
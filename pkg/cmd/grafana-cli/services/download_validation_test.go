@@ -0,0 +1,59 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestDownloadValidation(t *testing.T) {
+	Convey("Downloads are validated", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/portal.zip":
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte("<html><body>Please log in to the wifi</body></html>"))
+			case "/truncated.zip":
+				w.Header().Set("Content-Type", "application/zip")
+				w.Header().Set("Content-Length", "100")
+				w.Write([]byte("PK\x03\x04"))
+			default:
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write([]byte("PK\x03\x04"))
+			}
+		}))
+		defer server.Close()
+
+		Convey("html pages are not saved as archives", func() {
+			_, err := DownloadArchive("portal-panel", server.URL+"/portal.zip")
+			So(xerrors.Is(err, ErrUnexpectedContentType), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "captive portal")
+
+			var typeErr *ContentTypeError
+			So(xerrors.As(err, &typeErr), ShouldBeTrue)
+			So(typeErr.ContentType, ShouldEqual, "text/html; charset=utf-8")
+
+			_, err = OpenArchive("portal-panel", server.URL+"/portal.zip")
+			So(xerrors.Is(err, ErrUnexpectedContentType), ShouldBeTrue)
+		})
+
+		Convey("bodies shorter than their Content-Length fail", func() {
+			_, err := DownloadArchive("truncated-panel", server.URL+"/truncated.zip")
+			So(xerrors.Is(err, ErrIncompleteDownload), ShouldBeTrue)
+
+			var lengthErr *IncompleteDownloadError
+			So(xerrors.As(err, &lengthErr), ShouldBeTrue)
+			So(lengthErr.Expected, ShouldEqual, 100)
+			So(lengthErr.Received, ShouldEqual, 4)
+		})
+
+		Convey("archives are accepted", func() {
+			body, err := DownloadArchive("good-panel", server.URL+"/good.zip")
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "PK\x03\x04")
+		})
+	})
+}
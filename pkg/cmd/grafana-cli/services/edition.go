@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+)
+
+// Edition is a Grafana distribution plugin versions can be restricted to.
+type Edition string
+
+const (
+	EditionOSS        Edition = "oss"
+	EditionEnterprise Edition = "enterprise"
+	EditionCloud      Edition = "cloud"
+)
+
+var ErrEditionNotSupported = errors.New("plugin version is not available for this Grafana edition")
+
+// ParseEdition parses an edition name, case insensitively.
+func ParseEdition(value string) (Edition, error) {
+	switch e := Edition(strings.ToLower(strings.TrimSpace(value))); e {
+	case EditionOSS, EditionEnterprise, EditionCloud:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown Grafana edition %q, expected oss, enterprise or cloud", value)
+}
+
+// EditionNotSupportedError is returned when the requested plugin version
+// requires an edition or entitlement the instance does not have.
+type EditionNotSupportedError struct {
+	PluginID string
+	Version  string
+	// Editions the version is available for, empty when only entitlements are missing.
+	Editions []string
+	// Missing are the entitlements the version requires but the instance lacks.
+	Missing []string
+}
+
+func (e *EditionNotSupportedError) Error() string {
+	var required []string
+	if len(e.Editions) > 0 {
+		required = append(required, "editions "+strings.Join(e.Editions, ", "))
+	}
+	if len(e.Missing) > 0 {
+		required = append(required, "entitlements "+strings.Join(e.Missing, ", "))
+	}
+	return fmt.Sprintf("%s@%s requires %s: %v", e.PluginID, e.Version, strings.Join(required, " and "), ErrEditionNotSupported)
+}
+
+func (e *EditionNotSupportedError) Unwrap() error {
+	return ErrEditionNotSupported
+}
+
+var (
+	instanceEdition      Edition
+	instanceEntitlements []string
+)
+
+// SetEdition configures the edition and feature entitlements of the Grafana
+// instance plugins are resolved for. Without an edition, version restrictions
+// are not checked.
+func SetEdition(edition Edition, entitlements []string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	instanceEdition = edition
+	instanceEntitlements = entitlements
+}
+
+// requestEdition returns the edition and entitlements of the request, falling
+// back to the configured ones.
+func requestEdition(req PluginRequest) (Edition, []string) {
+	if req.Edition != "" {
+		return req.Edition, req.Entitlements
+	}
+
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return instanceEdition, instanceEntitlements
+}
+
+// checkEdition returns an EditionNotSupportedError when v is restricted to
+// editions or entitlements the requesting instance does not have.
+func checkEdition(pluginId string, v m.Version, req PluginRequest) error {
+	edition, entitlements := requestEdition(req)
+	if edition == "" {
+		return nil
+	}
+
+	err := &EditionNotSupportedError{PluginID: pluginId, Version: v.Version}
+	if len(v.Editions) > 0 && !containsFold(v.Editions, string(edition)) {
+		err.Editions = v.Editions
+	}
+	for _, required := range v.Entitlements {
+		if !containsFold(entitlements, required) {
+			err.Missing = append(err.Missing, required)
+		}
+	}
+
+	if err.Editions == nil && err.Missing == nil {
+		return nil
+	}
+	return err
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
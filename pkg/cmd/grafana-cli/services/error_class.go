@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/xerrors"
+)
+
+// ErrorClass is a coarse classification of failed repository operations,
+// used in errors, metric labels and log fields alike.
+type ErrorClass string
+
+const (
+	ErrorClassDNS     ErrorClass = "dns"
+	ErrorClassTLS     ErrorClass = "tls"
+	ErrorClassTimeout ErrorClass = "timeout"
+	ErrorClassNetwork ErrorClass = "network"
+	ErrorClassClient  ErrorClass = "client"
+	ErrorClassServer  ErrorClass = "server"
+	ErrorClassDecode  ErrorClass = "decode"
+	ErrorClassUnknown ErrorClass = "unknown"
+)
+
+// RepoError is a failed repository operation.
+type RepoError struct {
+	Op       Operation
+	PluginID string
+	URL      string
+	Class    ErrorClass
+	// StatusCode is set when the repository responded with an error status.
+	StatusCode int
+	// RequestID is the correlation id the request was sent with.
+	RequestID string
+	Err       error
+}
+
+func (e *RepoError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RepoError) Unwrap() error {
+	return e.Err
+}
+
+var repoErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "grafana",
+	Name:      "plugin_repo_errors_total",
+	Help:      "failed plugin repository operations by operation and error class",
+}, []string{"op", "class"})
+
+// RegisterMetrics registers the repository and resolution metrics with reg,
+// registering them again with the same reg is a no-op.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{repoErrors, resolutions} {
+		err := reg.Register(c)
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok && are.ExistingCollector == c {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClassifyError returns the class of a failed repository operation.
+func ClassifyError(err error) ErrorClass {
+	var repoErr *RepoError
+	if xerrors.As(err, &repoErr) {
+		return repoErr.Class
+	}
+
+	return classify(err)
+}
+
+func classify(err error) ErrorClass {
+	var (
+		status      *gcom.StatusError
+		dnsErr      *net.DNSError
+		unknownCA   x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		certErr     x509.CertificateInvalidError
+		recordErr   tls.RecordHeaderError
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+		decodeErr   *DecodeError
+		netErr      net.Error
+	)
+
+	switch {
+	case xerrors.Is(err, ErrNotFoundError):
+		return ErrorClassClient
+	case xerrors.As(err, &status):
+		if status.StatusCode/100 == 4 {
+			return ErrorClassClient
+		}
+		return ErrorClassServer
+	case xerrors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case xerrors.As(err, &unknownCA), xerrors.As(err, &hostnameErr), xerrors.As(err, &certErr), xerrors.As(err, &recordErr):
+		return ErrorClassTLS
+	case xerrors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case xerrors.As(err, &decodeErr), xerrors.As(err, &syntaxErr), xerrors.As(err, &typeErr):
+		return ErrorClassDecode
+	case xerrors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	}
+
+	return ErrorClassUnknown
+}
+
+// IsURLExpired reports whether a download was refused, as CDNs do once a
+// pre-signed url expired. Resolving the version again yields a fresh url.
+func IsURLExpired(err error) bool {
+	var repoErr *RepoError
+	if xerrors.As(err, &repoErr) && repoErr.StatusCode != 0 {
+		return repoErr.StatusCode == http.StatusForbidden
+	}
+
+	var status *gcom.StatusError
+	return xerrors.As(err, &status) && status.StatusCode == http.StatusForbidden
+}
+
+// repoError classifies and records a failed repository operation.
+func repoError(op Operation, pluginId, url string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var existing *RepoError
+	if xerrors.As(err, &existing) {
+		return err
+	}
+
+	e := &RepoError{Op: op, PluginID: pluginId, URL: url, Class: classify(err), Err: err}
+	var status *gcom.StatusError
+	if xerrors.As(err, &status) {
+		e.StatusCode = status.StatusCode
+	} else if xerrors.Is(err, ErrNotFoundError) {
+		e.StatusCode = 404
+	}
+	var idErr *requestIDError
+	if xerrors.As(err, &idErr) {
+		e.RequestID = idErr.id
+	}
+
+	repoErrors.WithLabelValues(string(op), string(e.Class)).Inc()
+	log.Debugf("repository request failed op=%v plugin=%v class=%v url=%v request_id=%v: %v\n", op, pluginId, e.Class, url, e.RequestID, err)
+
+	return e
+}
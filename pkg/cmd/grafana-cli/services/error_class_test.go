@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestClassifyError(t *testing.T) {
+	Convey("Failed repository operations are classified", t, func() {
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/missing-plugin":
+				http.NotFound(w, r)
+			case "/repo/forbidden-plugin":
+				w.WriteHeader(http.StatusForbidden)
+			case "/repo/broken-plugin":
+				w.WriteHeader(http.StatusBadGateway)
+			case "/repo/garbled-plugin":
+				w.Write([]byte(`{"id": `))
+			case "/repo/slow-plugin":
+				// answers only once the client gave up
+				select {
+				case <-r.Context().Done():
+				case <-release:
+				}
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		classOf := func(pluginId string) ErrorClass {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err := GetPluginWithContext(ctx, pluginId, server.URL)
+			So(err, ShouldNotBeNil)
+			return ClassifyError(err)
+		}
+
+		So(classOf("missing-plugin"), ShouldEqual, ErrorClassClient)
+		So(classOf("forbidden-plugin"), ShouldEqual, ErrorClassClient)
+		So(classOf("broken-plugin"), ShouldEqual, ErrorClassServer)
+		So(classOf("garbled-plugin"), ShouldEqual, ErrorClassDecode)
+		So(classOf("slow-plugin"), ShouldEqual, ErrorClassTimeout)
+
+		Convey("Typed errors keep the operation and status", func() {
+			_, err := GetPluginWithContext(context.Background(), "broken-plugin", server.URL)
+
+			var repoErr *RepoError
+			So(xerrors.As(err, &repoErr), ShouldBeTrue)
+			So(repoErr.Op, ShouldEqual, OpGetPlugin)
+			So(repoErr.PluginID, ShouldEqual, "broken-plugin")
+			So(repoErr.StatusCode, ShouldEqual, http.StatusBadGateway)
+		})
+
+		Convey("Failures are counted by operation and class", func() {
+			reg := prometheus.NewRegistry()
+			So(RegisterMetrics(reg), ShouldBeNil)
+			So(RegisterMetrics(reg), ShouldBeNil)
+
+			before := counterValue(repoErrors.WithLabelValues(string(OpGetPlugin), string(ErrorClassServer)))
+			_, err := GetPluginWithContext(context.Background(), "broken-plugin", server.URL)
+			So(err, ShouldNotBeNil)
+			So(counterValue(repoErrors.WithLabelValues(string(OpGetPlugin), string(ErrorClassServer))), ShouldEqual, before+1)
+
+			families, err := reg.Gather()
+			So(err, ShouldBeNil)
+			var names []string
+			for _, f := range families {
+				names = append(names, f.GetName())
+			}
+			So(names, ShouldContain, "grafana_plugin_repo_errors_total")
+		})
+
+		Convey("Not found errors are still recognized", func() {
+			_, err := GetPluginWithContext(context.Background(), "missing-plugin", server.URL)
+			So(xerrors.Is(err, ErrNotFoundError), ShouldBeTrue)
+		})
+	})
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	c.Write(&m)
+	return m.GetCounter().GetValue()
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/hashicorp/go-version"
+)
+
+var ErrVersionExcluded = errors.New("plugin version is excluded from installation")
+
+// versionExclusions maps plugin ids to the versions that must never be
+// installed, so known broken releases are skipped when upgrading.
+var versionExclusions map[string][]string
+
+// SetVersionExclusions replaces the versions excluded per plugin id. Entries
+// are exact versions like "2.3.1" or constraints like ">=2.3.0, <2.3.4".
+func SetVersionExclusions(exclusions map[string][]string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	versionExclusions = exclusions
+}
+
+// ParseVersionExclusion parses "<plugin id>@<version or constraint>".
+func ParseVersionExclusion(value string) (pluginId string, exclusion string, err error) {
+	parts := strings.SplitN(value, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.TrimSpace(parts[1]) == "" {
+		return "", "", fmt.Errorf("invalid version exclusion %q, expected <plugin id>@<version>", value)
+	}
+
+	exclusion = strings.TrimSpace(parts[1])
+	if _, err := parseConstraint(exclusion); err != nil {
+		return "", "", fmt.Errorf("invalid version exclusion %q: %v", value, err)
+	}
+	return parts[0], exclusion, nil
+}
+
+// isExcluded reports whether v of pluginId is excluded by the configured
+// exclusions or by the request.
+func isExcluded(pluginId string, v m.Version, req PluginRequest) bool {
+	stateMtx.RLock()
+	configured := versionExclusions[pluginId]
+	stateMtx.RUnlock()
+
+	// copied so appending never writes into the configured slice
+	excluded := make([]string, 0, len(configured)+len(req.Exclude))
+	excluded = append(append(excluded, configured...), req.Exclude...)
+	for _, exclusion := range excluded {
+		if matchesExclusion(v.Version, exclusion) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesExclusion(v, exclusion string) bool {
+	if v == exclusion {
+		return true
+	}
+
+	constraints, err := parseConstraint(exclusion)
+	if err != nil {
+		return false
+	}
+
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return false
+	}
+
+	return constraints.Check(parsed)
+}
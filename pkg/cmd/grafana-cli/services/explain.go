@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+)
+
+// Candidate is a plugin version considered while resolving a request.
+type Candidate struct {
+	Version  string `json:"version"`
+	Selected bool   `json:"selected,omitempty"`
+	// Rejected is why the version was not picked, empty for the selected one.
+	Rejected string `json:"rejected,omitempty"`
+}
+
+// Explain describes every version of the plugin and why it was or was not
+// chosen, one per line, followed by the archive of the selected version.
+func (r Resolution) Explain() string {
+	var b strings.Builder
+	for _, c := range r.Candidates {
+		if c.Selected {
+			fmt.Fprintf(&b, "%s: selected\n", c.Version)
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\n", c.Version, c.Rejected)
+	}
+	if r.URL != "" {
+		fmt.Fprintf(&b, "archive: %s (%s)\n", archiveName(r.Archive), r.URL)
+	}
+	return b.String()
+}
+
+// ExplainVersions returns the versions of plugin in repository order, marking
+// selected and giving the reason every other version was rejected for req.
+// Pass an empty selected version to explain a failed resolution.
+func ExplainVersions(plugin m.Plugin, req PluginRequest, selected string) []Candidate {
+	if req.PluginID == "" {
+		req.PluginID = plugin.Id
+	}
+
+	candidates := make([]Candidate, 0, len(plugin.Versions))
+	passed := false
+	for _, v := range plugin.Versions {
+		c := Candidate{Version: v.Version}
+		if selected != "" && v.Version == selected {
+			c.Selected, passed = true, true
+		} else if c.Rejected = rejection(v, req); c.Rejected == "" {
+			c.Rejected = "not selected"
+			if passed {
+				c.Rejected = "older than the selected version"
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// rejection returns the first filter v fails for req, in the order
+// SelectVersion applies them, or an empty string if it passes all of them.
+func rejection(v m.Version, req PluginRequest) string {
+	explicit := req.Version != "" || req.Build != ""
+
+	switch {
+	case explicit && !matchesRequest(v, req):
+		if req.Build != "" {
+			return fmt.Sprintf("not built from commit %s", req.Build)
+		}
+		return fmt.Sprintf("version %s was requested", req.Version)
+	case isExcluded(req.PluginID, v, req):
+		return "excluded"
+	case v.Yanked && !(explicit && req.AllowYanked):
+		if v.YankReason != "" {
+			return "yanked: " + v.YankReason
+		}
+		return "yanked"
+	case !explicit && !req.AsOf.IsZero() && (v.CreatedAt.IsZero() || !v.CreatedAt.Before(req.AsOf)):
+		return fmt.Sprintf("not published before %s", req.AsOf.Format("2006-01-02"))
+	case !explicit && !compatibleWithAll(v, req.GrafanaVersions):
+		return fmt.Sprintf("requires Grafana %s, not compatible with %s", v.GrafanaDependency, strings.Join(req.GrafanaVersions, ", "))
+	case !explicit && !isPatchOf(v, req.SafeUpdate):
+		return fmt.Sprintf("not a patch release of %s", req.SafeUpdate)
+	}
+
+	if err := checkEdition(req.PluginID, v, req); err != nil {
+		return err.Error()
+	}
+	if !supportsArch(v) {
+		return fmt.Sprintf("no archive for %s", osAndArchString())
+	}
+	return ""
+}
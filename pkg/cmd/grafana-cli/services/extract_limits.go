@@ -0,0 +1,133 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrExtractedSizeExceeded    = errors.New("archive decompresses to more than the maximum size")
+	ErrCompressionRatioExceeded = errors.New("archive entry exceeds the maximum compression ratio")
+)
+
+// ExtractLimits bound what extracting an archive may write, so an archive
+// crafted to decompress to far more than it weighs, e.g. served by a
+// compromised mirror, cannot fill the disk of the Grafana host. Zero values
+// are unlimited.
+type ExtractLimits struct {
+	// MaxTotalSize is the most bytes all entries of an archive decompress to.
+	MaxTotalSize int64
+	// MaxRatio is the highest ratio of the decompressed to the compressed
+	// size of an entry. Entries decompressing to less than ratioMinSize are
+	// not checked, as small files of repeated bytes compress that well.
+	MaxRatio float64
+}
+
+// DefaultExtractLimits fit the largest plugins published, including the
+// Chromium bundles of the image renderer.
+var DefaultExtractLimits = ExtractLimits{MaxTotalSize: 2 << 30, MaxRatio: 200}
+
+const ratioMinSize = 1 << 20
+
+var extractLimits = DefaultExtractLimits
+
+// SetExtractLimits replaces the limits archives are extracted with.
+func SetExtractLimits(limits ExtractLimits) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	extractLimits = limits
+}
+
+func getExtractLimits() ExtractLimits {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return extractLimits
+}
+
+// ExtractLimitError is returned when extracting the entry at Path exceeds
+// the ExtractLimits. Size is how many bytes were decompressed, of the entry for
+// ErrCompressionRatioExceeded and of the whole archive otherwise.
+type ExtractLimitError struct {
+	Path       string
+	Size       int64
+	Compressed int64
+	Err        error
+}
+
+func (e *ExtractLimitError) Error() string {
+	if e.Err == ErrCompressionRatioExceeded {
+		return fmt.Sprintf("%v: %s decompresses from %d to %d bytes", e.Err, e.Path, e.Compressed, e.Size)
+	}
+	return fmt.Sprintf("%v: %d bytes at %s", e.Err, e.Size, e.Path)
+}
+
+func (e *ExtractLimitError) Unwrap() error {
+	return e.Err
+}
+
+// ExtractLimiter enforces the configured ExtractLimits over the entries of
+// one archive.
+type ExtractLimiter struct {
+	limits   ExtractLimits
+	declared int64
+	total    int64
+}
+
+func NewExtractLimiter() *ExtractLimiter {
+	return &ExtractLimiter{limits: getExtractLimits()}
+}
+
+// Declare checks the sizes an entry declares before anything is extracted,
+// like the ones of zip headers. Archives can lie about them, so the entries
+// must still be read through Reader.
+func (l *ExtractLimiter) Declare(path string, compressed, size int64) error {
+	l.declared += size
+	if l.limits.MaxTotalSize > 0 && l.declared > l.limits.MaxTotalSize {
+		return &ExtractLimitError{Path: path, Size: l.declared, Err: ErrExtractedSizeExceeded}
+	}
+	return l.CheckRatio(path, compressed, size)
+}
+
+// CheckRatio checks the compression ratio of an entry, or of a stream of
+// them, like a compressed tar, when the entries are not compressed one by one.
+func (l *ExtractLimiter) CheckRatio(path string, compressed, size int64) error {
+	if l.limits.MaxRatio <= 0 || size < ratioMinSize || compressed < 0 {
+		return nil
+	}
+	if compressed == 0 || float64(size)/float64(compressed) > l.limits.MaxRatio {
+		return &ExtractLimitError{Path: path, Size: size, Compressed: compressed, Err: ErrCompressionRatioExceeded}
+	}
+	return nil
+}
+
+// Reader wraps the decompressed content of the entry at path, making reads
+// fail once the entry or the archive exceeds the limits. compressed is the
+// size of the entry in the archive, negative when it is unknown.
+func (l *ExtractLimiter) Reader(path string, r io.Reader, compressed int64) io.Reader {
+	return &limitedEntryReader{r: r, limiter: l, path: path, compressed: compressed}
+}
+
+type limitedEntryReader struct {
+	r          io.Reader
+	limiter    *ExtractLimiter
+	path       string
+	compressed int64
+	size       int64
+}
+
+func (r *limitedEntryReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.size += int64(n)
+	r.limiter.total += int64(n)
+
+	if max := r.limiter.limits.MaxTotalSize; max > 0 && r.limiter.total > max {
+		return n, &ExtractLimitError{Path: r.path, Size: r.limiter.total, Err: ErrExtractedSizeExceeded}
+	}
+	if ratioErr := r.limiter.CheckRatio(r.path, r.compressed, r.size); ratioErr != nil {
+		return n, ratioErr
+	}
+	return n, err
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestExtractLimiter(t *testing.T) {
+	Convey("Entries are limited by what they decompress to", t, func() {
+		SetExtractLimits(ExtractLimits{MaxTotalSize: 3 << 20, MaxRatio: 10})
+		defer SetExtractLimits(DefaultExtractLimits)
+
+		Convey("even when they declare less", func() {
+			l := NewExtractLimiter()
+			So(l.Declare("panel/a.bin", 1<<20, 1<<20), ShouldBeNil)
+
+			_, err := io.Copy(ioutil.Discard, l.Reader("panel/a.bin", bytes.NewReader(make([]byte, 4<<20)), 1<<20))
+			So(xerrors.Is(err, ErrExtractedSizeExceeded), ShouldBeTrue)
+		})
+
+		Convey("over all entries of an archive", func() {
+			l := NewExtractLimiter()
+			So(l.Declare("panel/a.bin", 1<<20, 2<<20), ShouldBeNil)
+			So(xerrors.Is(l.Declare("panel/b.bin", 1<<20, 2<<20), ErrExtractedSizeExceeded), ShouldBeTrue)
+		})
+
+		Convey("relative to their compressed size", func() {
+			l := NewExtractLimiter()
+			err := l.Declare("panel/zeros.bin", 1<<10, 2<<20)
+			So(xerrors.Is(err, ErrCompressionRatioExceeded), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "panel/zeros.bin decompresses from 1024 to 2097152 bytes")
+
+			// small files compress well without being bombs
+			So(l.CheckRatio("panel/small.txt", 1, 1<<10), ShouldBeNil)
+		})
+	})
+}
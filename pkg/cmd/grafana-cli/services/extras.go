@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var ErrExtraNotFound = errors.New("plugin version does not publish the requested extra")
+
+// ResolvedExtra is an optional component of a plugin version resolved to a
+// downloadable archive.
+type ResolvedExtra struct {
+	Name     string
+	URL      string
+	Checksum string
+	// Size is the archive size in bytes, 0 when unknown.
+	Size int64
+}
+
+// SelectExtras resolves the named extras of a plugin version. The name "*"
+// selects every extra the version publishes.
+func SelectExtras(repoUrl, pluginId string, v m.Version, names []string) ([]ResolvedExtra, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "*":
+			for extra := range v.Extras {
+				selected[extra] = true
+			}
+		default:
+			if _, ok := v.Extras[name]; !ok {
+				return nil, xerrors.Errorf("%s@%s has no extra %q: %w", pluginId, v.Version, name, ErrExtraNotFound)
+			}
+			selected[name] = true
+		}
+	}
+
+	result := make([]ResolvedExtra, 0, len(selected))
+	for name := range selected {
+		extra := v.Extras[name]
+		url := extra.Url
+		if url == "" {
+			url = fmt.Sprintf("%s/%s/versions/%s/extras/%s/download", repoUrl, pluginId, v.Version, name)
+		}
+		result = append(result, ResolvedExtra{Name: name, URL: url, Checksum: strings.ToLower(extra.Sha256), Size: extra.Size})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
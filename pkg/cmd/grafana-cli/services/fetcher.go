@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+)
+
+// Fetcher retrieves the bytes of plugin archives. Replacing it allows
+// distributing archives through e.g. a P2P network or an artifact sidecar,
+// while metadata is still resolved from the repository.
+type Fetcher interface {
+	Fetch(ctx context.Context, pluginId, url string) ([]byte, error)
+}
+
+// FetcherFunc adapts a function to the Fetcher interface.
+type FetcherFunc func(ctx context.Context, pluginId, url string) ([]byte, error)
+
+func (f FetcherFunc) Fetch(ctx context.Context, pluginId, url string) ([]byte, error) {
+	return f(ctx, pluginId, url)
+}
+
+// HTTPFetcher downloads archives from the repository with the download
+// client, applying the configured middlewares. Responses that are not
+// archives, end before their Content-Length or exceed the ArchiveLimitsFor
+// the plugin are rejected.
+type HTTPFetcher struct{}
+
+func (HTTPFetcher) Fetch(ctx context.Context, pluginId, url string) ([]byte, error) {
+	req, err := newRequest(url)
+	if err != nil {
+		return []byte{}, err
+	}
+	req = req.WithContext(ctx)
+
+	res, err := do(&DownloadClient, &RepoRequest{Op: OpDownload, PluginID: pluginId, Request: req})
+	res, err = trackDownload(pluginId, res, err)
+	res, err = checkArchiveSize(pluginId, url, res, err)
+	return readResponse(checkDownload(url, res, err))
+}
+
+var fetcher Fetcher = HTTPFetcher{}
+
+// SetFetcher replaces how archives are downloaded, nil restores HTTPFetcher.
+// Checksums are verified the same way regardless of the fetcher.
+func SetFetcher(f Fetcher) {
+	if f == nil {
+		f = HTTPFetcher{}
+	}
+
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	fetcher = f
+}
+
+func getFetcher() Fetcher {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return fetcher
+}
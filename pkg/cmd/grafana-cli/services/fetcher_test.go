@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFetcher(t *testing.T) {
+	Convey("Archives are fetched through the configured fetcher", t, func() {
+		var fetched []string
+		SetFetcher(FetcherFunc(func(ctx context.Context, pluginId, url string) ([]byte, error) {
+			fetched = append(fetched, pluginId+" "+url)
+			return []byte("archive"), nil
+		}))
+		defer SetFetcher(nil)
+
+		body, err := DownloadArchive("test-plugin", "ipfs://bafy/test-plugin.zip")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "archive")
+		So(fetched, ShouldResemble, []string{"test-plugin ipfs://bafy/test-plugin.zip"})
+	})
+}
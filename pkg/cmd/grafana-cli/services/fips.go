@@ -0,0 +1,22 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+var ErrDigestNotApproved = errors.New("checksum algorithm is not FIPS approved")
+
+var fipsMode = fipsBuild || systemFIPSEnabled()
+
+// FIPSMode reports whether only FIPS approved digests are accepted, either
+// because of a fips build or because the host kernel runs in FIPS mode.
+func FIPSMode() bool {
+	return fipsMode
+}
+
+func systemFIPSEnabled() bool {
+	data, err := ioutil.ReadFile("/proc/sys/crypto/fips_enabled")
+	return err == nil && strings.TrimSpace(string(data)) == "1"
+}
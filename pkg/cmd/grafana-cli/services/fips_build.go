@@ -0,0 +1,5 @@
+// +build fips
+
+package services
+
+const fipsBuild = true
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// cannedTransport answers requests with canned responses instead of the
+// network, like fixtures or recorded cassettes.
+type cannedTransport struct {
+	respond func(req *http.Request) (*http.Response, error)
+}
+
+func (t *cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.respond(req)
+}
+
+// fixtures serves repository requests from files on disk. The request path,
+// relative to the repository url, is mapped into the fixture directory,
+// trying the path as is and with a .json or .zip extension. For example with
+// the default repo url:
+//
+//	/api/plugins/repo                                -> <dir>/repo.json
+//	/api/plugins/repo/grafana-clock-panel            -> <dir>/repo/grafana-clock-panel.json
+//	/api/plugins/grafana-clock-panel/versions/1.0.0/download -> <dir>/grafana-clock-panel/versions/1.0.0/download.zip
+type fixtures struct {
+	dir string
+}
+
+// NewFromFixtures returns a http client serving all metadata and archives
+// from the fixture directory dir instead of the network, for deterministic
+// integration tests of grafana-cli and provisioning.
+func NewFromFixtures(dir string) (*http.Client, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid fixture directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, xerrors.Errorf("invalid fixture directory: %s is not a directory", dir)
+	}
+
+	f := &fixtures{dir: dir}
+	return &http.Client{Transport: &cannedTransport{respond: f.respond}}, nil
+}
+
+// WithFixtures serves all repository requests from the fixture directory dir,
+// see NewFromFixtures.
+func WithFixtures(dir string) (Option, error) {
+	client, err := NewFromFixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+	return WithClient(client), nil
+}
+
+func (f *fixtures) respond(req *http.Request) (*http.Response, error) {
+	p := req.URL.Path
+	if base, err := url.Parse(RepoURL()); err == nil && base.Path != "" {
+		p = strings.TrimPrefix(p, strings.TrimSuffix(base.Path, "/"))
+	}
+
+	// fixtures are only served from within dir
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return fixtureResponse(req, http.StatusBadRequest, []byte("invalid fixture path")), nil
+		}
+	}
+
+	for _, ext := range []string{"", ".json", ".zip"} {
+		name := filepath.Join(f.dir, filepath.FromSlash(p)+ext)
+		if info, err := os.Stat(name); err != nil || info.IsDir() {
+			continue
+		}
+
+		body, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return fixtureResponse(req, http.StatusOK, body), nil
+	}
+
+	return fixtureResponse(req, http.StatusNotFound, []byte("fixture not found")), nil
+}
+
+func fixtureResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFixtures(t *testing.T) {
+	Convey("Repository requests are served from fixtures", t, func() {
+		client, err := NewFromFixtures("testdata/fixtures")
+		So(err, ShouldBeNil)
+		prevClient := HttpClient
+		HttpClient = *client
+		defer func() { HttpClient = prevClient }()
+
+		repoUrl := "https://fixtures.example.com/api/plugins"
+		prevRepoURL := RepoURL()
+		setRepoURL(repoUrl)
+		defer setRepoURL(prevRepoURL)
+
+		plugin, err := GetPlugin("fixture-panel", repoUrl)
+		So(err, ShouldBeNil)
+		So(plugin.Versions, ShouldHaveLength, 2)
+
+		repo, err := ListAllPlugins(repoUrl)
+		So(err, ShouldBeNil)
+		So(repo.Plugins[0].Id, ShouldEqual, "fixture-panel")
+
+		_, err = GetPlugin("missing-panel", repoUrl)
+		So(err, ShouldNotBeNil)
+
+		Convey("serving archives", func() {
+			res, err := client.Get(repoUrl + "/fixture-panel/versions/1.1.0/download")
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+			archive, err := ioutil.ReadAll(res.Body)
+			So(err, ShouldBeNil)
+			fixture, err := ioutil.ReadFile("testdata/fixtures/fixture-panel/versions/1.1.0/download.zip")
+			So(err, ShouldBeNil)
+			So(archive, ShouldResemble, fixture)
+
+			res, err = client.Get(repoUrl + "/fixture-panel/versions/1.0.0/download")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("but no files outside of the fixture directory", func() {
+			res, err := client.Get(repoUrl + "/repo/../../fixtures_test.go")
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+
+	Convey("Fixtures are served from existing directories only", t, func() {
+		_, err := NewFromFixtures("testdata/missing")
+		So(err, ShouldNotBeNil)
+
+		_, err = WithFixtures("testdata/fixtures/repo.json")
+		So(err, ShouldNotBeNil)
+	})
+}
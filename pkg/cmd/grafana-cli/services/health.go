@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RepoHealth is the recent health of a repository, tracked from the requests
+// sent to it.
+type RepoHealth struct {
+	Requests int
+	// ErrorRate is the exponentially weighted share of recent requests that
+	// failed with network errors, 429 or 5xx responses.
+	ErrorRate float64
+	// Latency is the exponentially weighted latency of recent successful
+	// requests, 0 when none succeeded yet.
+	Latency time.Duration
+	// NextProbe is when an unhealthy repository is tried again.
+	NextProbe time.Time
+}
+
+// Healthy reports whether the repository is preferred for requests.
+func (h RepoHealth) Healthy() bool {
+	return h.Requests < minHealthSamples || h.ErrorRate < unhealthyErrorRate
+}
+
+const (
+	healthDecay        = 0.2
+	minHealthSamples   = 3
+	unhealthyErrorRate = 0.5
+	// slowLatencyFactor is how many times slower than the fastest healthy
+	// repository another one may be before it is only used after it.
+	slowLatencyFactor = 4
+)
+
+// HealthProbeInterval is how often unhealthy repositories are tried again, in
+// their usual order, to notice when they recovered.
+var HealthProbeInterval = 30 * time.Second
+
+var repoHealth = struct {
+	sync.Mutex
+	byRepo map[string]*RepoHealth
+}{byRepo: map[string]*RepoHealth{}}
+
+// failoverRepos are the repositories serving the same plugins as the
+// configured one, in order of preference.
+var failoverRepos []string
+
+// SetFailoverRepos replaces the repositories plugins are resolved from when
+// the configured repository is unhealthy or fails. Plugins referenced with a
+// namespace are only resolved from the repository of the namespace. Empty
+// urls are ignored.
+func SetFailoverRepos(repos []string) {
+	var urls []string
+	for _, repo := range repos {
+		if repo = strings.TrimSpace(repo); repo != "" {
+			urls = append(urls, repo)
+		}
+	}
+
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	failoverRepos = urls
+}
+
+// FailoverRepos returns the configured and discovered failover repositories.
+func FailoverRepos() []string {
+	return getFailoverRepos()
+}
+
+func getFailoverRepos() []string {
+	if d := GetRepoDiscovery(); d != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), DiscoveryTimeout)
+		d.refreshOnce(ctx)
+		cancel()
+	}
+
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return failoverRepos
+}
+
+// GetRepoHealth returns the tracked health of repoUrl.
+func GetRepoHealth(repoUrl string) RepoHealth {
+	repoHealth.Lock()
+	defer repoHealth.Unlock()
+
+	if h, ok := repoHealth.byRepo[repoKey(repoUrl)]; ok {
+		return *h
+	}
+	return RepoHealth{}
+}
+
+// ResetRepoHealth forgets the tracked health of all repositories.
+func ResetRepoHealth() {
+	repoHealth.Lock()
+	defer repoHealth.Unlock()
+
+	repoHealth.byRepo = map[string]*RepoHealth{}
+}
+
+// recordHealth records the outcome of a request to the repository at repoUrl,
+// its base url, that took latency.
+func recordHealth(repoUrl string, latency time.Duration, res *http.Response, err error) {
+	failed := err != nil || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+
+	repoHealth.Lock()
+	defer repoHealth.Unlock()
+
+	key := repoKey(repoUrl)
+	h, ok := repoHealth.byRepo[key]
+	if !ok {
+		h = &RepoHealth{}
+		repoHealth.byRepo[key] = h
+	}
+
+	h.Requests++
+	switch {
+	case failed:
+		h.ErrorRate += healthDecay * (1 - h.ErrorRate)
+		if !h.Healthy() {
+			h.NextProbe = getClock().Now().Add(HealthProbeInterval)
+		}
+	case !h.Healthy():
+		// a successful probe restores the repository
+		h.ErrorRate = 0
+		h.NextProbe = time.Time{}
+	default:
+		h.ErrorRate -= healthDecay * h.ErrorRate
+	}
+
+	if !failed {
+		if h.Latency == 0 {
+			h.Latency = latency
+		} else {
+			h.Latency += time.Duration(healthDecay * float64(latency-h.Latency))
+		}
+	}
+}
+
+// OrderByHealth orders repos by preference: healthy repositories first, in
+// their given order unless they are much slower than the others, then the
+// unhealthy ones. Unhealthy repositories due to be probed keep their place,
+// once per HealthProbeInterval.
+func OrderByHealth(repos []string) []string {
+	repoHealth.Lock()
+	defer repoHealth.Unlock()
+
+	now := getClock().Now()
+	health := make([]RepoHealth, len(repos))
+	var fastest time.Duration
+	for i, repo := range repos {
+		if h, ok := repoHealth.byRepo[repoKey(repo)]; ok {
+			if !h.Healthy() && !now.Before(h.NextProbe) {
+				log.Debugf("probing unhealthy repository %v\n", repo)
+				h.NextProbe = now.Add(HealthProbeInterval)
+				health[i] = RepoHealth{Latency: h.Latency}
+				continue
+			}
+			health[i] = *h
+		}
+		if l := health[i].Latency; health[i].Healthy() && l > 0 && (fastest == 0 || l < fastest) {
+			fastest = l
+		}
+	}
+
+	rank := func(h RepoHealth) int {
+		switch {
+		case !h.Healthy():
+			return 2
+		case fastest > 0 && h.Latency > slowLatencyFactor*fastest:
+			return 1
+		}
+		return 0
+	}
+
+	order := make([]int, len(repos))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return rank(health[order[a]]) < rank(health[order[b]])
+	})
+
+	ordered := make([]string, len(repos))
+	for i, j := range order {
+		ordered[i] = repos[j]
+	}
+	return ordered
+}
+
+// resolveHealthiest resolves req from the healthiest of repoUrl and the
+// failover repositories, trying the next one when a repository fails.
+func resolveHealthiest(ctx context.Context, repoUrl string, req PluginRequest) (Resolution, error) {
+	failover := getFailoverRepos()
+	if ns, _ := SplitPluginRef(req.PluginID); len(failover) == 0 || req.Namespace != "" || ns != "" {
+		return resolve(ctx, repoUrl, req)
+	}
+
+	var (
+		res Resolution
+		err error
+	)
+	for _, repo := range OrderByHealth(append([]string{repoUrl}, failover...)) {
+		if res, err = resolve(ctx, repo, req); err == nil || !repoFailed(err) {
+			return res, err
+		}
+		log.Infof("Failed to resolve %v from %v, trying the next repository: %v\n", req.PluginID, repo, err)
+	}
+	return res, err
+}
+
+// repoFailed reports whether err means the repository could not serve the
+// request, rather than e.g. not publishing the plugin.
+func repoFailed(err error) bool {
+	switch ClassifyError(err) {
+	case ErrorClassDNS, ErrorClassTLS, ErrorClassTimeout, ErrorClassNetwork, ErrorClassServer:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRepoHealth(t *testing.T) {
+	ok := &http.Response{StatusCode: http.StatusOK}
+	failed := errors.New("connection refused")
+
+	Convey("Unhealthy repositories are tried last and probed again", t, func() {
+		defer ResetRepoHealth()
+
+		for i := 0; i < 5; i++ {
+			recordHealth("https://primary.example.com", time.Millisecond, nil, failed)
+			recordHealth("https://mirror.example.com", time.Millisecond, ok, nil)
+		}
+		So(GetRepoHealth("https://primary.example.com").Healthy(), ShouldBeFalse)
+
+		repos := []string{"https://primary.example.com", "https://mirror.example.com"}
+		So(OrderByHealth(repos), ShouldResemble, []string{"https://mirror.example.com", "https://primary.example.com"})
+
+		Convey("until a probe succeeds", func() {
+			repoHealth.byRepo["https://primary.example.com"].NextProbe = time.Now().Add(-time.Second)
+			So(OrderByHealth(repos), ShouldResemble, repos)
+			So(OrderByHealth(repos), ShouldResemble, []string{"https://mirror.example.com", "https://primary.example.com"})
+
+			recordHealth("https://primary.example.com", time.Millisecond, ok, nil)
+			So(GetRepoHealth("https://primary.example.com").Healthy(), ShouldBeTrue)
+			So(OrderByHealth(repos), ShouldResemble, repos)
+		})
+	})
+
+	Convey("Health is tracked per repository base url", t, func() {
+		defer ResetRepoHealth()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/repo/health-panel" {
+				w.Write([]byte(`{"id": "health-panel", "versions": [{"version": "1.0.0"}]}`))
+				return
+			}
+			http.NotFound(w, r)
+		}))
+		defer server.Close()
+
+		_, err := GetPluginWithContext(context.Background(), "health-panel", server.URL+"/")
+		So(err, ShouldBeNil)
+		_, err = getSidecarChecksum(context.Background(), "health-panel", server.URL+"/download/health-panel.zip")
+		So(err, ShouldNotBeNil)
+
+		So(GetRepoHealth(server.URL).Requests, ShouldEqual, 1)
+		So(GetRepoHealth(server.URL+"/").Requests, ShouldEqual, 1)
+		So(repoHealth.byRepo, ShouldHaveLength, 1)
+	})
+
+	Convey("Much slower repositories are tried after faster ones", t, func() {
+		defer ResetRepoHealth()
+
+		recordHealth("https://primary.example.com", time.Second, ok, nil)
+		recordHealth("https://mirror.example.com", 10*time.Millisecond, ok, nil)
+
+		repos := []string{"https://primary.example.com", "https://mirror.example.com"}
+		So(OrderByHealth(repos), ShouldResemble, []string{"https://mirror.example.com", "https://primary.example.com"})
+	})
+
+	Convey("Resolution fails over to the next repository", t, func() {
+		defer ResetRepoHealth()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer primary.Close()
+		mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "failover-panel", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer mirror.Close()
+
+		SetFailoverRepos([]string{mirror.URL, ""})
+		defer SetFailoverRepos(nil)
+
+		res, err := Resolve(primary.URL, PluginRequest{PluginID: "failover-panel"})
+		So(err, ShouldBeNil)
+		So(res.RepoURL, ShouldEqual, mirror.URL)
+		So(res.URL, ShouldEqual, mirror.URL+"/failover-panel/versions/1.0.0/download")
+		So(GetRepoHealth(primary.URL).Requests, ShouldBeGreaterThan, 0)
+
+		Convey("but not when the plugin does not exist", func() {
+			_, err := Resolve(mirror.URL, PluginRequest{PluginID: "failover-panel", Version: "2.0.0"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var ErrArchiveTooLarge = errors.New("archive exceeds the maximum size")
+
+// RendererCategory is the category of renderer plugins, which ship a browser
+// in their os specific archives.
+const RendererCategory = "renderer"
+
+// SlimVariant is the suffix of arch keys under which heavy plugins publish
+// archives without their bundled dependencies, e.g. "linux-amd64-slim" for
+// the image renderer without Chromium, which the host must then provide.
+const SlimVariant = "slim"
+
+var preferSlimArtifacts bool
+
+// SetPreferSlimArtifacts selects the slim archive of a heavy plugin when a
+// version publishes one for this host.
+func SetPreferSlimArtifacts(prefer bool) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	preferSlimArtifacts = prefer
+}
+
+func getPreferSlimArtifacts() bool {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return preferSlimArtifacts
+}
+
+// ArchiveLimits bound the download of an archive. Zero values are unlimited.
+type ArchiveLimits struct {
+	MaxSize int64
+	Timeout time.Duration
+}
+
+var (
+	// DefaultArchiveLimits apply to the archives of most plugins.
+	DefaultArchiveLimits = ArchiveLimits{MaxSize: 512 << 20, Timeout: 10 * time.Minute}
+	// HeavyArchiveLimits apply to plugins shipping heavy per-os artifacts,
+	// like the Chromium bundles of grafana-image-renderer.
+	HeavyArchiveLimits = ArchiveLimits{MaxSize: 4 << 30, Timeout: time.Hour}
+)
+
+// heavyPlugins are the ids of plugins known to ship heavy artifacts, seeded
+// with the ones published before repositories categorized them and extended
+// with every renderer resolved since.
+var heavyPlugins = struct {
+	sync.RWMutex
+	ids map[string]bool
+}{ids: map[string]bool{"grafana-image-renderer": true}}
+
+// IsHeavyPlugin reports whether p ships heavy per-os artifacts.
+func IsHeavyPlugin(p m.Plugin) bool {
+	if p.Category == RendererCategory {
+		return true
+	}
+
+	heavyPlugins.RLock()
+	defer heavyPlugins.RUnlock()
+
+	return heavyPlugins.ids[p.Id]
+}
+
+// markHeavy makes downloads of pluginId use HeavyArchiveLimits, as archives
+// are downloaded by id after the plugin was resolved.
+func markHeavy(pluginId string) {
+	heavyPlugins.Lock()
+	defer heavyPlugins.Unlock()
+
+	heavyPlugins.ids[pluginId] = true
+}
+
+// SetDefaultArchiveLimits replaces DefaultArchiveLimits, e.g. to lift them
+// for a mirror serving larger archives.
+func SetDefaultArchiveLimits(limits ArchiveLimits) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	DefaultArchiveLimits = limits
+}
+
+// ArchiveLimitsFor returns the limits applying to archive downloads of pluginId.
+func ArchiveLimitsFor(pluginId string) ArchiveLimits {
+	if IsHeavyPlugin(m.Plugin{Id: pluginId}) {
+		return HeavyArchiveLimits
+	}
+
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return DefaultArchiveLimits
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"B", 1}}
+
+// ParseArchiveSize parses a size in bytes like "512MiB", "1GB" or "1048576",
+// 0 is unlimited.
+func ParseArchiveSize(value string) (int64, error) {
+	number, unit := strings.TrimSpace(value), int64(1)
+	for _, u := range sizeUnits {
+		if trimmed := strings.TrimSuffix(number, u.suffix); trimmed != number {
+			number, unit = strings.TrimSpace(trimmed), u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid archive size %q", value)
+	}
+	return n * unit, nil
+}
+
+// withSlimVariants puts the slim variant of each arch key before it.
+func withSlimVariants(keys []string) []string {
+	slim := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		slim = append(slim, key+"-"+SlimVariant, key)
+	}
+	return slim
+}
+
+// checkArchiveSize fails downloads larger than the limits of pluginId, before
+// reading the body when the size is announced.
+func checkArchiveSize(pluginId, url string, res *http.Response, err error) (*http.Response, error) {
+	limits := ArchiveLimitsFor(pluginId)
+	if err != nil || res.StatusCode/100 != 2 || limits.MaxSize <= 0 {
+		return res, err
+	}
+
+	if res.ContentLength > limits.MaxSize {
+		res.Body.Close()
+		return nil, archiveTooLarge(url, res.ContentLength, limits.MaxSize)
+	}
+	res.Body = &sizeLimitingReader{ReadCloser: res.Body, url: url, limit: limits.MaxSize}
+	return res, nil
+}
+
+func archiveTooLarge(url string, size, limit int64) error {
+	return xerrors.Errorf("%s has %d bytes, more than the limit of %d bytes: %w", url, size, limit, ErrArchiveTooLarge)
+}
+
+type sizeLimitingReader struct {
+	io.ReadCloser
+	url      string
+	limit    int64
+	received int64
+}
+
+func (r *sizeLimitingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.received += int64(n)
+
+	if r.received > r.limit {
+		return n, archiveTooLarge(r.url, r.received, r.limit)
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestHeavyPlugins(t *testing.T) {
+	Convey("Slim archives are selected when preferred", t, func() {
+		SetArchOverride([]string{"linux-amd64"})
+		defer SetArchOverride(nil)
+
+		v := m.Version{Arch: map[string]m.ArchMeta{
+			"linux-amd64":      {Url: "https://example.com/renderer-full.zip"},
+			"linux-amd64-slim": {Url: "https://example.com/renderer-slim.zip"},
+		}}
+
+		key, _, ok := SelectArchive(v)
+		So(ok, ShouldBeTrue)
+		So(key, ShouldEqual, "linux-amd64")
+
+		SetPreferSlimArtifacts(true)
+		defer SetPreferSlimArtifacts(false)
+
+		key, meta, ok := SelectArchive(v)
+		So(ok, ShouldBeTrue)
+		So(key, ShouldEqual, "linux-amd64-slim")
+		So(meta.Url, ShouldEqual, "https://example.com/renderer-slim.zip")
+		So(shipsBackend(v, key), ShouldBeTrue)
+	})
+
+	Convey("Renderers are downloaded with the heavy archive limits", t, func() {
+		archive := strings.Repeat("x", 1024)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/repo/"):
+				w.Write([]byte(`{"id": "custom-renderer", "category": "renderer", "versions": [{"version": "1.0.0"}]}`))
+			default:
+				w.Header().Set("Content-Type", "application/zip")
+				w.Write([]byte(archive))
+			}
+		}))
+		defer server.Close()
+
+		prevDefault, prevHeavy := DefaultArchiveLimits, HeavyArchiveLimits
+		DefaultArchiveLimits.MaxSize, HeavyArchiveLimits.MaxSize = 512, 2048
+		defer func() { DefaultArchiveLimits, HeavyArchiveLimits = prevDefault, prevHeavy }()
+
+		_, err := DownloadArchive("custom-renderer", server.URL+"/custom-renderer.zip")
+		So(xerrors.Is(err, ErrArchiveTooLarge), ShouldBeTrue)
+
+		res, err := Resolve(server.URL, PluginRequest{PluginID: "custom-renderer"})
+		So(err, ShouldBeNil)
+		So(IsHeavyPlugin(res.Plugin), ShouldBeTrue)
+		So(ArchiveLimitsFor("custom-renderer"), ShouldResemble, HeavyArchiveLimits)
+
+		body, err := DownloadArchive("custom-renderer", server.URL+"/custom-renderer.zip")
+		So(err, ShouldBeNil)
+		So(body, ShouldHaveLength, len(archive))
+	})
+
+	Convey("The image renderer is known to be heavy", t, func() {
+		So(ArchiveLimitsFor("grafana-image-renderer"), ShouldResemble, HeavyArchiveLimits)
+		So(ArchiveLimitsFor("grafana-clock-panel"), ShouldResemble, DefaultArchiveLimits)
+	})
+
+	Convey("Streamed archives are bound by the download timeout", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/zip")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		prevDefault := DefaultArchiveLimits
+		SetDefaultArchiveLimits(ArchiveLimits{Timeout: 50 * time.Millisecond})
+		defer SetDefaultArchiveLimits(prevDefault)
+
+		body, err := OpenArchive("slow-panel", server.URL+"/slow-panel.zip")
+		So(err, ShouldBeNil)
+		defer body.Close()
+
+		_, err = ioutil.ReadAll(body)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Archive sizes are parsed with units", t, func() {
+		for value, size := range map[string]int64{"512MiB": 512 << 20, "4 GiB": 4 << 30, "1GB": 1e9, "2048": 2048, "0": 0, "10KB": 10000} {
+			parsed, err := ParseArchiveSize(value)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldEqual, size)
+		}
+
+		for _, value := range []string{"", "MiB", "-1", "1TiB", "large"} {
+			_, err := ParseArchiveSize(value)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/xerrors"
+)
+
+var (
+	ErrIndexNotSigned        = errors.New("repository index is not signed")
+	ErrIndexSignatureInvalid = errors.New("repository index signature does not match any trusted key")
+)
+
+// IndexSignatureSuffix is appended to the path of a repository index to get
+// its detached signature, e.g. repo/grafana-clock-panel.sig.
+const IndexSignatureSuffix = ".sig"
+
+var indexKeys []ed25519.PublicKey
+
+// SetIndexKeys makes metadata from the repository trusted only if its index
+// carries a detached ed25519 signature by one of keys. It is meant for file
+// and S3 mirrors, so a compromised bucket cannot serve altered versions or
+// checksums. Without keys, indexes are not verified.
+func SetIndexKeys(keys []ed25519.PublicKey) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	indexKeys = keys
+}
+
+func getIndexKeys() []ed25519.PublicKey {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return indexKeys
+}
+
+// ParseIndexKey parses a base64 encoded ed25519 public key, or reads it from
+// the file value names.
+func ParseIndexKey(value string) (ed25519.PublicKey, error) {
+	raw, err := decodeKey(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("index key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// ParseIndexKeys parses a comma separated list of index keys.
+func ParseIndexKeys(list string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, value := range strings.Split(list, ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		key, err := ParseIndexKey(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ParseSigningKey parses a base64 encoded ed25519 private key or seed, or
+// reads it from the file value names.
+func ParseSigningKey(value string) (ed25519.PrivateKey, error) {
+	raw, err := decodeKey(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, fmt.Errorf("signing key must be a %d byte seed or %d byte private key, got %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize, len(raw))
+}
+
+func decodeKey(value string) ([]byte, error) {
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		content, err := ioutil.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		value = string(content)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	return raw, nil
+}
+
+// SignIndex returns the detached signature of an index body, as stored in
+// the signature file.
+func SignIndex(key ed25519.PrivateKey, body []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, body)) + "\n")
+}
+
+// VerifyIndex checks that signature is a signature of body by one of keys.
+func VerifyIndex(keys []ed25519.PublicKey, body, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrIndexSignatureInvalid
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, body, sig) {
+			return nil
+		}
+	}
+	return ErrIndexSignatureInvalid
+}
+
+// SignMirror writes a signature next to every index of the mirror directory
+// dir, for mirrors populated by other tools than SyncMirror.
+func SignMirror(dir string, key ed25519.PrivateKey) ([]string, error) {
+	var signed []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeIndexSignature(path, key, body); err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		signed = append(signed, rel)
+		return nil
+	})
+	return signed, err
+}
+
+// writeIndexSignature stores the signature of the index file path. Fixture
+// and bucket paths omit the .json extension, so the signature of
+// repo/<id>.json is fetched from repo/<id>.sig.
+func writeIndexSignature(path string, key ed25519.PrivateKey, body []byte) error {
+	return writeFileAtomic(indexSignatureURL(path), SignIndex(key, body))
+}
+
+// indexSignatureURL returns where the signature of the index at url or path
+// is, without the .json extension of files and static indexes.
+func indexSignatureURL(url string) string {
+	return strings.TrimSuffix(url, ".json") + IndexSignatureSuffix
+}
+
+// verifyIndex fetches the detached signature of the index at url, from where
+// writeIndexSignature stores it, and checks
+// body against the configured keys. Signatures themselves are not verified,
+// so the repository proxy can pass them through.
+func verifyIndex(ctx context.Context, op Operation, pluginId, repoUrl, url string, body []byte) error {
+	keys := getIndexKeys()
+	if len(keys) == 0 || strings.HasSuffix(url, IndexSignatureSuffix) {
+		return nil
+	}
+
+	signatureURL := indexSignatureURL(url)
+	req, err := newRequest(signatureURL)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	signature, err := readResponse(doRepo(repoUrl, &RepoRequest{Op: op, PluginID: pluginId, Request: req}))
+	if xerrors.Is(err, ErrNotFoundError) {
+		if caps, capsErr := Capabilities(ctx, repoUrl); capsErr == nil && !caps.Signatures {
+			return xerrors.Errorf("%s: %w, the repository serves no signatures, sign it with pluginrepo sign", url, ErrIndexNotSigned)
+		}
+		return xerrors.Errorf("%s: %w", url, ErrIndexNotSigned)
+	}
+	if err != nil {
+		return repoError(op, pluginId, signatureURL, err)
+	}
+
+	if err := VerifyIndex(keys, body, signature); err != nil {
+		return xerrors.Errorf("%s: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/xerrors"
+)
+
+func TestIndexSignature(t *testing.T) {
+	Convey("Mirror indexes are verified against the index keys", t, func() {
+		dir, err := ioutil.TempDir("", "signed-mirror")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		index, err := ioutil.ReadFile("testdata/fixtures/repo/fixture-panel.json")
+		So(err, ShouldBeNil)
+		So(os.MkdirAll(filepath.Join(dir, "repo"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "repo", "fixture-panel.json"), index, 0644), ShouldBeNil)
+
+		public, private, err := ed25519.GenerateKey(nil)
+		So(err, ShouldBeNil)
+
+		prevClient := HttpClient
+		client, err := NewFromFixtures(dir)
+		So(err, ShouldBeNil)
+		HttpClient = *client
+		defer func() { HttpClient = prevClient }()
+
+		repoUrl := "https://fixtures.example.com/api/plugins"
+		prevRepoURL := RepoURL()
+		setRepoURL(repoUrl)
+		defer setRepoURL(prevRepoURL)
+
+		prevCache := getCache()
+		SetCache(newMemoryCache())
+		defer SetCache(prevCache)
+
+		SetIndexKeys([]ed25519.PublicKey{public})
+		defer SetIndexKeys(nil)
+		defer ForgetCapabilities(repoUrl)
+
+		Convey("rejects unsigned indexes", func() {
+			_, err := GetPlugin("fixture-panel", repoUrl)
+			So(xerrors.Is(err, ErrIndexNotSigned), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "serves no signatures")
+		})
+
+		Convey("accepts indexes signed by a trusted key", func() {
+			signed, err := SignMirror(dir, private)
+			So(err, ShouldBeNil)
+			So(signed, ShouldResemble, []string{filepath.Join("repo", "fixture-panel.json")})
+
+			plugin, err := GetPlugin("fixture-panel", repoUrl)
+			So(err, ShouldBeNil)
+			So(plugin.Versions, ShouldHaveLength, 2)
+		})
+
+		Convey("rejects tampered indexes", func() {
+			_, err := SignMirror(dir, private)
+			So(err, ShouldBeNil)
+			tampered := append([]byte(" "), index...)
+			So(ioutil.WriteFile(filepath.Join(dir, "repo", "fixture-panel.json"), tampered, 0644), ShouldBeNil)
+
+			_, err = GetPlugin("fixture-panel", repoUrl)
+			So(xerrors.Is(err, ErrIndexSignatureInvalid), ShouldBeTrue)
+		})
+
+		Convey("rejects indexes signed by other keys", func() {
+			_, other, err := ed25519.GenerateKey(nil)
+			So(err, ShouldBeNil)
+			_, err = SignMirror(dir, other)
+			So(err, ShouldBeNil)
+
+			_, err = GetPlugin("fixture-panel", repoUrl)
+			So(xerrors.Is(err, ErrIndexSignatureInvalid), ShouldBeTrue)
+		})
+	})
+
+	Convey("Keys are parsed from base64 and files", t, func() {
+		public, private, err := ed25519.GenerateKey(nil)
+		So(err, ShouldBeNil)
+
+		key, err := ParseSigningKey(base64.StdEncoding.EncodeToString(private.Seed()))
+		So(err, ShouldBeNil)
+		So(key, ShouldResemble, private)
+
+		f, err := ioutil.TempFile("", "index-key")
+		So(err, ShouldBeNil)
+		defer os.Remove(f.Name())
+		f.WriteString(base64.StdEncoding.EncodeToString(public) + "\n")
+		f.Close()
+
+		keys, err := ParseIndexKeys(f.Name() + ", " + base64.StdEncoding.EncodeToString(public))
+		So(err, ShouldBeNil)
+		So(keys, ShouldHaveLength, 2)
+		So(keys[0], ShouldResemble, public)
+
+		_, err = ParseIndexKey(base64.StdEncoding.EncodeToString(private))
+		So(err, ShouldNotBeNil)
+	})
+}
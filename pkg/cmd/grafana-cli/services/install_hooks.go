@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+)
+
+// InstallHook runs after a plugin was extracted into dir, e.g. to register a
+// renderer with the embedding application. Hooks are Go code registered with
+// RegisterInstallHook only: grafana-cli never runs scripts or binaries shipped
+// in a plugin archive, and hooks must not either.
+type InstallHook func(plugin m.InstalledPlugin, dir string) error
+
+type installHook struct {
+	pluginType string
+	hook       InstallHook
+}
+
+var installHooks = []installHook{{hook: BackendExecutableHook}}
+
+// RegisterInstallHook runs hook after installing plugins of pluginType, like
+// "datasource" or "renderer", or after every install for an empty type. Hooks
+// run in the order they were registered.
+func RegisterInstallHook(pluginType string, hook InstallHook) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	// copy so RunInstallHooks iterating the old slice is not affected
+	hooks := make([]installHook, 0, len(installHooks)+1)
+	installHooks = append(append(hooks, installHooks...), installHook{pluginType: pluginType, hook: hook})
+}
+
+// RunInstallHooks runs the hooks registered for the type of the installed
+// plugin pluginName, stopping at the first failing one. Plugins without a
+// readable plugin.json are skipped.
+func RunInstallHooks(pluginDir, pluginName string) error {
+	plugin, err := ReadPlugin(pluginDir, pluginName)
+	if err != nil {
+		// without a plugin.json the type of the plugin is unknown
+		log.Debugf("skipping install hooks: %v\n", err)
+		return nil
+	}
+
+	stateMtx.RLock()
+	hooks := installHooks
+	stateMtx.RUnlock()
+
+	dir := filepath.Join(pluginDir, pluginName)
+	for _, h := range hooks {
+		if h.pluginType != "" && h.pluginType != plugin.Type {
+			continue
+		}
+		if err := h.hook(plugin, dir); err != nil {
+			return fmt.Errorf("install hook for %s failed: %v", pluginName, err)
+		}
+	}
+	return nil
+}
+
+// BackendExecutableHook marks the backend binaries of a plugin executable,
+// including the arm and musl builds the extraction leaves as archived. It
+// only changes file modes and never runs them. It is registered by default.
+func BackendExecutableHook(plugin m.InstalledPlugin, dir string) error {
+	if !plugin.Backend || plugin.Executable == "" {
+		return nil
+	}
+
+	// executables live next to the plugin.json they are declared in
+	for _, base := range []string{dir, filepath.Join(dir, "dist")} {
+		files, err := ioutil.ReadDir(base)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range files {
+			// Lstat mode, symlinks are never followed out of the plugin directory
+			if !fi.Mode().IsRegular() || !strings.HasPrefix(fi.Name(), plugin.Executable+"_") {
+				continue
+			}
+			if err := os.Chmod(filepath.Join(base, fi.Name()), fi.Mode().Perm()|0755); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
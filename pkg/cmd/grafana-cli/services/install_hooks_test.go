@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInstallHooks(t *testing.T) {
+	Convey("Install hooks", t, func() {
+		pluginDir, err := ioutil.TempDir("", "install-hooks")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(pluginDir)
+
+		dir := filepath.Join(pluginDir, "backend-datasource")
+		So(os.MkdirAll(dir, 0755), ShouldBeNil)
+		writeFile := func(name, content string) {
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), ShouldBeNil)
+		}
+		writeFile("plugin.json", `{"id": "backend-datasource", "type": "datasource", "backend": true, "executable": "gpx_ds", "info": {"version": "1.0.0"}}`)
+		writeFile("gpx_ds_linux_arm64", "binary")
+		writeFile("gpx_ds_linux_amd64_musl", "binary")
+		writeFile("README.md", "docs")
+
+		prevHooks := installHooks
+		defer func() { installHooks = prevHooks }()
+
+		Convey("mark backend binaries executable by default", func() {
+			So(RunInstallHooks(pluginDir, "backend-datasource"), ShouldBeNil)
+
+			for _, name := range []string{"gpx_ds_linux_arm64", "gpx_ds_linux_amd64_musl"} {
+				fi, err := os.Stat(filepath.Join(dir, name))
+				So(err, ShouldBeNil)
+				So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0755))
+			}
+			fi, err := os.Stat(filepath.Join(dir, "README.md"))
+			So(err, ShouldBeNil)
+			So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0644))
+		})
+
+		Convey("run the hooks registered for the plugin type in order", func() {
+			var ran []string
+			RegisterInstallHook("renderer", func(p m.InstalledPlugin, dir string) error {
+				ran = append(ran, "renderer")
+				return nil
+			})
+			RegisterInstallHook("datasource", func(p m.InstalledPlugin, hookDir string) error {
+				So(hookDir, ShouldEqual, dir)
+				ran = append(ran, "datasource:"+p.Id)
+				return nil
+			})
+			RegisterInstallHook("", func(p m.InstalledPlugin, dir string) error {
+				ran = append(ran, "all")
+				return nil
+			})
+
+			So(RunInstallHooks(pluginDir, "backend-datasource"), ShouldBeNil)
+			So(ran, ShouldResemble, []string{"datasource:backend-datasource", "all"})
+		})
+
+		Convey("stop at a failing hook", func() {
+			RegisterInstallHook("datasource", func(m.InstalledPlugin, string) error {
+				return errors.New("registration failed")
+			})
+			RegisterInstallHook("", func(m.InstalledPlugin, string) error {
+				t.Fatal("hook after a failing one ran")
+				return nil
+			})
+
+			err := RunInstallHooks(pluginDir, "backend-datasource")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "registration failed")
+		})
+	})
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// InstanceIDHeader carries the opaque instance id on archive downloads.
+const InstanceIDHeader = "X-Grafana-Instance-Id"
+
+// grafana.com only receives the instance id when listed explicitly
+var grafanaHosts = []string{"grafana.com", "*.grafana.com"}
+
+// InstanceIDMiddleware adds an opaque instance id to archive downloads so
+// self-hosted mirrors can deduplicate install analytics across a fleet. The
+// id is only sent to hosts matching one of the glob patterns in hosts; with
+// no hosts it is sent to any host except grafana.com. Redirects to other
+// hosts are followed without it.
+func InstanceIDMiddleware(id string, hosts []string) Middleware {
+	return func(next RepoHandler) RepoHandler {
+		return func(req *RepoRequest) (*http.Response, error) {
+			if id != "" && req.Op == OpDownload && instanceIDAllowed(hosts, req.Request.URL.Hostname()) {
+				req.Request.Header.Set(InstanceIDHeader, id)
+				ctx := context.WithValue(req.Request.Context(), instanceIDHostsKey{}, instanceIDHosts{hosts: hosts})
+				req.Request = req.Request.WithContext(ctx)
+			}
+			return next(req)
+		}
+	}
+}
+
+type instanceIDHostsKey struct{}
+
+// instanceIDHosts are the hosts of the middleware that added the instance id
+// to a request, as net/http copies it onto redirects to any host.
+type instanceIDHosts struct {
+	hosts []string
+}
+
+// instanceIDClient returns client with redirects dropping the instance id
+// when they leave the hosts allowed for req.
+func instanceIDClient(client *http.Client, req *http.Request) *http.Client {
+	scope, ok := req.Context().Value(instanceIDHostsKey{}).(instanceIDHosts)
+	if !ok {
+		return client
+	}
+
+	checkRedirect := client.CheckRedirect
+	c := *client
+	c.CheckRedirect = func(redirect *http.Request, via []*http.Request) error {
+		if !instanceIDAllowed(scope.hosts, redirect.URL.Hostname()) {
+			redirect.Header.Del(InstanceIDHeader)
+		}
+		if checkRedirect != nil {
+			return checkRedirect(redirect, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &c
+}
+
+func instanceIDAllowed(hosts []string, host string) bool {
+	if len(hosts) > 0 {
+		return matchHost(hosts, host)
+	}
+	return !matchHost(grafanaHosts, host)
+}
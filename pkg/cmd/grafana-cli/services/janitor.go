@@ -0,0 +1,256 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+// RetentionPolicy bounds the archive store. Versions beyond KeepVersions per
+// plugin, or last stored longer than MaxAge ago, are pruned. A zero value
+// disables the respective limit.
+type RetentionPolicy struct {
+	MaxAge       time.Duration
+	KeepVersions int
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.KeepVersions > 0
+}
+
+// PrunedArchive is a plugin version removed from the archive store.
+type PrunedArchive struct {
+	PluginID string `json:"pluginId"`
+	Version  string `json:"version"`
+	Reason   string `json:"reason"`
+}
+
+// PruneReport lists what a prune removed, or would remove in a dry run.
+type PruneReport struct {
+	DryRun   bool            `json:"dryRun"`
+	Archives []PrunedArchive `json:"archives"`
+	// Blobs are the digests no plugin version links to anymore.
+	Blobs      []string `json:"blobs"`
+	FreedBytes int64    `json:"freedBytes"`
+}
+
+var archiveRetention RetentionPolicy
+
+// SetArchiveRetention prunes the archive store with policy every time an
+// archive is stored.
+func SetArchiveRetention(policy RetentionPolicy) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	archiveRetention = policy
+}
+
+func getArchiveRetention() RetentionPolicy {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return archiveRetention
+}
+
+var ErrNoRetentionPolicy = errors.New("no archive store or retention policy configured")
+
+// PruneArchives prunes the configured archive store with the configured
+// retention policy.
+func PruneArchives(dryRun bool) (PruneReport, error) {
+	store, policy := getArchiveStore(), getArchiveRetention()
+	if store == nil || !policy.enabled() {
+		return PruneReport{}, ErrNoRetentionPolicy
+	}
+
+	return store.Prune(policy, dryRun)
+}
+
+// ParseRetentionAge parses a duration like "720h", additionally accepting
+// days as in "90d".
+func ParseRetentionAge(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention age %q", value)
+	}
+	return d, nil
+}
+
+type storedVersion struct {
+	version string
+	path    string
+	modTime time.Time
+}
+
+// Prune removes plugin versions violating policy, then the blobs no longer
+// linked by any version. With dryRun nothing is removed.
+func (s *ArchiveStore) Prune(policy RetentionPolicy, dryRun bool) (PruneReport, error) {
+	report := PruneReport{DryRun: dryRun, Archives: []PrunedArchive{}, Blobs: []string{}}
+
+	stored, err := s.versions()
+	if err != nil {
+		return report, err
+	}
+
+	now := getClock().Now()
+	var kept []storedVersion
+	for pluginId, versions := range stored {
+		pruned := 0
+		for i, v := range versions {
+			reason := ""
+			switch {
+			case policy.KeepVersions > 0 && i >= policy.KeepVersions:
+				reason = fmt.Sprintf("exceeds the %d newest versions kept", policy.KeepVersions)
+			case policy.MaxAge > 0 && now.Sub(v.modTime) > policy.MaxAge:
+				reason = fmt.Sprintf("stored %s ago, longer than %s", now.Sub(v.modTime).Round(time.Hour), policy.MaxAge)
+			}
+
+			if reason == "" {
+				kept = append(kept, v)
+				continue
+			}
+
+			pruned++
+			report.Archives = append(report.Archives, PrunedArchive{PluginID: pluginId, Version: v.version, Reason: reason})
+			if !dryRun {
+				if err := os.Remove(v.path); err != nil {
+					return report, err
+				}
+			}
+		}
+
+		if !dryRun && pruned == len(versions) {
+			// fails and leaves the directory in place if anything else is in it
+			os.Remove(filepath.Join(s.Dir, pluginId))
+		}
+	}
+
+	sort.Slice(report.Archives, func(i, j int) bool {
+		a, b := report.Archives[i], report.Archives[j]
+		return a.PluginID < b.PluginID || a.PluginID == b.PluginID && a.Version < b.Version
+	})
+
+	return report, s.pruneBlobs(kept, &report)
+}
+
+// staleTempAge is how old a temporary file has to be before it is taken as
+// left behind by an interrupted write rather than one still in progress.
+const staleTempAge = time.Hour
+
+// pruneBlobs removes the blobs none of the kept versions link to.
+func (s *ArchiveStore) pruneBlobs(kept []storedVersion, report *PruneReport) error {
+	var linked []os.FileInfo
+	for _, v := range kept {
+		// follows symlinks, so both kinds of links compare equal to their blob
+		if fi, err := os.Stat(v.path); err == nil {
+			linked = append(linked, fi)
+		}
+	}
+
+	blobDir := filepath.Join(s.Dir, "blobs", "sha256")
+	blobs, err := ioutil.ReadDir(blobDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		if strings.HasPrefix(blob.Name(), ".tmp-") && getClock().Since(blob.ModTime()) > staleTempAge && !report.DryRun {
+			// left behind by a write interrupted by a crash
+			os.Remove(filepath.Join(blobDir, blob.Name()))
+			continue
+		}
+		if blob.IsDir() || !strings.HasSuffix(blob.Name(), ".zip") || isLinked(blob, linked) {
+			continue
+		}
+
+		report.Blobs = append(report.Blobs, strings.TrimSuffix(blob.Name(), ".zip"))
+		report.FreedBytes += blob.Size()
+		if !report.DryRun {
+			if err := os.Remove(filepath.Join(blobDir, blob.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func isLinked(blob os.FileInfo, linked []os.FileInfo) bool {
+	for _, fi := range linked {
+		if os.SameFile(blob, fi) {
+			return true
+		}
+	}
+	return false
+}
+
+// versions returns the stored versions per plugin, newest first.
+func (s *ArchiveStore) versions() (map[string][]storedVersion, error) {
+	dirs, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stored := map[string][]storedVersion{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == "blobs" {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(s.Dir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var versions []storedVersion
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".zip") {
+				continue
+			}
+			// symlinks report when they were written, hardlinks when their
+			// blob was last stored
+			versions = append(versions, storedVersion{
+				version: strings.TrimSuffix(f.Name(), ".zip"),
+				path:    filepath.Join(s.Dir, dir.Name(), f.Name()),
+				modTime: f.ModTime(),
+			})
+		}
+
+		sort.SliceStable(versions, func(i, j int) bool {
+			return newerVersion(versions[i], versions[j])
+		})
+		stored[dir.Name()] = versions
+	}
+
+	return stored, nil
+}
+
+func newerVersion(a, b storedVersion) bool {
+	va, errA := version.NewVersion(a.version)
+	vb, errB := version.NewVersion(b.version)
+	if errA != nil || errB != nil {
+		return a.modTime.After(b.modTime)
+	}
+	return va.GreaterThan(vb)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArchiveStorePrune(t *testing.T) {
+	setup := func() (*ArchiveStore, func()) {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+
+		store := &ArchiveStore{Dir: dir}
+		for _, v := range []string{"1.0.0", "1.2.0", "1.10.0"} {
+			_, err := store.Put("test-plugin", v, []byte("archive "+v))
+			So(err, ShouldBeNil)
+		}
+		_, err = store.Put("other-plugin", "2.0.0", []byte("archive 1.0.0"))
+		So(err, ShouldBeNil)
+
+		return store, func() { os.RemoveAll(dir) }
+	}
+
+	age := func(store *ArchiveStore, pluginId, version string, d time.Duration) {
+		then := time.Now().Add(-d)
+		So(os.Chtimes(store.versionPath(pluginId, version), then, then), ShouldBeNil)
+	}
+
+	Convey("Keeps the newest versions of every plugin", t, func() {
+		store, cleanup := setup()
+		defer cleanup()
+
+		report, err := store.Prune(RetentionPolicy{KeepVersions: 1}, false)
+		So(err, ShouldBeNil)
+		So(report.Archives, ShouldHaveLength, 2)
+		So(report.Archives[0].Version, ShouldEqual, "1.0.0")
+		So(report.Archives[1].Version, ShouldEqual, "1.2.0")
+
+		// the blob of 1.0.0 is still linked by other-plugin
+		So(report.Blobs, ShouldHaveLength, 1)
+		So(report.FreedBytes, ShouldEqual, len("archive 1.2.0"))
+
+		_, ok := store.Get("test-plugin", "1.10.0")
+		So(ok, ShouldBeTrue)
+		_, ok = store.Get("test-plugin", "1.2.0")
+		So(ok, ShouldBeFalse)
+		_, ok = store.Get("other-plugin", "2.0.0")
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("Removes versions stored longer ago than the retention period", t, func() {
+		store, cleanup := setup()
+		defer cleanup()
+
+		age(store, "test-plugin", "1.2.0", 48*time.Hour)
+
+		report, err := store.Prune(RetentionPolicy{MaxAge: 24 * time.Hour}, false)
+		So(err, ShouldBeNil)
+		So(report.Archives, ShouldHaveLength, 1)
+		So(report.Archives[0].Version, ShouldEqual, "1.2.0")
+		So(report.Blobs, ShouldHaveLength, 1)
+
+		report, err = store.Prune(RetentionPolicy{KeepVersions: 1, MaxAge: time.Nanosecond}, false)
+		So(err, ShouldBeNil)
+		So(report.Archives, ShouldHaveLength, 3)
+
+		_, err = os.Stat(filepath.Join(store.Dir, "other-plugin"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		blobs, err := ioutil.ReadDir(filepath.Join(store.Dir, "blobs", "sha256"))
+		So(err, ShouldBeNil)
+		So(blobs, ShouldBeEmpty)
+	})
+
+	Convey("Dry runs report without removing anything", t, func() {
+		store, cleanup := setup()
+		defer cleanup()
+
+		report, err := store.Prune(RetentionPolicy{KeepVersions: 1}, true)
+		So(err, ShouldBeNil)
+		So(report.DryRun, ShouldBeTrue)
+		So(report.Archives, ShouldHaveLength, 2)
+		So(report.Blobs, ShouldHaveLength, 1)
+
+		_, ok := store.Get("test-plugin", "1.0.0")
+		So(ok, ShouldBeTrue)
+		blobs, err := ioutil.ReadDir(filepath.Join(store.Dir, "blobs", "sha256"))
+		So(err, ShouldBeNil)
+		So(blobs, ShouldHaveLength, 3)
+	})
+
+	Convey("Parses retention ages in days", t, func() {
+		d, err := ParseRetentionAge("90d")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 90*24*time.Hour)
+
+		d, err = ParseRetentionAge("36h")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 36*time.Hour)
+
+		_, err = ParseRetentionAge("soon")
+		So(err, ShouldNotBeNil)
+	})
+}
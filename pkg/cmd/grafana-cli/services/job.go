@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// JobState is the lifecycle state of a bulk operation.
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobPaused    JobState = "paused"
+	JobCancelled JobState = "cancelled"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+)
+
+// JobStatus is a snapshot of the progress of a bulk operation.
+type JobStatus struct {
+	State JobState `json:"state"`
+	// Total and Done count the plugins the operation works on.
+	Total int `json:"total"`
+	Done  int `json:"done"`
+	// Current is the plugin version being processed, if any.
+	Current   string    `json:"current,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// Job controls a long running bulk operation like SyncMirror. Pausing takes
+// effect between archives, so the download in progress completes first.
+// A Job is safe for concurrent use.
+type Job struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mtx    sync.Mutex
+	resume chan struct{}
+	status JobStatus
+}
+
+// NewJob returns a running job bound to ctx.
+func NewJob(ctx context.Context) *Job {
+	ctx, cancel := context.WithCancel(ctx)
+	resume := make(chan struct{})
+	close(resume)
+
+	return &Job{
+		ctx:    ctx,
+		cancel: cancel,
+		resume: resume,
+		status: JobStatus{State: JobRunning, StartedAt: time.Now().UTC()},
+	}
+}
+
+// Context is cancelled when the job is cancelled.
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Pause stops the job at its next checkpoint until Resume is called.
+func (j *Job) Pause() {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.status.State != JobRunning {
+		return
+	}
+	j.resume = make(chan struct{})
+	j.status.State = JobPaused
+}
+
+// Resume continues a paused job.
+func (j *Job) Resume() {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.status.State != JobPaused {
+		return
+	}
+	close(j.resume)
+	j.status.State = JobRunning
+}
+
+// Cancel stops the job, aborting requests in flight.
+func (j *Job) Cancel() {
+	j.cancel()
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.status.State == JobRunning || j.status.State == JobPaused {
+		j.status.State = JobCancelled
+	}
+}
+
+// Status returns a snapshot of the job progress.
+func (j *Job) Status() JobStatus {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	return j.status
+}
+
+// checkpoint blocks while the job is paused and returns an error once it
+// has been cancelled.
+func (j *Job) checkpoint(current string) error {
+	j.mtx.Lock()
+	j.status.Current = current
+	resume := j.resume
+	j.mtx.Unlock()
+
+	select {
+	case <-resume:
+	case <-j.ctx.Done():
+	}
+	return j.ctx.Err()
+}
+
+func (j *Job) setTotal(total int) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.status.Total = total
+}
+
+func (j *Job) step() {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.status.Done++
+}
+
+func (j *Job) finish(err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.status.Current = ""
+	switch {
+	case j.status.State == JobCancelled || err != nil && err == j.ctx.Err():
+		j.status.State = JobCancelled
+	case err != nil:
+		j.status.State = JobFailed
+		j.status.Error = err.Error()
+	default:
+		j.status.State = JobDone
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJob(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if path.Base(r.URL.Path) == "download" {
+			w.Write([]byte("plugin archive"))
+			return
+		}
+		w.Write([]byte(`{"id": "` + path.Base(r.URL.Path) + `", "versions": [{"version": "1.0.0"}]}`))
+	}))
+	defer server.Close()
+
+	sync := func(job *Job) (chan error, string) {
+		dir, err := ioutil.TempDir("", "mirror")
+		So(err, ShouldBeNil)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := SyncMirror(context.Background(), server.URL, dir, []string{"job-panel"}, SyncOptions{Job: job})
+			done <- err
+		}()
+		return done, dir
+	}
+
+	Convey("A paused sync continues where it stopped once resumed", t, func() {
+		atomic.StoreInt32(&requests, 0)
+		job := NewJob(context.Background())
+		job.Pause()
+
+		done, dir := sync(job)
+		defer os.RemoveAll(dir)
+
+		time.Sleep(50 * time.Millisecond)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 0)
+		So(job.Status().State, ShouldEqual, JobPaused)
+
+		job.Resume()
+		So(<-done, ShouldBeNil)
+
+		status := job.Status()
+		So(status.State, ShouldEqual, JobDone)
+		So(status.Done, ShouldEqual, 1)
+		So(status.Total, ShouldEqual, 1)
+	})
+
+	Convey("Cancelling a paused sync stops it", t, func() {
+		job := NewJob(context.Background())
+		job.Pause()
+
+		done, dir := sync(job)
+		defer os.RemoveAll(dir)
+
+		job.Cancel()
+		So(<-done, ShouldEqual, context.Canceled)
+		So(job.Status().State, ShouldEqual, JobCancelled)
+	})
+}
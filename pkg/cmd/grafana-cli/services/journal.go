@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// JournalEntry records a plugin that was installed as part of a bulk operation.
+type JournalEntry struct {
+	Version     string    `json:"version"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// Journal persists the progress of bulk installs so an interrupted run can be
+// resumed without redoing completed work, and without losing plugins that
+// were removed but not reinstalled yet.
+type Journal struct {
+	mtx  sync.Mutex
+	path string
+
+	Planned   []string                `json:"planned"`
+	Completed map[string]JournalEntry `json:"completed"`
+
+	// current are the ids planned by this run
+	current map[string]bool
+}
+
+// OpenJournal loads the journal at path, or starts an empty one when it does not exist.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path, Completed: map[string]JournalEntry{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, j); err != nil {
+		// a journal torn by a crash is not worth failing over, start over
+		log.Warnf("Ignoring unreadable install journal %v: %v\n", path, err)
+		return &Journal{path: path, Completed: map[string]JournalEntry{}}, nil
+	}
+	if j.Completed == nil {
+		j.Completed = map[string]JournalEntry{}
+	}
+
+	return j, nil
+}
+
+// Plan adds plugin ids to the set of planned installs and persists it before
+// any work starts. It returns all planned ids, including those left over
+// from an interrupted run. Completions recorded by earlier runs for pluginIds
+// are dropped, as planning them again means there is a newer version.
+func (j *Journal) Plan(pluginIds []string) ([]string, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.current = map[string]bool{}
+	for _, id := range pluginIds {
+		j.current[id] = true
+		delete(j.Completed, id)
+	}
+
+	planned := map[string]bool{}
+	for _, id := range j.Planned {
+		planned[id] = true
+	}
+	for _, id := range pluginIds {
+		if !planned[id] {
+			planned[id] = true
+			j.Planned = append(j.Planned, id)
+		}
+	}
+
+	return append([]string{}, j.Planned...), j.save()
+}
+
+// Done reports whether the installed pluginId needs no more work: plugins of
+// the current plan once they were completed at installedVersion, and those
+// left over from the plan of an interrupted run, which only need to be
+// installed again when they are missing.
+func (j *Journal) Done(pluginId, installedVersion string) bool {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if !j.current[pluginId] {
+		return true
+	}
+	entry, ok := j.Completed[pluginId]
+	return ok && entry.Version == installedVersion
+}
+
+func (j *Journal) Complete(pluginId, version string) error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.Completed[pluginId] = JournalEntry{Version: version, CompletedAt: time.Now().UTC()}
+	return j.save()
+}
+
+// Remove deletes the journal once the bulk operation has finished.
+func (j *Journal) Remove() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	err := os.Remove(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (j *Journal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(j.path, data)
+}
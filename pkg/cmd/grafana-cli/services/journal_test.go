@@ -0,0 +1,58 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJournal(t *testing.T) {
+	Convey("Bulk install journals", t, func() {
+		dir, err := ioutil.TempDir("", "journal")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "journal.json")
+
+		journal, err := OpenJournal(path)
+		So(err, ShouldBeNil)
+		planned, err := journal.Plan([]string{"plugin-a", "plugin-b"})
+		So(err, ShouldBeNil)
+		So(planned, ShouldResemble, []string{"plugin-a", "plugin-b"})
+		So(journal.Done("plugin-a", "1.0.0"), ShouldBeFalse)
+		So(journal.Complete("plugin-a", "2.0.0"), ShouldBeNil)
+		So(journal.Done("plugin-a", "2.0.0"), ShouldBeTrue)
+
+		Convey("resume the plan of an interrupted run", func() {
+			resumed, err := OpenJournal(path)
+			So(err, ShouldBeNil)
+			planned, err := resumed.Plan([]string{"plugin-b"})
+			So(err, ShouldBeNil)
+			So(planned, ShouldResemble, []string{"plugin-a", "plugin-b"})
+
+			So(resumed.Done("plugin-a", "2.0.0"), ShouldBeTrue)
+			So(resumed.Done("plugin-b", "1.0.0"), ShouldBeFalse)
+		})
+
+		Convey("redo plugins planned again with a newer version", func() {
+			resumed, err := OpenJournal(path)
+			So(err, ShouldBeNil)
+			_, err = resumed.Plan([]string{"plugin-a", "plugin-b"})
+			So(err, ShouldBeNil)
+
+			So(resumed.Done("plugin-a", "2.0.0"), ShouldBeFalse)
+		})
+
+		Convey("are removed once the run finished", func() {
+			So(journal.Remove(), ShouldBeNil)
+			_, err := os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			fresh, err := OpenJournal(path)
+			So(err, ShouldBeNil)
+			So(fresh.Planned, ShouldBeEmpty)
+		})
+	})
+}
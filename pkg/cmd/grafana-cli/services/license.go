@@ -0,0 +1,178 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+)
+
+var ErrLicenseNotAllowed = errors.New("plugin license is not allowed")
+
+// LicenseNotAllowedError is returned when resolving a plugin whose license
+// is not approved by the license policy.
+type LicenseNotAllowedError struct {
+	PluginID string
+	// License is empty when the repository publishes no license.
+	License string
+}
+
+func (e *LicenseNotAllowedError) Error() string {
+	if e.License == "" {
+		return fmt.Sprintf("%s publishes no license: %v", e.PluginID, ErrLicenseNotAllowed)
+	}
+	return fmt.Sprintf("%s is licensed under %q: %v", e.PluginID, e.License, ErrLicenseNotAllowed)
+}
+
+func (e *LicenseNotAllowedError) Unwrap() error {
+	return ErrLicenseNotAllowed
+}
+
+// LicensePolicy restricts resolution to plugins under approved licenses. An
+// empty policy allows every license.
+type LicensePolicy struct {
+	// Allowed are SPDX license identifiers, e.g. "Apache-2.0", compared case insensitively.
+	Allowed []string
+	// AllowUnknown permits plugins that publish no license.
+	AllowUnknown bool
+}
+
+var licensePolicy LicensePolicy
+
+func SetLicensePolicy(policy LicensePolicy) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	licensePolicy = policy
+}
+
+func getLicensePolicy() LicensePolicy {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return licensePolicy
+}
+
+// Check accepts plugins whose SPDX license expression is satisfied by the
+// allowed licenses: "MIT OR Apache-2.0" when either license is allowed and
+// "MIT AND BSD-3-Clause" when both are. AND binds tighter than OR,
+// parentheses group, operators are case insensitive and "GPL-2.0 WITH
+// Classpath-exception-2.0" is allowed with either the license or the whole
+// expression allowed. Malformed expressions are never allowed.
+func (p LicensePolicy) Check(plugin m.Plugin) error {
+	if len(p.Allowed) == 0 {
+		return nil
+	}
+
+	expr := strings.TrimSpace(plugin.License)
+	if expr == "" {
+		if p.AllowUnknown {
+			return nil
+		}
+		return &LicenseNotAllowedError{PluginID: plugin.Id}
+	}
+
+	parser := &licenseParser{tokens: tokenizeLicense(expr), policy: p}
+	allowed, ok := parser.or()
+	if !ok || parser.pos != len(parser.tokens) || !allowed {
+		return &LicenseNotAllowedError{PluginID: plugin.Id, License: plugin.License}
+	}
+	return nil
+}
+
+func (p LicensePolicy) allows(license string) bool {
+	for _, allowed := range p.Allowed {
+		if strings.EqualFold(strings.TrimSpace(allowed), license) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenizeLicense splits an SPDX license expression into license ids,
+// operators and parentheses.
+func tokenizeLicense(expr string) []string {
+	expr = strings.Replace(expr, "(", " ( ", -1)
+	expr = strings.Replace(expr, ")", " ) ", -1)
+	return strings.Fields(expr)
+}
+
+// licenseParser evaluates an SPDX license expression against a policy while
+// parsing it:
+//
+//	or   = and { "OR" and }
+//	and  = term { "AND" term }
+//	term = "(" or ")" | license [ "WITH" exception ]
+//
+// Every method returns whether the policy allows what it parsed and false
+// for ok when the expression is malformed.
+type licenseParser struct {
+	tokens []string
+	pos    int
+	policy LicensePolicy
+}
+
+func (lp *licenseParser) or() (allowed bool, ok bool) {
+	allowed, ok = lp.and()
+	for ok && lp.accept("OR") {
+		var right bool
+		right, ok = lp.and()
+		allowed = allowed || right
+	}
+	return allowed, ok
+}
+
+func (lp *licenseParser) and() (allowed bool, ok bool) {
+	allowed, ok = lp.term()
+	for ok && lp.accept("AND") {
+		var right bool
+		right, ok = lp.term()
+		allowed = allowed && right
+	}
+	return allowed, ok
+}
+
+func (lp *licenseParser) term() (allowed bool, ok bool) {
+	if lp.accept("(") {
+		allowed, ok = lp.or()
+		return allowed, ok && lp.accept(")")
+	}
+
+	license, ok := lp.license()
+	if !ok {
+		return false, false
+	}
+	allowed = lp.policy.allows(license)
+	if lp.accept("WITH") {
+		exception, ok := lp.license()
+		if !ok {
+			return false, false
+		}
+		allowed = allowed || lp.policy.allows(license+" WITH "+exception)
+	}
+	return allowed, true
+}
+
+// license consumes a license or exception id.
+func (lp *licenseParser) license() (string, bool) {
+	if lp.pos == len(lp.tokens) {
+		return "", false
+	}
+	token := lp.tokens[lp.pos]
+	switch strings.ToUpper(token) {
+	case "(", ")", "AND", "OR", "WITH":
+		return "", false
+	}
+	lp.pos++
+	return token, true
+}
+
+// accept consumes the next token if it is the operator or parenthesis want.
+func (lp *licenseParser) accept(want string) bool {
+	if lp.pos < len(lp.tokens) && strings.EqualFold(lp.tokens[lp.pos], want) {
+		lp.pos++
+		return true
+	}
+	return false
+}
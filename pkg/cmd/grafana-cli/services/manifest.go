@@ -0,0 +1,201 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var ErrNoPluginJSON = errors.New("archive contains no plugin.json")
+
+const (
+	// manifestTailSize is fetched first from the end of an archive. It fits
+	// the end of central directory record with the longest comment, and the
+	// central directory of most plugins.
+	manifestTailSize = 64 << 10
+	// manifestReadAhead is the least fetched by further range requests, so
+	// reading an entry takes one request rather than one per header.
+	manifestReadAhead = 16 << 10
+)
+
+// PeekManifest returns the plugin.json of a plugin version from the
+// configured repository, fetching only the central directory and the
+// plugin.json of the archive with range requests, e.g. to validate the plugin
+// id, dependencies and includes before installing it. An empty version peeks
+// at the latest one. Repositories not serving ranges send the whole archive.
+// The archive checksum cannot be verified from parts of it, so the manifest
+// must not be trusted beyond such checks.
+func PeekManifest(ctx context.Context, pluginId, version string) (m.InstalledPlugin, error) {
+	res, err := ResolveWithContext(ctx, "", PluginRequest{PluginID: pluginId, Version: version})
+	if err != nil {
+		return m.InstalledPlugin{}, err
+	}
+
+	r, err := openRemoteZip(ctx, pluginId, res.URL)
+	if err != nil {
+		return m.InstalledPlugin{}, err
+	}
+	return ReadManifest(r)
+}
+
+// ReadManifest reads the plugin.json in the dist directory of the archive, or
+// at its top.
+func ReadManifest(r *zip.Reader) (m.InstalledPlugin, error) {
+	var root, dist *zip.File
+	for _, zf := range r.File {
+		parts := strings.Split(strings.TrimPrefix(zf.Name, "/"), "/")
+		if len(parts) == 2 && parts[1] == "plugin.json" {
+			root = zf
+		} else if len(parts) == 3 && parts[1] == "dist" && parts[2] == "plugin.json" {
+			dist = zf
+		}
+	}
+
+	// like ReadPlugin, the built plugin.json takes precedence
+	manifest := dist
+	if manifest == nil {
+		manifest = root
+	}
+	if manifest == nil {
+		return m.InstalledPlugin{}, ErrNoPluginJSON
+	}
+
+	rc, err := manifest.Open()
+	if err != nil {
+		return m.InstalledPlugin{}, err
+	}
+	defer rc.Close()
+
+	var plugin m.InstalledPlugin
+	if err := json.NewDecoder(rc).Decode(&plugin); err != nil {
+		return m.InstalledPlugin{}, fmt.Errorf("invalid %s: %v", manifest.Name, err)
+	}
+	if plugin.Id == "" || plugin.Info.Version == "" {
+		return m.InstalledPlugin{}, fmt.Errorf("%s lacks the plugin id or version", manifest.Name)
+	}
+	if err := ValidatePluginID(plugin.Id); err != nil {
+		return m.InstalledPlugin{}, xerrors.Errorf("%s: %w", manifest.Name, err)
+	}
+	return plugin, nil
+}
+
+// openRemoteZip opens the archive at url, reading its parts with range
+// requests as the zip reader needs them.
+func openRemoteZip(ctx context.Context, pluginId, url string) (*zip.Reader, error) {
+	r := &rangeReader{ctx: ctx, pluginId: pluginId, url: url}
+
+	body, contentRange, err := r.get(fmt.Sprintf("-%d", manifestTailSize))
+	if err != nil {
+		return nil, err
+	}
+	if contentRange == "" {
+		// the repository ignored the range and sent the whole archive
+		return zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	}
+
+	start, size, err := parseContentRange(contentRange)
+	if err != nil {
+		return nil, repoError(OpDownload, pluginId, url, err)
+	}
+	r.size, r.block, r.blockOffset = size, body, start
+	return zip.NewReader(r, size)
+}
+
+// rangeReader reads a remote archive with range requests, keeping the last
+// fetched block.
+type rangeReader struct {
+	ctx      context.Context
+	pluginId string
+	url      string
+	size     int64
+
+	block       []byte
+	blockOffset int64
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= r.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	if off < r.blockOffset || end > r.blockOffset+int64(len(r.block)) {
+		fetchEnd := end
+		if fetchEnd-off < manifestReadAhead {
+			fetchEnd = off + manifestReadAhead
+		}
+		if fetchEnd > r.size {
+			fetchEnd = r.size
+		}
+
+		body, contentRange, err := r.get(fmt.Sprintf("%d-%d", off, fetchEnd-1))
+		if err != nil {
+			return 0, err
+		}
+		start, _, err := parseContentRange(contentRange)
+		if err != nil || start != off || int64(len(body)) < end-off {
+			return 0, repoError(OpDownload, r.pluginId, r.url, xerrors.Errorf("unexpected range %q in response to %d-%d", contentRange, off, fetchEnd-1))
+		}
+		r.block, r.blockOffset = body, start
+	}
+
+	n := copy(p, r.block[off-r.blockOffset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// get requests a range of the archive, returning the Content-Range of the
+// response, empty when the repository sent the whole archive.
+func (r *rangeReader) get(spec string) ([]byte, string, error) {
+	req, err := newRequest(r.url)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(r.ctx)
+	req.Header.Set("Range", "bytes="+spec)
+
+	res, err := do(&DownloadClient, &RepoRequest{Op: OpDownload, PluginID: r.pluginId, Request: req})
+	var contentRange string
+	if err == nil && res.StatusCode == http.StatusPartialContent {
+		contentRange = res.Header.Get("Content-Range")
+	}
+
+	body, err := readResponse(checkDownload(r.url, res, err))
+	if err != nil {
+		return nil, "", repoError(OpDownload, r.pluginId, r.url, err)
+	}
+	return body, contentRange, nil
+}
+
+// parseContentRange parses "bytes <start>-<end>/<size>".
+func parseContentRange(value string) (start int64, size int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range %q", value)
+
+	parts := strings.SplitN(strings.TrimPrefix(value, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, invalid
+	}
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil || len(bounds) != 2 {
+		return 0, 0, invalid
+	}
+	if size, err = strconv.ParseInt(parts[1], 10, 64); err != nil || start >= size {
+		return 0, 0, invalid
+	}
+	return start, size, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestPeekManifest(t *testing.T) {
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	f, _ := w.Create("peek-panel/dist/plugin.json")
+	f.Write([]byte(`{"id": "peek-panel", "type": "panel", "info": {"version": "1.0.0"}, "dependencies": {"grafanaVersion": "7.x"}}`))
+	// a large asset the manifest is not read from
+	f, _ = w.CreateHeader(&zip.FileHeader{Name: "peek-panel/dist/module.js", Method: zip.Store})
+	asset := make([]byte, 512<<10)
+	rand.New(rand.NewSource(1)).Read(asset)
+	f.Write(asset)
+	w.Close()
+
+	Convey("Manifests are read from parts of the archive", t, func() {
+		var mtx sync.Mutex
+		served, ranges := 0, true
+		servedBytes := func() int {
+			mtx.Lock()
+			defer mtx.Unlock()
+			return served
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/repo/"):
+				w.Write([]byte(`{"id": "peek-panel", "versions": [{"version": "1.0.0"}]}`))
+			case strings.HasSuffix(r.URL.Path, "/download"):
+				mtx.Lock()
+				defer mtx.Unlock()
+				if !ranges {
+					r.Header.Del("Range")
+				}
+				rw := &countingWriter{ResponseWriter: w}
+				http.ServeContent(rw, r, "peek-panel.zip", time.Time{}, bytes.NewReader(archive.Bytes()))
+				served += rw.n
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		prevRepoURL := RepoURL()
+		setRepoURL(server.URL)
+		defer setRepoURL(prevRepoURL)
+
+		plugin, err := PeekManifest(context.Background(), "peek-panel", "1.0.0")
+		So(err, ShouldBeNil)
+		So(plugin.Id, ShouldEqual, "peek-panel")
+		So(plugin.Dependencies.GrafanaVersion, ShouldEqual, "7.x")
+		So(servedBytes(), ShouldBeLessThan, archive.Len()/4)
+
+		Convey("or from the whole archive if the repository serves no ranges", func() {
+			mtx.Lock()
+			ranges, served = false, 0
+			mtx.Unlock()
+			plugin, err := PeekManifest(context.Background(), "peek-panel", "")
+			So(err, ShouldBeNil)
+			So(plugin.Info.Version, ShouldEqual, "1.0.0")
+			So(servedBytes(), ShouldEqual, archive.Len())
+		})
+	})
+
+	Convey("Archives without a plugin.json are reported", t, func() {
+		var empty bytes.Buffer
+		zip.NewWriter(&empty).Close()
+		r, err := zip.NewReader(bytes.NewReader(empty.Bytes()), int64(empty.Len()))
+		So(err, ShouldBeNil)
+
+		_, err = ReadManifest(r)
+		So(xerrors.Is(err, ErrNoPluginJSON), ShouldBeTrue)
+	})
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += n
+	return n, err
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+)
+
+func metadataCacheKey(repoUrl, pluginId string) string {
+	return "metadata:" + resolveRepoURL(repoUrl) + "/" + pluginId
+}
+
+func archiveCacheKey(url string) string {
+	return "archive:" + url
+}
+
+func metadataFetchedKey(repoUrl, pluginId string) string {
+	return "metadata-fetched:" + resolveRepoURL(repoUrl) + "/" + pluginId
+}
+
+func getCachedPlugin(repoUrl, pluginId string) (m.Plugin, time.Time, bool) {
+	return readCachedPlugin(getCache().Get, repoUrl, pluginId)
+}
+
+// getStalePlugin returns cached metadata even if it expired, as long as it
+// was fetched within the stale metadata max age.
+func getStalePlugin(repoUrl, pluginId string) (m.Plugin, time.Time, bool) {
+	c, ok := getCache().(StaleCache)
+	maxAge := getStaleMetadataMaxAge()
+	if !ok || maxAge <= 0 {
+		return m.Plugin{}, time.Time{}, false
+	}
+
+	var plugin m.Plugin
+	fetchedAt, ok := readStale(c, repoUrl, pluginId, maxAge, &plugin)
+	return plugin, fetchedAt, ok
+}
+
+// listingCacheKey is where the repository listing is kept in the metadata
+// cache, plugin ids cannot contain slashes.
+const listingCacheKey = "/repo"
+
+// getStaleListing returns the last listing of the repository, like
+// getStalePlugin. Listings are only read from the cache when the repository
+// failed.
+func getStaleListing(repoUrl string) (m.PluginRepo, time.Time, bool) {
+	c, ok := getCache().(StaleCache)
+	maxAge := getStaleMetadataMaxAge()
+	if !ok || maxAge <= 0 {
+		return m.PluginRepo{}, time.Time{}, false
+	}
+
+	var listing m.PluginRepo
+	fetchedAt, ok := readStale(c, repoUrl, listingCacheKey, maxAge, &listing)
+	return listing, fetchedAt, ok
+}
+
+func setCachedListing(repoUrl string, body []byte, fetchedAt time.Time) {
+	if getStaleMetadataMaxAge() > 0 {
+		setCachedPlugin(repoUrl, listingCacheKey, body, fetchedAt)
+	}
+}
+
+func readStale(c StaleCache, repoUrl, key string, maxAge time.Duration, v interface{}) (time.Time, bool) {
+	fetchedAt, ok := readCached(c.GetStale, repoUrl, key, v)
+	if !ok || fetchedAt.IsZero() || getClock().Since(fetchedAt) > maxAge {
+		return time.Time{}, false
+	}
+	return fetchedAt, true
+}
+
+func readCachedPlugin(get func(key string) ([]byte, bool), repoUrl, pluginId string) (m.Plugin, time.Time, bool) {
+	var plugin m.Plugin
+	fetchedAt, ok := readCached(get, repoUrl, pluginId, &plugin)
+	return plugin, fetchedAt, ok
+}
+
+func readCached(get func(key string) ([]byte, bool), repoUrl, key string, v interface{}) (time.Time, bool) {
+	body, ok := get(metadataCacheKey(repoUrl, key))
+	if !ok {
+		return time.Time{}, false
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return time.Time{}, false
+	}
+
+	// entries written by older versions sharing the cache have no timestamp
+	var fetchedAt time.Time
+	if ts, ok := get(metadataFetchedKey(repoUrl, key)); ok {
+		fetchedAt, _ = time.Parse(time.RFC3339Nano, string(ts))
+	}
+
+	return fetchedAt, true
+}
+
+func setCachedPlugin(repoUrl, pluginId string, body []byte, fetchedAt time.Time) {
+	getCache().Set(metadataCacheKey(repoUrl, pluginId), body, MetadataCacheTTL)
+	getCache().Set(metadataFetchedKey(repoUrl, pluginId), []byte(fetchedAt.Format(time.RFC3339Nano)), MetadataCacheTTL)
+}
+
+// InvalidateMetadata drops the cached metadata of a single plugin so the next
+// lookup fetches it from the repository again. Namespaced plugins are dropped
+// for the repository of their namespace, others for the configured repository
+// and the failover repositories.
+func InvalidateMetadata(pluginId string) {
+	if namespace, id := SplitPluginRef(pluginId); namespace != "" {
+		if repo, err := namespaceRepo(namespace, ""); err == nil {
+			invalidateMetadata(id, repo)
+		}
+		return
+	}
+
+	for _, repo := range append([]string{RepoURL()}, getFailoverRepos()...) {
+		invalidateMetadata(pluginId, repo)
+	}
+}
+
+// invalidateMetadata is InvalidateMetadata for the repository at repoUrl.
+func invalidateMetadata(pluginId, repoUrl string) {
+	getCache().Delete(metadataCacheKey(repoUrl, pluginId))
+	getCache().Delete(metadataFetchedKey(repoUrl, pluginId))
+	page := versionsPageCacheKey(pluginId, getVersionsPageSize())
+	getCache().Delete(metadataCacheKey(repoUrl, page))
+	getCache().Delete(metadataFetchedKey(repoUrl, page))
+	if IsStaticRepo(repoUrl) {
+		getCache().Delete(staticIndexCacheKey(repoUrl))
+	}
+}
+
+// InvalidateArchive drops a cached archive so the next download fetches it again.
+func InvalidateArchive(url string) {
+	getCache().Delete(archiveCacheKey(url))
+}
+
+// PrefetchMetadata fills the metadata cache for the given plugin ids of the
+// configured repository using at most concurrency parallel requests. Plugins
+// that fail to resolve are logged and the first error is returned once all
+// workers are done.
+func PrefetchMetadata(ctx context.Context, ids []string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	repoUrl := RepoURL()
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		firstErr error
+	)
+
+	sem := make(chan struct{}, concurrency)
+	ctx = WithPriority(ctx, PriorityBackground)
+
+	for _, id := range ids {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(pluginId string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if _, err := GetPluginWithContext(ctx, pluginId, repoUrl); err != nil {
+				log.Debugf("failed to prefetch metadata for %v: %v\n", pluginId, err)
+
+				mtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mtx.Unlock()
+			}
+		}(id)
+	}
+
+	wg.Wait()
+	return firstErr
+}
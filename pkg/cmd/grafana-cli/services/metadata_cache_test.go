@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestPrefetchMetadata(t *testing.T) {
+	Convey("Prefetching fills the metadata cache", t, func() {
+		var requests int32
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Write([]byte(`{"id": "` + path.Base(r.URL.Path) + `", "versions": [{"version": "1.0.0"}]}`))
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		prevRepoURL := RepoURL()
+		setRepoURL(server.URL)
+		defer setRepoURL(prevRepoURL)
+
+		ids := []string{"plugin-a", "plugin-b", "plugin-c"}
+		err := PrefetchMetadata(context.Background(), ids, 2)
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+
+		plugin, err := GetPlugin("plugin-b", server.URL)
+		So(err, ShouldBeNil)
+		So(plugin.Id, ShouldEqual, "plugin-b")
+		So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+
+		Convey("invalidating a plugin refetches only that plugin", func() {
+			InvalidateMetadata("plugin-b")
+
+			_, err := GetPlugin("plugin-b", server.URL)
+			So(err, ShouldBeNil)
+			_, err = GetPlugin("plugin-a", server.URL)
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 4)
+		})
+
+		Convey("invalidating a plugin refetches it from the failover repositories", func() {
+			mirror := httptest.NewServer(handler)
+			defer mirror.Close()
+			SetFailoverRepos([]string{mirror.URL})
+			defer SetFailoverRepos(nil)
+
+			_, err := GetPlugin("plugin-b", mirror.URL)
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 4)
+
+			InvalidateMetadata("plugin-b")
+			_, err = GetPlugin("plugin-b", mirror.URL)
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 5)
+		})
+
+		Convey("resolutions served from the cache report the metadata age", func() {
+			fresh, err := Resolve(server.URL, PluginRequest{PluginID: "plugin-c", Force: true})
+			So(err, ShouldBeNil)
+			So(fresh.FromCache, ShouldBeFalse)
+
+			res, err := Resolve(server.URL, PluginRequest{PluginID: "plugin-c"})
+			So(err, ShouldBeNil)
+			So(res.FromCache, ShouldBeTrue)
+			So(res.MetadataFetchedAt, ShouldEqual, fresh.MetadataFetchedAt)
+			So(res.MetadataAge(), ShouldBeGreaterThan, 0)
+		})
+	})
+}
+
+func TestStaleMetadata(t *testing.T) {
+	Convey("Expired metadata is served while the repository is down", t, func() {
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		prevCache := getCache()
+		SetCache(newMemoryCache())
+		defer SetCache(prevCache)
+
+		prevTTL, prevMaxAge := MetadataCacheTTL, getStaleMetadataMaxAge()
+		MetadataCacheTTL = time.Millisecond
+		SetStaleMetadataMaxAge(time.Hour)
+		defer func() {
+			MetadataCacheTTL = prevTTL
+			SetStaleMetadataMaxAge(prevMaxAge)
+		}()
+
+		var status int32 = http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if code := int(atomic.LoadInt32(&status)); code != http.StatusOK {
+				w.WriteHeader(code)
+				return
+			}
+			if r.URL.Path == "/repo" {
+				w.Write([]byte(`{"plugins": [{"id": "stale-plugin", "versions": [{"version": "1.0.0"}]}]}`))
+				return
+			}
+			w.Write([]byte(`{"id": "stale-plugin", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer server.Close()
+
+		fresh, err := Resolve(server.URL, PluginRequest{PluginID: "stale-plugin"})
+		So(err, ShouldBeNil)
+		So(fresh.Stale, ShouldBeFalse)
+		listing, err := ListAllPlugins(server.URL)
+		So(err, ShouldBeNil)
+		So(listing.Stale, ShouldBeFalse)
+		time.Sleep(5 * time.Millisecond)
+
+		Convey("when the repository fails", func() {
+			atomic.StoreInt32(&status, http.StatusBadGateway)
+
+			res, err := Resolve(server.URL, PluginRequest{PluginID: "stale-plugin"})
+			So(err, ShouldBeNil)
+			So(res.Stale, ShouldBeTrue)
+			So(res.Version.Version, ShouldEqual, "1.0.0")
+			So(res.MetadataFetchedAt, ShouldEqual, fresh.MetadataFetchedAt)
+		})
+
+		Convey("including the listing update checks use", func() {
+			atomic.StoreInt32(&status, http.StatusBadGateway)
+
+			listing, err := ListAllPlugins(server.URL)
+			So(err, ShouldBeNil)
+			So(listing.Stale, ShouldBeTrue)
+			So(listing.Plugins, ShouldHaveLength, 1)
+		})
+
+		Convey("but not when the plugin was removed", func() {
+			atomic.StoreInt32(&status, http.StatusNotFound)
+
+			_, err := Resolve(server.URL, PluginRequest{PluginID: "stale-plugin"})
+			So(xerrors.Is(err, ErrNotFoundError), ShouldBeTrue)
+		})
+
+		Convey("nor when it is older than the max age", func() {
+			atomic.StoreInt32(&status, http.StatusBadGateway)
+			SetStaleMetadataMaxAge(time.Millisecond)
+
+			_, err := Resolve(server.URL, PluginRequest{PluginID: "stale-plugin"})
+			So(err, ShouldNotBeNil)
+			_, err = ListAllPlugins(server.URL)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"net/http"
+)
+
+// Operation identifies the kind of repository call a request is made for.
+type Operation string
+
+const (
+	OpListPlugins   Operation = "list-plugins"
+	OpGetPlugin     Operation = "get-plugin"
+	OpChecksum      Operation = "checksum"
+	OpDownload      Operation = "download"
+	OpNotifications Operation = "notifications"
+	OpCapabilities  Operation = "capabilities"
+)
+
+// RepoRequest is a request to the plugin repository together with the
+// operation it belongs to.
+type RepoRequest struct {
+	Op       Operation
+	PluginID string
+	Request  *http.Request
+}
+
+// RepoHandler performs a repository request.
+type RepoHandler func(req *RepoRequest) (*http.Response, error)
+
+// Middleware wraps a RepoHandler to intercept requests and responses, e.g. for
+// auth refresh, request signing, caching or logging.
+type Middleware func(next RepoHandler) RepoHandler
+
+var middlewares []Middleware
+
+// Use appends middlewares to the chain applied to every repository request.
+// Middlewares run in the order they were added.
+func Use(mw ...Middleware) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	// copy so chains already built by do keep their own slice
+	chain := make([]Middleware, 0, len(middlewares)+len(mw))
+	middlewares = append(append(chain, middlewares...), mw...)
+}
+
+func do(client *http.Client, req *RepoRequest) (*http.Response, error) {
+	if err := checkDownloadHost(req); err != nil {
+		return nil, err
+	}
+
+	req, end, err := beginRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	id := setRequestID(req.Request)
+	requestLog(req).Debugf("repository request op=%v url=%v request_id=%v\n", req.Op, req.Request.URL, id)
+
+	handler := func(r *RepoRequest) (*http.Response, error) {
+		return doAuthenticated(instanceIDClient(repoClient(client), r.Request), r.Request)
+	}
+
+	stateMtx.RLock()
+	chain := middlewares
+	stateMtx.RUnlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+
+	res, err := checkTLSPolicy(withRetries(handler, req))
+	res, err = trackBody(res, err, end)
+	return res, withRequestID(id, err)
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMiddleware(t *testing.T) {
+	Convey("Middlewares are applied to repository requests in order", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Header.Get("X-Trace")))
+		}))
+		defer server.Close()
+
+		defer func() { middlewares = nil }()
+
+		var ops []Operation
+		tag := func(value string) Middleware {
+			return func(next RepoHandler) RepoHandler {
+				return func(req *RepoRequest) (*http.Response, error) {
+					ops = append(ops, req.Op)
+					req.Request.Header.Add("X-Trace", value)
+					return next(req)
+				}
+			}
+		}
+		Use(tag("first"), tag("second"))
+
+		body, err := sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL, "repo", "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "first")
+		So(ops, ShouldResemble, []Operation{OpGetPlugin, OpGetPlugin})
+	})
+}
+
+func TestInstanceIDMiddleware(t *testing.T) {
+	Convey("The instance id is only sent on downloads to allowed hosts", t, func() {
+		header := func(hosts []string, op Operation, url string) string {
+			var got string
+			handler := InstanceIDMiddleware("instance-1", hosts)(func(req *RepoRequest) (*http.Response, error) {
+				got = req.Request.Header.Get(InstanceIDHeader)
+				return nil, nil
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			handler(&RepoRequest{Op: op, Request: req})
+			return got
+		}
+
+		So(header(nil, OpDownload, "https://mirror.example.com/plugin.zip"), ShouldEqual, "instance-1")
+		So(header(nil, OpGetPlugin, "https://mirror.example.com/repo/plugin"), ShouldBeEmpty)
+		So(header(nil, OpDownload, "https://grafana.com/api/plugins/plugin/versions/1.0.0/download"), ShouldBeEmpty)
+		So(header(nil, OpDownload, "https://storage.grafana.com/plugin.zip"), ShouldBeEmpty)
+		So(header([]string{"*.example.com"}, OpDownload, "https://other.org/plugin.zip"), ShouldBeEmpty)
+		So(header([]string{"grafana.com"}, OpDownload, "https://grafana.com/api/plugins/plugin/versions/1.0.0/download"), ShouldEqual, "instance-1")
+
+		Convey("not even through redirects to other hosts", func() {
+			var received []string
+			var cdn *httptest.Server
+			cdn = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = append(received, r.Host+"="+r.Header.Get(InstanceIDHeader))
+				if r.URL.Path == "/mirror.zip" {
+					http.Redirect(w, r, strings.Replace(cdn.URL, "127.0.0.1", "localhost", 1)+"/cdn.zip", http.StatusFound)
+					return
+				}
+				w.Write([]byte("archive"))
+			}))
+			defer cdn.Close()
+
+			prevMiddlewares := middlewares
+			defer func() { middlewares = prevMiddlewares }()
+			Use(InstanceIDMiddleware("instance-1", []string{"127.0.0.1"}))
+
+			_, err := DownloadArchive("test-plugin", cdn.URL+"/mirror.zip")
+			So(err, ShouldBeNil)
+			So(received, ShouldHaveLength, 2)
+			So(received[0], ShouldEndWith, "=instance-1")
+			So(received[1], ShouldStartWith, "localhost:")
+			So(received[1], ShouldEndWith, "=")
+		})
+	})
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SyncOptions controls what SyncMirror copies.
+type SyncOptions struct {
+	// AllVersions mirrors every installable version instead of only the latest.
+	AllVersions bool
+	// Job, if set, pauses, resumes or cancels the sync and reports its
+	// progress. Its context is used instead of the one passed to SyncMirror.
+	Job *Job
+	// SigningKey, if set, signs the metadata of every mirrored plugin so
+	// clients configured with SetIndexKeys can verify it.
+	SigningKey ed25519.PrivateKey
+}
+
+// SyncResult lists the plugin versions copied by SyncMirror.
+type SyncResult struct {
+	Synced  []string
+	Skipped []string
+}
+
+// SyncMirror copies the metadata and archives of the given plugins into dir,
+// laid out like the repository so it can be used with WithFixtures or
+// --repoFixtures, and lists them in the repo.json listing of the mirror.
+// Archives are verified and mirrored for the os and arch of this host. Its
+// requests are scheduled with PriorityBackground and logged as OpMirrorSync.
+func SyncMirror(ctx context.Context, repoUrl, dir string, ids []string, opts SyncOptions) (SyncResult, error) {
+	job := opts.Job
+	if job == nil {
+		job = NewJob(ctx)
+	}
+	job.setTotal(len(ids))
+
+	result, err := syncMirror(job, repoUrl, dir, ids, opts)
+	if err != nil {
+		opLog(OpMirrorSync).Errorf("mirror sync failed after %d versions: %v\n", len(result.Synced), err)
+	}
+	job.finish(err)
+	return result, err
+}
+
+func syncMirror(job *Job, repoUrl, dir string, ids []string, opts SyncOptions) (SyncResult, error) {
+	var result SyncResult
+	var listed []m.Plugin
+	ctx := withOperation(WithPriority(job.Context(), PriorityBackground), OpMirrorSync)
+
+	for _, id := range ids {
+		if err := job.checkpoint(id); err != nil {
+			return result, err
+		}
+
+		plugin, err := GetPluginWithContext(ctx, id, repoUrl)
+		if err != nil {
+			return result, fmt.Errorf("failed to get %s: %v", id, err)
+		}
+
+		versions, err := mirrorVersions(plugin, opts)
+		if err != nil {
+			return result, fmt.Errorf("failed to select versions of %s: %v", id, err)
+		}
+
+		mirrored := plugin
+		mirrored.Versions = nil
+		for _, v := range versions {
+			name := id + "@" + v.Version
+			if err := job.checkpoint(name); err != nil {
+				return result, err
+			}
+
+			archive := filepath.Join(dir, id, "versions", v.Version, "download.zip")
+			if _, err := os.Stat(archive); err == nil {
+				result.Skipped = append(result.Skipped, name)
+				opLog(OpMirrorSync).Debugf("%s is already mirrored\n", name)
+				mirrored.Versions = append(mirrored.Versions, mirrorVersion(v))
+				continue
+			}
+
+			url := DownloadURL(resolveRepoURL(repoUrl), id, v.Version)
+			if _, meta, ok := SelectArchive(v); ok && meta.Url != "" {
+				url = meta.Url
+			}
+
+			checksum, err := GetChecksumWithContext(ctx, id, v, url)
+			if err != nil && err != ErrChecksumNotFound {
+				return result, err
+			}
+
+			body, err := DownloadArchiveWithContext(ctx, id, url)
+			if err != nil {
+				return result, fmt.Errorf("failed to download %s: %v", name, err)
+			}
+			if checksum != "" {
+				if err := VerifyChecksum(body, checksum); err != nil {
+					return result, fmt.Errorf("%s: %v", name, err)
+				}
+			}
+
+			if err := writeArchive(archive, body); err != nil {
+				return result, err
+			}
+
+			result.Synced = append(result.Synced, name)
+			opLog(OpMirrorSync).Infof("mirrored %s, %d versions so far\n", name, len(result.Synced))
+			mirrored.Versions = append(mirrored.Versions, mirrorVersion(v))
+		}
+
+		metadata, err := json.MarshalIndent(mirrored, "", "  ")
+		if err != nil {
+			return result, err
+		}
+		if err := writeMirrorIndex(filepath.Join(dir, "repo", id+".json"), metadata, opts.SigningKey); err != nil {
+			return result, err
+		}
+		listed = append(listed, mirrored)
+		job.step()
+	}
+
+	return result, writeMirrorListing(dir, listed, opts.SigningKey)
+}
+
+// writeMirrorIndex writes an index of the mirror, signed when key is set.
+func writeMirrorIndex(index string, body []byte, key ed25519.PrivateKey) error {
+	if err := writeFileAtomic(index, body); err != nil {
+		return err
+	}
+	if key == nil {
+		return nil
+	}
+	return writeIndexSignature(index, key, body)
+}
+
+// writeMirrorListing adds plugins to the repo.json listing of the mirror,
+// served for ListAllPlugins, replacing the entries of plugins synced before.
+func writeMirrorListing(dir string, plugins []m.Plugin, key ed25519.PrivateKey) error {
+	index := filepath.Join(dir, "repo.json")
+
+	var listing m.PluginRepo
+	if body, err := ioutil.ReadFile(index); err == nil {
+		if err := json.Unmarshal(body, &listing); err != nil {
+			return fmt.Errorf("failed to read mirror listing %s: %v", index, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	synced := map[string]bool{}
+	for _, plugin := range plugins {
+		synced[plugin.Id] = true
+	}
+	for _, plugin := range listing.Plugins {
+		if !synced[plugin.Id] {
+			plugins = append(plugins, plugin)
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Id < plugins[j].Id })
+	listing.Plugins = plugins
+
+	body, err := json.MarshalIndent(listing, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeMirrorIndex(index, body, key)
+}
+
+func mirrorVersions(plugin m.Plugin, opts SyncOptions) ([]m.Version, error) {
+	if !opts.AllVersions {
+		v, err := SelectVersion(plugin, PluginRequest{PluginID: plugin.Id})
+		if err != nil {
+			return nil, err
+		}
+		return []m.Version{v}, nil
+	}
+
+	var versions []m.Version
+	for _, v := range plugin.Versions {
+		if !v.Yanked && !isExcluded(plugin.Id, v, PluginRequest{}) && supportsArch(v) {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+// mirrorVersion keeps only the mirrored archive in the arch metadata and
+// drops its url, so installs from the mirror download from the mirror.
+func mirrorVersion(v m.Version) m.Version {
+	key, meta, ok := SelectArchive(v)
+	if !ok {
+		return v
+	}
+
+	meta.Url = ""
+	v.Arch = map[string]m.ArchMeta{key: meta}
+	return v
+}
+
+// ExportBundle writes the mirror directory dir as a zip bundle to w, for
+// carrying plugins into air-gapped environments. When w is a file inside dir
+// it is left out of the bundle.
+func ExportBundle(w io.Writer, dir string) error {
+	var out os.FileInfo
+	if f, ok := w.(*os.File); ok {
+		out, _ = f.Stat()
+	}
+
+	zw := zip.NewWriter(w)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if out != nil && os.SameFile(info, out) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		dst, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(dst, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
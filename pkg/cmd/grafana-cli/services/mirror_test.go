@@ -0,0 +1,176 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestSyncMirror(t *testing.T) {
+	Convey("Mirrored plugins are laid out like the repository", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/mirror-panel":
+				w.Write([]byte(`{"id": "mirror-panel", "versions": [
+					{"version": "1.1.0", "arch": {"any": {"sha256": "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353", "url": "` + "http://" + r.Host + `/cdn/mirror-panel.zip"}}}
+				]}`))
+			case "/cdn/mirror-panel.zip":
+				w.Write([]byte("plugin archive"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "mirror")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		res, err := SyncMirror(context.Background(), server.URL, dir, []string{"mirror-panel"}, SyncOptions{})
+		So(err, ShouldBeNil)
+		So(res.Synced, ShouldResemble, []string{"mirror-panel@1.1.0"})
+
+		archive, err := ioutil.ReadFile(filepath.Join(dir, "mirror-panel", "versions", "1.1.0", "download.zip"))
+		So(err, ShouldBeNil)
+		So(string(archive), ShouldEqual, "plugin archive")
+
+		metadata, err := ioutil.ReadFile(filepath.Join(dir, "repo", "mirror-panel.json"))
+		So(err, ShouldBeNil)
+		So(string(metadata), ShouldNotContainSubstring, "/cdn/")
+
+		Convey("Signed mirrors list their plugins with a signature", func() {
+			public, private, err := ed25519.GenerateKey(nil)
+			So(err, ShouldBeNil)
+			_, err = SyncMirror(context.Background(), server.URL, dir, []string{"mirror-panel"}, SyncOptions{SigningKey: private})
+			So(err, ShouldBeNil)
+
+			prevClient := HttpClient
+			client, err := NewFromFixtures(dir)
+			So(err, ShouldBeNil)
+			HttpClient = *client
+			defer func() { HttpClient = prevClient }()
+			repoUrl := "https://fixtures.example.com/api/plugins"
+			prevRepoURL := RepoURL()
+			setRepoURL(repoUrl)
+			defer setRepoURL(prevRepoURL)
+			prevCache := getCache()
+			SetCache(newMemoryCache())
+			defer SetCache(prevCache)
+			SetIndexKeys([]ed25519.PublicKey{public})
+			defer SetIndexKeys(nil)
+
+			listing, err := ListAllPlugins(repoUrl)
+			So(err, ShouldBeNil)
+			So(listing.Plugins, ShouldHaveLength, 1)
+			So(listing.Plugins[0].Id, ShouldEqual, "mirror-panel")
+			So(listing.Plugins[0].Versions[0].Version, ShouldEqual, "1.1.0")
+		})
+
+		Convey("Mirror syncs are scheduled in the background", func() {
+			prevMiddlewares := middlewares
+			defer func() { middlewares = prevMiddlewares }()
+			var priorities []Priority
+			Use(func(next RepoHandler) RepoHandler {
+				return func(req *RepoRequest) (*http.Response, error) {
+					priorities = append(priorities, priorityFromContext(req.Request.Context()))
+					return next(req)
+				}
+			})
+
+			other, err := ioutil.TempDir("", "mirror")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(other)
+
+			_, err = SyncMirror(context.Background(), server.URL, other, []string{"mirror-panel"}, SyncOptions{})
+			So(err, ShouldBeNil)
+			So(priorities, ShouldNotBeEmpty)
+			for _, p := range priorities {
+				So(p, ShouldEqual, PriorityBackground)
+			}
+		})
+
+		Convey("Mirror sync requests are logged as the mirror sync", func() {
+			recorder := &recordingLogger{}
+			prev := log
+			log = recorder
+			defer func() { log = prev }()
+			defer ResetOperationLogging()
+			SetOperationLogging(OpDownload, OperationLogging{Level: LogError})
+			SetOperationLogging(OpGetPlugin, OperationLogging{Level: LogError})
+
+			other, err := ioutil.TempDir("", "mirror")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(other)
+
+			_, err = SyncMirror(context.Background(), server.URL, other, []string{"mirror-panel"}, SyncOptions{})
+			So(err, ShouldBeNil)
+			So(strings.Join(recorder.lines, "\n"), ShouldContainSubstring, "repository request op=download")
+		})
+
+		Convey("Synced archives are skipped and the mirror exports as a bundle", func() {
+			res, err := SyncMirror(context.Background(), server.URL, dir, []string{"mirror-panel"}, SyncOptions{})
+			So(err, ShouldBeNil)
+			So(res.Skipped, ShouldResemble, []string{"mirror-panel@1.1.0"})
+
+			var bundle bytes.Buffer
+			So(ExportBundle(&bundle, dir), ShouldBeNil)
+			So(bundle.Len(), ShouldBeGreaterThan, 0)
+
+			Convey("leaving out a bundle written into the mirror", func() {
+				f, err := os.Create(filepath.Join(dir, "bundle.zip"))
+				So(err, ShouldBeNil)
+				So(ExportBundle(f, dir), ShouldBeNil)
+				So(f.Close(), ShouldBeNil)
+
+				zr, err := zip.OpenReader(f.Name())
+				So(err, ShouldBeNil)
+				defer zr.Close()
+				So(len(zr.File), ShouldBeGreaterThan, 0)
+				for _, file := range zr.File {
+					So(file.Name, ShouldNotEqual, "bundle.zip")
+				}
+			})
+		})
+	})
+	Convey("Cancelling a sync stops the download in flight", t, func() {
+		downloading := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/slow-panel":
+				w.Write([]byte(`{"id": "slow-panel", "versions": [
+					{"version": "1.0.0", "arch": {"any": {"sha256": "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353", "url": "` + "http://" + r.Host + `/cdn/slow-panel.zip"}}}
+				]}`))
+			case "/cdn/slow-panel.zip":
+				close(downloading)
+				<-r.Context().Done()
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		dir, err := ioutil.TempDir("", "mirror")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-downloading
+			cancel()
+		}()
+		_, err = SyncMirror(ctx, server.URL, dir, []string{"slow-panel"}, SyncOptions{})
+		So(err, ShouldNotBeNil)
+	})
+}
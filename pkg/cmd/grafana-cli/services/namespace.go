@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var ErrUnknownNamespace = errors.New("unknown repository namespace")
+
+// namespaces map a name to the repository plugins are resolved from when
+// referenced as "<namespace>/<plugin id>", so federated repositories
+// publishing the same plugin id can be told apart.
+var namespaces map[string]string
+
+// SetNamespaces replaces the repository namespaces, keyed by name.
+func SetNamespaces(ns map[string]string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	namespaces = ns
+}
+
+// ParseNamespaces parses a comma separated list of name=repository url pairs.
+func ParseNamespaces(value string) (map[string]string, error) {
+	ns := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(parts[0], "/") {
+			return nil, fmt.Errorf("invalid namespace %q, expected <name>=<repository url>", pair)
+		}
+		ns[parts[0]] = strings.TrimSuffix(parts[1], "/")
+	}
+	return ns, nil
+}
+
+// SplitPluginRef splits "<namespace>/<plugin id>" into its parts. Plugin
+// references without a namespace are returned with an empty namespace.
+func SplitPluginRef(ref string) (namespace string, pluginId string) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 {
+		return "", ref
+	}
+	return parts[0], parts[1]
+}
+
+// namespaceRepo returns the repository of namespace, or repoUrl when no
+// namespace is given.
+func namespaceRepo(namespace, repoUrl string) (string, error) {
+	if namespace == "" {
+		return repoUrl, nil
+	}
+
+	stateMtx.RLock()
+	repo, ok := namespaces[namespace]
+	stateMtx.RUnlock()
+
+	if !ok {
+		return "", xerrors.Errorf("%s: %w", namespace, ErrUnknownNamespace)
+	}
+	return repo, nil
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of a webhook body.
+const SignatureHeader = "X-Grafana-Signature"
+
+// VersionNotification announces a newly published plugin version.
+type VersionNotification struct {
+	PluginID    string    `json:"pluginId"`
+	Version     string    `json:"version"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+// NotificationReceiver is a webhook endpoint the repository posts version
+// notifications to, either a single notification or a list of them. Cached
+// metadata of the notified plugins is invalidated before OnVersion is called.
+type NotificationReceiver struct {
+	RepoURL string
+	// Secret is the HMAC key webhook bodies have to be signed with, without
+	// it every request is refused.
+	Secret    string
+	OnVersion func(n VersionNotification)
+}
+
+func (rcv *NotificationReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if rcv.Secret == "" {
+		http.Error(w, "webhook secret not configured", http.StatusForbidden)
+		return
+	}
+
+	if !validSignature(rcv.Secret, body, r.Header.Get(SignatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var notifications []VersionNotification
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		err = json.Unmarshal(body, &notifications)
+	} else {
+		var n VersionNotification
+		err = json.Unmarshal(body, &n)
+		notifications = append(notifications, n)
+	}
+	if err != nil {
+		http.Error(w, "invalid notification", http.StatusBadRequest)
+		return
+	}
+
+	for _, n := range notifications {
+		notify(rcv.RepoURL, n, rcv.OnVersion)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validSignature(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func notify(repoUrl string, n VersionNotification, fn func(VersionNotification)) {
+	if n.PluginID == "" {
+		return
+	}
+
+	log.Debugf("new version of %v published: %v\n", n.PluginID, n.Version)
+	invalidateMetadata(n.PluginID, repoUrl)
+	if fn != nil {
+		fn(n)
+	}
+}
+
+// notificationPage is a response of the repository notifications endpoint.
+type notificationPage struct {
+	Cursor        string                `json:"cursor"`
+	Notifications []VersionNotification `json:"notifications"`
+}
+
+// LongPollWait is how long the repository may hold a notifications request.
+var LongPollWait = 30 * time.Second
+
+// MinPollInterval is the least time between the start of two polls, so a
+// repository answering right away, with a page or no content, is not polled
+// in a tight loop.
+var MinPollInterval = 5 * time.Second
+
+// WatchNotifications long-polls the repository notifications endpoint until
+// ctx is cancelled, invalidating cached metadata and calling fn for every
+// new version. Polls start at least MinPollInterval apart, failed polls are
+// retried with backoff.
+func WatchNotifications(ctx context.Context, repoUrl string, fn func(n VersionNotification)) error {
+	var cursor string
+	backoff := time.Second
+
+	for {
+		started := getClock().Now()
+		page, err := pollNotifications(ctx, repoUrl, cursor)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			log.Debugf("polling notifications failed, retrying in %v: %v\n", backoff, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-getClock().After(backoff):
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		for _, n := range page.Notifications {
+			notify(repoUrl, n, fn)
+		}
+		if page.Cursor != "" {
+			cursor = page.Cursor
+		}
+
+		if wait := MinPollInterval - getClock().Now().Sub(started); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-getClock().After(wait):
+			}
+		}
+	}
+}
+
+func pollNotifications(ctx context.Context, repoUrl, cursor string) (notificationPage, error) {
+	u, err := url.Parse(strings.TrimSuffix(resolveRepoURL(repoUrl), "/") + "/notifications")
+	if err != nil {
+		return notificationPage{}, err
+	}
+
+	q := u.Query()
+	q.Set("wait", LongPollWait.String())
+	if cursor != "" {
+		q.Set("since", cursor)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := newRequest(u.String())
+	if err != nil {
+		return notificationPage{}, err
+	}
+
+	// the download client has no overall timeout, the request is held open
+	// by the repository for up to LongPollWait
+	ctx, cancel := context.WithTimeout(ctx, LongPollWait+10*time.Second)
+	defer cancel()
+
+	res, err := do(&DownloadClient, &RepoRequest{Op: OpNotifications, Request: req.WithContext(ctx)})
+	if err == nil && res.StatusCode == http.StatusNoContent {
+		res.Body.Close()
+		return notificationPage{Cursor: cursor}, nil
+	}
+
+	body, err := readResponse(res, err)
+	if err != nil {
+		return notificationPage{}, repoError(OpNotifications, "", u.String(), err)
+	}
+
+	var page notificationPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return notificationPage{}, repoError(OpNotifications, "", u.String(), err)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNotificationReceiver(t *testing.T) {
+	Convey("Webhook notifications invalidate cached metadata", t, func() {
+		var notified []VersionNotification
+		rcv := &NotificationReceiver{RepoURL: "https://repo.example.com", OnVersion: func(n VersionNotification) {
+			notified = append(notified, n)
+		}}
+
+		cache.Set(metadataCacheKey(rcv.RepoURL, "webhook-plugin"), []byte(`{"id": "webhook-plugin"}`), MetadataCacheTTL)
+
+		post := func(body, signature string) int {
+			req := httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(body))
+			req.Header.Set(SignatureHeader, signature)
+			w := httptest.NewRecorder()
+			rcv.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		body := `[{"pluginId": "webhook-plugin", "version": "2.0.0"}]`
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(body))
+
+		So(post(body, "sha256="+hex.EncodeToString(mac.Sum(nil))), ShouldEqual, http.StatusForbidden)
+		So(notified, ShouldBeEmpty)
+
+		rcv.Secret = "secret"
+		So(post(body, "sha256=00"), ShouldEqual, http.StatusUnauthorized)
+		So(notified, ShouldBeEmpty)
+
+		So(post(body, "sha256="+hex.EncodeToString(mac.Sum(nil))), ShouldEqual, http.StatusNoContent)
+		So(notified, ShouldResemble, []VersionNotification{{PluginID: "webhook-plugin", Version: "2.0.0"}})
+
+		_, _, ok := getCachedPlugin(rcv.RepoURL, "webhook-plugin")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestWatchNotifications(t *testing.T) {
+	Convey("Long-polling passes the cursor of the previous page", t, func() {
+		prevInterval := MinPollInterval
+		MinPollInterval = 50 * time.Millisecond
+		defer func() { MinPollInterval = prevInterval }()
+
+		var polls int32
+		var started []time.Time
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started = append(started, time.Now())
+			switch atomic.AddInt32(&polls, 1) {
+			case 1:
+				w.Write([]byte(`{"cursor": "c1", "notifications": [{"pluginId": "poll-plugin", "version": "1.1.0"}]}`))
+			case 2:
+				if r.URL.Query().Get("since") != "c1" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(`{"cursor": "c2", "notifications": [{"pluginId": "poll-plugin", "version": "1.2.0"}]}`))
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var versions []string
+		err := WatchNotifications(ctx, server.URL, func(n VersionNotification) {
+			versions = append(versions, n.Version)
+			if len(versions) == 2 {
+				cancel()
+			}
+		})
+
+		So(err, ShouldEqual, context.Canceled)
+		So(versions, ShouldResemble, []string{"1.1.0", "1.2.0"})
+		So(started, ShouldHaveLength, 2)
+		So(started[1].Sub(started[0]), ShouldBeGreaterThanOrEqualTo, MinPollInterval)
+	})
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// CredentialsRefresher is implemented by providers whose credentials can go
+// stale before they expire, e.g. revoked OAuth2 tokens. Refresh is called
+// once when the repository rejects a request with 401.
+type CredentialsRefresher interface {
+	Refresh(url string)
+}
+
+// ClientCredentials authenticates requests below RepoURL with tokens
+// obtained through the OAuth2 client credentials grant, for mirrors behind
+// an OAuth2 protected gateway. Tokens are fetched on first use and
+// refreshed when they expire.
+type ClientCredentials struct {
+	RepoURL string
+	Config  clientcredentials.Config
+
+	mtx    sync.Mutex
+	source oauth2.TokenSource
+}
+
+func NewClientCredentials(repoUrl, tokenURL, clientID, clientSecret string, scopes ...string) *ClientCredentials {
+	return &ClientCredentials{
+		RepoURL: strings.TrimSuffix(repoUrl, "/"),
+		Config: clientcredentials.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			TokenURL:     tokenURL,
+			Scopes:       scopes,
+		},
+	}
+}
+
+func (c *ClientCredentials) Credentials(url string) (Credentials, bool) {
+	if url != c.RepoURL && !strings.HasPrefix(url, c.RepoURL+"/") {
+		return Credentials{}, false
+	}
+
+	c.mtx.Lock()
+	if c.source == nil {
+		c.source = c.newTokenSource()
+	}
+	source := c.source
+	c.mtx.Unlock()
+
+	token, err := source.Token()
+	if err != nil {
+		log.Warnf("failed to fetch repository access token: %v\n", err)
+		return Credentials{}, false
+	}
+
+	return Credentials{Token: token.AccessToken}, true
+}
+
+// Refresh drops the cached token so the next request fetches a new one.
+func (c *ClientCredentials) Refresh(url string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.source = nil
+}
+
+func (c *ClientCredentials) newTokenSource() oauth2.TokenSource {
+	// token requests go through the same TLS and proxy settings as the repository
+	client := HttpClient
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &client)
+
+	return c.Config.TokenSource(ctx)
+}
+
+// doAuthenticated sends req with credentials, retrying once with refreshed
+// credentials when the repository responds with 401.
+func doAuthenticated(client *http.Client, req *http.Request) (*http.Response, error) {
+	authenticated := authenticate(req)
+
+	res, err := client.Do(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized || !authenticated {
+		return res, err
+	}
+
+	refresher, ok := getCredentials().(CredentialsRefresher)
+	if !ok {
+		return res, err
+	}
+
+	log.Debugf("repository rejected credentials for %v, retrying with refreshed credentials\n", req.URL)
+	res.Body.Close()
+	refresher.Refresh(req.URL.String())
+
+	req.Header.Del("Authorization")
+	authenticate(req)
+	return client.Do(req)
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientCredentials(t *testing.T) {
+	Convey("Revoked tokens are refreshed once on 401", t, func() {
+		var issued int32
+		tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&issued, 1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600}`, n)
+		}))
+		defer tokens.Close()
+
+		repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"id": "oauth-plugin"}`))
+		}))
+		defer repo.Close()
+
+		defer func() { credentials = credentialStore }()
+		credentials = NewClientCredentials(repo.URL, tokens.URL, "grafana", "secret")
+
+		body, err := sendRequest(context.Background(), OpGetPlugin, "oauth-plugin", repo.URL, "repo", "oauth-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, `{"id": "oauth-plugin"}`)
+		So(atomic.LoadInt32(&issued), ShouldEqual, 2)
+
+		Convey("Valid tokens are reused", func() {
+			_, err := sendRequest(context.Background(), OpGetPlugin, "oauth-plugin", repo.URL, "repo", "oauth-plugin")
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&issued), ShouldEqual, 2)
+		})
+	})
+}
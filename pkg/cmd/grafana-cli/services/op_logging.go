@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpMirrorSync is the operation of the per plugin version lines of SyncMirror
+// and of the repository requests it sends.
+const OpMirrorSync Operation = "mirror-sync"
+
+// loggedOperations are the operations log lines can be configured for.
+var loggedOperations = []Operation{OpListPlugins, OpGetPlugin, OpChecksum, OpDownload, OpNotifications, OpCapabilities, OpMirrorSync}
+
+// LogLevel is the severity of a log line.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = map[string]LogLevel{"debug": LogDebug, "info": LogInfo, "warn": LogWarn, "error": LogError}
+
+// OperationLogging controls the log lines of an operation, so bulk operations
+// like mirror syncs stay observable without drowning the logs.
+type OperationLogging struct {
+	// Level is the least severe level logged, errors are always logged.
+	Level LogLevel
+	// SampleEvery logs only every Nth debug and info line, 0 logs all of
+	// them. Warnings and errors are never sampled.
+	SampleEvery int
+}
+
+type opLogState struct {
+	OperationLogging
+	lines int
+}
+
+var opLogging = struct {
+	sync.Mutex
+	byOp map[Operation]*opLogState
+}{byOp: map[Operation]*opLogState{}}
+
+// SetOperationLogging replaces how the log lines of op are filtered.
+func SetOperationLogging(op Operation, cfg OperationLogging) {
+	opLogging.Lock()
+	defer opLogging.Unlock()
+
+	opLogging.byOp[op] = &opLogState{OperationLogging: cfg}
+}
+
+// ResetOperationLogging logs every line of every operation again.
+func ResetOperationLogging() {
+	opLogging.Lock()
+	defer opLogging.Unlock()
+
+	opLogging.byOp = map[Operation]*opLogState{}
+}
+
+// ParseOperationLogging parses comma separated "<operation>=<level>" levels,
+// e.g. "download=warn", and "<operation>=<n>" sample rates, e.g.
+// "mirror-sync=100". Unknown operations are rejected.
+func ParseOperationLogging(levels, samples string) (map[Operation]OperationLogging, error) {
+	result := map[Operation]OperationLogging{}
+
+	for _, entry := range splitEntries(levels) {
+		parts := strings.SplitN(entry, "=", 2)
+		level, ok := logLevelNames[strings.ToLower(strings.TrimSpace(parts[len(parts)-1]))]
+		if len(parts) != 2 || !ok {
+			return nil, fmt.Errorf("invalid operation log level %q, expected <operation>=debug|info|warn|error", entry)
+		}
+		op, err := parseLoggedOperation(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		cfg := result[op]
+		cfg.Level = level
+		result[op] = cfg
+	}
+
+	for _, entry := range splitEntries(samples) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid operation log sampling %q, expected <operation>=<n>", entry)
+		}
+		every, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || every < 0 {
+			return nil, fmt.Errorf("invalid operation log sampling %q, expected <operation>=<n>", entry)
+		}
+		op, err := parseLoggedOperation(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		cfg := result[op]
+		cfg.SampleEvery = every
+		result[op] = cfg
+	}
+
+	return result, nil
+}
+
+func parseLoggedOperation(name string) (Operation, error) {
+	op := Operation(strings.TrimSpace(name))
+	names := make([]string, 0, len(loggedOperations))
+	for _, known := range loggedOperations {
+		if op == known {
+			return op, nil
+		}
+		names = append(names, string(known))
+	}
+	return "", fmt.Errorf("unknown operation %q, expected one of %s", op, strings.Join(names, ", "))
+}
+
+func splitEntries(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// opLog returns the logger of the lines of op.
+func opLog(op Operation) Logger {
+	return operationLogger{op: op}
+}
+
+type operationKey struct{}
+
+// withOperation returns a context whose repository requests are logged as
+// lines of op, the bulk operation they are sent for.
+func withOperation(ctx context.Context, op Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// requestLog returns the logger of the lines of req, those of the operation
+// its context was tagged with by withOperation, or else of req.Op.
+func requestLog(req *RepoRequest) Logger {
+	if op, ok := req.Request.Context().Value(operationKey{}).(Operation); ok {
+		return opLog(op)
+	}
+	return opLog(req.Op)
+}
+
+type operationLogger struct {
+	op Operation
+}
+
+// allowed reports whether a line of level is logged, counting it for sampling.
+func (l operationLogger) allowed(level LogLevel) bool {
+	if level == LogError {
+		return true
+	}
+
+	opLogging.Lock()
+	defer opLogging.Unlock()
+
+	state, ok := opLogging.byOp[l.op]
+	if !ok {
+		return true
+	}
+	if level < state.Level {
+		return false
+	}
+	if level >= LogWarn || state.SampleEvery <= 1 {
+		return true
+	}
+
+	state.lines++
+	return state.lines%state.SampleEvery == 1
+}
+
+func (l operationLogger) Debugf(format string, args ...interface{}) {
+	if l.allowed(LogDebug) {
+		log.Debugf(format, args...)
+	}
+}
+
+func (l operationLogger) Infof(format string, args ...interface{}) {
+	if l.allowed(LogInfo) {
+		log.Infof(format, args...)
+	}
+}
+
+func (l operationLogger) Warnf(format string, args ...interface{}) {
+	if l.allowed(LogWarn) {
+		log.Warnf(format, args...)
+	}
+}
+
+func (l operationLogger) Errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+}
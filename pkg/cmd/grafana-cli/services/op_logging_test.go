@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) { l.record("debug", format, args) }
+func (l *recordingLogger) Infof(format string, args ...interface{})  { l.record("info", format, args) }
+func (l *recordingLogger) Warnf(format string, args ...interface{})  { l.record("warn", format, args) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.record("error", format, args) }
+
+func (l *recordingLogger) record(level, format string, args []interface{}) {
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func TestOperationLogging(t *testing.T) {
+	Convey("Log lines are filtered per operation", t, func() {
+		recorder := &recordingLogger{}
+		prev := log
+		log = recorder
+		defer func() { log = prev }()
+		defer ResetOperationLogging()
+
+		Convey("by level, always logging errors", func() {
+			SetOperationLogging(OpDownload, OperationLogging{Level: LogError})
+
+			opLog(OpDownload).Infof("downloading")
+			opLog(OpDownload).Warnf("slow download")
+			opLog(OpDownload).Errorf("download failed")
+			opLog(OpChecksum).Debugf("looking for checksum")
+			So(recorder.lines, ShouldResemble, []string{"error download failed", "debug looking for checksum"})
+		})
+
+		Convey("by sampling debug and info lines", func() {
+			SetOperationLogging(OpMirrorSync, OperationLogging{SampleEvery: 3})
+
+			for i := 1; i <= 7; i++ {
+				opLog(OpMirrorSync).Infof("mirrored %d", i)
+			}
+			opLog(OpMirrorSync).Warnf("skipped 8")
+			So(recorder.lines, ShouldResemble, []string{"info mirrored 1", "info mirrored 4", "info mirrored 7", "warn skipped 8"})
+		})
+	})
+
+	Convey("Operation logging is parsed", t, func() {
+		logging, err := ParseOperationLogging("download=warn, mirror-sync=info", "mirror-sync=100")
+		So(err, ShouldBeNil)
+		So(logging, ShouldResemble, map[Operation]OperationLogging{
+			OpDownload:   {Level: LogWarn},
+			OpMirrorSync: {Level: LogInfo, SampleEvery: 100},
+		})
+
+		_, err = ParseOperationLogging("download=loud", "")
+		So(err, ShouldNotBeNil)
+		_, err = ParseOperationLogging("", "mirror-sync=often")
+		So(err, ShouldNotBeNil)
+		_, err = ParseOperationLogging("mirror_sync=info", "")
+		So(err, ShouldNotBeNil)
+		_, err = ParseOperationLogging("", "mirror_sync=100")
+		So(err, ShouldNotBeNil)
+	})
+}
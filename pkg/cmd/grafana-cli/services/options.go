@@ -0,0 +1,104 @@
+package services
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+)
+
+// DefaultRepoURL is the plugin repository used when no other is configured.
+const DefaultRepoURL = "https://grafana.com/api/plugins"
+
+// Logger is the logging interface used by the services package.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type cliLogger struct{}
+
+func (cliLogger) Debugf(format string, args ...interface{}) { logger.Debugf(format, args...) }
+func (cliLogger) Infof(format string, args ...interface{})  { logger.Infof(format, args...) }
+func (cliLogger) Warnf(format string, args ...interface{})  { logger.Warnf(format, args...) }
+func (cliLogger) Errorf(format string, args ...interface{}) { logger.Errorf(format, args...) }
+
+var (
+	log     Logger = cliLogger{}
+	repoURL        = DefaultRepoURL
+)
+
+// stateMtx guards the configuration replaced by Init, Use and the Set*
+// functions, so they may be called while other goroutines are installing or
+// resolving plugins. Requests already in flight keep the configuration they
+// started with.
+var stateMtx sync.RWMutex
+
+type options struct {
+	repoURL     string
+	logger      Logger
+	client      *http.Client
+	tlsConfig   *tls.Config
+	credentials CredentialsProvider
+	hostTokens  map[string]string
+
+	downloadTransport func(tlsConfig *tls.Config) http.RoundTripper
+	tlsPolicy         TLSPolicy
+	strictDecoding    bool
+}
+
+// Option configures the services package on Init.
+type Option func(*options)
+
+// WithRepoURL sets the repository used when callers pass an empty repo url.
+func WithRepoURL(url string) Option {
+	return func(o *options) { o.repoURL = url }
+}
+
+// WithLogger replaces the grafana-cli logger.
+func WithLogger(l Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithClient uses client for both repository requests and archive downloads.
+func WithClient(client *http.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithTLSConfig sets the TLS configuration of the default transport. It has
+// no effect when combined with WithClient.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *options) { o.tlsConfig = cfg }
+}
+
+// RepoURL returns the configured default repository url.
+func RepoURL() string {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return repoURL
+}
+
+func setRepoURL(url string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	repoURL = url
+}
+
+func resolveRepoURL(url string) string {
+	if url == "" {
+		return RepoURL()
+	}
+	return url
+}
+
+// repoKey identifies a repository in the state kept per repository, like its
+// health and API version, whatever form its url was given in.
+func repoKey(repoUrl string) string {
+	return strings.TrimSuffix(resolveRepoURL(repoUrl), "/")
+}
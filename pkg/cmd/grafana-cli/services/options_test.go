@@ -0,0 +1,80 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOptions(t *testing.T) {
+	Convey("Init is configured with options", t, func() {
+		defer Init("", false)
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"plugins": [{"id": "option-panel", "versions": [{"version": "1.0.0"}]}]}`))
+		})
+
+		Convey("defaulting to grafana.com", func() {
+			Init("", false)
+			So(RepoURL(), ShouldEqual, DefaultRepoURL)
+		})
+
+		Convey("sending requests for an empty repo url to WithRepoURL through WithClient", func() {
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			transport := &countingTransport{}
+			Init("", false, WithRepoURL(server.URL), WithClient(&http.Client{Transport: transport}))
+			So(RepoURL(), ShouldEqual, server.URL)
+
+			repo, err := ListAllPlugins("")
+			So(err, ShouldBeNil)
+			So(repo.Plugins[0].Id, ShouldEqual, "option-panel")
+			So(transport.requests, ShouldEqual, 1)
+			So(DownloadClient.Transport, ShouldEqual, transport)
+		})
+
+		Convey("logging to WithLogger", func() {
+			server := httptest.NewServer(handler)
+			server.Close()
+
+			recorder := &recordingLogger{}
+			Init("", false, WithRepoURL(server.URL), WithLogger(recorder))
+
+			_, err := ListAllPlugins("")
+			So(err, ShouldNotBeNil)
+			So(strings.Join(recorder.lines, "\n"), ShouldContainSubstring, "Failed to send request")
+		})
+
+		Convey("trusting the certificates of WithTLSConfig", func() {
+			server := httptest.NewTLSServer(handler)
+			defer server.Close()
+
+			Init("", false, WithRepoURL(server.URL))
+			_, err := ListAllPlugins("")
+			So(err, ShouldNotBeNil)
+
+			roots := x509.NewCertPool()
+			roots.AddCert(server.Certificate())
+			Init("", false, WithRepoURL(server.URL), WithTLSConfig(&tls.Config{RootCAs: roots}))
+			_, err = ListAllPlugins("")
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrNoArchiveStore = errors.New("no archive store configured")
+
+// ArchiveRequest is an archive to download and verify in a pipeline.
+type ArchiveRequest struct {
+	PluginID string
+	Version  string
+	URL      string
+	// Checksum is verified when set.
+	Checksum string
+}
+
+// FetchedArchive is a downloaded archive that has not been verified yet.
+type FetchedArchive struct {
+	ArchiveRequest
+	Body []byte
+}
+
+// VerifiedArchive is an archive that passed Verify. As outcome of a
+// DownloadPipeline, Err is set when the download or the verification
+// failed, Body is only set on success.
+type VerifiedArchive struct {
+	ArchiveRequest
+	Body []byte
+	Err  error
+}
+
+// PersistedArchive is a verified archive added to an archive store.
+type PersistedArchive struct {
+	VerifiedArchive
+	// Digest is the sha256 digest the archive is stored under, Path the
+	// file of the plugin version, encrypted if the store has a key.
+	Digest string
+	Path   string
+}
+
+// Fetch is the download stage: it downloads the archive of req.
+func Fetch(ctx context.Context, req ArchiveRequest) (FetchedArchive, error) {
+	if err := ctx.Err(); err != nil {
+		return FetchedArchive{}, err
+	}
+
+	body, err := DownloadArchiveWithContext(ctx, req.PluginID, req.URL)
+	if err != nil {
+		return FetchedArchive{}, err
+	}
+	return FetchedArchive{ArchiveRequest: req, Body: body}, nil
+}
+
+// Verify is the verification stage: it checks the fetched archive against
+// its checksum, archives without one pass unverified.
+func Verify(archive FetchedArchive) (VerifiedArchive, error) {
+	if archive.Checksum != "" {
+		ReportProgress(archive.PluginID, StageVerifying)
+		if err := VerifyChecksum(archive.Body, archive.Checksum); err != nil {
+			return VerifiedArchive{}, err
+		}
+	}
+	return VerifiedArchive{ArchiveRequest: archive.ArchiveRequest, Body: archive.Body}, nil
+}
+
+// Persist is the storage stage: it adds the verified archive to store, or to
+// the configured archive store when store is nil.
+func Persist(store *ArchiveStore, archive VerifiedArchive) (PersistedArchive, error) {
+	if archive.Err != nil {
+		return PersistedArchive{}, archive.Err
+	}
+	if store == nil {
+		store = getArchiveStore()
+	}
+	if store == nil {
+		return PersistedArchive{}, ErrNoArchiveStore
+	}
+
+	digest, err := store.Put(archive.PluginID, archive.Version, archive.Body)
+	if err != nil {
+		return PersistedArchive{}, err
+	}
+	return PersistedArchive{VerifiedArchive: archive, Digest: digest, Path: store.versionPath(archive.PluginID, archive.Version)}, nil
+}
+
+// DownloadPipeline runs the Fetch and Verify stages with bounded workers each, so archives already downloaded are verified while
+// others are still being fetched.
+type DownloadPipeline struct {
+	// DownloadWorkers and VerifyWorkers default to 1.
+	DownloadWorkers int
+	VerifyWorkers   int
+}
+
+// Run starts the pipeline and returns its results in the order they complete.
+// The channel is closed once every request is done. Cancelling ctx aborts
+// downloads in flight, the remaining requests complete with ctx.Err().
+// Results are buffered per verify worker only, the pipeline waits for them to
+// be received so no more archives than workers are held in memory at once.
+func (p DownloadPipeline) Run(ctx context.Context, reqs []ArchiveRequest) <-chan VerifiedArchive {
+	downloaded := make(chan fetchResult)
+	results := make(chan VerifiedArchive, workers(p.VerifyWorkers))
+
+	queue := make(chan ArchiveRequest, len(reqs))
+	for _, req := range reqs {
+		queue <- req
+	}
+	close(queue)
+
+	var downloads sync.WaitGroup
+	for i := 0; i < workers(p.DownloadWorkers); i++ {
+		downloads.Add(1)
+		go func() {
+			defer downloads.Done()
+			for req := range queue {
+				archive, err := Fetch(ctx, req)
+				archive.ArchiveRequest = req
+				downloaded <- fetchResult{archive, err}
+			}
+		}()
+	}
+	go func() {
+		downloads.Wait()
+		close(downloaded)
+	}()
+
+	var verifications sync.WaitGroup
+	for i := 0; i < workers(p.VerifyWorkers); i++ {
+		verifications.Add(1)
+		go func() {
+			defer verifications.Done()
+			for fetched := range downloaded {
+				res := VerifiedArchive{ArchiveRequest: fetched.archive.ArchiveRequest, Err: fetched.err}
+				if res.Err == nil {
+					res, res.Err = Verify(fetched.archive)
+					res.ArchiveRequest = fetched.archive.ArchiveRequest
+				}
+				results <- res
+			}
+		}()
+	}
+	go func() {
+		verifications.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+type fetchResult struct {
+	archive FetchedArchive
+	err     error
+}
+
+func workers(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestDownloadPipeline(t *testing.T) {
+	var (
+		mtx               sync.Mutex
+		active, maxActive int
+	)
+	SetFetcher(FetcherFunc(func(ctx context.Context, pluginId, url string) ([]byte, error) {
+		mtx.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mtx.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mtx.Lock()
+		active--
+		mtx.Unlock()
+		return []byte("archive " + pluginId), nil
+	}))
+	defer SetFetcher(nil)
+
+	checksum := func(pluginId string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte("archive "+pluginId)))
+	}
+
+	Convey("Downloads and verifies every archive with bounded workers", t, func() {
+		var reqs []ArchiveRequest
+		for i := 0; i < 6; i++ {
+			id := fmt.Sprintf("plugin-%d", i)
+			reqs = append(reqs, ArchiveRequest{PluginID: id, Version: "1.0.0", URL: "https://example.com/" + id, Checksum: checksum(id)})
+		}
+		reqs[3].Checksum = checksum("other")
+
+		results := map[string]VerifiedArchive{}
+		pipeline := (DownloadPipeline{DownloadWorkers: 2, VerifyWorkers: 2}).Run(context.Background(), reqs)
+		// results are not all held until they are received
+		So(cap(pipeline), ShouldEqual, 2)
+		for res := range pipeline {
+			results[res.PluginID] = res
+		}
+
+		So(results, ShouldHaveLength, 6)
+		So(maxActive, ShouldBeLessThanOrEqualTo, 2)
+		So(string(results["plugin-0"].Body), ShouldEqual, "archive plugin-0")
+		So(results["plugin-0"].Err, ShouldBeNil)
+		So(xerrors.Is(results["plugin-3"].Err, ErrChecksumMismatch), ShouldBeTrue)
+		So(results["plugin-3"].Body, ShouldBeNil)
+	})
+
+	Convey("Stages can be called one by one", t, func() {
+		dir, err := ioutil.TempDir("", "archives")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		req := ArchiveRequest{PluginID: "plugin-0", Version: "1.0.0", URL: "https://example.com/plugin-0", Checksum: checksum("plugin-0")}
+		fetched, err := Fetch(context.Background(), req)
+		So(err, ShouldBeNil)
+		So(string(fetched.Body), ShouldEqual, "archive plugin-0")
+
+		verified, err := Verify(fetched)
+		So(err, ShouldBeNil)
+
+		persisted, err := Persist(&ArchiveStore{Dir: dir}, verified)
+		So(err, ShouldBeNil)
+		So(persisted.Digest, ShouldEqual, checksum("plugin-0"))
+		stored, err := ioutil.ReadFile(persisted.Path)
+		So(err, ShouldBeNil)
+		So(string(stored), ShouldEqual, "archive plugin-0")
+
+		fetched.Body = []byte("tampered")
+		_, err = Verify(fetched)
+		So(xerrors.Is(err, ErrChecksumMismatch), ShouldBeTrue)
+
+		_, err = Persist(nil, verified)
+		So(err, ShouldEqual, ErrNoArchiveStore)
+	})
+
+	Convey("Cancelled pipelines complete the remaining requests with the context error", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		reqs := []ArchiveRequest{{PluginID: "plugin-0", URL: "https://example.com/plugin-0"}}
+		for res := range (DownloadPipeline{}).Run(ctx, reqs) {
+			So(res.Err, ShouldEqual, context.Canceled)
+		}
+	})
+}
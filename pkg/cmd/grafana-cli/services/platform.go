@@ -0,0 +1,116 @@
+package services
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// platform describes the host beyond GOOS and GOARCH, as plugins may publish
+// backend binaries built for a specific libc or ARM revision.
+type platform struct {
+	goos string
+	arch string
+	arm  string
+	musl bool
+}
+
+var (
+	detectOnce   sync.Once
+	detectedArch []string
+	archOverride []string
+)
+
+// SetArchOverride replaces the detected arch keys with keys, most specific
+// first, e.g. "linux-armv7,linux-arm". Nil restores detection.
+func SetArchOverride(keys []string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	archOverride = keys
+}
+
+// archKeys returns the arch keys archives are looked up under, most specific
+// first. The generic key like "linux-arm" always comes last, so plugins not
+// publishing variant archives keep resolving as before.
+func archKeys() []string {
+	stateMtx.RLock()
+	override := archOverride
+	stateMtx.RUnlock()
+	if len(override) > 0 {
+		return override
+	}
+
+	detectOnce.Do(func() {
+		detectedArch = detectPlatform().keys()
+		log.Debugf("plugin arch keys: %v\n", detectedArch)
+	})
+	return detectedArch
+}
+
+func osAndArchString() string {
+	return archKeys()[0]
+}
+
+func (p platform) keys() []string {
+	generic := p.goos + "-" + p.arch
+
+	var archs []string
+	if p.arm != "" {
+		archs = append(archs, p.goos+"-arm"+p.arm)
+	}
+	archs = append(archs, generic)
+
+	if !p.musl {
+		return archs
+	}
+
+	// binaries linked against glibc do not run on musl, statically linked ones do
+	keys := make([]string, 0, 2*len(archs))
+	for _, arch := range archs {
+		keys = append(keys, arch+"-musl")
+	}
+	return append(keys, archs...)
+}
+
+func detectPlatform() platform {
+	p := platform{goos: strings.ToLower(runtime.GOOS), arch: runtime.GOARCH}
+	if p.goos != "linux" {
+		return p
+	}
+
+	if p.arch == "arm" {
+		if cpuinfo, err := ioutil.ReadFile("/proc/cpuinfo"); err == nil {
+			p.arm = armVersion(string(cpuinfo))
+		}
+	}
+
+	// Alpine and other musl based distributions ship the musl dynamic loader
+	if loaders, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(loaders) > 0 {
+		p.musl = true
+	}
+	return p
+}
+
+var cpuArchitecture = regexp.MustCompile(`(?m)^CPU architecture\s*:\s*(\d+)`)
+
+// armVersion returns the ARM revision from /proc/cpuinfo, "v6" or "v7".
+func armVersion(cpuinfo string) string {
+	match := cpuArchitecture.FindStringSubmatch(cpuinfo)
+	if match == nil {
+		return ""
+	}
+
+	switch match[1] {
+	case "6":
+		return "v6"
+	case "5", "4":
+		return ""
+	default:
+		// 32 bit userland on an ARMv8 kernel runs ARMv7 binaries
+		return "v7"
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArchKeys(t *testing.T) {
+	Convey("Variant arch keys come before the generic one", t, func() {
+		So(platform{goos: "linux", arch: "amd64"}.keys(), ShouldResemble, []string{"linux-amd64"})
+		So(platform{goos: "linux", arch: "arm", arm: "v6"}.keys(), ShouldResemble, []string{"linux-armv6", "linux-arm"})
+		So(platform{goos: "linux", arch: "amd64", musl: true}.keys(), ShouldResemble, []string{"linux-amd64-musl", "linux-amd64"})
+		So(platform{goos: "linux", arch: "arm", arm: "v7", musl: true}.keys(), ShouldResemble,
+			[]string{"linux-armv7-musl", "linux-arm-musl", "linux-armv7", "linux-arm"})
+	})
+
+	Convey("The ARM revision is read from cpuinfo", t, func() {
+		So(armVersion("processor\t: 0\nmodel name\t: ARMv6-compatible processor rev 7 (v6l)\nCPU architecture: 6\n"), ShouldEqual, "v6")
+		So(armVersion("CPU architecture: 7\n"), ShouldEqual, "v7")
+		So(armVersion("CPU architecture: 8\n"), ShouldEqual, "v7")
+		So(armVersion("model name\t: Intel(R) Xeon(R)\n"), ShouldEqual, "")
+	})
+
+	Convey("Archives are selected for the most specific arch key", t, func() {
+		defer SetArchOverride(nil)
+		SetArchOverride([]string{"linux-arm64-musl", "linux-arm64"})
+
+		key, _, ok := SelectArchive(m.Version{Arch: map[string]m.ArchMeta{"linux-arm64": {}, "linux-arm64-musl": {}}})
+		So(ok, ShouldBeTrue)
+		So(key, ShouldEqual, "linux-arm64-musl")
+
+		key, _, ok = SelectArchive(m.Version{Arch: map[string]m.ArchMeta{"linux-arm64": {}, "any": {}}})
+		So(ok, ShouldBeTrue)
+		So(key, ShouldEqual, "linux-arm64")
+		So(osAndArchString(), ShouldEqual, "linux-arm64-musl")
+	})
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPluginStateFile is the name of the plugin state file in the data
+// path of Grafana.
+const DefaultPluginStateFile = "plugin-state.json"
+
+var pluginStateFile string
+
+// SetPluginStateFile sets the file the PluginState of the installed plugins is
+// recorded in, nothing is recorded when it is empty.
+func SetPluginStateFile(path string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	pluginStateFile = path
+}
+
+// PluginStateFile returns the file set with SetPluginStateFile.
+func PluginStateFile() string {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return pluginStateFile
+}
+
+// PluginState is when a plugin was last checked for updates and installed on
+// this instance, and the last error doing either.
+type PluginState struct {
+	PluginID         string    `json:"pluginId"`
+	LastCheck        time.Time `json:"lastCheck"`
+	LastInstall      time.Time `json:"lastInstall"`
+	InstalledVersion string    `json:"installedVersion,omitempty"`
+	// LastError is kept after later successes, LastErrorAt tells whether it
+	// is still relevant.
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt"`
+}
+
+// PluginStateStore keeps the PluginState of every plugin in a JSON file, so
+// operators can tell when an instance last checked for updates successfully.
+// Concurrent processes may lose each other's updates, the file itself is
+// replaced atomically.
+type PluginStateStore struct {
+	Path string
+}
+
+// NewPluginStateStore returns the store kept in the configured plugin state
+// file, see SetPluginStateFile.
+func NewPluginStateStore() PluginStateStore {
+	return PluginStateStore{Path: PluginStateFile()}
+}
+
+var pluginStateMtx sync.Mutex
+
+// Load returns the states of all plugins, sorted by plugin id.
+func (s PluginStateStore) Load() ([]PluginState, error) {
+	pluginStateMtx.Lock()
+	defer pluginStateMtx.Unlock()
+
+	states, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PluginState, 0, len(states))
+	for _, state := range states {
+		result = append(result, state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].PluginID < result[j].PluginID })
+	return result, nil
+}
+
+// Get returns the state of pluginId, a zero state but for the id when nothing
+// was recorded yet.
+func (s PluginStateStore) Get(pluginId string) (PluginState, error) {
+	pluginStateMtx.Lock()
+	defer pluginStateMtx.Unlock()
+
+	states, err := s.load()
+	if err != nil {
+		return PluginState{}, err
+	}
+	if state, ok := states[pluginId]; ok {
+		return state, nil
+	}
+	return PluginState{PluginID: pluginId}, nil
+}
+
+// RecordCheck records an update check of pluginIds, failed when err is set.
+func (s PluginStateStore) RecordCheck(pluginIds []string, err error) error {
+	now := getClock().Now().UTC()
+	return s.update(pluginIds, func(state *PluginState) {
+		if err != nil {
+			state.LastError, state.LastErrorAt = err.Error(), now
+			return
+		}
+		state.LastCheck = now
+	})
+}
+
+// RecordInstall records an install of version of pluginId, failed when err is set.
+func (s PluginStateStore) RecordInstall(pluginId, version string, err error) error {
+	now := getClock().Now().UTC()
+	return s.update([]string{pluginId}, func(state *PluginState) {
+		if err != nil {
+			state.LastError, state.LastErrorAt = err.Error(), now
+			return
+		}
+		state.LastInstall = now
+		if version != "" {
+			state.InstalledVersion = version
+		}
+	})
+}
+
+func (s PluginStateStore) update(pluginIds []string, apply func(state *PluginState)) error {
+	if len(pluginIds) == 0 {
+		return nil
+	}
+
+	pluginStateMtx.Lock()
+	defer pluginStateMtx.Unlock()
+
+	states, err := s.load()
+	if err != nil {
+		// an unreadable state is replaced rather than blocking installs
+		log.Warnf("replacing unreadable plugin state %v: %v\n", s.Path, err)
+		states = map[string]PluginState{}
+	}
+
+	for _, id := range pluginIds {
+		state := states[id]
+		state.PluginID = id
+		apply(&state)
+		states[id] = state
+	}
+
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+func (s PluginStateStore) load() (map[string]PluginState, error) {
+	states := map[string]PluginState{}
+
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &states)
+	return states, err
+}
+
+// recordCheck records an update check of the plugins installed in pluginDir.
+func recordCheck(pluginDir string, err error) {
+	store := NewPluginStateStore()
+	if pluginDir == "" || store.Path == "" {
+		return
+	}
+
+	var ids []string
+	for _, plugin := range GetLocalPlugins(pluginDir) {
+		ids = append(ids, plugin.Id)
+	}
+	if saveErr := store.RecordCheck(ids, err); saveErr != nil {
+		log.Errorf("failed to save plugin state: %v\n", saveErr)
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPluginState(t *testing.T) {
+	Convey("Update checks and installs are recorded per plugin", t, func() {
+		mock := clock.NewMock()
+		mock.Add(time.Hour)
+		SetClock(mock)
+		defer SetClock(nil)
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.MkdirAll(filepath.Join(dir, "state-panel"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "state-panel", "plugin.json"), []byte(`{"id": "state-panel", "info": {"version": "1.0.0"}}`), 0644), ShouldBeNil)
+
+		SetPluginStateFile(filepath.Join(dir, "data", DefaultPluginStateFile))
+		defer SetPluginStateFile("")
+
+		failing := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{"plugins": [{"id": "state-panel", "versions": [{"version": "1.1.0"}]}]}`))
+		}))
+		defer server.Close()
+
+		_, err = CheckForUpdates(server.URL, dir, nil)
+		So(err, ShouldBeNil)
+		checkedAt := mock.Now().UTC()
+
+		store := NewPluginStateStore()
+		state, err := store.Get("state-panel")
+		So(err, ShouldBeNil)
+		So(state.LastCheck, ShouldResemble, checkedAt)
+		So(state.LastError, ShouldBeEmpty)
+
+		Convey("keeping the last successful check after a failure", func() {
+			failing = true
+			mock.Add(time.Minute)
+			_, err := CheckForUpdates(server.URL, dir, nil)
+			So(err, ShouldNotBeNil)
+
+			state, err := store.Get("state-panel")
+			So(err, ShouldBeNil)
+			So(state.LastCheck, ShouldResemble, checkedAt)
+			So(state.LastError, ShouldNotBeEmpty)
+			So(state.LastErrorAt, ShouldResemble, mock.Now().UTC())
+		})
+
+		Convey("including the checks of the update checker", func() {
+			mock.Add(time.Minute)
+			checker := NewUpdateChecker(server.URL, dir, FileUpdateStore{Path: filepath.Join(dir, "data", "plugin-updates.json")})
+			_, err := checker.CheckOnce(context.Background())
+			So(err, ShouldBeNil)
+
+			state, err := store.Get("state-panel")
+			So(err, ShouldBeNil)
+			So(state.LastCheck, ShouldResemble, mock.Now().UTC())
+		})
+
+		Convey("with the installed version", func() {
+			So(store.RecordInstall("state-panel", "1.1.0", nil), ShouldBeNil)
+			So(store.RecordInstall("other-panel", "", errors.New("checksum mismatch")), ShouldBeNil)
+
+			states, err := store.Load()
+			So(err, ShouldBeNil)
+			So(states, ShouldHaveLength, 2)
+			So(states[0].PluginID, ShouldEqual, "other-panel")
+			So(states[0].LastError, ShouldEqual, "checksum mismatch")
+			So(states[0].LastInstall.IsZero(), ShouldBeTrue)
+			So(states[1].InstalledVersion, ShouldEqual, "1.1.0")
+			So(states[1].LastInstall, ShouldResemble, checkedAt)
+		})
+	})
+}
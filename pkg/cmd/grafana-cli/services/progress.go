@@ -0,0 +1,112 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// ProgressStage is a step of a plugin install.
+type ProgressStage string
+
+const (
+	StageResolving   ProgressStage = "resolving"
+	StageDownloading ProgressStage = "downloading"
+	StageVerifying   ProgressStage = "verifying"
+	StageExtracting  ProgressStage = "extracting"
+	StageDone        ProgressStage = "done"
+	StageError       ProgressStage = "error"
+)
+
+// ProgressEvent reports the state of a plugin install. Events are JSON
+// encodable so they can be forwarded to the plugin catalog as they are.
+type ProgressEvent struct {
+	PluginID string        `json:"pluginId"`
+	Stage    ProgressStage `json:"stage"`
+	// BytesDone and BytesTotal are set while downloading, BytesTotal is 0
+	// when the server does not announce the archive size.
+	BytesDone  int64     `json:"bytesDone,omitempty"`
+	BytesTotal int64     `json:"bytesTotal,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// ProgressHandler receives progress events. It is called synchronously and
+// should not block. Bulk installs download several plugins at once, so it
+// may be called concurrently.
+type ProgressHandler func(ev ProgressEvent)
+
+var progressHandler ProgressHandler
+
+// SetProgressHandler registers the handler receiving install progress, nil
+// disables progress reporting.
+func SetProgressHandler(h ProgressHandler) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	progressHandler = h
+}
+
+func getProgressHandler() ProgressHandler {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return progressHandler
+}
+
+// ReportProgress sends an event to the registered progress handler.
+func ReportProgress(pluginId string, stage ProgressStage) {
+	emitProgress(ProgressEvent{PluginID: pluginId, Stage: stage})
+}
+
+// ReportProgressError sends the error that ended an install.
+func ReportProgressError(pluginId string, err error) {
+	emitProgress(ProgressEvent{PluginID: pluginId, Stage: StageError, Error: err.Error()})
+}
+
+func emitProgress(ev ProgressEvent) {
+	h := getProgressHandler()
+	if h == nil {
+		return
+	}
+
+	ev.Time = time.Now().UTC()
+	h(ev)
+}
+
+// progressInterval limits how often download progress is reported.
+const progressInterval = 64 * 1024
+
+type progressReader struct {
+	io.ReadCloser
+	pluginId string
+	total    int64
+	done     int64
+	reported int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.done += int64(n)
+
+	if r.done-r.reported >= progressInterval || err == io.EOF {
+		r.reported = r.done
+		emitProgress(ProgressEvent{PluginID: r.pluginId, Stage: StageDownloading, BytesDone: r.done, BytesTotal: r.total})
+	}
+
+	return n, err
+}
+
+func trackDownload(pluginId string, res *http.Response, err error) (*http.Response, error) {
+	if err != nil || getProgressHandler() == nil {
+		return res, err
+	}
+
+	total := res.ContentLength
+	if total < 0 {
+		total = 0
+	}
+	res.Body = &progressReader{ReadCloser: res.Body, pluginId: pluginId, total: total}
+
+	return res, nil
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDownloadProgress(t *testing.T) {
+	Convey("Archive downloads report the bytes received", t, func() {
+		archive := bytes.Repeat([]byte("a"), 3*progressInterval)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+			w.Write(archive)
+		}))
+		defer server.Close()
+
+		var events []ProgressEvent
+		SetProgressHandler(func(ev ProgressEvent) { events = append(events, ev) })
+		defer SetProgressHandler(nil)
+
+		body, err := DownloadArchive("test-plugin", server.URL+"/repo/test-plugin/versions/1.0.0/download")
+		So(err, ShouldBeNil)
+		So(body, ShouldHaveLength, len(archive))
+
+		So(len(events), ShouldBeGreaterThan, 1)
+		last := events[len(events)-1]
+		So(last.PluginID, ShouldEqual, "test-plugin")
+		So(last.Stage, ShouldEqual, StageDownloading)
+		So(last.BytesDone, ShouldEqual, len(archive))
+		So(last.BytesTotal, ShouldEqual, len(archive))
+	})
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// RepoProxy is a caching proxy for the plugin repository. Grafana can expose
+// it internally so sibling instances and renderer containers resolve and
+// download plugins through a single instance with egress to grafana.com.
+type RepoProxy struct {
+	// Upstream is the repository to proxy, defaults to the configured repo url.
+	Upstream string
+	// ArchiveTTL is how long proxied archives are cached, 0 disables caching archives.
+	ArchiveTTL time.Duration
+}
+
+func NewRepoProxy(upstream string, archiveTTL time.Duration) *RepoProxy {
+	return &RepoProxy{Upstream: upstream, ArchiveTTL: archiveTTL}
+}
+
+func (p *RepoProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// upstream requests carry the id of the request they are made for
+	ctx := r.Context()
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		ctx = WithRequestID(ctx, id)
+	}
+
+	upstream := resolveRepoURL(p.Upstream)
+	target, err := proxyTarget(upstream, r.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	isDownload := strings.HasSuffix(r.URL.Path, "/download")
+	key := "proxy:" + target
+
+	contentType := "application/json"
+	ttl := MetadataCacheTTL
+	if isDownload {
+		contentType = "application/zip"
+		ttl = p.ArchiveTTL
+	}
+
+	body, ok := getCache().Get(key)
+	if !ok {
+		if isDownload {
+			body, err = DownloadArchiveWithContext(ctx, pluginIdFromPath(r.URL.Path), target)
+		} else {
+			body, err = sendRequestURL(ctx, OpGetPlugin, pluginIdFromPath(r.URL.Path), upstream, target)
+		}
+
+		if xerrors.Is(err, ErrNotFoundError) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Warnf("Plugin repository proxy failed to fetch %v: %v\n", r.URL.Path, err)
+			http.Error(w, "failed to fetch from plugin repository", http.StatusBadGateway)
+			return
+		}
+
+		if ttl > 0 {
+			getCache().Set(key, body, ttl)
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+// proxyTarget returns the upstream url of a proxied request, with its query.
+// Paths that resolve outside of the upstream repository are refused.
+func proxyTarget(upstream string, requested *url.URL) (string, error) {
+	base, err := url.Parse(upstream)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := strings.TrimSuffix(base.Path, "/")
+	target := *base
+	target.Path = path.Join(prefix, requested.Path)
+	if target.Path != prefix && !strings.HasPrefix(target.Path, prefix+"/") {
+		return "", fmt.Errorf("path %q is outside of the plugin repository", requested.Path)
+	}
+	target.RawPath = ""
+	target.RawQuery = requested.RawQuery
+	return target.String(), nil
+}
+
+// pluginIdFromPath extracts the plugin id from /repo/<id> and /<id>/versions/<version>/download.
+func pluginIdFromPath(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "repo" {
+		return parts[1]
+	}
+	if len(parts) >= 1 && parts[0] != "repo" {
+		return parts[0]
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRepoProxy(t *testing.T) {
+	Convey("Proxy serves and caches repository responses", t, func() {
+		var requests int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			switch r.URL.Path {
+			case "/repo/proxied-plugin":
+				w.Write([]byte(`{"id": "proxied-plugin"}`))
+			case "/repo/search":
+				w.Write([]byte(`{"query": "` + r.URL.Query().Get("q") + `"}`))
+			case "/proxied-plugin/versions/1.0.0/download":
+				w.Write([]byte("zip"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer upstream.Close()
+
+		proxy := httptest.NewServer(NewRepoProxy(upstream.URL, time.Hour))
+		defer proxy.Close()
+
+		get := func(p string) (int, string) {
+			res, err := http.Get(proxy.URL + p)
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			return res.StatusCode, string(body)
+		}
+
+		for i := 0; i < 2; i++ {
+			status, body := get("/repo/proxied-plugin")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, `{"id": "proxied-plugin"}`)
+
+			status, body = get("/proxied-plugin/versions/1.0.0/download")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "zip")
+		}
+		So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+
+		status, _ := get("/repo/unknown-plugin")
+		So(status, ShouldEqual, http.StatusNotFound)
+
+		Convey("forwarding the query", func() {
+			status, body := get("/repo/search?q=clock")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, `{"query": "clock"}`)
+
+			status, body = get("/repo/search?q=worldmap")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, `{"query": "worldmap"}`)
+		})
+	})
+
+	Convey("Proxy refuses paths outside of the upstream repository", t, func() {
+		target, err := proxyTarget("https://grafana.com/api/plugins", &url.URL{Path: "/repo/../../../api/users"})
+		So(err, ShouldNotBeNil)
+		So(target, ShouldBeEmpty)
+
+		target, err = proxyTarget("https://grafana.com/api/plugins/", &url.URL{Path: "/repo/a-panel/../b-panel", RawQuery: "x=1"})
+		So(err, ShouldBeNil)
+		So(target, ShouldEqual, "https://grafana.com/api/plugins/repo/b-panel?x=1")
+
+		proxy := httptest.NewServer(NewRepoProxy("http://localhost:0/api/plugins", time.Hour))
+		defer proxy.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL, nil)
+		req.URL.Opaque = "/repo/../../users"
+		res, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		res.Body.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var ErrQuotaExceeded = errors.New("plugin directory quota exceeded")
+
+type QuotaPolicy string
+
+const (
+	// QuotaRefuse rejects installs that would exceed the quota.
+	QuotaRefuse QuotaPolicy = "refuse"
+	// QuotaEvictOldest removes the least recently modified plugins until the install fits.
+	QuotaEvictOldest QuotaPolicy = "evict-oldest"
+)
+
+func (p QuotaPolicy) valid() bool {
+	return p == QuotaRefuse || p == QuotaEvictOldest
+}
+
+// QuotaManager limits the total size of the plugin directory.
+type QuotaManager struct {
+	Limit  int64
+	Policy QuotaPolicy
+}
+
+var pluginDirQuota *QuotaManager
+
+// SetPluginDirQuota limits the plugin directory to limit bytes. A limit of 0
+// disables the quota.
+func SetPluginDirQuota(limit int64, policy QuotaPolicy) error {
+	if limit > 0 && !policy.valid() {
+		return fmt.Errorf("unknown plugin directory quota policy %q, expected %s or %s", policy, QuotaRefuse, QuotaEvictOldest)
+	}
+
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	if limit <= 0 {
+		pluginDirQuota = nil
+		return nil
+	}
+
+	pluginDirQuota = &QuotaManager{Limit: limit, Policy: policy}
+	return nil
+}
+
+// CheckQuota makes sure installing size bytes for pluginName into pluginDir
+// stays within the configured quota, evicting other plugins if the policy allows it.
+func CheckQuota(pluginDir, pluginName string, size int64) error {
+	stateMtx.RLock()
+	quota := pluginDirQuota
+	stateMtx.RUnlock()
+
+	if quota == nil {
+		return nil
+	}
+
+	return quota.Reserve(pluginDir, pluginName, size)
+}
+
+func (q *QuotaManager) Reserve(pluginDir, pluginName string, size int64) error {
+	plugins, err := pluginDirSizes(pluginDir)
+	if err != nil {
+		return err
+	}
+
+	var usage int64
+	for _, p := range plugins {
+		if p.name != pluginName {
+			usage += p.size
+		}
+	}
+
+	if usage+size <= q.Limit {
+		return nil
+	}
+
+	if q.Policy != QuotaEvictOldest {
+		return xerrors.Errorf("installing %s requires %d bytes, %d of %d bytes in use: %w", pluginName, size, usage, q.Limit, ErrQuotaExceeded)
+	}
+
+	// the plugins to evict are picked before removing any, so they are only
+	// removed when that makes the install fit
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].modTime < plugins[j].modTime })
+
+	var evict []pluginDirEntry
+	remaining := usage
+	for _, p := range plugins {
+		if remaining+size <= q.Limit {
+			break
+		}
+		// the plugin installed and the copies set aside by installs are kept
+		if p.name == pluginName || strings.HasPrefix(p.name, ".") {
+			continue
+		}
+		evict = append(evict, p)
+		remaining -= p.size
+	}
+
+	if remaining+size > q.Limit {
+		return xerrors.Errorf("installing %s requires %d bytes, exceeds the quota of %d bytes even after evicting other plugins: %w", pluginName, size, q.Limit, ErrQuotaExceeded)
+	}
+
+	for _, p := range evict {
+		log.Infof("Evicting %v to stay within the plugin directory quota\n", p.name)
+		if err := RemoveInstalledPlugin(pluginDir, p.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type pluginDirEntry struct {
+	name    string
+	size    int64
+	modTime int64
+}
+
+func pluginDirSizes(pluginDir string) ([]pluginDirEntry, error) {
+	files, err := IoHelper.ReadDir(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []pluginDirEntry
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+
+		var size int64
+		err := filepath.Walk(filepath.Join(pluginDir, f.Name()), func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, pluginDirEntry{name: f.Name(), size: size, modTime: f.ModTime().UnixNano()})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestQuotaManager(t *testing.T) {
+	Convey("Plugin directory quota", t, func() {
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		for i, name := range []string{"old-plugin", "new-plugin"} {
+			So(os.MkdirAll(filepath.Join(dir, name), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, name, "module.js"), make([]byte, 100), 0644), ShouldBeNil)
+			modTime := time.Now().Add(time.Duration(i-2) * time.Hour)
+			So(os.Chtimes(filepath.Join(dir, name), modTime, modTime), ShouldBeNil)
+		}
+
+		Convey("refuses installs exceeding the limit", func() {
+			q := &QuotaManager{Limit: 250, Policy: QuotaRefuse}
+			So(q.Reserve(dir, "other-plugin", 50), ShouldBeNil)
+
+			err := q.Reserve(dir, "other-plugin", 51)
+			So(xerrors.Is(err, ErrQuotaExceeded), ShouldBeTrue)
+		})
+
+		Convey("does not count the plugin being replaced", func() {
+			q := &QuotaManager{Limit: 250, Policy: QuotaRefuse}
+			So(q.Reserve(dir, "new-plugin", 150), ShouldBeNil)
+		})
+
+		Convey("evicts the least recently modified plugin", func() {
+			q := &QuotaManager{Limit: 250, Policy: QuotaEvictOldest}
+			So(q.Reserve(dir, "other-plugin", 120), ShouldBeNil)
+
+			_, err := os.Stat(filepath.Join(dir, "old-plugin"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = os.Stat(filepath.Join(dir, "new-plugin"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("evicts nothing when evicting would not make the install fit", func() {
+			q := &QuotaManager{Limit: 250, Policy: QuotaEvictOldest}
+			err := q.Reserve(dir, "other-plugin", 251)
+			So(xerrors.Is(err, ErrQuotaExceeded), ShouldBeTrue)
+
+			for _, name := range []string{"old-plugin", "new-plugin"} {
+				_, err := os.Stat(filepath.Join(dir, name))
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+
+	Convey("Quota policies are validated", t, func() {
+		defer SetPluginDirQuota(0, "")
+
+		So(SetPluginDirQuota(100, QuotaEvictOldest), ShouldBeNil)
+		So(SetPluginDirQuota(100, "evict-newest"), ShouldNotBeNil)
+		So(SetPluginDirQuota(0, "evict-newest"), ShouldBeNil)
+	})
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var ErrRedirectNotAllowed = errors.New("redirect not allowed by download redirect policy")
+var ErrDownloadHostNotAllowed = errors.New("download host not allowed by download redirect policy")
+
+// RedirectPolicy restricts where plugin archive downloads may be redirected to.
+type RedirectPolicy struct {
+	// MaxRedirects is the maximum number of hops to follow, 0 uses the net/http default of 10.
+	MaxRedirects int
+	// AllowedHosts are glob patterns, e.g. "*.grafana.com", matched against the
+	// host of the download url and of every redirect target. An empty list
+	// allows any host.
+	AllowedHosts []string
+	// SameScheme rejects redirects that change the scheme, e.g. https to http.
+	SameScheme bool
+}
+
+var redirectPolicy *RedirectPolicy
+
+// SetRedirectPolicy applies the policy to archive downloads.
+func SetRedirectPolicy(policy RedirectPolicy) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	redirectPolicy = &policy
+}
+
+func getRedirectPolicy() *RedirectPolicy {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return redirectPolicy
+}
+
+// checkDownloadHost refuses archive downloads from hosts the policy set with
+// SetRedirectPolicy does not allow, before any redirect is followed.
+func checkDownloadHost(req *RepoRequest) error {
+	policy := getRedirectPolicy()
+	if req.Op != OpDownload || policy == nil || policy.hostAllowed(req.Request.URL.Hostname()) {
+		return nil
+	}
+	return xerrors.Errorf("host %s is not allowed: %w", req.Request.URL.Hostname(), ErrDownloadHostNotAllowed)
+}
+
+// downloadRedirects returns the CheckRedirect of DownloadClient, applying
+// the policy set with SetRedirectPolicy, or else checkRedirect when it is set
+// and the net/http default otherwise.
+func downloadRedirects(checkRedirect func(req *http.Request, via []*http.Request) error) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if policy := getRedirectPolicy(); policy != nil {
+			return policy.CheckRedirect(req, via)
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		return RedirectPolicy{}.CheckRedirect(req, via)
+	}
+}
+
+func (p RedirectPolicy) CheckRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := p.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+
+	if len(via) >= maxRedirects {
+		return xerrors.Errorf("stopped after %d redirects: %w", len(via), ErrRedirectNotAllowed)
+	}
+
+	if p.SameScheme && len(via) > 0 && req.URL.Scheme != via[0].URL.Scheme {
+		return xerrors.Errorf("scheme changed from %s to %s: %w", via[0].URL.Scheme, req.URL.Scheme, ErrRedirectNotAllowed)
+	}
+
+	if !p.hostAllowed(req.URL.Hostname()) {
+		return xerrors.Errorf("host %s is not allowed: %w", req.URL.Hostname(), ErrRedirectNotAllowed)
+	}
+
+	return nil
+}
+
+func (p RedirectPolicy) hostAllowed(host string) bool {
+	if len(p.AllowedHosts) == 0 {
+		return true
+	}
+
+	return matchHost(p.AllowedHosts, host)
+}
+
+// matchHost reports whether host matches any of the glob patterns.
+func matchHost(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	request := func(rawurl string) *http.Request {
+		u, _ := url.Parse(rawurl)
+		return &http.Request{URL: u}
+	}
+	via := []*http.Request{request("https://grafana.com/api/plugins/x/versions/1.0.0/download")}
+
+	Convey("Redirect policy", t, func() {
+		policy := RedirectPolicy{MaxRedirects: 2, AllowedHosts: []string{"*.grafana.com"}, SameScheme: true}
+
+		So(policy.CheckRedirect(request("https://cdn.grafana.com/x.zip"), via), ShouldBeNil)
+
+		err := policy.CheckRedirect(request("https://evil.example.com/x.zip"), via)
+		So(xerrors.Is(err, ErrRedirectNotAllowed), ShouldBeTrue)
+
+		err = policy.CheckRedirect(request("http://cdn.grafana.com/x.zip"), via)
+		So(xerrors.Is(err, ErrRedirectNotAllowed), ShouldBeTrue)
+
+		err = policy.CheckRedirect(request("https://cdn.grafana.com/x.zip"), append(via, via[0]))
+		So(xerrors.Is(err, ErrRedirectNotAllowed), ShouldBeTrue)
+	})
+
+	Convey("The allowed hosts apply to the download url", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("plugin archive"))
+		}))
+		defer server.Close()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+		defer func() { redirectPolicy = nil }()
+
+		SetRedirectPolicy(RedirectPolicy{AllowedHosts: []string{"*.grafana.com"}})
+		_, err := DownloadArchive("redirect-panel", server.URL+"/redirect-panel.zip")
+		So(xerrors.Is(err, ErrDownloadHostNotAllowed), ShouldBeTrue)
+
+		SetRedirectPolicy(RedirectPolicy{AllowedHosts: []string{"127.0.0.1"}})
+		body, err := DownloadArchive("redirect-panel", server.URL+"/redirect-panel.zip")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "plugin archive")
+	})
+}
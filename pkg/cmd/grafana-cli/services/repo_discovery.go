@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// RepoProvider lists the repositories serving the same plugins as the
+// configured one, most preferred first, e.g. from Consul or DNS SRV records,
+// so mirrors can be added and removed without editing the Grafana config.
+type RepoProvider interface {
+	Repos(ctx context.Context) ([]string, error)
+}
+
+// RepoProviderFunc adapts a function to a RepoProvider.
+type RepoProviderFunc func(ctx context.Context) ([]string, error)
+
+func (f RepoProviderFunc) Repos(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// repoProviders create the provider of references of the form
+// "<scheme>:<target>", keyed by scheme.
+var repoProviders = map[string]func(target string) (RepoProvider, error){
+	"srv": newSRVRepoProvider,
+}
+
+// RegisterRepoProvider makes references of the form "<scheme>:<target>"
+// discover repositories through the provider returned by newProvider, e.g.
+// "consul:grafana-plugins" with one backed by the Consul catalog.
+func RegisterRepoProvider(scheme string, newProvider func(target string) (RepoProvider, error)) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	repoProviders[scheme] = newProvider
+}
+
+// ParseRepoProvider returns the provider a reference like
+// "srv:_grafana-plugins._tcp.example.com" refers to.
+func ParseRepoProvider(value string) (RepoProvider, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid repository discovery %q, expected <scheme>:<target>", value)
+	}
+
+	stateMtx.RLock()
+	newProvider, ok := repoProviders[parts[0]]
+	stateMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown repository discovery scheme %q", parts[0])
+	}
+	return newProvider(parts[1])
+}
+
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// SRVRepoProvider lists the targets of the SRV records of Name, ordered by
+// priority and weight, as repositories at Scheme://<target>:<port>Path.
+type SRVRepoProvider struct {
+	Name   string
+	Scheme string
+	Path   string
+}
+
+func newSRVRepoProvider(target string) (RepoProvider, error) {
+	return SRVRepoProvider{Name: target, Scheme: "https", Path: "/api/plugins"}, nil
+}
+
+func (p SRVRepoProvider) Repos(ctx context.Context) ([]string, error) {
+	_, records, err := lookupSRV(ctx, "", "", p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]string, 0, len(records))
+	for _, srv := range records {
+		host := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		repos = append(repos, p.Scheme+"://"+host+p.Path)
+	}
+	return repos, nil
+}
+
+// RepoDiscovery keeps the failover repositories up to date with a provider.
+// Static repositories, like the configured mirrors, are preferred to the
+// discovered ones. The last discovered repositories are kept as warm standbys
+// while the provider fails or lists none.
+type RepoDiscovery struct {
+	Provider RepoProvider
+	Static   []string
+	// Interval between refreshes.
+	Interval time.Duration
+	Clock    clock.Clock
+
+	discovered []string
+	first      sync.Once
+}
+
+// DiscoveryTimeout bounds the first refresh of a discovery, made once the
+// failover repositories are needed.
+var DiscoveryTimeout = 10 * time.Second
+
+// NewRepoDiscovery returns a discovery refreshing every minute.
+func NewRepoDiscovery(provider RepoProvider, static []string) *RepoDiscovery {
+	return &RepoDiscovery{
+		Provider: provider,
+		Static:   static,
+		Interval: time.Minute,
+		Clock:    getClock(),
+	}
+}
+
+// Refresh lists the repositories of the provider and makes them the failover
+// repositories.
+func (d *RepoDiscovery) Refresh(ctx context.Context) error {
+	repos, err := d.Provider.Repos(ctx)
+	if err != nil {
+		log.Warnf("repository discovery failed, keeping %d known repositories: %v\n", len(d.discovered), err)
+	} else if len(repos) == 0 {
+		log.Warnf("repository discovery found no repositories, keeping %d known repositories\n", len(d.discovered))
+	} else {
+		d.discovered = repos
+	}
+
+	seen := map[string]bool{}
+	var failover []string
+	for _, repo := range append(append([]string{}, d.Static...), d.discovered...) {
+		repo = strings.TrimSpace(repo)
+		if !seen[repo] {
+			seen[repo] = true
+			failover = append(failover, repo)
+		}
+	}
+	SetFailoverRepos(failover)
+	return err
+}
+
+// refreshOnce makes the first refresh of d, unless one was already made.
+func (d *RepoDiscovery) refreshOnce(ctx context.Context) {
+	d.first.Do(func() {
+		d.Refresh(ctx)
+	})
+}
+
+// Run refreshes the repositories every Interval until ctx is done.
+func (d *RepoDiscovery) Run(ctx context.Context) error {
+	d.refreshOnce(ctx)
+	for {
+		timer := d.Clock.Timer(d.Interval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		d.Refresh(ctx)
+	}
+}
+
+var repoDiscovery *RepoDiscovery
+
+// SetRepoDiscovery configures the discovery the failover repositories are
+// refreshed with, nil disables it. The provider is first queried when the
+// failover repositories are needed, so commands which do not fail over, like
+// listing the installed plugins, do not wait for it. Long running commands
+// keep refreshing them with Run.
+func SetRepoDiscovery(d *RepoDiscovery) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	repoDiscovery = d
+}
+
+// GetRepoDiscovery returns the configured discovery, nil when there is none.
+func GetRepoDiscovery() *RepoDiscovery {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return repoDiscovery
+}
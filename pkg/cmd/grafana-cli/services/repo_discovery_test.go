@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRepoDiscovery(t *testing.T) {
+	Convey("Discovered repositories become failover repositories", t, func() {
+		defer SetFailoverRepos(nil)
+
+		var discovered []string
+		var discoveryErr error
+		d := NewRepoDiscovery(RepoProviderFunc(func(ctx context.Context) ([]string, error) {
+			return discovered, discoveryErr
+		}), []string{"https://mirror.example.com/api/plugins"})
+
+		discovered = []string{"https://a.example.com/api/plugins", "https://mirror.example.com/api/plugins"}
+		So(d.Refresh(context.Background()), ShouldBeNil)
+		So(FailoverRepos(), ShouldResemble, []string{"https://mirror.example.com/api/plugins", "https://a.example.com/api/plugins"})
+
+		Convey("and are kept while the provider fails or lists none", func() {
+			discovered, discoveryErr = nil, errors.New("consul unavailable")
+			So(d.Refresh(context.Background()), ShouldNotBeNil)
+			So(FailoverRepos(), ShouldHaveLength, 2)
+
+			discoveryErr = nil
+			So(d.Refresh(context.Background()), ShouldBeNil)
+			So(FailoverRepos(), ShouldHaveLength, 2)
+
+			discovered = []string{"https://b.example.com/api/plugins"}
+			So(d.Refresh(context.Background()), ShouldBeNil)
+			So(FailoverRepos(), ShouldResemble, []string{"https://mirror.example.com/api/plugins", "https://b.example.com/api/plugins"})
+		})
+	})
+
+	Convey("The provider is first queried when the failover repositories are needed", t, func() {
+		defer SetFailoverRepos(nil)
+		defer SetRepoDiscovery(nil)
+
+		lookups := 0
+		SetRepoDiscovery(NewRepoDiscovery(RepoProviderFunc(func(ctx context.Context) ([]string, error) {
+			lookups++
+			return []string{"https://a.example.com/api/plugins"}, nil
+		}), nil))
+		So(lookups, ShouldEqual, 0)
+
+		So(FailoverRepos(), ShouldResemble, []string{"https://a.example.com/api/plugins"})
+		So(FailoverRepos(), ShouldHaveLength, 1)
+		So(lookups, ShouldEqual, 1)
+	})
+
+	Convey("SRV records are discovered as repositories", t, func() {
+		prev := lookupSRV
+		lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+			So(name, ShouldEqual, "_grafana-plugins._tcp.example.com")
+			return name, []*net.SRV{{Target: "a.example.com.", Port: 443}, {Target: "b.example.com.", Port: 8443}}, nil
+		}
+		defer func() { lookupSRV = prev }()
+
+		provider, err := ParseRepoProvider("srv:_grafana-plugins._tcp.example.com")
+		So(err, ShouldBeNil)
+
+		repos, err := provider.Repos(context.Background())
+		So(err, ShouldBeNil)
+		So(repos, ShouldResemble, []string{"https://a.example.com:443/api/plugins", "https://b.example.com:8443/api/plugins"})
+
+		_, err = ParseRepoProvider("zookeeper:plugins")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RepoTLS are the TLS settings of one repository, replacing the global ones
+// for its host.
+type RepoTLS struct {
+	InsecureSkipVerify bool
+	// CAFile is a PEM file of the CAs trusted in place of the system roots,
+	// e.g. the private CA of an internal mirror.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented
+	// to the repository.
+	CertFile string
+	KeyFile  string
+}
+
+// repoTransports are the transports of the hosts with their own TLS
+// settings, keyed by host.
+var repoTransports map[string]http.RoundTripper
+
+// SetRepoTLS replaces the per repository TLS settings, keyed by repository
+// url. TLS is negotiated per host, so the settings apply to every request to
+// the host of a repository. Other hosts, like the CDN archives are served
+// from, keep the global settings. The TLS policy applies to all of them, so
+// SetRepoTLS has to be called after Init.
+func SetRepoTLS(settings map[string]RepoTLS) error {
+	stateMtx.RLock()
+	policy := tlsPolicy
+	stateMtx.RUnlock()
+
+	transports := map[string]http.RoundTripper{}
+	for repoUrl, s := range settings {
+		u, err := url.Parse(resolveRepoURL(repoUrl))
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid repository url %q", repoUrl)
+		}
+
+		cfg, err := s.config()
+		if err != nil {
+			return fmt.Errorf("TLS settings of %s: %v", repoUrl, err)
+		}
+		policy.apply(cfg)
+		transports[u.Host] = newTransport(cfg)
+	}
+
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	repoTransports = transports
+	return nil
+}
+
+func (s RepoTLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+
+	if s.CAFile != "" {
+		pem, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.CAFile)
+		}
+	}
+
+	if s.CertFile != "" || s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// ParseRepoTLS parses per repository TLS settings, one repository per value
+// as "<repository url>,ca=<file>,cert=<file>,key=<file>,insecure" with every
+// setting optional.
+func ParseRepoTLS(values []string) (map[string]RepoTLS, error) {
+	settings := map[string]RepoTLS{}
+	for _, value := range values {
+		parts := strings.Split(value, ",")
+		repoUrl := strings.TrimSpace(parts[0])
+		if repoUrl == "" {
+			return nil, fmt.Errorf("invalid repository TLS settings %q, expected <repository url>,<setting>,...", value)
+		}
+
+		var s RepoTLS
+		for _, part := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			switch {
+			case kv[0] == "insecure" && len(kv) == 1:
+				s.InsecureSkipVerify = true
+			case kv[0] == "ca" && len(kv) == 2:
+				s.CAFile = kv[1]
+			case kv[0] == "cert" && len(kv) == 2:
+				s.CertFile = kv[1]
+			case kv[0] == "key" && len(kv) == 2:
+				s.KeyFile = kv[1]
+			default:
+				return nil, fmt.Errorf("unknown TLS setting %q of %s, expected ca=, cert=, key= or insecure", part, repoUrl)
+			}
+		}
+		settings[repoUrl] = s
+	}
+	return settings, nil
+}
+
+// repoClient returns client with a transport choosing the TLS settings of
+// every request's host, so redirects leaving a repository with settings of
+// its own get the global ones again.
+func repoClient(client *http.Client) *http.Client {
+	stateMtx.RLock()
+	configured := len(repoTransports) > 0
+	stateMtx.RUnlock()
+
+	if !configured {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &repoTLSTransport{base: base}
+	return &c
+}
+
+// repoTLSTransport sends https requests to hosts with TLS settings of their
+// own through their transport, and all others through base.
+type repoTLSTransport struct {
+	base http.RoundTripper
+}
+
+func (t *repoTLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	stateMtx.RLock()
+	transport, ok := repoTransports[req.URL.Host]
+	stateMtx.RUnlock()
+
+	if !ok || req.URL.Scheme != "https" {
+		return t.base.RoundTrip(req)
+	}
+	return transport.RoundTrip(req)
+}
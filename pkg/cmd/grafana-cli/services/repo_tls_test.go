@@ -0,0 +1,78 @@
+package services
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRepoTLS(t *testing.T) {
+	Convey("Repositories can trust their own CA", t, func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"plugins": []}`))
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		caFile := filepath.Join(dir, "ca.pem")
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		So(ioutil.WriteFile(caFile, ca, 0644), ShouldBeNil)
+
+		_, err = ListAllPlugins(server.URL)
+		So(ClassifyError(err), ShouldEqual, ErrorClassTLS)
+
+		So(SetRepoTLS(map[string]RepoTLS{server.URL: {CAFile: caFile}}), ShouldBeNil)
+		defer SetRepoTLS(nil)
+
+		_, err = ListAllPlugins(server.URL)
+		So(err, ShouldBeNil)
+
+		Convey("unless they are configured for another host", func() {
+			So(SetRepoTLS(map[string]RepoTLS{"https://mirror.example.com": {CAFile: caFile}}), ShouldBeNil)
+
+			_, err := ListAllPlugins(server.URL)
+			So(ClassifyError(err), ShouldEqual, ErrorClassTLS)
+		})
+	})
+
+	Convey("Redirects to other hosts do not keep the settings of the repository", t, func() {
+		cdn := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"plugins": []}`))
+		}))
+		defer cdn.Close()
+		mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, cdn.URL+r.URL.Path, http.StatusFound)
+		}))
+		defer mirror.Close()
+
+		So(SetRepoTLS(map[string]RepoTLS{mirror.URL: {InsecureSkipVerify: true}}), ShouldBeNil)
+		defer SetRepoTLS(nil)
+
+		_, err := ListAllPlugins(mirror.URL)
+		So(ClassifyError(err), ShouldEqual, ErrorClassTLS)
+	})
+
+	Convey("Repository TLS settings are parsed per repository", t, func() {
+		settings, err := ParseRepoTLS([]string{
+			"https://mirror.example.com/api/plugins,ca=/etc/ssl/ca.pem,cert=client.pem,key=client-key.pem",
+			"https://dev.example.com,insecure",
+		})
+		So(err, ShouldBeNil)
+		So(settings, ShouldResemble, map[string]RepoTLS{
+			"https://mirror.example.com/api/plugins": {CAFile: "/etc/ssl/ca.pem", CertFile: "client.pem", KeyFile: "client-key.pem"},
+			"https://dev.example.com":                {InsecureSkipVerify: true},
+		})
+
+		_, err = ParseRepoTLS([]string{"https://mirror.example.com,verify=false"})
+		So(err, ShouldNotBeNil)
+	})
+}
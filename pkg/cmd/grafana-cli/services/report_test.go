@@ -0,0 +1,60 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerificationReport(t *testing.T) {
+	Convey("Verification reports record the verified archive", t, func() {
+		archive := []byte("archive")
+		checksum := fmt.Sprintf("%x", sha256.Sum256(archive))
+
+		report := NewVerificationReport("report-panel", "1.0.0", checksum)
+		So(report.Signature, ShouldEqual, SignatureNotChecked)
+		So(report.ChecksumVerified, ShouldBeFalse)
+
+		report.RecordArchive("https://example.com/report-panel.zip", archive)
+		So(report.Source, ShouldEqual, "https://example.com/report-panel.zip")
+		So(report.Sha256, ShouldEqual, checksum)
+		So(report.ChecksumVerified, ShouldBeTrue)
+		So(report.CompletedAt.Before(report.StartedAt), ShouldBeFalse)
+
+		Convey("but not a checksum verification without expected checksum", func() {
+			unverified := NewVerificationReport("report-panel", "1.0.0", "")
+			unverified.RecordStream("https://example.com/report-panel.zip", checksum)
+			So(unverified.Sha256, ShouldEqual, checksum)
+			So(unverified.ChecksumVerified, ShouldBeFalse)
+		})
+
+		Convey("and are written to sinks", func() {
+			dir, err := ioutil.TempDir("", "reports")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			So(os.MkdirAll(filepath.Join(dir, "plugins", "report-panel"), 0755), ShouldBeNil)
+			So(PluginDirReportSink{PluginDir: filepath.Join(dir, "plugins")}.WriteReport(report), ShouldBeNil)
+			So(DirReportSink{Dir: filepath.Join(dir, "audit")}.WriteReport(report), ShouldBeNil)
+
+			for _, path := range []string{
+				filepath.Join(dir, "plugins", "report-panel", "verification-report.json"),
+				filepath.Join(dir, "audit", "report-panel-1.0.0.json"),
+			} {
+				data, err := ioutil.ReadFile(path)
+				So(err, ShouldBeNil)
+
+				var written VerificationReport
+				So(json.Unmarshal(data, &written), ShouldBeNil)
+				So(written.Sha256, ShouldEqual, checksum)
+				So(written.ChecksumVerified, ShouldBeTrue)
+			}
+		})
+	})
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// RequestIDHeader carries the correlation id on every repository request, so
+// a failed install can be matched to the access logs of a mirror.
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose repository requests carry id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id of ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request id.
+func NewRequestID() string {
+	return newUUID()
+}
+
+var defaultRequestID string
+
+// SetRequestID sets the request id of repository requests whose context
+// carries none, e.g. one id for all requests of a grafana-cli run. Without
+// it, every request gets a random id.
+func SetRequestID(id string) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	defaultRequestID = id
+}
+
+func requestID(ctx context.Context) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return id
+	}
+
+	stateMtx.RLock()
+	id := defaultRequestID
+	stateMtx.RUnlock()
+
+	if id != "" {
+		return id
+	}
+	return newUUID()
+}
+
+// setRequestID adds the request id header unless the request already has
+// one, so retries of a request keep its id.
+func setRequestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	id := requestID(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+	return id
+}
+
+// requestIDError adds the request id to a failed repository request.
+type requestIDError struct {
+	id  string
+	err error
+}
+
+func (e *requestIDError) Error() string {
+	return fmt.Sprintf("%v (request id %s)", e.err, e.id)
+}
+
+func (e *requestIDError) Unwrap() error {
+	return e.err
+}
+
+func withRequestID(id string, err error) error {
+	if err == nil || id == "" {
+		return err
+	}
+	return &requestIDError{id: id, err: err}
+}
+
+// responseRequestID returns the request id a response was requested with.
+func responseRequestID(res *http.Response) string {
+	if res == nil || res.Request == nil {
+		return ""
+	}
+	return res.Request.Header.Get(RequestIDHeader)
+}
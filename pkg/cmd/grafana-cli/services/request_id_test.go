@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestRequestID(t *testing.T) {
+	Convey("Repository requests carry a request id", t, func() {
+		var ids []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ids = append(ids, r.Header.Get(RequestIDHeader))
+			switch r.URL.Path {
+			case "/repo/id-panel":
+				w.Write([]byte(`{"id": "id-panel", "versions": [{"version": "1.0.0"}]}`))
+			default:
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+		defer server.Close()
+
+		Convey("taken from the context", func() {
+			ctx := WithRequestID(context.Background(), "support-ticket-42")
+			_, err := GetPluginWithContext(ctx, "id-panel", server.URL)
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{"support-ticket-42"})
+		})
+
+		Convey("from the configured default", func() {
+			SetRequestID("cli-run")
+			defer SetRequestID("")
+			SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+			defer SetRetryPolicy(DefaultRetryPolicy)
+
+			_, err := GetPluginWithContext(context.Background(), "other-panel", server.URL)
+			So(err, ShouldNotBeNil)
+			So(ids, ShouldResemble, []string{"cli-run"})
+		})
+
+		Convey("generated per request and reported in errors", func() {
+			SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+			defer SetRetryPolicy(DefaultRetryPolicy)
+
+			_, err := DownloadArchive("id-panel", server.URL+"/id-panel.zip")
+			So(err, ShouldNotBeNil)
+			So(ids, ShouldHaveLength, 1)
+			So(ids[0], ShouldNotBeEmpty)
+			So(err.Error(), ShouldContainSubstring, "request id "+ids[0])
+
+			var repoErr *RepoError
+			So(xerrors.As(err, &repoErr), ShouldBeTrue)
+			So(repoErr.RequestID, ShouldEqual, ids[0])
+			So(repoErr.StatusCode, ShouldEqual, http.StatusBadGateway)
+		})
+
+		Convey("forwarded by the repository proxy", func() {
+			proxy := httptest.NewServer(NewRepoProxy(server.URL, 0))
+			defer proxy.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/repo/id-panel", nil)
+			req.Header.Set(RequestIDHeader, "from-grafana")
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(ids, ShouldResemble, []string{"from-grafana"})
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/xerrors"
+)
+
+// ResolutionOutcome is the outcome of resolving a plugin version, telling
+// compatibility problems apart from infrastructure errors.
+type ResolutionOutcome string
+
+const (
+	ResolutionLatest              ResolutionOutcome = "latest"
+	ResolutionExact               ResolutionOutcome = "exact"
+	ResolutionFallbackSuggested   ResolutionOutcome = "fallback_suggested"
+	ResolutionNotFound            ResolutionOutcome = "not_found"
+	ResolutionUnsupportedArch     ResolutionOutcome = "unsupported_arch"
+	ResolutionIncompatibleGrafana ResolutionOutcome = "incompatible_grafana"
+	// ResolutionRepoError is a failure to reach or read the repository.
+	ResolutionRepoError ResolutionOutcome = "repo_error"
+	// ResolutionOther covers refusals like yanked versions or license policies.
+	ResolutionOther ResolutionOutcome = "other"
+)
+
+var resolutions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "grafana",
+	Name:      "plugin_resolutions_total",
+	Help:      "plugin version resolutions by outcome",
+}, []string{"outcome"})
+
+// ClassifyResolution returns the outcome of resolving req, err being the
+// error resolution failed with.
+func ClassifyResolution(req PluginRequest, err error) ResolutionOutcome {
+	var (
+		alt     interface{ Alternatives() []string }
+		repoErr *RepoError
+	)
+
+	switch {
+	case err == nil && req.Version == "":
+		return ResolutionLatest
+	case err == nil:
+		return ResolutionExact
+	case xerrors.Is(err, ErrArchNotSupported):
+		return ResolutionUnsupportedArch
+	case xerrors.Is(err, ErrNoCompatibleVersion):
+		return ResolutionIncompatibleGrafana
+	case xerrors.As(err, &alt) && len(alt.Alternatives()) > 0:
+		return ResolutionFallbackSuggested
+	case xerrors.Is(err, ErrVersionNotFound), xerrors.Is(err, ErrNotFoundError):
+		return ResolutionNotFound
+	case xerrors.As(err, &repoErr):
+		return ResolutionRepoError
+	}
+	return ResolutionOther
+}
+
+func countResolution(req PluginRequest, err error) {
+	resolutions.WithLabelValues(string(ClassifyResolution(req, err))).Inc()
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+	"github.com/prometheus/client_golang/prometheus"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestClassifyResolution(t *testing.T) {
+	Convey("Resolution outcomes tell compatibility from infrastructure problems", t, func() {
+		latest := PluginRequest{PluginID: "test-panel"}
+		exact := PluginRequest{PluginID: "test-panel", Version: "1.0.0"}
+
+		So(ClassifyResolution(latest, nil), ShouldEqual, ResolutionLatest)
+		So(ClassifyResolution(exact, nil), ShouldEqual, ResolutionExact)
+
+		So(ClassifyResolution(exact, &ArchNotSupportedError{PluginID: "test-panel", Older: "0.9.0"}), ShouldEqual, ResolutionUnsupportedArch)
+		So(ClassifyResolution(latest, xerrors.Errorf("test-panel: %w", ErrNoCompatibleVersion)), ShouldEqual, ResolutionIncompatibleGrafana)
+		So(ClassifyResolution(exact, &VersionNotFoundError{PluginID: "test-panel", Version: "1.0.0", Newer: "1.1.0"}), ShouldEqual, ResolutionFallbackSuggested)
+		So(ClassifyResolution(exact, &VersionNotFoundError{PluginID: "test-panel", Version: "1.0.0"}), ShouldEqual, ResolutionNotFound)
+
+		notFound := repoError(OpGetPlugin, "test-panel", "https://example.com/repo/test-panel", ErrNotFoundError)
+		So(ClassifyResolution(latest, notFound), ShouldEqual, ResolutionNotFound)
+
+		unavailable := repoError(OpGetPlugin, "test-panel", "https://example.com/repo/test-panel", &gcom.StatusError{StatusCode: 503})
+		So(ClassifyResolution(latest, unavailable), ShouldEqual, ResolutionRepoError)
+
+		So(ClassifyResolution(exact, xerrors.Errorf("test-panel@1.0.0: %w", ErrVersionYanked)), ShouldEqual, ResolutionOther)
+		So(ClassifyResolution(exact, errors.New("refused")), ShouldEqual, ResolutionOther)
+	})
+}
+
+func TestResolutionMetrics(t *testing.T) {
+	Convey("Resolutions are counted by outcome", t, func() {
+		reg := prometheus.NewRegistry()
+		So(RegisterMetrics(reg), ShouldBeNil)
+
+		before := counterValue(resolutions.WithLabelValues(string(ResolutionExact)))
+		countResolution(PluginRequest{PluginID: "test-panel", Version: "1.0.0"}, nil)
+		So(counterValue(resolutions.WithLabelValues(string(ResolutionExact))), ShouldEqual, before+1)
+
+		families, err := reg.Gather()
+		So(err, ShouldBeNil)
+		var names []string
+		for _, f := range families {
+			names = append(names, f.GetName())
+		}
+		So(names, ShouldContain, "grafana_plugin_resolutions_total")
+	})
+}
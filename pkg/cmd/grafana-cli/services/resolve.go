@@ -0,0 +1,714 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+	"github.com/hashicorp/go-version"
+	"golang.org/x/xerrors"
+)
+
+var (
+	ErrVersionNotFound     = errors.New("Could not find the version you're looking for")
+	ErrVersionYanked       = errors.New("version has been yanked from the repository")
+	ErrArchNotSupported    = errors.New("plugin version does not support this os and architecture")
+	ErrNoCompatibleVersion = errors.New("no plugin version is compatible with all requested Grafana versions")
+)
+
+// ArchNotSupportedError is returned when versions of a plugin exist but none
+// of the matching ones publish an archive for this os and architecture.
+type ArchNotSupportedError struct {
+	PluginID string
+	// Version is empty when no explicit version was requested.
+	Version        string
+	Arch           string
+	SupportedArchs []string
+	// LatestSupported is the newest version installable on Arch, if any.
+	LatestSupported string
+	// Older and Newer are the installable versions closest to Version.
+	Older string
+	Newer string
+}
+
+func (e *ArchNotSupportedError) Error() string {
+	msg := fmt.Sprintf("%s has no %s build", e.PluginID, e.Arch)
+	if e.Version != "" {
+		msg = fmt.Sprintf("%s@%s has no %s build", e.PluginID, e.Version, e.Arch)
+	}
+	if len(e.SupportedArchs) > 0 {
+		msg += fmt.Sprintf(", supported: %s", strings.Join(e.SupportedArchs, ", "))
+	}
+	if e.LatestSupported != "" {
+		msg += fmt.Sprintf("; latest %s-capable version is %s", e.Arch, e.LatestSupported)
+	}
+	return msg + alternativesMessage(e.Older, e.Newer)
+}
+
+func (e *ArchNotSupportedError) Unwrap() error {
+	return ErrArchNotSupported
+}
+
+// Alternatives returns the installable versions closest to the requested one.
+func (e *ArchNotSupportedError) Alternatives() []string {
+	return alternatives(e.Older, e.Newer)
+}
+
+// VersionNotFoundError is returned when an explicitly requested version does
+// not exist. Older and Newer are the closest installable versions, if any, so
+// callers can offer them instead.
+type VersionNotFoundError struct {
+	PluginID string
+	Version  string
+	Older    string
+	Newer    string
+}
+
+func (e *VersionNotFoundError) Error() string {
+	return fmt.Sprintf("%v: %s@%s", ErrVersionNotFound, e.PluginID, e.Version) + alternativesMessage(e.Older, e.Newer)
+}
+
+func (e *VersionNotFoundError) Unwrap() error {
+	return ErrVersionNotFound
+}
+
+// Alternatives returns the installable versions closest to the requested one.
+func (e *VersionNotFoundError) Alternatives() []string {
+	return alternatives(e.Older, e.Newer)
+}
+
+func alternatives(older, newer string) []string {
+	var result []string
+	for _, v := range []string{older, newer} {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func alternativesMessage(older, newer string) string {
+	switch {
+	case older != "" && newer != "":
+		return fmt.Sprintf("; nearest installable versions are %s and %s", older, newer)
+	case older != "":
+		return fmt.Sprintf("; nearest installable version is %s", older)
+	case newer != "":
+		return fmt.Sprintf("; nearest installable version is %s", newer)
+	}
+	return ""
+}
+
+var refuseDeprecated bool
+
+// SetRefuseDeprecated makes resolution fail for deprecated or end of life
+// plugins.
+func SetRefuseDeprecated(value bool) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	refuseDeprecated = value
+}
+
+func getRefuseDeprecated() bool {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return refuseDeprecated
+}
+
+// PluginRequest identifies a plugin version to resolve. An empty Version
+// resolves the latest version.
+type PluginRequest struct {
+	// PluginID may be prefixed with a namespace, e.g. "internal/clock-panel".
+	PluginID string
+	// Namespace resolves the plugin from the repository registered with
+	// SetNamespaces instead of the one passed to Resolve.
+	Namespace string
+	Version   string
+	// AllowYanked permits installing a yanked version when it is requested explicitly.
+	AllowYanked bool
+	// AsOf resolves the newest version published before the given time, to
+	// reproduce historical environments.
+	AsOf time.Time
+	// Extras names the optional components to resolve, "*" selects all of them.
+	Extras []string
+	// Force skips cached metadata and fetches it from the repository again.
+	Force bool
+	// Build selects the version built from this commit instead of Version,
+	// to pin to a specific CI build of an internal repository.
+	Build string
+	// GrafanaVersions restricts the latest version to those compatible with
+	// every listed Grafana version, to pick one version for a mixed fleet.
+	GrafanaVersions []string
+	// SafeUpdate is the installed version of the plugin, to restrict the
+	// latest version to patch releases of its major.minor. Admins trusting
+	// patches can apply them unattended and review minor and major releases.
+	SafeUpdate string
+	// Exclude lists versions or version constraints that must not be picked,
+	// in addition to those configured with SetVersionExclusions.
+	Exclude []string
+	// Edition and Entitlements describe the instance the plugin is resolved
+	// for, overriding those configured with SetEdition.
+	Edition      Edition
+	Entitlements []string
+	// Digest pins the sha256 or md5 digest of the archive. Resolution fails
+	// with a DigestMismatchError when the repository publishes another one.
+	Digest string
+}
+
+// Resolution is a plugin version resolved to a downloadable archive. Checksum
+// is empty when the repository publishes none.
+type Resolution struct {
+	// Namespace is the repository namespace the plugin was resolved from, if any.
+	Namespace string
+	// RepoURL is the repository the plugin was resolved from, a failover
+	// repository when the configured one was unhealthy or failed.
+	RepoURL string
+	Plugin  m.Plugin
+	Version m.Version
+	URL     string
+	// Archive is the arch key of the archive URL points to, e.g.
+	// "linux-amd64" or "any", empty when the version publishes no archive
+	// of its own and the repository download url is used.
+	Archive  string
+	Checksum string
+	Extras   []ResolvedExtra
+	// Size is the size of the archive in bytes, 0 when the repository
+	// publishes none. Backend is set when the archive ships a backend
+	// binary and Permissions are the Grafana permissions the plugin
+	// requires, so users can be asked for consent before downloading.
+	Size        int64
+	Backend     bool
+	Permissions []string
+	// FromCache is set when the metadata was served from the cache, fetched
+	// by the repository at MetadataFetchedAt.
+	FromCache         bool
+	MetadataFetchedAt time.Time
+	// Stale is set when the metadata expired but was served anyway because
+	// the repository was unreachable, see SetStaleMetadataMaxAge.
+	Stale bool
+	// Candidates are all versions of the plugin with the reason they were
+	// rejected, see Explain.
+	Candidates []Candidate
+	// NewestWithoutArch is the newest version that was skipped for the
+	// latest one because it has no archive for this host, see
+	// ArchNotSupportedError.
+	NewestWithoutArch string
+}
+
+// QualifiedID is the plugin id prefixed with its namespace, if any.
+func (r Resolution) QualifiedID() string {
+	if r.Namespace == "" {
+		return r.Plugin.Id
+	}
+	return r.Namespace + "/" + r.Plugin.Id
+}
+
+// DownloadSize is the size of the archive and the selected extras in bytes,
+// 0 when any of them is unknown.
+func (r Resolution) DownloadSize() int64 {
+	if r.Size == 0 {
+		return 0
+	}
+
+	size := r.Size
+	for _, extra := range r.Extras {
+		if extra.Size == 0 {
+			return 0
+		}
+		size += extra.Size
+	}
+	return size
+}
+
+// MetadataAge is how old the metadata the resolution is based on is, 0 when unknown.
+func (r Resolution) MetadataAge() time.Duration {
+	if r.MetadataFetchedAt.IsZero() {
+		return 0
+	}
+	return getClock().Since(r.MetadataFetchedAt)
+}
+
+// Resolve looks up the requested plugin version in the repository and returns
+// the archive to download without downloading it.
+func Resolve(repoUrl string, req PluginRequest) (Resolution, error) {
+	return ResolveWithContext(context.Background(), repoUrl, req)
+}
+
+// ResolveWithContext is Resolve bound to ctx.
+func ResolveWithContext(ctx context.Context, repoUrl string, req PluginRequest) (Resolution, error) {
+	res, err := resolveHealthiest(ctx, repoUrl, req)
+	countResolution(req, err)
+	return res, err
+}
+
+func resolve(ctx context.Context, repoUrl string, req PluginRequest) (Resolution, error) {
+	repoUrl, req, err := withNamespace(repoUrl, req)
+	if err != nil {
+		return Resolution{}, err
+	}
+
+	if req.Force {
+		invalidateMetadata(req.PluginID, repoUrl)
+	}
+
+	md, err := getVersionsPage(ctx, req.PluginID, repoUrl)
+	if err != nil {
+		return Resolution{}, err
+	}
+	plugin := md.plugin
+
+	if err := CheckDeprecation(plugin, getRefuseDeprecated()); err != nil {
+		return Resolution{}, err
+	}
+	if err := getLicensePolicy().Check(plugin); err != nil {
+		return Resolution{}, err
+	}
+
+	v, md, err := selectPagedVersion(ctx, repoUrl, req, md)
+	if err != nil {
+		return Resolution{}, err
+	}
+
+	res, err := newResolution(ctx, repoUrl, req, md, v)
+	if err != nil {
+		return Resolution{}, err
+	}
+	res.NewestWithoutArch = newestWithoutArch(md.plugin, req, v)
+	if res.NewestWithoutArch != "" {
+		log.Debugf("%v@%v has no archive for %v, resolved %v\n", req.PluginID, res.NewestWithoutArch, osAndArchString(), v.Version)
+	}
+	return res, nil
+}
+
+// newestWithoutArch returns the newest version latest would have resolved to
+// instead of selected if it had an archive for this host, empty when there is
+// none or a version was requested explicitly.
+func newestWithoutArch(plugin m.Plugin, req PluginRequest, selected m.Version) string {
+	if req.Version != "" || req.Build != "" {
+		return ""
+	}
+
+	for _, v := range plugin.Versions {
+		if v.Version == selected.Version {
+			return ""
+		}
+		if isLatestCandidate(v, req) && !supportsArch(v) {
+			return v.Version
+		}
+	}
+	return ""
+}
+
+// ResolveDowngrade picks the newest version strictly older than
+// currentVersion that matches constraint, e.g. to roll back one release. An
+// empty constraint accepts any older version. Yanked and excluded versions
+// are skipped.
+func ResolveDowngrade(ctx context.Context, repoUrl, pluginId, currentVersion, constraint string) (Resolution, error) {
+	repoUrl, req, err := withNamespace(repoUrl, PluginRequest{PluginID: pluginId})
+	if err != nil {
+		return Resolution{}, err
+	}
+	pluginId = req.PluginID
+
+	current, err := version.NewVersion(currentVersion)
+	if err != nil {
+		return Resolution{}, fmt.Errorf("invalid current version %q: %v", currentVersion, err)
+	}
+
+	var constraints version.Constraints
+	if constraint != "" {
+		if constraints, err = parseConstraint(constraint); err != nil {
+			return Resolution{}, fmt.Errorf("invalid version constraint %q: %v", constraint, err)
+		}
+	}
+
+	md, err := getPluginMetadata(ctx, pluginId, repoUrl)
+	if err != nil {
+		return Resolution{}, err
+	}
+	if err := getLicensePolicy().Check(md.plugin); err != nil {
+		return Resolution{}, err
+	}
+
+	var best *version.Version
+	var selected m.Version
+	for _, v := range md.plugin.Versions {
+		if !isLatestCandidate(v, req) || !supportsArch(v) {
+			continue
+		}
+
+		candidate, err := version.NewVersion(v.Version)
+		if err != nil || !candidate.LessThan(current) || constraints != nil && !constraints.Check(candidate) {
+			continue
+		}
+
+		if best == nil || candidate.GreaterThan(best) {
+			best, selected = candidate, v
+		}
+	}
+
+	if best == nil {
+		return Resolution{}, xerrors.Errorf("no version of %s older than %s: %w", pluginId, currentVersion, ErrVersionNotFound)
+	}
+
+	res, err := newResolution(ctx, repoUrl, req, md, selected)
+	for i, c := range res.Candidates {
+		if c.Rejected != "not selected" {
+			continue
+		}
+		if candidate, err := version.NewVersion(c.Version); err == nil && candidate.LessThan(current) {
+			res.Candidates[i].Rejected = "does not match " + constraint
+		} else {
+			res.Candidates[i].Rejected = "not older than " + currentVersion
+		}
+	}
+	return res, err
+}
+
+// withNamespace moves a namespace prefix of the plugin id into the request and
+// returns the repository to resolve it from.
+func withNamespace(repoUrl string, req PluginRequest) (string, PluginRequest, error) {
+	if req.Namespace == "" {
+		req.Namespace, req.PluginID = SplitPluginRef(req.PluginID)
+	}
+
+	repo, err := namespaceRepo(req.Namespace, repoUrl)
+	return repo, req, err
+}
+
+func newResolution(ctx context.Context, repoUrl string, req PluginRequest, md pluginMetadata, v m.Version) (Resolution, error) {
+	if IsHeavyPlugin(md.plugin) {
+		markHeavy(req.PluginID)
+	}
+
+	url := DownloadURL(resolveRepoURL(repoUrl), req.PluginID, v.Version)
+	archive := ""
+	key, meta, ok := SelectArchive(v)
+	if ok && meta.Url != "" {
+		url, archive = meta.Url, key
+	}
+	log.Debugf("using %v archive of %v@%v: %v\n", archiveName(archive), req.PluginID, v.Version, url)
+
+	checksum, err := GetChecksumWithContext(ctx, req.PluginID, v, url)
+	if err != nil && err != ErrChecksumNotFound {
+		return Resolution{}, err
+	}
+	if checksum, err = pinnedChecksum(req, v, checksum); err != nil {
+		return Resolution{}, err
+	}
+
+	extras, err := SelectExtras(resolveRepoURL(repoUrl), req.PluginID, v, req.Extras)
+	if err != nil {
+		return Resolution{}, err
+	}
+
+	return Resolution{
+		Namespace:         req.Namespace,
+		RepoURL:           repoUrl,
+		Plugin:            md.plugin,
+		Version:           v,
+		URL:               url,
+		Archive:           archive,
+		Checksum:          checksum,
+		Extras:            extras,
+		Size:              meta.Size,
+		Backend:           shipsBackend(v, key),
+		Permissions:       v.Permissions,
+		FromCache:         md.cached,
+		MetadataFetchedAt: md.fetchedAt,
+		Stale:             md.stale,
+		Candidates:        ExplainVersions(md.plugin, req, v.Version),
+	}, nil
+}
+
+// archiveName names the archive of arch key for humans.
+func archiveName(key string) string {
+	if key == "" {
+		return "default"
+	}
+	return key
+}
+
+// shipsBackend reports whether the archive published under arch key ships a
+// backend binary. Repositories only publish os specific archives of backend
+// plugins, so those count even when the version does not say so.
+func shipsBackend(v m.Version, key string) bool {
+	switch key {
+	case FrontendOnlyVariant:
+		return false
+	case "", "any":
+		return v.Backend
+	}
+	return true
+}
+
+// ResolveURLs resolves the final download urls and checksums of all requests
+// from the configured repository, so the archives can be fetched by external
+// tooling.
+func ResolveURLs(ctx context.Context, requests []PluginRequest) ([]Resolution, error) {
+	repoUrl := RepoURL()
+	result := make([]Resolution, 0, len(requests))
+
+	for _, req := range requests {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		res, err := ResolveWithContext(ctx, repoUrl, req)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to resolve %s: %w", req.PluginID, err)
+		}
+
+		result = append(result, res)
+	}
+
+	return result, nil
+}
+
+// DownloadURL returns the repository download url of a plugin version.
+func DownloadURL(repoUrl, pluginId, version string) string {
+	if IsStaticRepo(repoUrl) {
+		return staticArchiveURL(repoUrl, pluginId, version)
+	}
+	c := gcom.Client{BaseURL: repoUrl}
+	return c.DownloadURL(pluginId, version)
+}
+
+// isLatestCandidate reports whether v may be picked when no explicit version
+// is requested. Versions are listed newest first by the repository.
+func isLatestCandidate(v m.Version, req PluginRequest) bool {
+	return isEligible(v, req) && checkEdition(req.PluginID, v, req) == nil
+}
+
+// IsUpgradeCandidate reports whether v of pluginId may be installed as the
+// latest version, i.e. it is not yanked, excluded or of another edition.
+func IsUpgradeCandidate(pluginId string, v m.Version) bool {
+	return isLatestCandidate(v, PluginRequest{PluginID: pluginId})
+}
+
+// isEligible is isLatestCandidate without the edition check.
+func isEligible(v m.Version, req PluginRequest) bool {
+	if v.Yanked || isExcluded(req.PluginID, v, req) {
+		return false
+	}
+
+	if !req.AsOf.IsZero() && (v.CreatedAt.IsZero() || !v.CreatedAt.Before(req.AsOf)) {
+		return false
+	}
+
+	return compatibleWithAll(v, req.GrafanaVersions) && isPatchOf(v, req.SafeUpdate)
+}
+
+// IsCompatible reports whether the plugin version supports grafanaVersion.
+// Versions without a Grafana dependency are assumed to support any version.
+func IsCompatible(v m.Version, grafanaVersion string) (bool, error) {
+	if v.GrafanaDependency == "" {
+		return true, nil
+	}
+
+	constraints, err := parseConstraint(v.GrafanaDependency)
+	if err != nil {
+		return false, fmt.Errorf("invalid grafana dependency %q of %s: %v", v.GrafanaDependency, v.Version, err)
+	}
+
+	gv, err := version.NewVersion(grafanaVersion)
+	if err != nil {
+		return false, fmt.Errorf("invalid grafana version %q: %v", grafanaVersion, err)
+	}
+
+	return constraints.Check(gv), nil
+}
+
+// compatibleWithAll reports whether v supports all of grafanaVersions. Like
+// versions without a Grafana dependency, those whose dependency cannot be
+// parsed are of unknown compatibility and not ruled out.
+func compatibleWithAll(v m.Version, grafanaVersions []string) bool {
+	for _, gv := range grafanaVersions {
+		if _, err := version.NewVersion(gv); err != nil {
+			log.Debugf("invalid grafana version %q: %v\n", gv, err)
+			return false
+		}
+
+		ok, err := IsCompatible(v, gv)
+		if err != nil {
+			log.Debugf("assuming compatibility, %v\n", err)
+			continue
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isPatchOf reports whether v is installed or a later patch release of its
+// major.minor. Any version is when installed is empty, none when it cannot be
+// parsed.
+func isPatchOf(v m.Version, installed string) bool {
+	if installed == "" {
+		return true
+	}
+
+	current, err := version.NewVersion(installed)
+	if err != nil {
+		return false
+	}
+	candidate, err := version.NewVersion(v.Version)
+	if err != nil {
+		return false
+	}
+
+	cs, vs := current.Segments(), candidate.Segments()
+	return cs[0] == vs[0] && cs[1] == vs[1] && !candidate.LessThan(current)
+}
+
+// SelectVersion picks the requested version or build of plugin, or the latest
+// version that has not been yanked or excluded and matches the request when
+// neither is requested.
+func SelectVersion(plugin m.Plugin, req PluginRequest) (m.Version, error) {
+	if req.PluginID == "" {
+		req.PluginID = plugin.Id
+	}
+
+	if req.Version == "" && req.Build == "" {
+		var newest *m.Version
+		for i, v := range plugin.Versions {
+			if !isLatestCandidate(v, req) {
+				continue
+			}
+			if supportsArch(v) {
+				return v, nil
+			}
+			if newest == nil {
+				newest = &plugin.Versions[i]
+			}
+		}
+
+		if newest != nil {
+			return m.Version{}, newArchNotSupportedError(plugin, *newest, req)
+		}
+		for _, v := range plugin.Versions {
+			// only versions of other editions are left
+			if isEligible(v, req) {
+				return m.Version{}, checkEdition(plugin.Id, v, req)
+			}
+		}
+		if len(req.GrafanaVersions) > 0 && len(plugin.Versions) > 0 {
+			return m.Version{}, xerrors.Errorf("%s (%s): %w", plugin.Id, strings.Join(req.GrafanaVersions, ", "), ErrNoCompatibleVersion)
+		}
+		return m.Version{}, ErrVersionNotFound
+	}
+
+	for _, v := range plugin.Versions {
+		if !matchesRequest(v, req) {
+			continue
+		}
+
+		if isExcluded(req.PluginID, v, req) {
+			return m.Version{}, xerrors.Errorf("%s@%s: %w", plugin.Id, v.Version, ErrVersionExcluded)
+		}
+
+		if v.Yanked && !req.AllowYanked {
+			reason := ""
+			if v.YankReason != "" {
+				reason = " (" + v.YankReason + ")"
+			}
+			return m.Version{}, xerrors.Errorf("%s@%s%s, use --allowYanked to install it anyway: %w", plugin.Id, v.Version, reason, ErrVersionYanked)
+		}
+
+		if err := checkEdition(plugin.Id, v, req); err != nil {
+			return m.Version{}, err
+		}
+
+		if !supportsArch(v) {
+			err := newArchNotSupportedError(plugin, v, req)
+			err.Version = v.Version
+			err.Older, err.Newer = nearestVersions(plugin, v.Version, req)
+			return m.Version{}, err
+		}
+
+		return v, nil
+	}
+
+	if req.Version == "" {
+		return m.Version{}, ErrVersionNotFound
+	}
+
+	err := &VersionNotFoundError{PluginID: plugin.Id, Version: req.Version}
+	err.Older, err.Newer = nearestVersions(plugin, req.Version, req)
+	return m.Version{}, err
+}
+
+// nearestVersions returns the closest versions below and above requested
+// that could be installed instead. Either is empty when there is none or
+// requested is not a valid version.
+func nearestVersions(plugin m.Plugin, requested string, req PluginRequest) (older string, newer string) {
+	target, err := version.NewVersion(requested)
+	if err != nil {
+		return "", ""
+	}
+
+	var below, above *version.Version
+	for _, v := range plugin.Versions {
+		if !isLatestCandidate(v, req) || !supportsArch(v) {
+			continue
+		}
+
+		candidate, err := version.NewVersion(v.Version)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case candidate.LessThan(target) && (below == nil || candidate.GreaterThan(below)):
+			below, older = candidate, v.Version
+		case candidate.GreaterThan(target) && (above == nil || candidate.LessThan(above)):
+			above, newer = candidate, v.Version
+		}
+	}
+
+	return older, newer
+}
+
+func matchesRequest(v m.Version, req PluginRequest) bool {
+	if req.Build != "" {
+		return v.Commit != "" && strings.EqualFold(v.Commit, req.Build)
+	}
+	return v.Version == req.Version
+}
+
+// supportsArch reports whether v can be installed on this host. Versions
+// without arch metadata, like GitHub zipballs, are assumed to run anywhere.
+func supportsArch(v m.Version) bool {
+	if len(v.Arch) == 0 {
+		return true
+	}
+
+	_, _, ok := SelectArchive(v)
+	return ok
+}
+
+func newArchNotSupportedError(plugin m.Plugin, v m.Version, req PluginRequest) *ArchNotSupportedError {
+	err := &ArchNotSupportedError{PluginID: plugin.Id, Arch: osAndArchString()}
+
+	for arch := range v.Arch {
+		err.SupportedArchs = append(err.SupportedArchs, arch)
+	}
+	sort.Strings(err.SupportedArchs)
+
+	for _, candidate := range plugin.Versions {
+		if isLatestCandidate(candidate, req) && supportsArch(candidate) {
+			err.LatestSupported = candidate.Version
+			break
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,651 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestSelectVersion(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "2.0.0", Yanked: true, YankReason: "breaks dashboards"},
+		{Version: "1.0.0"},
+	}}
+
+	Convey("Yanked versions are never picked automatically", t, func() {
+		v, err := SelectVersion(plugin, PluginRequest{PluginID: "test-plugin"})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.0.0")
+	})
+
+	Convey("Yanked versions require an explicit opt-in", t, func() {
+		_, err := SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", Version: "2.0.0"})
+		So(xerrors.Is(err, ErrVersionYanked), ShouldBeTrue)
+
+		v, err := SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", Version: "2.0.0", AllowYanked: true})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "2.0.0")
+	})
+}
+
+func TestSelectVersionSafeUpdate(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "2.0.0"},
+		{Version: "1.3.0"},
+		{Version: "1.2.5", Yanked: true},
+		{Version: "1.2.4"},
+		{Version: "1.2.3"},
+	}}
+
+	Convey("Safe updates pick the newest patch of the installed major.minor", t, func() {
+		req := PluginRequest{PluginID: "test-plugin", SafeUpdate: "1.2.3"}
+		v, err := SelectVersion(plugin, req)
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.2.4")
+		So(ExplainVersions(plugin, req, v.Version)[1].Rejected, ShouldEqual, "not a patch release of 1.2.3")
+
+		v, err = SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", SafeUpdate: "1.3.0"})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.3.0")
+	})
+}
+
+func TestSelectVersionBuild(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "1.1.0", Commit: "7c1f5e2a9b"},
+		{Version: "1.1.0-nightly", Commit: "a41d0be37f"},
+		{Version: "1.0.0"},
+	}}
+
+	Convey("Select a version by the commit it was built from", t, func() {
+		v, err := SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", Build: "A41D0BE37F"})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.1.0-nightly")
+
+		_, err = SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", Build: "deadbeef"})
+		So(xerrors.Is(err, ErrVersionNotFound), ShouldBeTrue)
+	})
+}
+
+func TestSelectVersionCompatibility(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "3.0.0", GrafanaDependency: ">=6.4.0"},
+		{Version: "2.0.0", GrafanaDependency: ">=6.0.0, <6.5.0"},
+		{Version: "1.0.0"},
+	}}
+
+	Convey("Select the newest version compatible with every Grafana version", t, func() {
+		v, err := SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", GrafanaVersions: []string{"6.4.2"}})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "3.0.0")
+
+		v, err = SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", GrafanaVersions: []string{"6.3.0", "6.4.2"}})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "2.0.0")
+
+		v, err = SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", GrafanaVersions: []string{"5.4.0", "6.5.0"}})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.0.0")
+	})
+
+	Convey("Report when no version fits the whole fleet", t, func() {
+		strict := m.Plugin{Id: "test-plugin", Versions: plugin.Versions[:2]}
+		_, err := SelectVersion(strict, PluginRequest{PluginID: "test-plugin", GrafanaVersions: []string{"5.4.0", "6.5.0"}})
+		So(xerrors.Is(err, ErrNoCompatibleVersion), ShouldBeTrue)
+	})
+}
+
+func TestSelectVersionAsOf(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2019, 5, d, 0, 0, 0, 0, time.UTC) }
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "1.2.0", CreatedAt: m.Timestamp{Time: day(20)}},
+		{Version: "1.1.0", CreatedAt: m.Timestamp{Time: day(10)}},
+		{Version: "1.0.0", CreatedAt: m.Timestamp{Time: day(1)}},
+	}}
+
+	Convey("Select the newest version published before a date", t, func() {
+		v, err := SelectVersion(plugin, PluginRequest{AsOf: day(15)})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.1.0")
+
+		_, err = SelectVersion(plugin, PluginRequest{AsOf: day(1)})
+		So(err, ShouldEqual, ErrVersionNotFound)
+	})
+
+	Convey("Publish dates that are empty or not RFC3339 do not fail decoding", t, func() {
+		var decoded m.Plugin
+		err := json.Unmarshal([]byte(`{"id": "test-plugin", "updatedAt": "", "versions": [
+			{"version": "1.3.0", "createdAt": "soon"},
+			{"version": "1.2.0", "createdAt": null},
+			{"version": "1.1.0", "createdAt": "2019-05-10"},
+			{"version": "1.0.0", "createdAt": "2019-05-01 12:00:00"}]}`), &decoded)
+		So(err, ShouldBeNil)
+		So(decoded.UpdatedAt.IsZero(), ShouldBeTrue)
+		So(decoded.Versions[0].CreatedAt.IsZero(), ShouldBeTrue)
+		So(decoded.Versions[1].CreatedAt.IsZero(), ShouldBeTrue)
+		So(decoded.Versions[2].CreatedAt.Time, ShouldResemble, day(10))
+
+		v, err := SelectVersion(decoded, PluginRequest{AsOf: day(15)})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.1.0")
+	})
+}
+
+func TestSelectVersionArch(t *testing.T) {
+	other := map[string]m.ArchMeta{"plan9-386": {}}
+	own := map[string]m.ArchMeta{osAndArchString(): {}}
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "2.0.0", Arch: other},
+		{Version: "1.0.0", Arch: own},
+	}}
+
+	Convey("Latest picks the newest version supporting this arch", t, func() {
+		v, err := SelectVersion(plugin, PluginRequest{})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "1.0.0")
+	})
+
+	Convey("Explicit versions without a build for this arch", t, func() {
+		_, err := SelectVersion(plugin, PluginRequest{Version: "2.0.0"})
+		So(xerrors.Is(err, ErrArchNotSupported), ShouldBeTrue)
+
+		archErr := err.(*ArchNotSupportedError)
+		So(archErr.SupportedArchs, ShouldResemble, []string{"plan9-386"})
+		So(archErr.LatestSupported, ShouldEqual, "1.0.0")
+		So(archErr.Alternatives(), ShouldResemble, []string{"1.0.0"})
+	})
+}
+
+func TestSelectVersionAlternatives(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "2.1.0", Yanked: true},
+		{Version: "2.0.0"},
+		{Version: "1.2.0"},
+		{Version: "1.0.0"},
+	}}
+
+	Convey("Missing versions report the nearest installable versions", t, func() {
+		_, err := SelectVersion(plugin, PluginRequest{Version: "1.5.0"})
+		So(xerrors.Is(err, ErrVersionNotFound), ShouldBeTrue)
+
+		var notFound *VersionNotFoundError
+		So(xerrors.As(err, &notFound), ShouldBeTrue)
+		So(notFound.Older, ShouldEqual, "1.2.0")
+		So(notFound.Newer, ShouldEqual, "2.0.0")
+		So(err.Error(), ShouldContainSubstring, "nearest installable versions are 1.2.0 and 2.0.0")
+
+		_, err = SelectVersion(plugin, PluginRequest{Version: "3.0.0"})
+		So(xerrors.As(err, &notFound), ShouldBeTrue)
+		So(notFound.Alternatives(), ShouldResemble, []string{"2.0.0"})
+	})
+}
+
+func TestResolveURLs(t *testing.T) {
+	Convey("Resolve download urls without downloading", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/resolve-plugin":
+				w.Write([]byte(`{"id": "resolve-plugin", "versions": [
+					{"version": "2.0.0", "arch": {"any": {"sha256": "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353"}}},
+					{"version": "1.0.0"}
+				]}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		prevRepoURL := RepoURL()
+		setRepoURL(server.URL)
+		defer setRepoURL(prevRepoURL)
+
+		res, err := ResolveURLs(context.Background(), []PluginRequest{
+			{PluginID: "resolve-plugin"},
+			{PluginID: "resolve-plugin", Version: "1.0.0"},
+		})
+		So(err, ShouldBeNil)
+		So(res, ShouldHaveLength, 2)
+		So(res[0].URL, ShouldEqual, server.URL+"/resolve-plugin/versions/2.0.0/download")
+		So(res[0].Checksum, ShouldEqual, "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353")
+		So(res[1].URL, ShouldEqual, server.URL+"/resolve-plugin/versions/1.0.0/download")
+		So(res[1].Checksum, ShouldBeEmpty)
+
+		_, err = ResolveURLs(context.Background(), []PluginRequest{{PluginID: "resolve-plugin", Version: "3.0.0"}})
+		So(xerrors.Is(err, ErrVersionNotFound), ShouldBeTrue)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = ResolveURLs(ctx, []PluginRequest{{PluginID: "resolve-plugin"}})
+		So(xerrors.Is(err, context.Canceled), ShouldBeTrue)
+	})
+}
+
+func TestResolvePinnedDigest(t *testing.T) {
+	Convey("Resolving with a pinned digest", t, func() {
+		published := "6e76fe095b525e1d5cbc73edaa871e0a7bb90026cfdee398e0fc4f35e0866353"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/pinned-plugin":
+				w.Write([]byte(`{"id": "pinned-plugin", "versions": [
+					{"version": "2.0.0", "arch": {"any": {"sha256": "` + published + `"}}},
+					{"version": "1.0.0"}
+				]}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		Convey("accepts the published digest", func() {
+			res, err := Resolve(server.URL, PluginRequest{PluginID: "pinned-plugin", Version: "2.0.0", Digest: "sha256:" + strings.ToUpper(published)})
+			So(err, ShouldBeNil)
+			So(res.Checksum, ShouldEqual, published)
+		})
+
+		Convey("fails when the version was republished", func() {
+			pinned := strings.Repeat("ab", 32)
+			_, err := Resolve(server.URL, PluginRequest{PluginID: "pinned-plugin", Version: "2.0.0", Digest: pinned})
+			So(xerrors.Is(err, ErrDigestMismatch), ShouldBeTrue)
+
+			var mismatch *DigestMismatchError
+			So(xerrors.As(err, &mismatch), ShouldBeTrue)
+			So(mismatch.Expected, ShouldEqual, pinned)
+			So(mismatch.Published, ShouldEqual, published)
+		})
+
+		Convey("verifies versions without a published digest against the pin", func() {
+			pinned := strings.Repeat("cd", 32)
+			res, err := Resolve(server.URL, PluginRequest{PluginID: "pinned-plugin", Version: "1.0.0", Digest: pinned})
+			So(err, ShouldBeNil)
+			So(res.Checksum, ShouldEqual, pinned)
+		})
+	})
+}
+
+func TestResolveArchive(t *testing.T) {
+	Convey("Resolutions record the archive chosen for this host", t, func() {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/arch-plugin":
+				fmt.Fprintf(w, `{"id": "arch-plugin", "versions": [
+					{"version": "2.0.0", "arch": {"test-arch": {"url": "%[1]s/test-arch.zip"}, "any": {"url": "%[1]s/any.zip"}}},
+					{"version": "1.0.0", "arch": {"any": {"url": "%[1]s/any.zip"}}},
+					{"version": "0.9.0"}
+				]}`, server.URL)
+			case "/repo/ported-plugin":
+				fmt.Fprintf(w, `{"id": "ported-plugin", "versions": [
+					{"version": "2.0.0", "arch": {"plan9-386": {"url": "%[1]s/plan9-386.zip"}}},
+					{"version": "1.0.0", "arch": {"test-arch": {"url": "%[1]s/test-arch.zip"}}}
+				]}`, server.URL)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		SetArchOverride([]string{"test-arch"})
+		defer SetArchOverride(nil)
+
+		res, err := Resolve(server.URL, PluginRequest{PluginID: "arch-plugin"})
+		So(err, ShouldBeNil)
+		So(res.NewestWithoutArch, ShouldBeEmpty)
+		So(res.Archive, ShouldEqual, "test-arch")
+		So(res.URL, ShouldEqual, server.URL+"/test-arch.zip")
+		So(res.Explain(), ShouldEndWith, "archive: test-arch ("+server.URL+"/test-arch.zip)\n")
+
+		res, err = Resolve(server.URL, PluginRequest{PluginID: "arch-plugin", Version: "1.0.0"})
+		So(err, ShouldBeNil)
+		So(res.Archive, ShouldEqual, "any")
+
+		Convey("and the newer versions skipped for lacking one", func() {
+			res, err := Resolve(server.URL, PluginRequest{PluginID: "ported-plugin"})
+			So(err, ShouldBeNil)
+			So(res.Version.Version, ShouldEqual, "1.0.0")
+			So(res.NewestWithoutArch, ShouldEqual, "2.0.0")
+
+			res, err = Resolve(server.URL, PluginRequest{PluginID: "ported-plugin", Version: "1.0.0"})
+			So(err, ShouldBeNil)
+			So(res.NewestWithoutArch, ShouldBeEmpty)
+		})
+
+		res, err = Resolve(server.URL, PluginRequest{PluginID: "arch-plugin", Version: "0.9.0"})
+		So(err, ShouldBeNil)
+		So(res.Archive, ShouldBeEmpty)
+		So(res.URL, ShouldEqual, server.URL+"/arch-plugin/versions/0.9.0/download")
+	})
+}
+
+func TestResolveConsentData(t *testing.T) {
+	Convey("Resolutions carry what users consent to before downloading", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/consent-plugin":
+				w.Write([]byte(`{"id": "consent-plugin", "versions": [
+					{"version": "2.0.0", "permissions": ["datasources:read"], "arch": {"test-arch": {"size": 2048}},
+						"extras": {"dashboards": {"sha256": "` + strings.Repeat("ab", 32) + `", "size": 512}}},
+					{"version": "1.0.0", "backend": true, "arch": {"any": {"size": 1024}}},
+					{"version": "0.9.0", "arch": {"any": {}}}
+				]}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		SetArchOverride([]string{"test-arch"})
+		defer SetArchOverride(nil)
+
+		res, err := Resolve(server.URL, PluginRequest{PluginID: "consent-plugin", Version: "2.0.0", Extras: []string{"dashboards"}})
+		So(err, ShouldBeNil)
+		So(res.Size, ShouldEqual, 2048)
+		So(res.DownloadSize(), ShouldEqual, 2560)
+		So(res.Backend, ShouldBeTrue)
+		So(res.Permissions, ShouldResemble, []string{"datasources:read"})
+
+		res, err = Resolve(server.URL, PluginRequest{PluginID: "consent-plugin", Version: "1.0.0"})
+		So(err, ShouldBeNil)
+		So(res.Archive, ShouldBeEmpty)
+		So(res.Backend, ShouldBeTrue)
+		So(res.DownloadSize(), ShouldEqual, 1024)
+
+		res, err = Resolve(server.URL, PluginRequest{PluginID: "consent-plugin", Version: "0.9.0"})
+		So(err, ShouldBeNil)
+		So(res.Backend, ShouldBeFalse)
+		So(res.DownloadSize(), ShouldEqual, 0)
+	})
+}
+
+func TestSelectExtras(t *testing.T) {
+	Convey("Select optional components of a version", t, func() {
+		v := m.Version{Version: "1.0.0", Extras: map[string]m.Extra{
+			"dashboards": {Url: "https://example.com/dashboards.zip", Sha256: "ABC"},
+			"windows":    {},
+		}}
+
+		extras, err := SelectExtras("https://repo", "extras-plugin", v, []string{"dashboards"})
+		So(err, ShouldBeNil)
+		So(extras, ShouldResemble, []ResolvedExtra{{Name: "dashboards", URL: "https://example.com/dashboards.zip", Checksum: "abc"}})
+
+		extras, err = SelectExtras("https://repo", "extras-plugin", v, []string{"*"})
+		So(err, ShouldBeNil)
+		So(extras, ShouldHaveLength, 2)
+		So(extras[1].URL, ShouldEqual, "https://repo/extras-plugin/versions/1.0.0/extras/windows/download")
+
+		extras, err = SelectExtras("https://repo", "extras-plugin", v, nil)
+		So(err, ShouldBeNil)
+		So(extras, ShouldBeEmpty)
+
+		_, err = SelectExtras("https://repo", "extras-plugin", v, []string{"samples"})
+		So(xerrors.Is(err, ErrExtraNotFound), ShouldBeTrue)
+	})
+}
+
+func TestSelectVersionExclusions(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "2.3.2"},
+		{Version: "2.3.1"},
+		{Version: "2.3.0"},
+	}}
+
+	Convey("Excluded versions are skipped when picking the latest version", t, func() {
+		defer SetVersionExclusions(nil)
+		SetVersionExclusions(map[string][]string{"test-plugin": {"2.3.2"}})
+
+		v, err := SelectVersion(plugin, PluginRequest{PluginID: "test-plugin"})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "2.3.1")
+
+		v, err = SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", Exclude: []string{">=2.3.1, <2.3.2"}})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "2.3.0")
+
+		Convey("and refused when requested explicitly", func() {
+			_, err := SelectVersion(plugin, PluginRequest{PluginID: "test-plugin", Version: "2.3.2"})
+			So(xerrors.Is(err, ErrVersionExcluded), ShouldBeTrue)
+		})
+	})
+
+	Convey("Parse exclusions from the command line", t, func() {
+		id, exclusion, err := ParseVersionExclusion("test-plugin@>=2.3.0, <2.3.2")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "test-plugin")
+		So(exclusion, ShouldEqual, ">=2.3.0, <2.3.2")
+
+		_, _, err = ParseVersionExclusion("test-plugin")
+		So(err, ShouldNotBeNil)
+
+		_, _, err = ParseVersionExclusion("test-plugin@latest")
+		So(err, ShouldNotBeNil)
+		_, _, err = ParseVersionExclusion("test-plugin@>=2.3.0 <<2.3.2")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Request exclusions do not change the configured ones", t, func() {
+		configured := make([]string, 1, 4)
+		configured[0] = "2.3.1"
+		SetVersionExclusions(map[string][]string{"test-plugin": configured})
+		defer SetVersionExclusions(nil)
+
+		So(isExcluded("test-plugin", m.Version{Version: "2.3.2"}, PluginRequest{Exclude: []string{"2.3.2"}}), ShouldBeTrue)
+		So(configured[:cap(configured)][1], ShouldBeEmpty)
+		So(isExcluded("test-plugin", m.Version{Version: "2.3.2"}, PluginRequest{}), ShouldBeFalse)
+	})
+}
+
+func TestSelectVersionEditions(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "3.0.0", Editions: []string{"enterprise"}},
+		{Version: "2.1.0", Entitlements: []string{"reporting"}},
+		{Version: "2.0.0"},
+	}}
+
+	Convey("Versions of other editions are skipped when picking the latest version", t, func() {
+		v, err := SelectVersion(plugin, PluginRequest{})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "3.0.0")
+
+		v, err = SelectVersion(plugin, PluginRequest{Edition: EditionOSS})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "2.0.0")
+
+		v, err = SelectVersion(plugin, PluginRequest{Edition: EditionCloud, Entitlements: []string{"Reporting"}})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "2.1.0")
+
+		defer SetEdition("", nil)
+		SetEdition(EditionEnterprise, nil)
+
+		v, err = SelectVersion(plugin, PluginRequest{})
+		So(err, ShouldBeNil)
+		So(v.Version, ShouldEqual, "3.0.0")
+	})
+
+	Convey("Versions of other editions are refused when requested explicitly", t, func() {
+		_, err := SelectVersion(plugin, PluginRequest{Version: "2.1.0", Edition: EditionEnterprise})
+		So(xerrors.Is(err, ErrEditionNotSupported), ShouldBeTrue)
+
+		var editionErr *EditionNotSupportedError
+		So(xerrors.As(err, &editionErr), ShouldBeTrue)
+		So(editionErr.Missing, ShouldResemble, []string{"reporting"})
+
+		only := m.Plugin{Id: "test-plugin", Versions: plugin.Versions[:1]}
+		_, err = SelectVersion(only, PluginRequest{Edition: EditionOSS})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "test-plugin@3.0.0 requires editions enterprise: "+ErrEditionNotSupported.Error())
+	})
+}
+
+func TestExplainVersions(t *testing.T) {
+	plugin := m.Plugin{Id: "test-plugin", Versions: []m.Version{
+		{Version: "2.0.0", GrafanaDependency: ">=7.0.0"},
+		{Version: "1.9.0"},
+		{Version: "1.8.0", Arch: map[string]m.ArchMeta{"plan9-mips": {}}},
+		{Version: "1.7.0", Yanked: true, YankReason: "broken migration"},
+		{Version: "1.6.0"},
+	}}
+
+	Convey("Every rejected version carries the filter it failed", t, func() {
+		req := PluginRequest{GrafanaVersions: []string{"6.5.0"}, Exclude: []string{"1.9.0"}}
+		v, err := SelectVersion(plugin, req)
+		So(err, ShouldBeNil)
+
+		candidates := ExplainVersions(plugin, req, v.Version)
+		So(candidates, ShouldResemble, []Candidate{
+			{Version: "2.0.0", Rejected: "requires Grafana >=7.0.0, not compatible with 6.5.0"},
+			{Version: "1.9.0", Rejected: "excluded"},
+			{Version: "1.8.0", Rejected: "no archive for " + osAndArchString()},
+			{Version: "1.7.0", Rejected: "yanked: broken migration"},
+			{Version: "1.6.0", Selected: true},
+		})
+	})
+
+	Convey("Explicit requests reject every other version", t, func() {
+		candidates := ExplainVersions(plugin, PluginRequest{Version: "1.9.0"}, "1.9.0")
+		So(candidates[0].Rejected, ShouldEqual, "version 1.9.0 was requested")
+		So(candidates[1].Selected, ShouldBeTrue)
+	})
+}
+
+func TestResolveDowngrade(t *testing.T) {
+	Convey("Resolve the newest version older than the installed one", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "downgrade-panel", "versions": [
+				{"version": "3.0.0"},
+				{"version": "2.1.0", "yanked": true},
+				{"version": "2.0.0"},
+				{"version": "1.9.0"},
+				{"version": "1.0.0"}
+			]}`))
+		}))
+		defer server.Close()
+
+		res, err := ResolveDowngrade(context.Background(), server.URL, "downgrade-panel", "3.0.0", "")
+		So(err, ShouldBeNil)
+		So(res.Version.Version, ShouldEqual, "2.0.0")
+		So(res.URL, ShouldEqual, server.URL+"/downgrade-panel/versions/2.0.0/download")
+
+		res, err = ResolveDowngrade(context.Background(), server.URL, "downgrade-panel", "3.0.0", "<2.0.0")
+		So(err, ShouldBeNil)
+		So(res.Version.Version, ShouldEqual, "1.9.0")
+		So(res.Explain(), ShouldEqual, `3.0.0: not older than 3.0.0
+2.1.0: yanked
+2.0.0: does not match <2.0.0
+1.9.0: selected
+1.0.0: older than the selected version
+archive: default (`+res.URL+`)
+`)
+
+		_, err = ResolveDowngrade(context.Background(), server.URL, "downgrade-panel", "1.0.0", "")
+		So(xerrors.Is(err, ErrVersionNotFound), ShouldBeTrue)
+	})
+}
+
+func TestResolveNamespaces(t *testing.T) {
+	Convey("Namespaced plugin ids resolve from their repository", t, func() {
+		repo := func(name string) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"id": "clock-panel", "versions": [{"version": "1.0.0", "commit": "` + name + `"}]}`))
+			}))
+		}
+		internal, public := repo("internal"), repo("public")
+		defer internal.Close()
+		defer public.Close()
+
+		defer SetNamespaces(nil)
+		ns, err := ParseNamespaces("internal=" + internal.URL + "/, grafanacom=" + public.URL)
+		So(err, ShouldBeNil)
+		SetNamespaces(ns)
+
+		res, err := Resolve(public.URL, PluginRequest{PluginID: "internal/clock-panel"})
+		So(err, ShouldBeNil)
+		So(res.Version.Commit, ShouldEqual, "internal")
+		So(res.QualifiedID(), ShouldEqual, "internal/clock-panel")
+		So(res.URL, ShouldEqual, internal.URL+"/clock-panel/versions/1.0.0/download")
+
+		res, err = Resolve(internal.URL, PluginRequest{PluginID: "clock-panel", Namespace: "grafanacom"})
+		So(err, ShouldBeNil)
+		So(res.Version.Commit, ShouldEqual, "public")
+
+		res, err = Resolve(public.URL, PluginRequest{PluginID: "clock-panel"})
+		So(err, ShouldBeNil)
+		So(res.QualifiedID(), ShouldEqual, "clock-panel")
+
+		_, err = Resolve(public.URL, PluginRequest{PluginID: "partner/clock-panel"})
+		So(xerrors.Is(err, ErrUnknownNamespace), ShouldBeTrue)
+	})
+}
+
+func TestLicensePolicy(t *testing.T) {
+	policy := LicensePolicy{Allowed: []string{"Apache-2.0", "mit"}}
+	check := func(license string) error {
+		return policy.Check(m.Plugin{Id: "test-plugin", License: license})
+	}
+
+	Convey("Only plugins under approved licenses are allowed", t, func() {
+		So(check("MIT"), ShouldBeNil)
+		So(check("AGPL-3.0-only OR Apache-2.0"), ShouldBeNil)
+		So(check("(MIT AND Apache-2.0)"), ShouldBeNil)
+
+		err := check("AGPL-3.0-only")
+		So(xerrors.Is(err, ErrLicenseNotAllowed), ShouldBeTrue)
+		So(err.(*LicenseNotAllowedError).License, ShouldEqual, "AGPL-3.0-only")
+		So(check("MIT AND AGPL-3.0-only"), ShouldNotBeNil)
+
+		So(check(""), ShouldNotBeNil)
+
+		Convey("parsing the SPDX expression", func() {
+			So(check("AGPL-3.0 OR MIT AND Apache-2.0"), ShouldBeNil)
+			So(check("(AGPL-3.0 OR MIT) AND Apache-2.0"), ShouldBeNil)
+			So(check("mit or agpl-3.0"), ShouldBeNil)
+			So(check("MIT AND (AGPL-3.0 OR Apache-2.0)"), ShouldBeNil)
+			So(check("MIT AND (AGPL-3.0 OR GPL-3.0)"), ShouldNotBeNil)
+			So(check("MIT and AGPL-3.0"), ShouldNotBeNil)
+
+			So(check("(MIT"), ShouldNotBeNil)
+			So(check("MIT OR"), ShouldNotBeNil)
+			So(check("MIT Apache-2.0"), ShouldNotBeNil)
+			So(check("AND"), ShouldNotBeNil)
+		})
+
+		Convey("allowing a license with an exception once the license is", func() {
+			So(check("Apache-2.0 WITH LLVM-exception"), ShouldBeNil)
+			So(check("GPL-2.0 WITH Classpath-exception-2.0"), ShouldNotBeNil)
+			So(check("MIT WITH"), ShouldNotBeNil)
+
+			exception := LicensePolicy{Allowed: []string{"GPL-2.0 WITH Classpath-exception-2.0"}}
+			So(exception.Check(m.Plugin{Id: "test-plugin", License: "GPL-2.0 with Classpath-exception-2.0"}), ShouldBeNil)
+		})
+
+		Convey("binding AND tighter than OR", func() {
+			mitOnly := LicensePolicy{Allowed: []string{"MIT"}}
+			So(mitOnly.Check(m.Plugin{Id: "test-plugin", License: "(MIT OR GPL-3.0) AND AGPL-3.0"}), ShouldNotBeNil)
+			So(mitOnly.Check(m.Plugin{Id: "test-plugin", License: "MIT OR GPL-3.0 AND AGPL-3.0"}), ShouldBeNil)
+		})
+
+		unknown := policy
+		unknown.AllowUnknown = true
+		So(unknown.Check(m.Plugin{Id: "test-plugin"}), ShouldBeNil)
+	})
+
+	Convey("The policy is enforced when resolving", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "agpl-panel", "license": "AGPL-3.0-only", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer server.Close()
+
+		defer SetLicensePolicy(LicensePolicy{})
+		SetLicensePolicy(LicensePolicy{Allowed: []string{"Apache-2.0"}})
+
+		_, err := Resolve(server.URL, PluginRequest{PluginID: "agpl-panel"})
+		So(xerrors.Is(err, ErrLicenseNotAllowed), ShouldBeTrue)
+	})
+}
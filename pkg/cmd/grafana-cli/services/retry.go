@@ -0,0 +1,180 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy decides which failed repository requests are retried, so
+// mirrors answering e.g. 409 while they sync can be retried without code
+// changes.
+type RetryPolicy struct {
+	// MaxAttempts is how often a request is sent at most, 1 disables retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for every further
+	// one. Longer Retry-After delays of the repository are honored up to
+	// maxRetryDelay.
+	Backoff time.Duration
+	// Statuses are the response statuses retried.
+	Statuses []int
+	// Classes are the classes of transport errors retried.
+	Classes []ErrorClass
+}
+
+// DefaultRetryPolicy retries timeouts, dropped connections, throttling and
+// unavailable gateways.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     500 * time.Millisecond,
+	Statuses:    []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	Classes:     []ErrorClass{ErrorClassTimeout, ErrorClassNetwork},
+}
+
+const maxRetryDelay = 10 * time.Second
+
+var retryPolicy = DefaultRetryPolicy
+
+// SetRetryPolicy replaces the policy repository requests are retried with.
+func SetRetryPolicy(p RetryPolicy) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	retryPolicy = p
+}
+
+func getRetryPolicy() RetryPolicy {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return retryPolicy
+}
+
+// Retryable reports whether a request that ended with res or err is retried.
+func (p RetryPolicy) Retryable(res *http.Response, err error) bool {
+	if err != nil {
+		class := classify(err)
+		for _, c := range p.Classes {
+			if c == class {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, status := range p.Statuses {
+		if status == res.StatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// delay is how long to wait before the given retry, 1 being the first one.
+// Requests the repository asks to retry after more than maxRetryDelay are
+// not retried, that is left to callers like the UpdateChecker.
+func (p RetryPolicy) delay(retry int, res *http.Response) (time.Duration, bool) {
+	delay := p.Backoff << uint(retry-1)
+	if res != nil {
+		after := parseRetryAfter(res.Header.Get("Retry-After"), getClock().Now())
+		if after > maxRetryDelay {
+			return 0, false
+		}
+		if after > delay {
+			delay = after
+		}
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay, true
+}
+
+// ParseRetryStatuses parses a comma separated list of HTTP statuses, where
+// "5xx" stands for all statuses of a class.
+func ParseRetryStatuses(value string) ([]int, error) {
+	var statuses []int
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+			class := int(s[0]-'0') * 100
+			for status := class; status < class+100; status++ {
+				statuses = append(statuses, status)
+			}
+			continue
+		}
+
+		status, err := strconv.Atoi(s)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid HTTP status %q, expected e.g. 409 or 5xx", s)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ParseErrorClasses parses a comma separated list of error classes, e.g.
+// "timeout,network".
+func ParseErrorClasses(value string) ([]ErrorClass, error) {
+	var classes []ErrorClass
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		switch class := ErrorClass(s); class {
+		case ErrorClassDNS, ErrorClassTLS, ErrorClassTimeout, ErrorClassNetwork, ErrorClassDecode, ErrorClassUnknown:
+			classes = append(classes, class)
+		default:
+			return nil, fmt.Errorf("invalid error class %q, expected dns, tls, timeout, network, decode or unknown", s)
+		}
+	}
+	return classes, nil
+}
+
+// withRetries sends req with handler, retrying it as the retry policy says.
+// Requests with a body that cannot be replayed are sent once.
+func withRetries(handler RepoHandler, req *RepoRequest) (*http.Response, error) {
+	p := getRetryPolicy()
+	replayable := req.Request.Body == nil || req.Request.GetBody != nil
+
+	res, err := handler(req)
+	for retry := 1; retry < p.MaxAttempts && replayable && p.Retryable(res, err); retry++ {
+		delay, ok := p.delay(retry, res)
+		if !ok {
+			break
+		}
+		if err != nil {
+			log.Debugf("repository request to %v failed, retrying in %v: %v\n", req.Request.URL, delay, err)
+		} else {
+			log.Debugf("repository answered %v for %v, retrying in %v\n", res.StatusCode, req.Request.URL, delay)
+		}
+
+		select {
+		case <-req.Request.Context().Done():
+			// the failure of the repository says more than the deadline
+			// that expired waiting to retry
+			return res, err
+		case <-getClock().After(delay):
+		}
+
+		if err == nil {
+			res.Body.Close()
+		}
+
+		if req.Request.GetBody != nil {
+			body, bodyErr := req.Request.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			req.Request.Body = body
+		}
+		res, err = handler(req)
+	}
+	return res, err
+}
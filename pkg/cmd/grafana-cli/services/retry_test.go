@@ -0,0 +1,70 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	Convey("Statuses of the retry policy are retried", t, func() {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests < 3 {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.Write([]byte(`{"id": "syncing-panel", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer server.Close()
+
+		Convey("when configured", func() {
+			SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Statuses: []int{http.StatusConflict}})
+			defer SetRetryPolicy(DefaultRetryPolicy)
+
+			_, err := GetPlugin("syncing-panel", server.URL)
+			So(err, ShouldBeNil)
+			So(requests, ShouldEqual, 3)
+		})
+
+		Convey("and others are not", func() {
+			_, err := GetPlugin("syncing-panel", server.URL)
+			So(err, ShouldNotBeNil)
+			So(requests, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Long Retry-After delays are not waited for", t, func() {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		_, err := GetPlugin("throttled-panel", server.URL)
+		var repoErr *RepoError
+		So(xerrors.As(err, &repoErr), ShouldBeTrue)
+		So(repoErr.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+		So(requests, ShouldEqual, 1)
+	})
+
+	Convey("Retry statuses are parsed", t, func() {
+		statuses, err := ParseRetryStatuses("409, 5xx")
+		So(err, ShouldBeNil)
+		So(statuses, ShouldHaveLength, 101)
+		So(statuses[0], ShouldEqual, 409)
+		So(statuses[100], ShouldEqual, 599)
+
+		_, err = ParseRetryStatuses("40x")
+		So(err, ShouldNotBeNil)
+		_, err = ParseRetryStatuses("200000")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// SBOMFormat is the software bill of materials format written for installs.
+type SBOMFormat string
+
+const (
+	SBOMCycloneDX SBOMFormat = "cyclonedx"
+	SBOMSPDX      SBOMFormat = "spdx"
+)
+
+// ParseSBOMFormat accepts "cyclonedx" and "spdx".
+func ParseSBOMFormat(value string) (SBOMFormat, error) {
+	switch f := SBOMFormat(value); f {
+	case SBOMCycloneDX, SBOMSPDX:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown SBOM format %q, expected %s or %s", value, SBOMCycloneDX, SBOMSPDX)
+}
+
+// SBOMComponent describes an installed plugin for a software bill of materials.
+type SBOMComponent struct {
+	PluginID  string
+	Version   string
+	Sha256    string
+	SourceURL string
+	// License is the SPDX license expression published by the repository, if any.
+	License string
+}
+
+// NewSBOMComponent describes the plugin archive recorded in report.
+func NewSBOMComponent(report *VerificationReport) SBOMComponent {
+	return SBOMComponent{
+		PluginID:  report.PluginID,
+		Version:   report.Version,
+		Sha256:    report.Sha256,
+		SourceURL: report.Source,
+		License:   report.License,
+	}
+}
+
+// WriteSBOM writes an SBOM document named name listing the components.
+func WriteSBOM(w io.Writer, format SBOMFormat, name string, components []SBOMComponent) error {
+	var doc interface{}
+	switch format {
+	case SBOMCycloneDX:
+		doc = newCycloneDXDocument(components)
+	case SBOMSPDX:
+		doc = newSPDXDocument(name, components)
+	default:
+		return fmt.Errorf("unknown SBOM format %q", format)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// SBOMSink writes an SBOM document per installed plugin version into Dir,
+// for deployments merging them into the bill of materials of the instance.
+type SBOMSink struct {
+	Dir    string
+	Format SBOMFormat
+}
+
+func (s SBOMSink) WriteComponent(c SBOMComponent) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	ext := "cdx.json"
+	if s.Format == SBOMSPDX {
+		ext = "spdx.json"
+	}
+
+	f, err := os.Create(filepath.Join(s.Dir, fmt.Sprintf("%s-%s.%s", c.PluginID, c.Version, ext)))
+	if err != nil {
+		return err
+	}
+
+	if err := WriteSBOM(f, s.Format, c.PluginID+"-"+c.Version, []SBOMComponent{c}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type cycloneDXDocument struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Tools     []cycloneDXTool `json:"tools"`
+}
+
+type cycloneDXTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type cycloneDXComponent struct {
+	Type               string              `json:"type"`
+	BOMRef             string              `json:"bom-ref"`
+	Name               string              `json:"name"`
+	Version            string              `json:"version"`
+	Hashes             []cycloneDXHash     `json:"hashes,omitempty"`
+	Licenses           []cycloneDXLicense  `json:"licenses,omitempty"`
+	ExternalReferences []cycloneDXExternal `json:"externalReferences,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cycloneDXExternal struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+func newCycloneDXDocument(components []SBOMComponent) cycloneDXDocument {
+	doc := cycloneDXDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.2",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: time.Now().UTC(),
+			Tools:     []cycloneDXTool{{Vendor: "Grafana Labs", Name: "grafana-cli", Version: grafanaVersion}},
+		},
+		Components: []cycloneDXComponent{},
+	}
+
+	for _, c := range components {
+		component := cycloneDXComponent{
+			Type:    "application",
+			BOMRef:  c.PluginID + "@" + c.Version,
+			Name:    c.PluginID,
+			Version: c.Version,
+		}
+		if c.Sha256 != "" {
+			component.Hashes = []cycloneDXHash{{Alg: "SHA-256", Content: c.Sha256}}
+		}
+		if c.License != "" {
+			component.Licenses = []cycloneDXLicense{{Expression: c.License}}
+		}
+		if c.SourceURL != "" {
+			component.ExternalReferences = []cycloneDXExternal{{Type: "distribution", URL: c.SourceURL}}
+		}
+		doc.Components = append(doc.Components, component)
+	}
+
+	return doc
+}
+
+type spdxDocument struct {
+	SPDXVersion       string           `json:"spdxVersion"`
+	DataLicense       string           `json:"dataLicense"`
+	SPDXID            string           `json:"SPDXID"`
+	Name              string           `json:"name"`
+	DocumentNamespace string           `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo `json:"creationInfo"`
+	Packages          []spdxPackage    `json:"packages"`
+}
+
+type spdxCreationInfo struct {
+	Created  time.Time `json:"created"`
+	Creators []string  `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string         `json:"name"`
+	SPDXID           string         `json:"SPDXID"`
+	VersionInfo      string         `json:"versionInfo"`
+	DownloadLocation string         `json:"downloadLocation"`
+	FilesAnalyzed    bool           `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum `json:"checksums,omitempty"`
+	LicenseConcluded string         `json:"licenseConcluded"`
+	LicenseDeclared  string         `json:"licenseDeclared"`
+	CopyrightText    string         `json:"copyrightText"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+// spdxIDChars are the characters not allowed in SPDX identifiers.
+var spdxIDChars = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+func newSPDXDocument(name string, components []SBOMComponent) spdxDocument {
+	tool := "Tool: grafana-cli"
+	if grafanaVersion != "" {
+		tool += "-" + grafanaVersion
+	}
+
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.2",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://grafana.com/spdxdocs/" + name + "-" + newUUID(),
+		CreationInfo:      spdxCreationInfo{Created: time.Now().UTC(), Creators: []string{tool}},
+		Packages:          []spdxPackage{},
+	}
+
+	for _, c := range components {
+		pkg := spdxPackage{
+			Name:             c.PluginID,
+			SPDXID:           "SPDXRef-Plugin-" + spdxIDChars.ReplaceAllString(c.PluginID+"-"+c.Version, "-"),
+			VersionInfo:      c.Version,
+			DownloadLocation: noAssertion(c.SourceURL),
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  noAssertion(c.License),
+			CopyrightText:    "NOASSERTION",
+		}
+		if c.Sha256 != "" {
+			pkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: c.Sha256}}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+	}
+
+	return doc
+}
+
+func noAssertion(value string) string {
+	if value == "" {
+		return "NOASSERTION"
+	}
+	return value
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
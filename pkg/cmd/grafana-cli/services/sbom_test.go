@@ -0,0 +1,62 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSBOM(t *testing.T) {
+	report := NewVerificationReport("test-plugin", "1.0.0", "")
+	report.RecordStream("https://grafana.com/api/plugins/test-plugin/versions/1.0.0/download", "abc123")
+	report.License = "Apache-2.0"
+	component := NewSBOMComponent(report)
+
+	Convey("CycloneDX documents list the plugin with its digest, source and license", t, func() {
+		buf := new(bytes.Buffer)
+		So(WriteSBOM(buf, SBOMCycloneDX, "plugins", []SBOMComponent{component}), ShouldBeNil)
+
+		var doc cycloneDXDocument
+		So(json.Unmarshal(buf.Bytes(), &doc), ShouldBeNil)
+		So(doc.BOMFormat, ShouldEqual, "CycloneDX")
+		So(doc.Components, ShouldHaveLength, 1)
+		So(doc.Components[0].Name, ShouldEqual, "test-plugin")
+		So(doc.Components[0].Hashes, ShouldResemble, []cycloneDXHash{{Alg: "SHA-256", Content: "abc123"}})
+		So(doc.Components[0].Licenses, ShouldResemble, []cycloneDXLicense{{Expression: "Apache-2.0"}})
+		So(doc.Components[0].ExternalReferences[0].URL, ShouldEqual, report.Source)
+	})
+
+	Convey("SPDX documents use NOASSERTION for unknown values", t, func() {
+		unlicensed := component
+		unlicensed.License = ""
+
+		buf := new(bytes.Buffer)
+		So(WriteSBOM(buf, SBOMSPDX, "plugins", []SBOMComponent{unlicensed}), ShouldBeNil)
+
+		var doc spdxDocument
+		So(json.Unmarshal(buf.Bytes(), &doc), ShouldBeNil)
+		So(doc.SPDXVersion, ShouldEqual, "SPDX-2.2")
+		So(doc.Packages, ShouldHaveLength, 1)
+		So(doc.Packages[0].SPDXID, ShouldEqual, "SPDXRef-Plugin-test-plugin-1.0.0")
+		So(doc.Packages[0].LicenseDeclared, ShouldEqual, "NOASSERTION")
+		So(doc.Packages[0].Checksums, ShouldResemble, []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: "abc123"}})
+	})
+
+	Convey("The sink writes a document per plugin version", t, func() {
+		dir, err := ioutil.TempDir("", "sbom")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		So(SBOMSink{Dir: dir, Format: SBOMSPDX}.WriteComponent(component), ShouldBeNil)
+		_, err = os.Stat(filepath.Join(dir, "test-plugin-1.0.0.spdx.json"))
+		So(err, ShouldBeNil)
+
+		_, err = ParseSBOMFormat("swid")
+		So(err, ShouldNotBeNil)
+	})
+}
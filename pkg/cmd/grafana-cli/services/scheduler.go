@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Priority orders repository requests waiting for the scheduler.
+type Priority int
+
+const (
+	// PriorityBackground is used for mirror syncs, prefetching and update checks.
+	PriorityBackground Priority = iota
+	// PriorityInteractive is used for user facing operations and is the default.
+	PriorityInteractive
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose repository requests are scheduled with priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityInteractive
+}
+
+// Scheduler limits the number of concurrent repository requests. When all
+// slots are taken, interactive requests are served before background ones so
+// an install from the UI is not starved by a running sync.
+type Scheduler struct {
+	mtx     sync.Mutex
+	free    int
+	waiting map[Priority][]chan struct{}
+}
+
+// NewScheduler returns a scheduler running at most maxConcurrent requests at
+// once, see Use.
+func NewScheduler(maxConcurrent int) *Scheduler {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	return &Scheduler{free: maxConcurrent, waiting: map[Priority][]chan struct{}{}}
+}
+
+// Middleware returns the scheduler as a repository middleware, see Use.
+func (s *Scheduler) Middleware() Middleware {
+	return func(next RepoHandler) RepoHandler {
+		return func(req *RepoRequest) (*http.Response, error) {
+			ctx := req.Request.Context()
+			if err := s.acquire(ctx, priorityFromContext(ctx)); err != nil {
+				return nil, err
+			}
+			defer s.release()
+
+			return next(req)
+		}
+	}
+}
+
+func (s *Scheduler) acquire(ctx context.Context, p Priority) error {
+	s.mtx.Lock()
+	if s.free > 0 {
+		s.free--
+		s.mtx.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	s.waiting[p] = append(s.waiting[p], ready)
+	s.mtx.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		for i, ch := range s.waiting[p] {
+			if ch == ready {
+				s.waiting[p] = append(s.waiting[p][:i], s.waiting[p][i+1:]...)
+				return ctx.Err()
+			}
+		}
+
+		// the slot was handed over while we were cancelled, pass it on
+		s.handOver()
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) release() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.handOver()
+}
+
+// handOver gives a released slot to the highest priority waiter. Must be
+// called with the lock held.
+func (s *Scheduler) handOver() {
+	for _, p := range []Priority{PriorityInteractive, PriorityBackground} {
+		if queue := s.waiting[p]; len(queue) > 0 {
+			s.waiting[p] = queue[1:]
+			close(queue[0])
+			return
+		}
+	}
+
+	s.free++
+}
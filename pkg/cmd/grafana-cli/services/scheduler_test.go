@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduler(t *testing.T) {
+	Convey("Interactive requests jump the queue", t, func() {
+		s := NewScheduler(1)
+		So(s.acquire(context.Background(), PriorityBackground), ShouldBeNil)
+
+		var mtx sync.Mutex
+		var order []Priority
+		var wg sync.WaitGroup
+		enqueue := func(p Priority) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.acquire(context.Background(), p); err != nil {
+					return
+				}
+				mtx.Lock()
+				order = append(order, p)
+				mtx.Unlock()
+				s.release()
+			}()
+			// wait until the request is queued
+			for {
+				s.mtx.Lock()
+				queued := len(s.waiting[p]) > 0
+				s.mtx.Unlock()
+				if queued {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		enqueue(PriorityBackground)
+		enqueue(PriorityInteractive)
+		s.release()
+		wg.Wait()
+
+		So(order, ShouldResemble, []Priority{PriorityInteractive, PriorityBackground})
+	})
+
+	Convey("Cancelled requests leave the queue", t, func() {
+		s := NewScheduler(1)
+		So(s.acquire(context.Background(), PriorityInteractive), ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		So(s.acquire(ctx, PriorityBackground), ShouldEqual, context.Canceled)
+
+		s.release()
+		So(s.free, ShouldEqual, 1)
+	})
+}
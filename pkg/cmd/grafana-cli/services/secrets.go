@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretResolver looks up secrets by name, e.g. from a KMS or vault.
+type SecretResolver interface {
+	ResolveSecret(name string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver.
+type SecretResolverFunc func(name string) (string, error)
+
+func (f SecretResolverFunc) ResolveSecret(name string) (string, error) {
+	return f(name)
+}
+
+// secretResolvers are keyed by the scheme of a secret reference. file reads
+// secrets mounted as files, e.g. by Kubernetes or Docker, env reads them from
+// the environment.
+var secretResolvers = map[string]SecretResolver{
+	"file": SecretResolverFunc(readSecretFile),
+	"env":  SecretResolverFunc(readSecretEnv),
+}
+
+// RegisterSecretResolver makes references of the form "<scheme>:<name>"
+// resolve through r.
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	secretResolvers[scheme] = r
+}
+
+// IsSecretRef reports whether value refers to a secret of a registered scheme
+// instead of being the secret itself.
+func IsSecretRef(value string) bool {
+	_, _, ok := secretResolver(value)
+	return ok
+}
+
+// ResolveSecret returns the secret value refers to, or value itself when it
+// is not a secret reference.
+func ResolveSecret(value string) (string, error) {
+	r, name, ok := secretResolver(value)
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := r.ResolveSecret(name)
+	if err != nil {
+		return "", xerrors.Errorf("failed to resolve secret %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+func secretResolver(value string) (SecretResolver, string, bool) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, "", false
+	}
+
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	r, ok := secretResolvers[parts[0]]
+	return r, parts[1], ok
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readSecretEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// SecretCredentials authenticates requests below RepoURL with credentials
+// given as secret references like "file:/run/secrets/grafana-com-token".
+// References are resolved on every request, so rotated secrets are picked
+// up without a restart.
+type SecretCredentials struct {
+	RepoURL  string
+	Username string
+	Password string
+	Token    string
+}
+
+func (c *SecretCredentials) Credentials(url string) (Credentials, bool) {
+	repo := strings.TrimSuffix(c.RepoURL, "/")
+	if url != repo && !strings.HasPrefix(url, repo+"/") {
+		return Credentials{}, false
+	}
+
+	var creds Credentials
+	for _, field := range []struct {
+		ref   string
+		value *string
+	}{{c.Username, &creds.Username}, {c.Password, &creds.Password}, {c.Token, &creds.Token}} {
+		secret, err := ResolveSecret(field.ref)
+		if err != nil {
+			log.Warnf("%v\n", err)
+			return Credentials{}, false
+		}
+		*field.value = secret
+	}
+
+	return creds, true
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestSecretCredentials(t *testing.T) {
+	Convey("Credentials are read from mounted secrets on every request", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Header.Get("Authorization")))
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "secrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		secret := filepath.Join(dir, "token")
+		So(ioutil.WriteFile(secret, []byte("first\n"), 0600), ShouldBeNil)
+
+		defer func() { credentials = credentialStore }()
+		credentials = &SecretCredentials{RepoURL: server.URL, Token: "file:" + secret}
+
+		body, err := sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL, "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "Bearer first")
+
+		So(ioutil.WriteFile(secret, []byte("second"), 0600), ShouldBeNil)
+		body, err = sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL, "test-plugin")
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "Bearer second")
+
+		Convey("Missing secrets send the request unauthenticated", func() {
+			So(os.Remove(secret), ShouldBeNil)
+
+			body, err := sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL, "test-plugin")
+			So(err, ShouldBeNil)
+			So(string(body), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Resolve secret references", t, func() {
+		defer delete(secretResolvers, "vault")
+		RegisterSecretResolver("vault", SecretResolverFunc(func(name string) (string, error) {
+			if name == "grafana/token" {
+				return "from-vault", nil
+			}
+			return "", ErrSecretNotFound
+		}))
+
+		secret, err := ResolveSecret("vault:grafana/token")
+		So(err, ShouldBeNil)
+		So(secret, ShouldEqual, "from-vault")
+
+		_, err = ResolveSecret("vault:other")
+		So(xerrors.Is(err, ErrSecretNotFound), ShouldBeTrue)
+
+		secret, err = ResolveSecret("plain-token")
+		So(err, ShouldBeNil)
+		So(secret, ShouldEqual, "plain-token")
+		So(IsSecretRef("plain-token"), ShouldBeFalse)
+	})
+}
@@ -1,33 +1,95 @@
+// Package services talks to the plugin repository and installs plugins.
+//
+// All exported functions are safe for concurrent use once Init has returned.
+// Use and the Set* functions may be called at any time, requests already in
+// flight keep the configuration they started with. Init itself, and writes to
+// the exported variables such as HttpClient, DownloadClient or
+// MetadataCacheTTL, must happen before the package is used concurrently.
 package services
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
 	"path"
 	"runtime"
+	"strings"
 	"time"
 
-	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
 	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+	"golang.org/x/xerrors"
 )
 
 var (
 	IoHelper         m.IoUtil = IoUtilImp{}
 	HttpClient       http.Client
+	DownloadClient   http.Client
 	grafanaVersion   string
-	ErrNotFoundError = errors.New("404 not found error")
+	ErrNotFoundError = gcom.ErrNotFound
+
+	ErrInvalidPluginID = errors.New("plugin id must be a single path element")
 )
 
-func Init(version string, skipTLSVerify bool) {
+// Init configures the package. It must not be called concurrently with other
+// functions of the package.
+func Init(version string, skipTLSVerify bool, opts ...Option) {
 	grafanaVersion = version
 
-	tr := &http.Transport{
+	credentialStore = &CredentialStore{}
+	o := options{repoURL: DefaultRepoURL, logger: cliLogger{}, tlsConfig: &tls.Config{}, credentials: credentialStore}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	log = o.logger
+	setRepoURL(o.repoURL)
+	credentials = o.credentials
+	if len(o.hostTokens) > 0 {
+		credentials = hostCredentials{tokens: o.hostTokens, next: o.credentials}
+	}
+	stateMtx.Lock()
+	tlsPolicy = o.tlsPolicy
+	strictDecoding = o.strictDecoding
+	stateMtx.Unlock()
+
+	tlsConfig := o.tlsConfig.Clone()
+	if skipTLSVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	o.tlsPolicy.apply(tlsConfig)
+
+	if o.client != nil {
+		HttpClient = *o.client
+		DownloadClient = *o.client
+		DownloadClient.CheckRedirect = downloadRedirects(o.client.CheckRedirect)
+		if o.downloadTransport != nil {
+			DownloadClient.Transport = newFallbackTransport(o.downloadTransport(tlsConfig), o.client.Transport)
+		}
+		return
+	}
+
+	tr := newTransport(tlsConfig)
+
+	HttpClient = http.Client{
+		Timeout:   10 * time.Second,
+		Transport: tr,
+	}
+
+	DownloadClient = http.Client{
+		Transport:     tr,
+		CheckRedirect: downloadRedirects(nil),
+	}
+	if o.downloadTransport != nil {
+		DownloadClient.Transport = newFallbackTransport(o.downloadTransport(tlsConfig), tr)
+	}
+}
+
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
@@ -37,33 +99,49 @@ func Init(version string, skipTLSVerify bool) {
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: skipTLSVerify,
-		},
-	}
-
-	HttpClient = http.Client{
-		Timeout:   10 * time.Second,
-		Transport: tr,
+		TLSClientConfig:       tlsConfig,
 	}
 }
 
+// ListAllPlugins returns the plugins listed by the repository, without those
+// the trust policy rejects.
 func ListAllPlugins(repoUrl string) (m.PluginRepo, error) {
-	body, err := sendRequest(repoUrl, "repo")
+	body, err := sendRequest(context.Background(), OpListPlugins, "", repoUrl, "repo")
 
 	if err != nil {
-		logger.Info("Failed to send request", "error", err)
-		return m.PluginRepo{}, fmt.Errorf("Failed to send request. error: %v", err)
+		opLog(OpListPlugins).Infof("Failed to send request. error: %v\n", err)
+		if listing, ok := staleListing(repoUrl, err); ok {
+			return trustedPlugins(listing), nil
+		}
+		return m.PluginRepo{}, xerrors.Errorf("Failed to send request. error: %w", err)
 	}
 
 	var data m.PluginRepo
-	err = json.Unmarshal(body, &data)
+	err = decodeResponse(repoPath(repoUrl, "repo"), body, &data)
 	if err != nil {
-		logger.Info("Failed to unmarshal plugin repo response error:", err)
-		return m.PluginRepo{}, err
+		opLog(OpListPlugins).Infof("Failed to unmarshal plugin repo response error: %v\n", err)
+		return m.PluginRepo{}, repoError(OpListPlugins, "", repoUrl, err)
 	}
+	setCachedListing(repoUrl, body, getClock().Now().UTC())
 
-	return data, nil
+	return trustedPlugins(data), nil
+}
+
+// staleListing returns the last known good listing of the repository, like
+// staleMetadata does for a single plugin.
+func staleListing(repoUrl string, err error) (m.PluginRepo, bool) {
+	if !servesStale(err) {
+		return m.PluginRepo{}, false
+	}
+
+	listing, fetchedAt, ok := getStaleListing(repoUrl)
+	if !ok {
+		return m.PluginRepo{}, false
+	}
+
+	log.Warnf("Serving the plugin listing fetched at %v, the plugin repository failed: %v\n", fetchedAt.Format(time.RFC3339), err)
+	listing.Stale = true
+	return listing, true
 }
 
 func ReadPlugin(pluginDir, pluginName string) (m.InstalledPlugin, error) {
@@ -109,8 +187,21 @@ func GetLocalPlugins(pluginDir string) []m.InstalledPlugin {
 	return result
 }
 
+// ValidatePluginID rejects plugin ids that are not a single path element.
+// Ids name the plugin directory, ".." would address the parent of the
+// plugins directory.
+func ValidatePluginID(pluginId string) error {
+	if pluginId == "" || pluginId == "." || pluginId == ".." || strings.ContainsAny(pluginId, `/\`) {
+		return xerrors.Errorf("%q: %w", pluginId, ErrInvalidPluginID)
+	}
+	return nil
+}
+
 func RemoveInstalledPlugin(pluginPath, pluginName string) error {
-	logger.Infof("Removing plugin: %v\n", pluginName)
+	if err := ValidatePluginID(pluginName); err != nil {
+		return err
+	}
+	log.Infof("Removing plugin: %v\n", pluginName)
 	pluginDir := path.Join(pluginPath, pluginName)
 
 	_, err := IoHelper.Stat(pluginDir)
@@ -122,58 +213,191 @@ func RemoveInstalledPlugin(pluginPath, pluginName string) error {
 }
 
 func GetPlugin(pluginId, repoUrl string) (m.Plugin, error) {
-	logger.Debugf("getting plugin metadata from: %v pluginId: %v \n", repoUrl, pluginId)
-	body, err := sendRequest(repoUrl, "repo", pluginId)
+	return GetPluginWithContext(context.Background(), pluginId, repoUrl)
+}
+
+// GetPluginWithContext is GetPlugin bound to ctx, which also carries the
+// request priority.
+func GetPluginWithContext(ctx context.Context, pluginId, repoUrl string) (m.Plugin, error) {
+	md, err := getPluginMetadata(ctx, pluginId, repoUrl)
+	return md.plugin, err
+}
+
+// pluginMetadata is plugin metadata together with when it was fetched from
+// the repository. fetchedAt is zero when a cached entry has no timestamp.
+type pluginMetadata struct {
+	plugin    m.Plugin
+	fetchedAt time.Time
+	cached    bool
+	// stale is set when expired metadata was served as the repository failed.
+	stale bool
+}
+
+func getPluginMetadata(ctx context.Context, pluginId, repoUrl string) (pluginMetadata, error) {
+	if plugin, fetchedAt, ok := getCachedPlugin(repoUrl, pluginId); ok {
+		if err := getTrustPolicy().Check(plugin); err != nil {
+			return pluginMetadata{}, err
+		}
+		return pluginMetadata{plugin: plugin, fetchedAt: fetchedAt, cached: true}, nil
+	}
+
+	opLog(OpGetPlugin).Debugf("getting plugin metadata from: %v pluginId: %v \n", repoUrl, pluginId)
+	body, err := sendRequest(ctx, OpGetPlugin, pluginId, repoUrl, "repo", pluginId)
 
 	if err != nil {
-		logger.Info("Failed to send request: ", err)
-		if err == ErrNotFoundError {
-			return m.Plugin{}, fmt.Errorf("Failed to find requested plugin, check if the plugin_id is correct. error: %v", err)
+		opLog(OpGetPlugin).Infof("Failed to send request: %v\n", err)
+		if md, ok := staleMetadata(pluginId, repoUrl, err); ok {
+			return md, getTrustPolicy().Check(md.plugin)
+		}
+		if xerrors.Is(err, ErrNotFoundError) {
+			return pluginMetadata{}, xerrors.Errorf("Failed to find requested plugin, check if the plugin_id is correct. error: %w", err)
 		}
-		return m.Plugin{}, fmt.Errorf("Failed to send request. error: %v", err)
+		return pluginMetadata{}, xerrors.Errorf("Failed to send request. error: %w", err)
 	}
 
 	var data m.Plugin
-	err = json.Unmarshal(body, &data)
+	err = decodeResponse(repoPath(repoUrl, "repo", pluginId), body, &data)
 	if err != nil {
-		logger.Info("Failed to unmarshal plugin repo response error:", err)
-		return m.Plugin{}, err
+		opLog(OpGetPlugin).Infof("Failed to unmarshal plugin repo response error: %v\n", err)
+		return pluginMetadata{}, repoError(OpGetPlugin, pluginId, repoUrl, err)
+	}
+
+	fetchedAt := getClock().Now().UTC()
+	setCachedPlugin(repoUrl, pluginId, body, fetchedAt)
+
+	if err := getTrustPolicy().Check(data); err != nil {
+		return pluginMetadata{}, err
 	}
 
-	return data, nil
+	return pluginMetadata{plugin: data, fetchedAt: fetchedAt}, nil
 }
 
-func sendRequest(repoUrl string, subPaths ...string) ([]byte, error) {
-	u, _ := url.Parse(repoUrl)
-	for _, v := range subPaths {
-		u.Path = path.Join(u.Path, v)
+// staleMetadata returns the last known good metadata of the plugin when the
+// repository could not be reached or failed, but not when it answered that
+// the plugin does not exist or the response could not be trusted.
+func staleMetadata(pluginId, repoUrl string, err error) (pluginMetadata, bool) {
+	if !servesStale(err) {
+		return pluginMetadata{}, false
 	}
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	plugin, fetchedAt, ok := getStalePlugin(repoUrl, pluginId)
+	if !ok {
+		return pluginMetadata{}, false
+	}
 
-	req.Header.Set("grafana-version", grafanaVersion)
-	req.Header.Set("grafana-os", runtime.GOOS)
-	req.Header.Set("grafana-arch", runtime.GOARCH)
-	req.Header.Set("User-Agent", "grafana "+grafanaVersion)
+	log.Warnf("Serving metadata of %v fetched at %v, the plugin repository failed: %v\n", pluginId, fetchedAt.Format(time.RFC3339), err)
+	return pluginMetadata{plugin: plugin, fetchedAt: fetchedAt, cached: true, stale: true}, true
+}
+
+// servesStale reports whether err means the repository could not be reached
+// or failed, rather than answering that the plugin does not exist.
+func servesStale(err error) bool {
+	switch ClassifyError(err) {
+	case ErrorClassDNS, ErrorClassTimeout, ErrorClassNetwork, ErrorClassServer:
+		return true
+	}
+	return false
+}
+
+// DownloadArchive fetches the plugin archive from url.
+func DownloadArchive(pluginId, url string) ([]byte, error) {
+	return DownloadArchiveWithContext(context.Background(), pluginId, url)
+}
+
+func DownloadArchiveWithContext(ctx context.Context, pluginId, url string) ([]byte, error) {
+	if ArchiveCacheTTL > 0 {
+		if body, ok := getCache().Get(archiveCacheKey(url)); ok {
+			return body, nil
+		}
+	}
+
+	limits := ArchiveLimitsFor(pluginId)
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+
+	body, err := getFetcher().Fetch(ctx, pluginId, url)
+	if err == nil && limits.MaxSize > 0 && int64(len(body)) > limits.MaxSize {
+		body, err = nil, archiveTooLarge(url, int64(len(body)), limits.MaxSize)
+	}
+	err = repoError(OpDownload, pluginId, url, err)
+	if err == nil && ArchiveCacheTTL > 0 {
+		getCache().Set(archiveCacheKey(url), body, ArchiveCacheTTL)
+	}
+
+	return body, err
+}
+
+func sendRequest(ctx context.Context, op Operation, pluginId, repoUrl string, subPaths ...string) ([]byte, error) {
+	if len(subPaths) == 2 && subPaths[0] == "repo" && IsStaticRepo(repoUrl) {
+		return staticPlugin(ctx, op, pluginId, repoUrl)
+	}
 
+	return sendRequestURL(ctx, op, pluginId, repoUrl, repoPath(repoUrl, subPaths...))
+}
+
+// sendRequestURL sends an API request for u to the repository at repoUrl.
+func sendRequestURL(ctx context.Context, op Operation, pluginId, repoUrl, u string) ([]byte, error) {
+	req, err := newRequest(u)
 	if err != nil {
 		return []byte{}, err
 	}
+	req = req.WithContext(ctx)
 
-	res, err := HttpClient.Do(req)
+	body, err := readResponse(doNegotiated(repoUrl, &RepoRequest{Op: op, PluginID: pluginId, Request: req}))
 	if err != nil {
-		return []byte{}, err
+		return body, repoError(op, pluginId, u, err)
 	}
 
-	if res.StatusCode == 404 {
-		return []byte{}, ErrNotFoundError
+	if op == OpListPlugins || op == OpGetPlugin {
+		if err := verifyIndex(ctx, op, pluginId, repoUrl, u, body); err != nil {
+			return []byte{}, err
+		}
 	}
-	if res.StatusCode/100 != 2 {
-		return []byte{}, fmt.Errorf("Api returned invalid status: %s", res.Status)
+	return body, nil
+}
+
+// repoPath joins subPaths onto the repository url, or returns the index of a
+// static repository for the listing and plugin endpoints, repo and
+// repo/<id>. Static repositories serve no other endpoints.
+func repoPath(repoUrl string, subPaths ...string) string {
+	if len(subPaths) > 0 && len(subPaths) <= 2 && subPaths[0] == "repo" && IsStaticRepo(repoUrl) && !isRepoEndpoint(subPaths[1:]) {
+		// plugins are selected from the index of static repositories
+		return resolveRepoURL(repoUrl)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
+	c := gcom.Client{BaseURL: resolveRepoURL(repoUrl)}
+	return c.URL(subPaths...)
+}
 
-	return body, err
+// isRepoEndpoint reports whether the path below repo is one of its endpoints
+// rather than a plugin id.
+func isRepoEndpoint(subPaths []string) bool {
+	return len(subPaths) == 1 && (subPaths[0] == "search" || subPaths[0] == "changes")
+}
+
+func newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("grafana-version", grafanaVersion)
+	req.Header.Set("grafana-os", runtime.GOOS)
+	req.Header.Set("grafana-arch", runtime.GOARCH)
+	req.Header.Set("User-Agent", "grafana "+grafanaVersion)
+
+	return req, nil
+}
+
+func readResponse(res *http.Response, err error) ([]byte, error) {
+	if err != nil {
+		return gcom.ReadResponse(res, err)
+	}
+
+	id := responseRequestID(res)
+	body, err := gcom.ReadResponse(res, nil)
+	return body, withRequestID(id, err)
 }
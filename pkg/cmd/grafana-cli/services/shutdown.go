@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var ErrShuttingDown = errors.New("plugin service is shutting down")
+
+// inFlight tracks the operations in progress, like installing a plugin from
+// resolving it to extracting its archive, and the repository requests in
+// flight, from sending them until their response body is closed, so Shutdown
+// can drain them.
+var inFlight = struct {
+	sync.Mutex
+	closing    bool
+	cancelled  bool
+	operations int
+	next       int
+	cancels    map[int]context.CancelFunc
+	drained    chan struct{}
+}{cancels: map[int]context.CancelFunc{}}
+
+// BeginOperation registers an operation made of several repository
+// requests, returning the func ending it. Once Shutdown was called new
+// operations fail with ErrShuttingDown, while those in progress can keep
+// sending requests until they end or the drain deadline passes.
+func BeginOperation() (func(), error) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	if inFlight.closing {
+		return nil, ErrShuttingDown
+	}
+	inFlight.operations++
+
+	var once sync.Once
+	end := func() {
+		once.Do(func() {
+			inFlight.Lock()
+			defer inFlight.Unlock()
+
+			inFlight.operations--
+			notifyDrained()
+		})
+	}
+	return end, nil
+}
+
+// beginRequest registers a repository request, returning it with a context
+// Shutdown cancels once its deadline passes, and the func ending it. During
+// shutdown only the operations in progress can send requests.
+func beginRequest(req *RepoRequest) (*RepoRequest, func(), error) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	if inFlight.cancelled || (inFlight.closing && inFlight.operations == 0) {
+		return nil, nil, ErrShuttingDown
+	}
+
+	ctx, cancel := context.WithCancel(req.Request.Context())
+	id := inFlight.next
+	inFlight.next++
+	inFlight.cancels[id] = cancel
+
+	var once sync.Once
+	end := func() {
+		once.Do(func() {
+			cancel()
+			endRequest(id)
+		})
+	}
+
+	tracked := *req
+	tracked.Request = req.Request.WithContext(ctx)
+	return &tracked, end, nil
+}
+
+func endRequest(id int) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	delete(inFlight.cancels, id)
+	notifyDrained()
+}
+
+// notifyDrained wakes up Shutdown once nothing is in flight anymore, it must
+// be called holding inFlight.
+func notifyDrained() {
+	if len(inFlight.cancels) == 0 && inFlight.operations == 0 && inFlight.drained != nil {
+		close(inFlight.drained)
+		inFlight.drained = nil
+	}
+}
+
+// trackBody ends the operation of res once its body is closed, as streamed
+// archives are still downloading after the request returned.
+func trackBody(res *http.Response, err error, end func()) (*http.Response, error) {
+	if err != nil || res == nil || res.Body == nil {
+		end()
+		return res, err
+	}
+	res.Body = &operationBody{ReadCloser: res.Body, end: end}
+	return res, nil
+}
+
+type operationBody struct {
+	io.ReadCloser
+	end func()
+}
+
+func (b *operationBody) Close() error {
+	defer b.end()
+	return b.ReadCloser.Close()
+}
+
+// Shutdown stops the package from starting new operations and sending
+// requests outside of them, which fail with ErrShuttingDown, and waits for
+// the operations and requests in flight to finish, including the bodies of
+// streamed archives. When ctx is done first, the remaining requests are
+// cancelled and fail like any interrupted download, as do the requests the
+// remaining operations send afterwards, and ctx.Err() is returned. An update
+// interrupted this way keeps the installed version of its plugin. Downloads
+// cut off persist no resume state, nothing of their archive is kept and they
+// start over when the install runs again. Bulk installs resume from their
+// Journal, which records plugins once installed, so the interrupted plugin
+// is installed again by the next run. Idle connections of HttpClient and
+// DownloadClient are closed either way.
+func Shutdown(ctx context.Context) error {
+	inFlight.Lock()
+	inFlight.closing = true
+	var drained chan struct{}
+	if len(inFlight.cancels) > 0 || inFlight.operations > 0 {
+		if inFlight.drained == nil {
+			inFlight.drained = make(chan struct{})
+		}
+		drained = inFlight.drained
+	}
+	inFlight.Unlock()
+
+	defer closeIdleConnections()
+
+	if drained == nil {
+		return nil
+	}
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	inFlight.Lock()
+	log.Warnf("Shutdown deadline passed, cancelling %d operations and %d repository requests in flight\n", inFlight.operations, len(inFlight.cancels))
+	inFlight.cancelled = true
+	for _, cancel := range inFlight.cancels {
+		cancel()
+	}
+	inFlight.Unlock()
+
+	return ctx.Err()
+}
+
+func closeIdleConnections() {
+	HttpClient.CloseIdleConnections()
+	DownloadClient.CloseIdleConnections()
+}
+
+// CloseIdleConnections closes the idle connections of both transports.
+func (t *fallbackTransport) CloseIdleConnections() {
+	for _, rt := range []http.RoundTripper{t.primary, t.fallback} {
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
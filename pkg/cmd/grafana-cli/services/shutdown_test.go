@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("Shutdown drains repository requests in flight", t, func() {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/zip")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			w.Write([]byte("archive"))
+		}))
+		defer server.Close()
+		defer reopenAfterShutdown()
+
+		body, err := OpenArchive("test-panel", server.URL+"/archive.zip")
+		So(err, ShouldBeNil)
+		defer body.Close()
+
+		Convey("waiting for streamed archives to be read", func() {
+			shutdown := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				shutdown <- Shutdown(ctx)
+			}()
+			for !shuttingDown() {
+				time.Sleep(time.Millisecond)
+			}
+
+			_, err := OpenArchive("test-panel", server.URL+"/archive.zip")
+			So(xerrors.Is(err, ErrShuttingDown), ShouldBeTrue)
+
+			close(release)
+			archive, err := ioutil.ReadAll(body)
+			So(err, ShouldBeNil)
+			So(string(archive), ShouldEqual, "archive")
+			So(body.Close(), ShouldBeNil)
+			So(<-shutdown, ShouldBeNil)
+		})
+
+		Convey("cancelling them once the deadline passes", func() {
+			defer close(release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			So(Shutdown(ctx), ShouldResemble, context.DeadlineExceeded)
+
+			_, err := ioutil.ReadAll(body)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Shutdown drains operations in progress", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"plugins": []}`))
+		}))
+		defer server.Close()
+		defer reopenAfterShutdown()
+
+		end, err := BeginOperation()
+		So(err, ShouldBeNil)
+
+		shutdown := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdown <- Shutdown(ctx)
+		}()
+		for !shuttingDown() {
+			time.Sleep(time.Millisecond)
+		}
+
+		Convey("refusing new operations but not the requests of those in progress", func() {
+			_, err := BeginOperation()
+			So(xerrors.Is(err, ErrShuttingDown), ShouldBeTrue)
+
+			_, err = ListAllPlugins(server.URL)
+			So(err, ShouldBeNil)
+
+			select {
+			case <-shutdown:
+				t.Fatal("Shutdown returned with an operation in progress")
+			default:
+			}
+			end()
+			So(<-shutdown, ShouldBeNil)
+		})
+	})
+
+	Convey("Shutdown returns right away without requests in flight", t, func() {
+		defer reopenAfterShutdown()
+
+		So(Shutdown(context.Background()), ShouldBeNil)
+		_, err := sendRequest(context.Background(), OpListPlugins, "", "http://localhost:0", "repo")
+		So(xerrors.Is(err, ErrShuttingDown), ShouldBeTrue)
+	})
+}
+
+func shuttingDown() bool {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	return inFlight.closing
+}
+
+func reopenAfterShutdown() {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	inFlight.closing = false
+	inFlight.cancelled = false
+}
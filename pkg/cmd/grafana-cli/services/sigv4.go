@@ -0,0 +1,108 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"golang.org/x/xerrors"
+)
+
+// SigV4Middleware signs requests to hosts matching one of the glob patterns
+// in hosts with AWS Signature Version 4, for mirrors in S3-compatible buckets
+// that are not public. Presigned urls are sent as they are, as S3 refuses
+// requests authenticated both ways.
+func SigV4Middleware(creds *awscreds.Credentials, region, service string, hosts []string) Middleware {
+	signer := v4.NewSigner(creds)
+	// S3 signs the path as it is sent rather than escaped once more
+	signer.DisableURIPathEscaping = service == "s3"
+
+	return func(next RepoHandler) RepoHandler {
+		return func(req *RepoRequest) (*http.Response, error) {
+			r := req.Request
+			if matchHost(hosts, r.URL.Hostname()) && r.URL.Query().Get("X-Amz-Signature") == "" {
+				// signed again on every attempt, signatures expire
+				r.Header.Del("Authorization")
+				if _, err := signer.Sign(r, nil, service, region, getClock().Now()); err != nil {
+					return nil, xerrors.Errorf("signing request to %s: %w", r.URL.Host, err)
+				}
+			}
+			return next(req)
+		}
+	}
+}
+
+// NewAWSCredentials resolves credentials like the AWS SDKs do: from the
+// environment, a web identity token like the ones of IAM roles for service
+// accounts on EKS, the shared credentials file, and the ECS task or EC2
+// instance role. They are refreshed when they expire.
+func NewAWSCredentials(region string) (*awscreds.Credentials, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+
+	providers := []awscreds.Provider{&awscreds.EnvProvider{}}
+	if tokenFile, role := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); tokenFile != "" && role != "" {
+		providers = append(providers, &webIdentityProvider{
+			client:      sts.New(sess),
+			tokenFile:   tokenFile,
+			roleARN:     role,
+			sessionName: os.Getenv("AWS_ROLE_SESSION_NAME"),
+		})
+	}
+	providers = append(providers,
+		&awscreds.SharedCredentialsProvider{},
+		defaults.RemoteCredProvider(*sess.Config, sess.Handlers),
+	)
+	return awscreds.NewChainCredentials(providers), nil
+}
+
+// webIdentityProvider exchanges a web identity token for credentials of a
+// role. The token file is read on every exchange, as it is rotated.
+type webIdentityProvider struct {
+	awscreds.Expiry
+
+	client      *sts.STS
+	tokenFile   string
+	roleARN     string
+	sessionName string
+}
+
+func (p *webIdentityProvider) Retrieve() (awscreds.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return awscreds.Value{}, xerrors.Errorf("reading web identity token: %w", err)
+	}
+
+	sessionName := p.sessionName
+	if sessionName == "" {
+		sessionName = "grafana-cli"
+	}
+
+	res, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(sessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return awscreds.Value{}, xerrors.Errorf("assuming role %s with web identity: %w", p.roleARN, err)
+	}
+
+	// refresh a minute early so requests are not signed with expiring credentials
+	p.SetExpiration(aws.TimeValue(res.Credentials.Expiration), time.Minute)
+	return awscreds.Value{
+		AccessKeyID:     aws.StringValue(res.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(res.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(res.Credentials.SessionToken),
+		ProviderName:    "WebIdentityProvider",
+	}, nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSigV4(t *testing.T) {
+	Convey("Requests to S3 mirrors are signed", t, func() {
+		var headers []http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = append(headers, r.Header)
+			w.Write([]byte(`{"id": "private-panel", "versions": [{"version": "1.0.0"}]}`))
+		}))
+		defer server.Close()
+
+		prev := middlewares
+		defer func() { middlewares = prev }()
+		creds := awscreds.NewStaticCredentials("AKID", "SECRET", "SESSION")
+		Use(SigV4Middleware(creds, "eu-west-1", "s3", []string{"127.0.0.1"}))
+
+		_, err := GetPlugin("private-panel", server.URL)
+		So(err, ShouldBeNil)
+		So(headers[0].Get("Authorization"), ShouldStartWith, "AWS4-HMAC-SHA256 Credential=AKID/")
+		So(headers[0].Get("Authorization"), ShouldContainSubstring, "/eu-west-1/s3/aws4_request")
+		So(headers[0].Get("X-Amz-Security-Token"), ShouldEqual, "SESSION")
+		So(headers[0].Get("X-Amz-Content-Sha256"), ShouldNotBeEmpty)
+
+		Convey("unless presigned or for other hosts", func() {
+			_, err := DownloadArchive("private-panel", server.URL+"/private-panel.zip?X-Amz-Signature=abc")
+			So(err, ShouldBeNil)
+			So(headers[1].Get("Authorization"), ShouldBeEmpty)
+
+			middlewares = prev
+			Use(SigV4Middleware(creds, "eu-west-1", "s3", []string{"*.amazonaws.com"}))
+			_, err = DownloadArchive("private-panel", server.URL+"/private-panel.zip")
+			So(err, ShouldBeNil)
+			So(headers[2].Get("Authorization"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Web identity tokens are exchanged for credentials", t, func() {
+		dir, err := ioutil.TempDir("", "sigv4")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		tokenFile := filepath.Join(dir, "token")
+		So(ioutil.WriteFile(tokenFile, []byte("service-account-token\n"), 0600), ShouldBeNil)
+
+		var form string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			form = r.Form.Encode()
+			w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>AKID</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey><SessionToken>SESSION</SessionToken>
+<Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+		}))
+		defer server.Close()
+
+		sess, err := session.NewSession(&aws.Config{Region: aws.String("eu-west-1"), Endpoint: aws.String(server.URL)})
+		So(err, ShouldBeNil)
+		p := &webIdentityProvider{client: sts.New(sess), tokenFile: tokenFile, roleARN: "arn:aws:iam::123456789012:role/plugins"}
+
+		value, err := p.Retrieve()
+		So(err, ShouldBeNil)
+		So(value.AccessKeyID, ShouldEqual, "AKID")
+		So(value.SessionToken, ShouldEqual, "SESSION")
+		So(p.IsExpired(), ShouldBeFalse)
+		So(form, ShouldContainSubstring, "WebIdentityToken=service-account-token")
+		So(strings.Contains(form, "RoleSessionName=grafana-cli"), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+// IsStaticRepo reports whether repoUrl is a static repository: the url of a
+// JSON index like https://mirror.example.com/plugins/index.json, listing
+// every plugin with all its versions in the format of the repo endpoint. Any
+// file server or bucket can serve one, plugins are selected from the index
+// by the client. Archives are at <id>/<version>/<id>-<version>.zip next to
+// the index, unless a version lists its own archive urls, which may be
+// relative to the index.
+func IsStaticRepo(repoUrl string) bool {
+	u, err := url.Parse(resolveRepoURL(repoUrl))
+	return err == nil && strings.HasSuffix(u.Path, ".json")
+}
+
+func staticIndexCacheKey(repoUrl string) string {
+	return "static-index:" + resolveRepoURL(repoUrl)
+}
+
+// staticArchiveURL returns the archive url of a plugin version in a static
+// repository.
+func staticArchiveURL(repoUrl, pluginId, version string) string {
+	u, err := url.Parse(resolveRepoURL(repoUrl))
+	if err != nil {
+		return repoUrl
+	}
+	u.RawQuery, u.Fragment = "", ""
+	u.Path = path.Join(path.Dir(u.Path), pluginId, version, pluginId+"-"+version+".zip")
+	return u.String()
+}
+
+// staticPlugin selects the metadata of pluginId from the index of a static
+// repository, returned like the repo/<id> endpoint of the API would.
+func staticPlugin(ctx context.Context, op Operation, pluginId, repoUrl string) ([]byte, error) {
+	index, err := staticIndex(ctx, op, pluginId, repoUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, plugin := range index.Plugins {
+		if plugin.Id == pluginId {
+			return json.Marshal(resolveArchiveURLs(repoUrl, plugin))
+		}
+	}
+	return nil, repoError(op, pluginId, resolveRepoURL(repoUrl), xerrors.Errorf("%s is not listed in the index: %w", pluginId, ErrNotFoundError))
+}
+
+// staticIndex fetches the index of a static repository, reusing it for
+// MetadataCacheTTL as every plugin is selected from it.
+func staticIndex(ctx context.Context, op Operation, pluginId, repoUrl string) (m.PluginRepo, error) {
+	key := staticIndexCacheKey(repoUrl)
+	body, ok := getCache().Get(key)
+	if !ok {
+		var err error
+		if body, err = sendRequest(ctx, op, pluginId, repoUrl, "repo"); err != nil {
+			return m.PluginRepo{}, err
+		}
+	}
+
+	var index m.PluginRepo
+	if err := decodeResponse(resolveRepoURL(repoUrl), body, &index); err != nil {
+		return m.PluginRepo{}, repoError(op, pluginId, repoUrl, err)
+	}
+	if !ok && MetadataCacheTTL > 0 {
+		getCache().Set(key, body, MetadataCacheTTL)
+	}
+	return index, nil
+}
+
+// resolveArchiveURLs makes the archive urls of plugin relative to the index absolute.
+func resolveArchiveURLs(repoUrl string, plugin m.Plugin) m.Plugin {
+	base, err := url.Parse(resolveRepoURL(repoUrl))
+	if err != nil {
+		return plugin
+	}
+
+	versions := make([]m.Version, len(plugin.Versions))
+	for i, v := range plugin.Versions {
+		if len(v.Arch) > 0 {
+			arch := make(map[string]m.ArchMeta, len(v.Arch))
+			for key, meta := range v.Arch {
+				if u, err := base.Parse(meta.Url); err == nil && meta.Url != "" {
+					meta.Url = u.String()
+				}
+				arch[key] = meta
+			}
+			v.Arch = arch
+		}
+		versions[i] = v
+	}
+	plugin.Versions = versions
+	return plugin
+}
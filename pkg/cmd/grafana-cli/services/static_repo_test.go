@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/xerrors"
+)
+
+func TestStaticRepo(t *testing.T) {
+	Convey("Plugins are resolved from the index of a static repository", t, func() {
+		dir, err := ioutil.TempDir("", "static-repo")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		index := `{"plugins": [
+			{"id": "static-panel", "versions": [{"version": "2.0.0", "yanked": true}, {"version": "1.1.0"}, {"version": "1.0.0"}]},
+			{"id": "static-datasource", "versions": [{"version": "1.0.0", "arch": {"linux-amd64": {"url": "builds/static-datasource-linux.zip"}}}]}
+		]}`
+		So(ioutil.WriteFile(filepath.Join(dir, "index.json"), []byte(index), 0644), ShouldBeNil)
+		So(os.MkdirAll(filepath.Join(dir, "static-panel", "1.1.0"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "static-panel", "1.1.0", "static-panel-1.1.0.zip"), []byte("archive"), 0644), ShouldBeNil)
+
+		var requested []string
+		files := http.FileServer(http.Dir(dir))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.Path)
+			files.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		repoUrl := server.URL + "/index.json"
+		So(IsStaticRepo(repoUrl), ShouldBeTrue)
+		So(IsStaticRepo(server.URL+"/api/plugins"), ShouldBeFalse)
+
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		res, err := Resolve(repoUrl, PluginRequest{PluginID: "static-panel"})
+		So(err, ShouldBeNil)
+		So(res.Version.Version, ShouldEqual, "1.1.0")
+		So(res.URL, ShouldEqual, server.URL+"/static-panel/1.1.0/static-panel-1.1.0.zip")
+
+		body, err := DownloadArchive("static-panel", res.URL)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "archive")
+
+		Convey("with archive urls relative to the index", func() {
+			SetArchOverride([]string{"linux-amd64"})
+			defer SetArchOverride(nil)
+
+			res, err := Resolve(repoUrl, PluginRequest{PluginID: "static-datasource"})
+			So(err, ShouldBeNil)
+			So(res.URL, ShouldEqual, server.URL+"/builds/static-datasource-linux.zip")
+		})
+
+		Convey("fetching the index once while it is cached", func() {
+			requested = nil
+			_, err := GetPlugin("static-datasource", repoUrl)
+			So(err, ShouldBeNil)
+			So(requested, ShouldBeEmpty)
+		})
+
+		Convey("reporting plugins missing from the index", func() {
+			_, err := GetPlugin("missing-panel", repoUrl)
+			So(xerrors.Is(err, ErrNotFoundError), ShouldBeTrue)
+		})
+
+		Convey("verifying an index signed with SignMirror", func() {
+			public, private, err := ed25519.GenerateKey(nil)
+			So(err, ShouldBeNil)
+			_, err = SignMirror(dir, private)
+			So(err, ShouldBeNil)
+
+			prevCache := getCache()
+			SetCache(newMemoryCache())
+			defer SetCache(prevCache)
+			SetIndexKeys([]ed25519.PublicKey{public})
+			defer SetIndexKeys(nil)
+
+			requested = nil
+			_, err = GetPlugin("static-panel", repoUrl)
+			So(err, ShouldBeNil)
+			So(requested, ShouldResemble, []string{"/index.json", "/index.sig"})
+		})
+
+		Convey("building urls of other endpoints below the index", func() {
+			So(repoPath(repoUrl, "repo"), ShouldEqual, repoUrl)
+			So(repoPath(repoUrl, "repo", "static-panel"), ShouldEqual, repoUrl)
+			So(repoPath(repoUrl, "repo", "search"), ShouldNotEqual, repoUrl)
+			So(repoPath(repoUrl, "repo", "changes"), ShouldNotEqual, repoUrl)
+		})
+
+		Convey("without probing for API endpoints", func() {
+			requested = nil
+			caps, err := Capabilities(context.Background(), repoUrl)
+			So(err, ShouldBeNil)
+			So(caps.Search, ShouldBeFalse)
+			So(caps.DeltaIndex, ShouldBeFalse)
+			So(requested, ShouldResemble, []string{"/index.sig"})
+		})
+	})
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+)
+
+// OpenArchive streams the archive at url, or from the local file url points
+// to, without buffering it in memory. Custom fetchers that only return whole
+// archives are wrapped as is. The download, up to closing the body, is bound
+// by the timeout of the ArchiveLimitsFor pluginId.
+func OpenArchive(pluginId, url string) (io.ReadCloser, error) {
+	if _, err := os.Stat(url); err == nil {
+		return os.Open(url)
+	}
+
+	if _, ok := getFetcher().(HTTPFetcher); !ok {
+		body, err := DownloadArchive(pluginId, url)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	req, err := newRequest(url)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if limits := ArchiveLimitsFor(pluginId); limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+
+	res, err := do(&DownloadClient, &RepoRequest{Op: OpDownload, PluginID: pluginId, Request: req.WithContext(ctx)})
+	res, err = trackDownload(pluginId, res, err)
+	res, err = checkArchiveSize(pluginId, url, res, err)
+	if res, err = checkDownload(url, res, err); err != nil {
+		cancel()
+		return nil, repoError(OpDownload, pluginId, url, err)
+	}
+	res.Body = &cancelingBody{ReadCloser: res.Body, cancel: cancel}
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		id := responseRequestID(res)
+		if res.StatusCode == 404 {
+			return nil, repoError(OpDownload, pluginId, url, withRequestID(id, ErrNotFoundError))
+		}
+		return nil, repoError(OpDownload, pluginId, url, withRequestID(id, gcom.NewStatusError(res)))
+	}
+
+	return res.Body, nil
+}
+
+// cancelingBody releases the timeout of a streamed archive once it is closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// VerifyingReader computes the digest of everything read through it, so
+// streamed archives are verified without being held in memory.
+type VerifyingReader struct {
+	r        io.Reader
+	sha256   hash.Hash
+	check    hash.Hash
+	expected string
+}
+
+// NewVerifyingReader verifies r against expected, which may be empty to
+// only compute the sha256 digest.
+func NewVerifyingReader(r io.Reader, expected string) (*VerifyingReader, error) {
+	v := &VerifyingReader{r: r, sha256: sha256.New(), expected: strings.ToLower(expected)}
+
+	switch len(expected) {
+	case 0:
+	case md5.Size * 2:
+		if fipsMode {
+			return nil, ErrDigestNotApproved
+		}
+		v.check = md5.New()
+	case sha256.Size * 2:
+		v.check = v.sha256
+	default:
+		return nil, fmt.Errorf("unsupported checksum format: %v", expected)
+	}
+
+	return v, nil
+}
+
+func (v *VerifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.sha256.Write(p[:n])
+	if v.check != nil && v.check != v.sha256 {
+		v.check.Write(p[:n])
+	}
+	return n, err
+}
+
+// Sha256 is the hex encoded sha256 digest of the bytes read so far.
+func (v *VerifyingReader) Sha256() string {
+	return fmt.Sprintf("%x", v.sha256.Sum(nil))
+}
+
+// Verify reads the remainder of the stream and compares it against the
+// expected checksum.
+func (v *VerifyingReader) Verify() error {
+	if _, err := io.Copy(ioutil.Discard, v); err != nil {
+		return err
+	}
+
+	if v.check == nil {
+		return nil
+	}
+	if fmt.Sprintf("%x", v.check.Sum(nil)) != v.expected {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var ErrTLSPolicy = errors.New("connection does not satisfy the TLS policy")
+
+// TLSPolicy restricts the TLS connections made to the repository and to
+// archive download hosts. Go does not allow configuring TLS 1.3 cipher
+// suites, CipherSuites only applies to TLS 1.2 connections.
+type TLSPolicy struct {
+	// MinVersion is the lowest accepted TLS version, e.g. tls.VersionTLS12.
+	MinVersion uint16
+	// CipherSuites are the accepted TLS 1.2 cipher suites, empty accepts the Go defaults.
+	CipherSuites []uint16
+}
+
+var tlsPolicy TLSPolicy
+
+// WithTLSPolicy applies the policy to the default transport and rejects
+// responses received over connections violating it, which also covers
+// clients set with WithClient.
+func WithTLSPolicy(p TLSPolicy) Option {
+	return func(o *options) { o.tlsPolicy = p }
+}
+
+func (p TLSPolicy) apply(cfg *tls.Config) {
+	if p.MinVersion > cfg.MinVersion {
+		cfg.MinVersion = p.MinVersion
+	}
+	if len(p.CipherSuites) > 0 {
+		cfg.CipherSuites = p.CipherSuites
+	}
+}
+
+// Check verifies the negotiated connection state. Plain http responses have
+// no state and are accepted, ValidateConfig warns about those.
+func (p TLSPolicy) Check(state *tls.ConnectionState) error {
+	if state == nil {
+		return nil
+	}
+
+	if state.Version < p.MinVersion {
+		return xerrors.Errorf("negotiated %s, require at least %s: %w", tlsVersionName(state.Version), tlsVersionName(p.MinVersion), ErrTLSPolicy)
+	}
+
+	if len(p.CipherSuites) == 0 || state.Version >= tls.VersionTLS13 {
+		return nil
+	}
+	for _, suite := range p.CipherSuites {
+		if state.CipherSuite == suite {
+			return nil
+		}
+	}
+	return xerrors.Errorf("negotiated cipher suite %s: %w", cipherSuiteName(state.CipherSuite), ErrTLSPolicy)
+}
+
+func checkTLSPolicy(res *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return res, err
+	}
+
+	stateMtx.RLock()
+	policy := tlsPolicy
+	stateMtx.RUnlock()
+
+	if err := policy.Check(res.TLS); err != nil {
+		res.Body.Close()
+		return nil, xerrors.Errorf("%s: %w", res.Request.URL.Host, err)
+	}
+	return res, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses versions like "1.2".
+func ParseTLSVersion(value string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.TrimSpace(value), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2, 1.3", value)
+	}
+	return v, nil
+}
+
+func tlsVersionName(v uint16) string {
+	for name, version := range tlsVersions {
+		if version == v {
+			return "TLS " + name
+		}
+	}
+	return fmt.Sprintf("TLS 0x%04x", v)
+}
+
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":        tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":          tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":               tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// ParseCipherSuites parses IANA cipher suite names, e.g.
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	var suites []uint16
+	for _, name := range names {
+		suite, ok := cipherSuites[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		suites = append(suites, suite)
+	}
+	return suites, nil
+}
+
+func cipherSuiteName(suite uint16) string {
+	for name, s := range cipherSuites {
+		if s == suite && !strings.HasSuffix(name, "_POLY1305_SHA256") {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", suite)
+}
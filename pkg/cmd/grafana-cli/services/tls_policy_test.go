@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	Convey("Responses over connections below the minimum version are rejected", t, func() {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "test-plugin"}`))
+		}))
+		server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+		server.StartTLS()
+		defer server.Close()
+
+		defer Init("", false)
+		Init("", false, WithClient(server.Client()), WithTLSPolicy(TLSPolicy{MinVersion: tls.VersionTLS13}))
+
+		_, err := sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL, "repo", "test-plugin")
+		So(xerrors.Is(err, ErrTLSPolicy), ShouldBeTrue)
+
+		Init("", false, WithClient(server.Client()), WithTLSPolicy(TLSPolicy{MinVersion: tls.VersionTLS12}))
+		_, err = sendRequest(context.Background(), OpGetPlugin, "test-plugin", server.URL, "repo", "test-plugin")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("The policy is applied to the default transport", t, func() {
+		defer Init("", false)
+
+		suites, err := ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+		So(err, ShouldBeNil)
+		Init("", false, WithTLSPolicy(TLSPolicy{MinVersion: tls.VersionTLS12, CipherSuites: suites}))
+
+		cfg := DownloadClient.Transport.(*http.Transport).TLSClientConfig
+		So(cfg.MinVersion, ShouldEqual, tls.VersionTLS12)
+		So(cfg.CipherSuites, ShouldResemble, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+	})
+
+	Convey("Parse TLS settings", t, func() {
+		v, err := ParseTLSVersion("1.3")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, tls.VersionTLS13)
+
+		_, err = ParseTLSVersion("1.4")
+		So(err, ShouldNotBeNil)
+		_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_MD5"})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var ErrUntrustedPublisher = errors.New("plugin publisher is not trusted")
+
+// UntrustedPublisherError is returned when resolving a plugin published by an
+// organization that is not part of the trust policy.
+type UntrustedPublisherError struct {
+	PluginID  string
+	Publisher string
+}
+
+func (e *UntrustedPublisherError) Error() string {
+	return fmt.Sprintf("%s is published by %q: %v", e.PluginID, e.Publisher, ErrUntrustedPublisher)
+}
+
+func (e *UntrustedPublisherError) Unwrap() error {
+	return ErrUntrustedPublisher
+}
+
+// TrustPolicy restricts resolution to plugins published by the listed
+// grafana.com organizations. An empty policy trusts every publisher.
+type TrustPolicy struct {
+	TrustedPublishers []string
+}
+
+var trustPolicy TrustPolicy
+
+func SetTrustPolicy(policy TrustPolicy) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	trustPolicy = policy
+}
+
+func getTrustPolicy() TrustPolicy {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return trustPolicy
+}
+
+func (p TrustPolicy) Check(plugin m.Plugin) error {
+	if len(p.TrustedPublishers) == 0 {
+		return nil
+	}
+
+	for _, publisher := range p.TrustedPublishers {
+		if strings.EqualFold(strings.TrimSpace(publisher), plugin.OrgSlug) {
+			return nil
+		}
+	}
+
+	return &UntrustedPublisherError{PluginID: plugin.Id, Publisher: plugin.OrgSlug}
+}
+
+// CheckPolicies applies the trust and the license policy to plugin, for
+// installs that do not resolve it from the repository, e.g. from a url or a
+// local archive. Plugins the repository does not publish have no publisher
+// or license, so they only pass policies that allow those.
+func CheckPolicies(plugin m.Plugin) error {
+	if err := getTrustPolicy().Check(plugin); err != nil {
+		return err
+	}
+	return getLicensePolicy().Check(plugin)
+}
+
+// CheckPublishedPolicies is CheckPolicies for a plugin installed from
+// outside of the repository, e.g. from a url, with the publisher and license
+// the repository publishes for pluginId, if any. The repository is not
+// contacted when no policy is configured.
+func CheckPublishedPolicies(ctx context.Context, pluginId, repoUrl string) error {
+	if len(getTrustPolicy().TrustedPublishers) == 0 && len(getLicensePolicy().Allowed) == 0 {
+		return nil
+	}
+
+	plugin, err := GetPluginWithContext(ctx, pluginId, repoUrl)
+	if xerrors.Is(err, ErrNotFoundError) {
+		return CheckPolicies(m.Plugin{Id: pluginId})
+	}
+	if err != nil {
+		return err
+	}
+	return CheckPolicies(plugin)
+}
+
+// trustedPlugins drops the plugins of a listing that are not published by a
+// trusted publisher.
+func trustedPlugins(listing m.PluginRepo) m.PluginRepo {
+	policy := getTrustPolicy()
+	if len(policy.TrustedPublishers) == 0 {
+		return listing
+	}
+
+	trusted := make([]m.Plugin, 0, len(listing.Plugins))
+	for _, plugin := range listing.Plugins {
+		if err := policy.Check(plugin); err != nil {
+			log.Debugf("not listing %v: %v\n", plugin.Id, err)
+			continue
+		}
+		trusted = append(trusted, plugin)
+	}
+	listing.Plugins = trusted
+	return listing
+}
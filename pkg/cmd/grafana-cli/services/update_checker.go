@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/grafana/grafana/pkg/plugins/gcom"
+)
+
+// UpdateStatus is the result of the last update check.
+type UpdateStatus struct {
+	CheckedAt time.Time `json:"checkedAt"`
+	Updates   []Update  `json:"updates"`
+	// Deprecations are the installed plugins flagged as deprecated or end of
+	// life.
+	Deprecations []Deprecation `json:"deprecations,omitempty"`
+	// Error is set when the last check failed, Updates and Deprecations are
+	// those of the last successful one then.
+	Error string `json:"error,omitempty"`
+	// NextCheck is when the checker polls the repository again.
+	NextCheck time.Time `json:"nextCheck"`
+}
+
+// UpdateStore shares update check results between the process running the
+// UpdateChecker and readers like the UI or grafana-cli.
+type UpdateStore interface {
+	Load() (UpdateStatus, error)
+	Save(status UpdateStatus) error
+}
+
+// FileUpdateStore keeps the update status in a JSON file, so grafana-cli can
+// read what a running Grafana found.
+type FileUpdateStore struct {
+	Path string
+}
+
+// Load returns the stored status, a zero status if nothing was stored yet.
+func (s FileUpdateStore) Load() (UpdateStatus, error) {
+	var status UpdateStatus
+
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	err = json.Unmarshal(data, &status)
+	return status, err
+}
+
+func (s FileUpdateStore) Save(status UpdateStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+// UpdateChecker polls the repository for updates of the installed plugins in
+// the background and saves the results to Store. Polls are spread with
+// jitter, use conditional requests so an unchanged index is not transferred
+// again, and back off on errors and when the repository throttles.
+type UpdateChecker struct {
+	RepoURL   string
+	PluginDir string
+	Pins      map[string]string
+	Store     UpdateStore
+	// Interval between checks, Jitter is the fraction it varies by.
+	Interval time.Duration
+	Jitter   float64
+	// MaxBackoff caps the delay after consecutive failures.
+	MaxBackoff time.Duration
+	Clock      clock.Clock
+	// BatchCheck polls the versioncheck endpoint of grafana.com for the
+	// latest versions of the installed plugins instead of the full index.
+	// The index is still polled while index signatures are verified, as
+	// versioncheck responses are not signed.
+	BatchCheck bool
+
+	mtx      sync.Mutex
+	failures int
+	index    m.PluginRepo
+	// url is the one etag and modified were returned for
+	url      string
+	etag     string
+	modified string
+}
+
+// NewUpdateChecker returns a checker polling every 10 minutes with 10% jitter
+// and backing off up to 6 hours.
+func NewUpdateChecker(repoUrl, pluginDir string, store UpdateStore) *UpdateChecker {
+	return &UpdateChecker{
+		RepoURL:    repoUrl,
+		PluginDir:  pluginDir,
+		Store:      store,
+		Interval:   10 * time.Minute,
+		Jitter:     0.1,
+		MaxBackoff: 6 * time.Hour,
+		Clock:      getClock(),
+	}
+}
+
+// throttledError is returned when the repository asks to retry later.
+type throttledError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", e.err, e.retryAfter)
+}
+
+func (e *throttledError) Unwrap() error {
+	return e.err
+}
+
+// Run checks for updates until ctx is cancelled.
+func (u *UpdateChecker) Run(ctx context.Context) error {
+	for {
+		status, _ := u.CheckOnce(ctx)
+		timer := u.Clock.Timer(status.NextCheck.Sub(u.Clock.Now()))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// CheckOnce checks for updates, saves the result to the store and returns it.
+func (u *UpdateChecker) CheckOnce(ctx context.Context) (UpdateStatus, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	now := u.Clock.Now()
+	status := UpdateStatus{CheckedAt: now.UTC()}
+
+	err := u.refreshIndex(ctx)
+	recordCheck(u.PluginDir, err)
+	if err == nil {
+		u.failures = 0
+		status.Updates = availableUpdates(u.index, u.PluginDir, u.Pins, false)
+		status.Deprecations = installedDeprecations(u.index, u.PluginDir)
+	} else {
+		u.failures++
+		log.Warnf("failed to check for plugin updates: %v\n", err)
+		status.Error = err.Error()
+		if previous, loadErr := u.Store.Load(); loadErr == nil {
+			status.Updates = previous.Updates
+			status.Deprecations = previous.Deprecations
+		}
+	}
+	status.NextCheck = now.Add(u.nextDelay(err)).UTC()
+
+	if saveErr := u.Store.Save(status); saveErr != nil {
+		log.Errorf("failed to save plugin update status: %v\n", saveErr)
+	}
+	return status, err
+}
+
+// nextDelay returns the jittered interval, doubled for every consecutive
+// failure up to MaxBackoff, and never shorter than a Retry-After.
+func (u *UpdateChecker) nextDelay(err error) time.Duration {
+	delay := u.Interval
+	for i := 0; i < u.failures && delay < u.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if u.MaxBackoff > 0 && delay > u.MaxBackoff {
+		delay = u.MaxBackoff
+	}
+
+	if u.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * u.Jitter * float64(delay))
+	}
+
+	if throttled, ok := err.(*throttledError); ok && throttled.retryAfter > delay {
+		delay = throttled.retryAfter
+	}
+	return delay
+}
+
+// refreshIndex fetches the repository index, or the latest versions of the
+// installed plugins with BatchCheck, sending the validators of the last
+// response so an unchanged index is answered with 304 Not Modified.
+func (u *UpdateChecker) refreshIndex(ctx context.Context) error {
+	batch := u.BatchCheck && len(getIndexKeys()) == 0
+	url := repoPath(u.RepoURL, "repo")
+	if batch {
+		url = u.versionCheckURL()
+	}
+	req, err := newRequest(url)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(WithPriority(ctx, PriorityBackground))
+	if u.url == url {
+		if u.etag != "" {
+			req.Header.Set("If-None-Match", u.etag)
+		}
+		if u.modified != "" {
+			req.Header.Set("If-Modified-Since", u.modified)
+		}
+	}
+
+	res, err := doNegotiated(u.RepoURL, &RepoRequest{Op: OpListPlugins, Request: req})
+	if err != nil {
+		return repoError(OpListPlugins, "", url, err)
+	}
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		res.Body.Close()
+		log.Debugf("plugin repository index not modified\n")
+		return nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		statusErr := withRequestID(responseRequestID(res), gcom.NewStatusError(res))
+		res.Body.Close()
+		return &throttledError{err: repoError(OpListPlugins, "", url, statusErr), retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), u.Clock.Now())}
+	}
+
+	etag, modified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	body, err := readResponse(res, nil)
+	if err != nil {
+		return repoError(OpListPlugins, "", url, err)
+	}
+
+	var index m.PluginRepo
+	if batch {
+		var latest []latestVersion
+		if err := decodeResponse(url, body, &latest); err != nil {
+			return repoError(OpListPlugins, "", url, err)
+		}
+		for _, l := range latest {
+			index.Plugins = append(index.Plugins, l.plugin())
+		}
+	} else {
+		if err := verifyIndex(ctx, OpListPlugins, "", u.RepoURL, url, body); err != nil {
+			return err
+		}
+		if err := decodeResponse(url, body, &index); err != nil {
+			return repoError(OpListPlugins, "", url, err)
+		}
+	}
+
+	u.index, u.url, u.etag, u.modified = index, url, etag, modified
+	return nil
+}
+
+// versionCheckURL returns the versioncheck url of the installed plugins.
+func (u *UpdateChecker) versionCheckURL() string {
+	var ids []string
+	for _, local := range GetLocalPlugins(u.PluginDir) {
+		ids = append(ids, local.Id)
+	}
+	sort.Strings(ids)
+
+	query := url.Values{"slugIn": {strings.Join(ids, ",")}, "grafanaVersion": {grafanaVersion}}
+	return repoPath(u.RepoURL, "versioncheck") + "?" + query.Encode()
+}
+
+// latestVersion is an entry of a versioncheck response.
+type latestVersion struct {
+	Slug               string `json:"slug"`
+	Version            string `json:"version"`
+	Deprecated         bool   `json:"deprecated"`
+	EndOfLife          bool   `json:"endOfLife"`
+	DeprecationMessage string `json:"deprecationMessage"`
+}
+
+func (l latestVersion) plugin() m.Plugin {
+	return m.Plugin{
+		Id:                 l.Slug,
+		Versions:           []m.Version{{Version: l.Version}},
+		Deprecated:         l.Deprecated,
+		EndOfLife:          l.EndOfLife,
+		DeprecationMessage: l.DeprecationMessage,
+	}
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpdateChecker(t *testing.T) {
+	Convey("Update checks use conditional requests and back off when throttled", t, func() {
+		var requests, notModified int
+		throttle := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if throttle {
+				w.Header().Set("Retry-After", "7200")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(`{"plugins": [{"id": "update-plugin", "versions": [{"version": "2.0.0"}, {"version": "1.0.0"}]}]}`))
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		So(os.MkdirAll(filepath.Join(dir, "update-plugin"), 0755), ShouldBeNil)
+		json := `{"id": "update-plugin", "info": {"version": "1.0.0"}}`
+		So(ioutil.WriteFile(filepath.Join(dir, "update-plugin", "plugin.json"), []byte(json), 0644), ShouldBeNil)
+
+		mock := clock.NewMock()
+		mock.Set(time.Date(2019, 6, 1, 3, 0, 0, 0, time.UTC))
+
+		store := FileUpdateStore{Path: filepath.Join(dir, "status", "updates.json")}
+		checker := NewUpdateChecker(server.URL, dir, store)
+		checker.Clock = mock
+		checker.Jitter = 0
+
+		expected := []Update{{PluginID: "update-plugin", InstalledVersion: "1.0.0", Version: "2.0.0"}}
+
+		status, err := checker.CheckOnce(context.Background())
+		So(err, ShouldBeNil)
+		So(status.Updates, ShouldResemble, expected)
+		So(status.NextCheck, ShouldResemble, mock.Now().Add(10*time.Minute).UTC())
+
+		stored, err := store.Load()
+		So(err, ShouldBeNil)
+		So(stored.Updates, ShouldResemble, expected)
+
+		Convey("an unchanged index is not transferred again", func() {
+			status, err := checker.CheckOnce(context.Background())
+			So(err, ShouldBeNil)
+			So(notModified, ShouldEqual, 1)
+			So(status.Updates, ShouldResemble, expected)
+		})
+
+		Convey("a throttled check keeps the last updates and honours Retry-After", func() {
+			throttle = true
+
+			status, err := checker.CheckOnce(context.Background())
+			So(err, ShouldNotBeNil)
+			So(status.Error, ShouldNotBeEmpty)
+			So(status.Updates, ShouldResemble, expected)
+			So(status.NextCheck, ShouldResemble, mock.Now().Add(2*time.Hour).UTC())
+
+			stored, err := store.Load()
+			So(err, ShouldBeNil)
+			So(stored.Error, ShouldEqual, status.Error)
+		})
+	})
+
+	Convey("Batch checks ask versioncheck for the installed plugins only", t, func() {
+		var queries []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/versioncheck" {
+				http.NotFound(w, r)
+				return
+			}
+			queries = append(queries, r.URL.RawQuery)
+			w.Write([]byte(`[{"slug": "a-panel", "version": "2.0.0"}, {"slug": "b-panel", "version": "1.0.0", "endOfLife": true}]`))
+		}))
+		defer server.Close()
+		prevVersion := grafanaVersion
+		grafanaVersion = "7.0.0"
+		defer func() { grafanaVersion = prevVersion }()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		for _, id := range []string{"b-panel", "a-panel"} {
+			So(os.MkdirAll(filepath.Join(dir, id), 0755), ShouldBeNil)
+			json := `{"id": "` + id + `", "info": {"version": "1.0.0"}}`
+			So(ioutil.WriteFile(filepath.Join(dir, id, "plugin.json"), []byte(json), 0644), ShouldBeNil)
+		}
+
+		checker := NewUpdateChecker(server.URL, dir, FileUpdateStore{Path: filepath.Join(dir, "updates.json")})
+		checker.BatchCheck = true
+
+		status, err := checker.CheckOnce(context.Background())
+		So(err, ShouldBeNil)
+		So(queries, ShouldResemble, []string{"grafanaVersion=7.0.0&slugIn=a-panel%2Cb-panel"})
+		So(status.Updates, ShouldResemble, []Update{{PluginID: "a-panel", InstalledVersion: "1.0.0", Version: "2.0.0"}})
+		So(status.Deprecations, ShouldResemble, []Deprecation{{PluginID: "b-panel", EndOfLife: true, Notice: "b-panel is end of life"}})
+	})
+
+	Convey("Consecutive failures double the delay up to the maximum", t, func() {
+		checker := NewUpdateChecker("", "", FileUpdateStore{})
+		checker.Jitter = 0
+
+		checker.failures = 2
+		So(checker.nextDelay(nil), ShouldEqual, 40*time.Minute)
+
+		checker.failures = 20
+		So(checker.nextDelay(nil), ShouldEqual, 6*time.Hour)
+	})
+
+	Convey("Retry-After is read in seconds and as a date", t, func() {
+		now := time.Date(2019, 6, 1, 3, 0, 0, 0, time.UTC)
+		So(parseRetryAfter("120", now), ShouldEqual, 2*time.Minute)
+		So(parseRetryAfter("Sat, 01 Jun 2019 03:05:00 GMT", now), ShouldEqual, 5*time.Minute)
+		So(parseRetryAfter("soon", now), ShouldEqual, 0)
+	})
+}
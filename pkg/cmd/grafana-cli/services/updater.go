@@ -0,0 +1,353 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/hashicorp/go-version"
+	"github.com/robfig/cron"
+)
+
+// Update is a version an installed plugin should be moved to.
+type Update struct {
+	PluginID         string `json:"pluginId"`
+	InstalledVersion string `json:"installedVersion"`
+	Version          string `json:"version"`
+}
+
+// CheckForUpdates returns the installed plugins with a newer version in the
+// repository. Pinned plugins are kept at, or moved to, their pinned version.
+func CheckForUpdates(repoUrl, pluginDir string, pins map[string]string) ([]Update, error) {
+	return checkForUpdates(repoUrl, pluginDir, pins, false)
+}
+
+// CheckForSafeUpdates is CheckForUpdates limited to patch releases of the
+// installed major.minor versions, see PluginRequest.SafeUpdate. Pins apply
+// regardless.
+func CheckForSafeUpdates(repoUrl, pluginDir string, pins map[string]string) ([]Update, error) {
+	return checkForUpdates(repoUrl, pluginDir, pins, true)
+}
+
+// checkForUpdates also records the check in the PluginStateStore.
+func checkForUpdates(repoUrl, pluginDir string, pins map[string]string, safe bool) ([]Update, error) {
+	remote, err := ListAllPlugins(repoUrl)
+	recordCheck(pluginDir, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return availableUpdates(remote, pluginDir, pins, safe), nil
+}
+
+func availableUpdates(remote m.PluginRepo, pluginDir string, pins map[string]string, safe bool) []Update {
+	var updates []Update
+	for _, local := range GetLocalPlugins(pluginDir) {
+		if pin, ok := pins[local.Id]; ok {
+			if pin != local.Info.Version {
+				updates = append(updates, Update{PluginID: local.Id, InstalledVersion: local.Info.Version, Version: pin})
+			}
+			continue
+		}
+
+		installed, err := version.NewVersion(local.Info.Version)
+		if err != nil {
+			continue
+		}
+
+		for _, plugin := range remote.Plugins {
+			if plugin.Id != local.Id {
+				continue
+			}
+
+			req := PluginRequest{PluginID: plugin.Id}
+			if safe {
+				req.SafeUpdate = local.Info.Version
+			}
+			latest, err := SelectVersion(plugin, req)
+			if err != nil {
+				break
+			}
+
+			if v, err := version.NewVersion(latest.Version); err == nil && installed.LessThan(v) {
+				updates = append(updates, Update{PluginID: local.Id, InstalledVersion: local.Info.Version, Version: latest.Version})
+			}
+			break
+		}
+	}
+
+	return updates
+}
+
+// Deprecation is an installed plugin the repository flags as deprecated or
+// end of life, see DeprecationNotice.
+type Deprecation struct {
+	PluginID  string `json:"pluginId"`
+	EndOfLife bool   `json:"endOfLife"`
+	Notice    string `json:"notice"`
+}
+
+func installedDeprecations(remote m.PluginRepo, pluginDir string) []Deprecation {
+	flagged := map[string]m.Plugin{}
+	for _, plugin := range remote.Plugins {
+		if plugin.Deprecated || plugin.EndOfLife {
+			flagged[plugin.Id] = plugin
+		}
+	}
+
+	var deprecations []Deprecation
+	for _, local := range GetLocalPlugins(pluginDir) {
+		if plugin, ok := flagged[local.Id]; ok {
+			deprecations = append(deprecations, Deprecation{PluginID: plugin.Id, EndOfLife: plugin.EndOfLife, Notice: DeprecationNotice(plugin)})
+		}
+	}
+
+	return deprecations
+}
+
+// MaintenanceWindow is a daily period updates may be applied in. Start and
+// End are offsets from midnight, a window ending before it starts crosses
+// midnight. An empty Weekdays allows every day.
+type MaintenanceWindow struct {
+	Weekdays []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseMaintenanceWindow reads windows like "02:00-05:00" or "Sat,Sun 22:00-02:00".
+func ParseMaintenanceWindow(value string) (MaintenanceWindow, error) {
+	var w MaintenanceWindow
+
+	fields := strings.Fields(value)
+	if len(fields) == 2 {
+		for _, day := range strings.Split(fields[0], ",") {
+			d, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return w, fmt.Errorf("invalid weekday %q in maintenance window %q", day, value)
+			}
+			w.Weekdays = append(w.Weekdays, d)
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return w, fmt.Errorf("invalid maintenance window %q, expected [days] HH:MM-HH:MM", value)
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid maintenance window %q, expected [days] HH:MM-HH:MM", value)
+	}
+
+	var err error
+	if w.Start, err = parseTimeOfDay(times[0]); err != nil {
+		return w, err
+	}
+	if w.End, err = parseTimeOfDay(times[1]); err != nil {
+		return w, err
+	}
+
+	return w, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls within the window, in t's location.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	if w.Start <= w.End {
+		return w.onDay(t.Weekday()) && offset >= w.Start && offset < w.End
+	}
+
+	// the part after midnight belongs to the window opened the day before
+	return w.onDay(t.Weekday()) && offset >= w.Start ||
+		w.onDay(midnight.AddDate(0, 0, -1).Weekday()) && offset < w.End
+}
+
+func (w MaintenanceWindow) onDay(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+
+	for _, d := range w.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateResult records the outcome of applying a single update.
+type UpdateResult struct {
+	Update
+	Error     string    `json:"error,omitempty"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// Updater checks for plugin updates on a cron schedule and applies them
+// inside the configured maintenance windows.
+type Updater struct {
+	RepoURL   string
+	PluginDir string
+	Schedule  cron.Schedule
+	// Windows restricts when updates are applied, empty allows any time.
+	Windows []MaintenanceWindow
+	Pins    map[string]string
+	// SafeUpdates only applies patch releases of the installed major.minor
+	// versions, leaving minor and major updates to be reviewed.
+	SafeUpdates bool
+	// Apply installs a single update.
+	Apply func(u Update) error
+	// HistoryFile, if set, gets every result appended as a JSON line.
+	HistoryFile string
+	Clock       clock.Clock
+
+	mtx     sync.Mutex
+	results []UpdateResult
+}
+
+// NewUpdater parses the cron schedule of an updater.
+func NewUpdater(schedule string, apply func(u Update) error) (*Updater, error) {
+	s, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid update schedule %q: %v", schedule, err)
+	}
+
+	return &Updater{Schedule: s, Apply: apply, Clock: getClock()}, nil
+}
+
+// Run applies updates on every scheduled check until ctx is cancelled.
+func (u *Updater) Run(ctx context.Context) error {
+	for {
+		now := u.Clock.Now()
+		timer := u.Clock.Timer(u.Schedule.Next(now).Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+			u.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce checks for updates and applies them if the current time is inside
+// a maintenance window. Updates left when the window closes or Shutdown is
+// called wait for the next scheduled check.
+func (u *Updater) RunOnce(ctx context.Context) []UpdateResult {
+	if !u.inWindow() {
+		log.Debugf("outside of maintenance windows, not checking for updates\n")
+		return nil
+	}
+
+	check := CheckForUpdates
+	if u.SafeUpdates {
+		check = CheckForSafeUpdates
+	}
+	end, err := BeginOperation()
+	if err != nil {
+		log.Infof("not checking for plugin updates: %v\n", err)
+		return nil
+	}
+	updates, err := check(u.RepoURL, u.PluginDir, u.Pins)
+	end()
+	if err != nil {
+		log.Errorf("failed to check for plugin updates: %v\n", err)
+		return nil
+	}
+
+	var results []UpdateResult
+	for _, update := range updates {
+		if ctx.Err() != nil || !u.inWindow() {
+			log.Infof("maintenance window closed, %d updates postponed\n", len(updates)-len(results))
+			break
+		}
+
+		// each update is an operation Shutdown waits for, so updates in
+		// progress finish while the remaining ones are postponed
+		end, err := BeginOperation()
+		if err != nil {
+			log.Infof("%v, %d updates postponed\n", err, len(updates)-len(results))
+			break
+		}
+		result := UpdateResult{Update: update}
+		err = u.Apply(update)
+		end()
+		if err != nil {
+			log.Errorf("failed to update %v to %v: %v\n", update.PluginID, update.Version, err)
+			result.Error = err.Error()
+		}
+		result.AppliedAt = u.Clock.Now().UTC()
+
+		results = append(results, result)
+		u.record(result)
+	}
+
+	u.mtx.Lock()
+	u.results = results
+	u.mtx.Unlock()
+
+	return results
+}
+
+// Results returns the results of the last check that applied updates.
+func (u *Updater) Results() []UpdateResult {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	return append([]UpdateResult(nil), u.results...)
+}
+
+func (u *Updater) inWindow() bool {
+	if len(u.Windows) == 0 {
+		return true
+	}
+
+	now := u.Clock.Now()
+	for _, w := range u.Windows {
+		if w.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Updater) record(result UpdateResult) {
+	if u.HistoryFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(u.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Errorf("failed to record update result: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	f.Write(append(line, '\n'))
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	Convey("Maintenance windows", t, func() {
+		// 2019-06-01 is a Saturday
+		at := func(day int, hour, minute int) time.Time {
+			return time.Date(2019, 6, day, hour, minute, 0, 0, time.UTC)
+		}
+
+		Convey("Daily window", func() {
+			w, err := ParseMaintenanceWindow("02:00-05:00")
+			So(err, ShouldBeNil)
+			So(w.Contains(at(3, 2, 0)), ShouldBeTrue)
+			So(w.Contains(at(3, 4, 59)), ShouldBeTrue)
+			So(w.Contains(at(3, 5, 0)), ShouldBeFalse)
+			So(w.Contains(at(3, 1, 59)), ShouldBeFalse)
+		})
+
+		Convey("Weekend window crossing midnight", func() {
+			w, err := ParseMaintenanceWindow("Sat,Sun 22:00-02:00")
+			So(err, ShouldBeNil)
+			So(w.Contains(at(1, 23, 0)), ShouldBeTrue)
+			So(w.Contains(at(2, 1, 0)), ShouldBeTrue)
+			So(w.Contains(at(3, 1, 0)), ShouldBeTrue)
+			So(w.Contains(at(3, 23, 0)), ShouldBeFalse)
+			So(w.Contains(at(1, 1, 0)), ShouldBeFalse)
+		})
+
+		Convey("Invalid windows", func() {
+			_, err := ParseMaintenanceWindow("Caturday 02:00-05:00")
+			So(err, ShouldNotBeNil)
+			_, err = ParseMaintenanceWindow("02:00")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUpdater(t *testing.T) {
+	Convey("Updates are applied inside maintenance windows respecting pins", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"plugins": [
+				{"id": "update-plugin", "versions": [{"version": "2.0.0"}, {"version": "1.0.0"}]},
+				{"id": "pinned-plugin", "versions": [{"version": "3.0.0"}, {"version": "1.5.0"}, {"version": "1.0.0"}]},
+				{"id": "current-plugin", "versions": [{"version": "1.0.0"}]}
+			]}`))
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		for _, id := range []string{"update-plugin", "pinned-plugin", "current-plugin"} {
+			So(os.MkdirAll(filepath.Join(dir, id), 0755), ShouldBeNil)
+			json := `{"id": "` + id + `", "info": {"version": "1.0.0"}}`
+			So(ioutil.WriteFile(filepath.Join(dir, id, "plugin.json"), []byte(json), 0644), ShouldBeNil)
+		}
+
+		var applied []Update
+		updater, err := NewUpdater("0 3 * * *", func(u Update) error {
+			applied = append(applied, u)
+			return nil
+		})
+		So(err, ShouldBeNil)
+
+		mock := clock.NewMock()
+		mock.Set(time.Date(2019, 6, 1, 3, 0, 0, 0, time.UTC))
+		window, _ := ParseMaintenanceWindow("02:00-05:00")
+
+		updater.Clock = mock
+		updater.RepoURL = server.URL
+		updater.PluginDir = dir
+		updater.Windows = []MaintenanceWindow{window}
+		updater.Pins = map[string]string{"pinned-plugin": "1.5.0"}
+		updater.HistoryFile = filepath.Join(dir, "history.jsonl")
+
+		results := updater.RunOnce(context.Background())
+		So(results, ShouldHaveLength, 2)
+		So(applied, ShouldResemble, []Update{
+			{PluginID: "pinned-plugin", InstalledVersion: "1.0.0", Version: "1.5.0"},
+			{PluginID: "update-plugin", InstalledVersion: "1.0.0", Version: "2.0.0"},
+		})
+		So(updater.Results(), ShouldResemble, results)
+
+		history, err := ioutil.ReadFile(updater.HistoryFile)
+		So(err, ShouldBeNil)
+		So(string(history), ShouldContainSubstring, `"pluginId":"pinned-plugin"`)
+
+		Convey("Nothing is applied outside of the windows", func() {
+			applied = nil
+			mock.Set(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+			So(updater.RunOnce(context.Background()), ShouldBeEmpty)
+			So(applied, ShouldBeEmpty)
+		})
+	})
+	Convey("Safe updates only move to patch releases", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"plugins": [
+				{"id": "patched-plugin", "versions": [{"version": "2.0.0"}, {"version": "1.1.0"}, {"version": "1.0.2"}, {"version": "1.0.1"}, {"version": "1.0.0"}]},
+				{"id": "minor-plugin", "versions": [{"version": "1.1.0"}, {"version": "1.0.0"}]}
+			]}`))
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		for _, id := range []string{"patched-plugin", "minor-plugin"} {
+			So(os.MkdirAll(filepath.Join(dir, id), 0755), ShouldBeNil)
+			json := `{"id": "` + id + `", "info": {"version": "1.0.0"}}`
+			So(ioutil.WriteFile(filepath.Join(dir, id, "plugin.json"), []byte(json), 0644), ShouldBeNil)
+		}
+
+		var applied []Update
+		updater, err := NewUpdater("0 3 * * *", func(u Update) error {
+			applied = append(applied, u)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		updater.RepoURL = server.URL
+		updater.PluginDir = dir
+		updater.SafeUpdates = true
+
+		updater.RunOnce(context.Background())
+		So(applied, ShouldResemble, []Update{
+			{PluginID: "patched-plugin", InstalledVersion: "1.0.0", Version: "1.0.2"},
+		})
+	})
+}
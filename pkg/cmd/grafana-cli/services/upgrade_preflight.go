@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"github.com/hashicorp/go-version"
+)
+
+// UpgradeStatus is how an installed plugin fares with a Grafana upgrade.
+type UpgradeStatus string
+
+const (
+	// UpgradeCompatible plugins keep working at their installed version.
+	UpgradeCompatible UpgradeStatus = "compatible"
+	// UpgradeRequired plugins must be updated to at least MinimumVersion.
+	UpgradeRequired UpgradeStatus = "update-required"
+	// UpgradeNoCompatibleVersion plugins have no version newer than the
+	// installed one supporting the target, they must be removed first.
+	UpgradeNoCompatibleVersion UpgradeStatus = "no-compatible-version"
+	// UpgradeUnknown plugins could not be checked, see Error.
+	UpgradeUnknown UpgradeStatus = "unknown"
+)
+
+// PluginUpgradeCheck is the result of PreflightGrafanaUpgrade for one plugin.
+type PluginUpgradeCheck struct {
+	PluginID         string        `json:"pluginId"`
+	InstalledVersion string        `json:"installedVersion"`
+	Status           UpgradeStatus `json:"status"`
+	// MinimumVersion is the oldest version after the installed one that
+	// supports the target Grafana version, when the installed one does not
+	// or its compatibility is unknown.
+	MinimumVersion string `json:"minimumVersion,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// PreflightGrafanaUpgrade reports for each of installedPlugins whether it is
+// compatible with targetGrafanaVersion, according to the Grafana dependency
+// its versions declare in the configured repository. Versions that are
+// yanked, excluded or not built for this platform are not suggested.
+// Plugins that cannot be looked up are reported as UpgradeUnknown rather
+// than failing the check, only an invalid target version is an error.
+func PreflightGrafanaUpgrade(ctx context.Context, targetGrafanaVersion string, installedPlugins []m.InstalledPlugin) ([]PluginUpgradeCheck, error) {
+	if _, err := version.NewVersion(targetGrafanaVersion); err != nil {
+		return nil, fmt.Errorf("invalid grafana version %q: %v", targetGrafanaVersion, err)
+	}
+
+	repoUrl := RepoURL()
+	checks := make([]PluginUpgradeCheck, 0, len(installedPlugins))
+	for _, local := range installedPlugins {
+		check := PluginUpgradeCheck{PluginID: local.Id, InstalledVersion: local.Info.Version, Status: UpgradeUnknown}
+
+		plugin, err := GetPluginWithContext(ctx, local.Id, repoUrl)
+		if err != nil {
+			check.Error = err.Error()
+			checks = append(checks, check)
+			continue
+		}
+
+		checks = append(checks, checkUpgrade(plugin, check, targetGrafanaVersion))
+	}
+
+	return checks, nil
+}
+
+func checkUpgrade(plugin m.Plugin, check PluginUpgradeCheck, targetGrafanaVersion string) PluginUpgradeCheck {
+	installed, err := version.NewVersion(check.InstalledVersion)
+	if err != nil {
+		check.Error = fmt.Sprintf("invalid installed version %q: %v", check.InstalledVersion, err)
+		return check
+	}
+
+	req := PluginRequest{PluginID: plugin.Id, GrafanaVersions: []string{targetGrafanaVersion}}
+	listed := false
+	var unknown error
+	var minimum *version.Version
+	for _, v := range plugin.Versions {
+		candidate, err := version.NewVersion(v.Version)
+		if err != nil {
+			continue
+		}
+
+		// a dependency that cannot be parsed leaves the compatibility unknown
+		compatible, err := IsCompatible(v, targetGrafanaVersion)
+		if candidate.Equal(installed) {
+			listed = true
+			if err != nil {
+				check.Error = err.Error()
+				return check
+			}
+			if compatible {
+				check.Status = UpgradeCompatible
+				return check
+			}
+			continue
+		}
+
+		if candidate.GreaterThan(installed) && isEligible(v, req) && supportsArch(v) && (minimum == nil || candidate.LessThan(minimum)) {
+			if err != nil {
+				unknown = err
+				continue
+			}
+			minimum = candidate
+			check.MinimumVersion = v.Version
+		}
+	}
+
+	switch {
+	case !listed:
+		check.Error = fmt.Sprintf("%s@%s is not listed in the repository", plugin.Id, check.InstalledVersion)
+	case minimum != nil:
+		check.Status = UpgradeRequired
+	case unknown != nil:
+		check.Error = unknown.Error()
+	default:
+		check.Status = UpgradeNoCompatibleVersion
+	}
+	return check
+}
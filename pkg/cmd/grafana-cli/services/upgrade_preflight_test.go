@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPreflightGrafanaUpgrade(t *testing.T) {
+	Convey("Installed plugins are checked against the target Grafana version", t, func() {
+		plugins := map[string]string{
+			"/repo/current-panel": `{"id": "current-panel", "versions": [{"version": "2.0.0", "grafanaDependency": ">=6.0.0"}]}`,
+			"/repo/outdated-panel": `{"id": "outdated-panel", "versions": [
+				{"version": "3.0.0", "grafanaDependency": ">=7.0.0"},
+				{"version": "2.1.0", "grafanaDependency": ">=7.0.0"},
+				{"version": "2.0.1", "grafanaDependency": ">=7.0.0", "yanked": true},
+				{"version": "2.0.0", "grafanaDependency": "<7.0.0"},
+				{"version": "1.0.0", "grafanaDependency": ">=6.0.0"}]}`,
+			"/repo/abandoned-panel": `{"id": "abandoned-panel", "versions": [{"version": "1.0.0", "grafanaDependency": "<7.0.0"}]}`,
+			"/repo/caret-panel":     `{"id": "caret-panel", "versions": [{"version": "1.0.0", "grafanaDependency": "^7.0.0"}]}`,
+			"/repo/odd-panel": `{"id": "odd-panel", "versions": [
+				{"version": "2.0.0", "grafanaDependency": "next major"},
+				{"version": "1.0.0", "grafanaDependency": "<7.0.0"}]}`,
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := plugins[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+		defer server.Close()
+
+		prevRepoURL := RepoURL()
+		setRepoURL(server.URL)
+		defer setRepoURL(prevRepoURL)
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		installed := func(id, version string) m.InstalledPlugin {
+			return m.InstalledPlugin{Id: id, Info: m.PluginInfo{Version: version}}
+		}
+		checks, err := PreflightGrafanaUpgrade(context.Background(), "7.0.0", []m.InstalledPlugin{
+			installed("current-panel", "2.0.0"),
+			installed("outdated-panel", "2.0.0"),
+			installed("abandoned-panel", "1.0.0"),
+			installed("missing-panel", "1.0.0"),
+			installed("caret-panel", "1.0.0"),
+			installed("odd-panel", "1.0.0"),
+		})
+		So(err, ShouldBeNil)
+		So(checks, ShouldHaveLength, 6)
+
+		So(checks[0].Status, ShouldEqual, UpgradeCompatible)
+		So(checks[0].MinimumVersion, ShouldBeEmpty)
+
+		So(checks[1].Status, ShouldEqual, UpgradeRequired)
+		So(checks[1].MinimumVersion, ShouldEqual, "2.1.0")
+
+		So(checks[2].Status, ShouldEqual, UpgradeNoCompatibleVersion)
+
+		So(checks[3].Status, ShouldEqual, UpgradeUnknown)
+		So(checks[3].Error, ShouldNotBeEmpty)
+
+		So(checks[4].Status, ShouldEqual, UpgradeCompatible)
+
+		So(checks[5].Status, ShouldEqual, UpgradeUnknown)
+		So(checks[5].Error, ShouldContainSubstring, "next major")
+
+		Convey("rejecting invalid target versions", func() {
+			_, err := PreflightGrafanaUpgrade(context.Background(), "next", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
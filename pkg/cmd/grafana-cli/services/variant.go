@@ -0,0 +1,56 @@
+package services
+
+import (
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+)
+
+// FrontendOnlyVariant is the arch key under which plugins publish a slim
+// archive without backend binaries.
+const FrontendOnlyVariant = "frontend"
+
+var preferFrontendOnly bool
+
+// SetPreferFrontendOnly selects the frontend-only archive when a version
+// publishes one.
+func SetPreferFrontendOnly(prefer bool) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	preferFrontendOnly = prefer
+}
+
+func getPreferFrontendOnly() bool {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return preferFrontendOnly
+}
+
+// SelectArchive returns the arch key and metadata of the archive to install
+// for v on this host.
+func SelectArchive(v m.Version) (string, m.ArchMeta, bool) {
+	keys := archKeys()
+	if getPreferSlimArtifacts() {
+		keys = withSlimVariants(keys)
+	}
+	keys = append(append([]string{}, keys...), "any")
+	if getPreferFrontendOnly() {
+		keys = append([]string{FrontendOnlyVariant}, keys...)
+	}
+
+	for _, key := range keys {
+		meta, ok := v.Arch[key]
+		if !ok {
+			continue
+		}
+
+		// the frontend-only archive is only usable when it has its own download url
+		if key == FrontendOnlyVariant && meta.Url == "" {
+			continue
+		}
+
+		return key, meta, true
+	}
+
+	return "", m.ArchMeta{}, false
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// files written next to installed plugins by grafana-cli itself
+var ignoredPluginFiles = map[string]bool{
+	"verification-report.json": true,
+}
+
+// PluginManifest lists the sha256 digest of every file in a plugin version,
+// keyed by slash separated path relative to the plugin directory.
+type PluginManifest struct {
+	Plugin  string            `json:"plugin"`
+	Version string            `json:"version"`
+	Files   map[string]string `json:"files"`
+}
+
+// DriftReport describes how an installed plugin differs from what the
+// repository published for its version.
+type DriftReport struct {
+	PluginID         string
+	Directory        string
+	InstalledVersion string
+	// UnknownVersion is set when the repository does not publish the installed version.
+	UnknownVersion bool
+	Modified       []string
+	Missing        []string
+	Added          []string
+	// Err is set when the plugin could not be verified, e.g. because the
+	// repository publishes no manifest for it.
+	Err error
+}
+
+// HasDrift reports whether the installed plugin does not match the repository.
+func (r DriftReport) HasDrift() bool {
+	return r.UnknownVersion || len(r.Modified) > 0 || len(r.Missing) > 0 || len(r.Added) > 0
+}
+
+// GetManifest returns the file manifest of a plugin version, read from the
+// archive the repository publishes for this platform once its checksum is
+// verified. Repositories publish no separate manifests.
+func GetManifest(ctx context.Context, repoUrl, pluginId, version string) (PluginManifest, error) {
+	res, err := ResolveWithContext(ctx, repoUrl, PluginRequest{PluginID: pluginId, Version: version, AllowYanked: true})
+	if err != nil {
+		return PluginManifest{}, err
+	}
+
+	body, err := DownloadArchiveWithContext(ctx, pluginId, res.URL)
+	if err != nil {
+		return PluginManifest{}, err
+	}
+	if res.Checksum != "" {
+		if err := VerifyChecksum(body, res.Checksum); err != nil {
+			return PluginManifest{}, err
+		}
+	}
+
+	return ArchiveManifest(pluginId, res.Version.Version, body)
+}
+
+// ArchiveManifest hashes the files of a plugin archive, keyed by their path
+// once extracted into the plugin directory, below the top directory.
+func ArchiveManifest(pluginId, version string, body []byte) (PluginManifest, error) {
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return PluginManifest{}, err
+	}
+
+	manifest := PluginManifest{Plugin: pluginId, Version: version, Files: map[string]string{}}
+	for _, zf := range r.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		name := strings.TrimPrefix(zf.Name, "/")
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return PluginManifest{}, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return PluginManifest{}, fmt.Errorf("%s: %v", zf.Name, err)
+		}
+		manifest.Files[name] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	return manifest, nil
+}
+
+// VerifyInstalled compares every plugin in pluginDir with the manifest the
+// repository publishes for the installed version, to catch tampering or
+// botched manual edits.
+func VerifyInstalled(ctx context.Context, repoUrl, pluginDir string) ([]DriftReport, error) {
+	files, err := IoHelper.ReadDir(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []DriftReport
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return reports, err
+		}
+
+		installed, err := ReadPlugin(pluginDir, f.Name())
+		if err != nil {
+			continue
+		}
+
+		report := DriftReport{PluginID: installed.Id, Directory: f.Name(), InstalledVersion: installed.Info.Version}
+		report.Err = verifyPlugin(ctx, repoUrl, filepath.Join(pluginDir, f.Name()), &report)
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+func verifyPlugin(ctx context.Context, repoUrl, dir string, report *DriftReport) error {
+	plugin, err := GetPluginWithContext(ctx, report.PluginID, repoUrl)
+	if err != nil {
+		return err
+	}
+
+	if _, err := SelectVersion(plugin, PluginRequest{PluginID: report.PluginID, Version: report.InstalledVersion, AllowYanked: true}); xerrors.Is(err, ErrVersionNotFound) {
+		report.UnknownVersion = true
+		return nil
+	}
+
+	manifest, err := GetManifest(ctx, repoUrl, report.PluginID, report.InstalledVersion)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %v", err)
+	}
+
+	actual, err := hashPluginFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for name, digest := range manifest.Files {
+		got, ok := actual[name]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, name)
+		case got != digest:
+			report.Modified = append(report.Modified, name)
+		}
+	}
+
+	for name := range actual {
+		if _, ok := manifest.Files[name]; !ok {
+			report.Added = append(report.Added, name)
+		}
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Modified)
+	sort.Strings(report.Added)
+
+	return nil
+}
+
+func hashPluginFiles(dir string) (map[string]string, error) {
+	digests := map[string]string{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignoredPluginFiles[rel] {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+
+		digests[rel] = fmt.Sprintf("%x", h.Sum(nil))
+		return nil
+	})
+
+	return digests, err
+}
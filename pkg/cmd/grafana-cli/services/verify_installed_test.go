@@ -0,0 +1,85 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifyInstalled(t *testing.T) {
+	Convey("Installed plugins are compared with the archive of their version", t, func() {
+		prevIoHelper := IoHelper
+		IoHelper = IoUtilImp{}
+		defer func() { IoHelper = prevIoHelper }()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		pluginJSON := `{"id": "drift-panel", "info": {"version": "1.0.0"}}`
+		var archive bytes.Buffer
+		w := zip.NewWriter(&archive)
+		for name, content := range map[string]string{
+			"drift-panel-abc123/plugin.json":      pluginJSON,
+			"drift-panel-abc123/module.js":        "module",
+			"drift-panel-abc123/img/logo.svg":     "logo",
+			"drift-panel-abc123/dist/README.md":   "readme",
+			"drift-panel-abc123/dist/unused.json": "{}",
+		} {
+			f, _ := w.Create(name)
+			f.Write([]byte(content))
+		}
+		w.Close()
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repo/drift-panel":
+				fmt.Fprintf(w, `{"id": "drift-panel", "versions": [{"version": "1.0.0", "arch": {"any": {"url": "%s/drift-panel.zip", "sha256": "%x"}}}]}`, server.URL, sha256.Sum256(archive.Bytes()))
+			case "/drift-panel.zip":
+				w.Write(archive.Bytes())
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "plugins")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		write := func(name, content string) {
+			path := filepath.Join(dir, "drift-panel", filepath.FromSlash(name))
+			So(os.MkdirAll(filepath.Dir(path), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+		}
+		write("plugin.json", pluginJSON)
+		write("module.js", "tampered")
+		write("img/logo.svg", "logo")
+		write("dist/README.md", "readme")
+		write("extra.js", "added")
+
+		reports, err := VerifyInstalled(context.Background(), server.URL, dir)
+		So(err, ShouldBeNil)
+		So(reports, ShouldHaveLength, 1)
+		So(reports[0].Err, ShouldBeNil)
+		So(reports[0].Modified, ShouldResemble, []string{"module.js"})
+		So(reports[0].Missing, ShouldResemble, []string{"dist/unused.json"})
+		So(reports[0].Added, ShouldResemble, []string{"extra.js"})
+		So(reports[0].HasDrift(), ShouldBeTrue)
+
+		Convey("refusing archives that do not match their checksum", func() {
+			archive.WriteString("corrupted")
+
+			_, err := GetManifest(context.Background(), server.URL, "drift-panel", "1.0.0")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	m "github.com/grafana/grafana/pkg/cmd/grafana-cli/models"
+	"golang.org/x/xerrors"
+)
+
+var ErrVersionsPageOutsideRepo = errors.New("next versions page is outside of the repository")
+
+var versionsPageSize int
+
+// SetVersionsPageSize makes resolution fetch only the newest size versions of
+// a plugin, and the next pages only when the requested version is not among
+// them. It relies on the repository listing versions newest first and
+// linking the next page as nextVersionsPage. Repositories that do not
+// paginate return every version as before. 0 fetches all versions at once,
+// as do repositories with signed indexes, whose signatures cover the
+// complete metadata.
+func SetVersionsPageSize(size int) {
+	stateMtx.Lock()
+	defer stateMtx.Unlock()
+
+	versionsPageSize = size
+}
+
+func getVersionsPageSize() int {
+	stateMtx.RLock()
+	defer stateMtx.RUnlock()
+
+	return versionsPageSize
+}
+
+// maxVersionsPages bounds the pages followed, in case a repository links
+// pages in a loop.
+const maxVersionsPages = 100
+
+func versionsPageCacheKey(pluginId string, pageSize int) string {
+	return fmt.Sprintf("%s?versionsPageSize=%d", pluginId, pageSize)
+}
+
+// getVersionsPage returns the metadata of a plugin with the first page of
+// its versions, or every version when paging is off or already cached.
+func getVersionsPage(ctx context.Context, pluginId, repoUrl string) (pluginMetadata, error) {
+	pageSize := getVersionsPageSize()
+	if pageSize <= 0 || len(getIndexKeys()) > 0 || IsStaticRepo(repoUrl) {
+		return getPluginMetadata(ctx, pluginId, repoUrl)
+	}
+	if _, _, ok := getCachedPlugin(repoUrl, pluginId); ok {
+		return getPluginMetadata(ctx, pluginId, repoUrl)
+	}
+
+	key := versionsPageCacheKey(pluginId, pageSize)
+	if plugin, fetchedAt, ok := getCachedPlugin(repoUrl, key); ok {
+		if err := getTrustPolicy().Check(plugin); err != nil {
+			return pluginMetadata{}, err
+		}
+		return pluginMetadata{plugin: plugin, fetchedAt: fetchedAt, cached: true}, nil
+	}
+
+	u := fmt.Sprintf("%s?versionsPageSize=%d", repoPath(repoUrl, "repo", pluginId), pageSize)
+	plugin, body, err := fetchVersionsPage(ctx, pluginId, repoUrl, u)
+	if err != nil {
+		// the full metadata request reports not found plugins and serves stale metadata
+		log.Debugf("failed to fetch the first versions page of %v, fetching all versions: %v\n", pluginId, err)
+		return getPluginMetadata(ctx, pluginId, repoUrl)
+	}
+
+	fetchedAt := getClock().Now().UTC()
+	setCachedPlugin(repoUrl, key, body, fetchedAt)
+
+	if err := getTrustPolicy().Check(plugin); err != nil {
+		return pluginMetadata{}, err
+	}
+	return pluginMetadata{plugin: plugin, fetchedAt: fetchedAt}, nil
+}
+
+// nextVersionsPage adds the versions of the next page to md.
+func nextVersionsPage(ctx context.Context, pluginId, repoUrl string, md pluginMetadata) (pluginMetadata, error) {
+	base, err := url.Parse(repoPath(repoUrl, "repo", pluginId))
+	if err != nil {
+		return md, err
+	}
+	next, err := base.Parse(md.plugin.NextVersionsPage)
+	if err != nil {
+		return md, repoError(OpGetPlugin, pluginId, md.plugin.NextVersionsPage, err)
+	}
+	// pages are fetched with the credentials of the repository
+	if next.Scheme != base.Scheme || next.Host != base.Host {
+		return md, repoError(OpGetPlugin, pluginId, md.plugin.NextVersionsPage, ErrVersionsPageOutsideRepo)
+	}
+
+	page, _, err := fetchVersionsPage(ctx, pluginId, repoUrl, next.String())
+	if err != nil {
+		return md, err
+	}
+
+	md.plugin.Versions = append(append([]m.Version{}, md.plugin.Versions...), page.Versions...)
+	md.plugin.NextVersionsPage = page.NextVersionsPage
+	return md, nil
+}
+
+func fetchVersionsPage(ctx context.Context, pluginId, repoUrl, u string) (m.Plugin, []byte, error) {
+	req, err := newRequest(u)
+	if err != nil {
+		return m.Plugin{}, nil, err
+	}
+	req = req.WithContext(ctx)
+
+	body, err := readResponse(doNegotiated(repoUrl, &RepoRequest{Op: OpGetPlugin, PluginID: pluginId, Request: req}))
+	if err != nil {
+		return m.Plugin{}, nil, repoError(OpGetPlugin, pluginId, u, err)
+	}
+
+	var plugin m.Plugin
+	if err := decodeResponse(u, body, &plugin); err != nil {
+		return m.Plugin{}, nil, repoError(OpGetPlugin, pluginId, u, err)
+	}
+	return plugin, body, nil
+}
+
+// selectPagedVersion selects the requested version, fetching further pages
+// of versions while it may be on one of them.
+func selectPagedVersion(ctx context.Context, repoUrl string, req PluginRequest, md pluginMetadata) (m.Version, pluginMetadata, error) {
+	v, err := SelectVersion(md.plugin, req)
+	for page := 1; err != nil && md.plugin.NextVersionsPage != "" && onLaterPage(err); page++ {
+		if page > maxVersionsPages {
+			return v, md, fmt.Errorf("%s: more than %d pages of versions", req.PluginID, maxVersionsPages)
+		}
+
+		log.Debugf("fetching more versions of %v from %v\n", req.PluginID, md.plugin.NextVersionsPage)
+		var pageErr error
+		if md, pageErr = nextVersionsPage(ctx, req.PluginID, repoUrl, md); pageErr != nil {
+			return v, md, pageErr
+		}
+		v, err = SelectVersion(md.plugin, req)
+	}
+	return v, md, err
+}
+
+// onLaterPage reports whether a version selection failure may be resolved
+// by older versions.
+func onLaterPage(err error) bool {
+	return xerrors.Is(err, ErrVersionNotFound) || xerrors.Is(err, ErrNoCompatibleVersion) || xerrors.Is(err, ErrArchNotSupported)
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/xerrors"
+)
+
+func TestVersionPages(t *testing.T) {
+	Convey("Versions are fetched page by page while the requested one is missing", t, func() {
+		var requests []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/repo/") {
+				requests = append(requests, r.URL.String())
+			}
+			switch r.URL.Query().Get("page") {
+			case "":
+				w.Write([]byte(`{"id": "paged-panel", "versions": [
+					{"version": "3.0.0"}, {"version": "2.0.0"}
+				], "nextVersionsPage": "paged-panel?versionsPageSize=2&page=2"}`))
+			case "2":
+				w.Write([]byte(`{"id": "paged-panel", "versions": [{"version": "1.0.0"}]}`))
+			}
+		}))
+		defer server.Close()
+
+		SetVersionsPageSize(2)
+		defer SetVersionsPageSize(0)
+		defer invalidateMetadata("paged-panel", server.URL)
+
+		res, err := Resolve(server.URL, PluginRequest{PluginID: "paged-panel"})
+		So(err, ShouldBeNil)
+		So(res.Version.Version, ShouldEqual, "3.0.0")
+		So(requests, ShouldResemble, []string{"/repo/paged-panel?versionsPageSize=2"})
+
+		Convey("and older pages are only fetched for older versions", func() {
+			requests = nil
+			res, err := Resolve(server.URL, PluginRequest{PluginID: "paged-panel", Version: "1.0.0"})
+			So(err, ShouldBeNil)
+			So(res.Version.Version, ShouldEqual, "1.0.0")
+			So(requests, ShouldResemble, []string{"/repo/paged-panel?versionsPageSize=2&page=2"})
+		})
+
+		Convey("and versions on no page are not found", func() {
+			_, err := Resolve(server.URL, PluginRequest{PluginID: "paged-panel", Version: "0.1.0"})
+			So(xerrors.Is(err, ErrVersionNotFound), ShouldBeTrue)
+		})
+	})
+
+	Convey("Versions pages are only fetched from the repository", t, func() {
+		var requests []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.String())
+			w.Write([]byte(`{"id": "redirected-panel", "versions": [
+				{"version": "3.0.0"}, {"version": "2.0.0"}
+			], "nextVersionsPage": "http://evil.example.com/redirected-panel?page=2"}`))
+		}))
+		defer server.Close()
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		defer SetRetryPolicy(DefaultRetryPolicy)
+
+		SetVersionsPageSize(2)
+		defer SetVersionsPageSize(0)
+		defer invalidateMetadata("redirected-panel", server.URL)
+
+		_, err := Resolve(server.URL, PluginRequest{PluginID: "redirected-panel", Version: "1.0.0"})
+		So(xerrors.Is(err, ErrVersionsPageOutsideRepo), ShouldBeTrue)
+		So(requests, ShouldResemble, []string{"/repo/redirected-panel?versionsPageSize=2"})
+	})
+
+	Convey("Repositories ignoring the page size return every version", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "unpaged-panel", "versions": [
+				{"version": "3.0.0"}, {"version": "2.0.0"}, {"version": "1.0.0"}
+			]}`))
+		}))
+		defer server.Close()
+
+		SetVersionsPageSize(2)
+		defer SetVersionsPageSize(0)
+		defer invalidateMetadata("unpaged-panel", server.URL)
+
+		res, err := Resolve(server.URL, PluginRequest{PluginID: "unpaged-panel", Version: "1.0.0"})
+		So(err, ShouldBeNil)
+		So(res.Version.Version, ShouldEqual, "1.0.0")
+	})
+}
@@ -14,6 +14,8 @@ type CommandLine interface {
 	String(name string) string
 	StringSlice(name string) []string
 	GlobalString(name string) string
+	GlobalBool(name string) bool
+	GlobalInt(name string) int
 	FlagNames() (names []string)
 	Generic(name string) interface{}
 
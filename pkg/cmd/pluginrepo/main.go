@@ -0,0 +1,361 @@
+// Command pluginrepo scripts plugin repository operations, like mirroring
+// and resolving plugins, without a running Grafana.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var version = "master"
+
+func main() {
+	for _, f := range os.Args {
+		if f == "-d" || f == "--debug" || f == "-debug" {
+			logger.SetDebug(true)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Name = "pluginrepo"
+	app.Usage = "manage Grafana plugin repositories and mirrors"
+	app.Author = "Grafana Project"
+	app.Email = "https://github.com/grafana/grafana"
+	app.Version = version
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "url to the plugin repository",
+			Value:  services.DefaultRepoURL,
+			EnvVar: "GF_PLUGIN_REPO",
+		},
+		cli.StringFlag{
+			Name:   "repoToken",
+			Usage:  "bearer token to authenticate to the plugin repository with",
+			EnvVar: "GF_PLUGIN_REPO_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "repoNamespaces",
+			Usage:  "comma separated list of name=url repositories plugins can be resolved from as <name>/<plugin id>",
+			EnvVar: "GF_PLUGIN_REPO_NAMESPACES",
+		},
+		cli.StringFlag{
+			Name:   "repoIndexKeys",
+			Usage:  "comma separated base64 ed25519 public keys, or files holding them, the repository index must be signed with",
+			EnvVar: "GF_PLUGIN_REPO_INDEX_KEYS",
+		},
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "reject repository responses with unknown or missing fields, to validate a mirror index",
+		},
+		cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "Skip TLS verification (insecure)",
+		},
+		cli.BoolFlag{
+			Name:   "verifyWrites",
+			Usage:  "read mirrored archives back after writing them and compare their digest, for unreliable storage",
+			EnvVar: "GF_PLUGIN_VERIFY_ARCHIVE_WRITES",
+		},
+		cli.BoolFlag{
+			Name:  "debug, d",
+			Usage: "enable debug logging",
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		opts := []services.Option{services.WithRepoURL(c.GlobalString("repo"))}
+		if c.GlobalBool("strict") {
+			opts = append(opts, services.WithStrictDecoding())
+		}
+		services.Init(version, c.GlobalBool("insecure"), opts...)
+		ns, err := services.ParseNamespaces(c.GlobalString("repoNamespaces"))
+		if err != nil {
+			return err
+		}
+		services.SetNamespaces(ns)
+		keys, err := services.ParseIndexKeys(c.GlobalString("repoIndexKeys"))
+		if err != nil {
+			return err
+		}
+		services.SetIndexKeys(keys)
+		services.SetVerifyWrites(c.GlobalBool("verifyWrites"))
+		if token := c.GlobalString("repoToken"); token != "" {
+			services.UpdateCredentials(c.GlobalString("repo"), services.Credentials{Token: token})
+		}
+		return nil
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:      "sync",
+			Usage:     "copy plugins into a mirror directory",
+			ArgsUsage: "<plugin id>...",
+			Action:    run(syncCommand),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "dir", Usage: "mirror directory"},
+				cli.BoolFlag{Name: "allVersions", Usage: "mirror every version instead of only the latest"},
+				cli.StringFlag{Name: "signingKey", Usage: "base64 ed25519 private key, or a file holding it, to sign the mirrored metadata with", EnvVar: "GF_PLUGIN_MIRROR_SIGNING_KEY"},
+			},
+		},
+		{
+			Name:   "sign",
+			Usage:  "sign every index of a mirror directory",
+			Action: run(signCommand),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "dir", Usage: "mirror directory"},
+				cli.StringFlag{Name: "signingKey", Usage: "base64 ed25519 private key, or a file holding it", EnvVar: "GF_PLUGIN_MIRROR_SIGNING_KEY"},
+			},
+		},
+		{
+			Name:   "export",
+			Usage:  "write a mirror directory as a zip bundle",
+			Action: run(exportCommand),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "dir", Usage: "mirror directory"},
+				cli.StringFlag{Name: "out", Usage: "bundle file to write"},
+			},
+		},
+		{
+			Name:   "verify",
+			Usage:  "verify installed plugins against the repository",
+			Action: run(verifyCommand),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "pluginsDir", Usage: "path to the grafana plugin directory", EnvVar: "GF_PLUGIN_DIR"},
+			},
+		},
+		{
+			Name:   "proxy",
+			Usage:  "serve a caching proxy of the repository, with the cache admin api on its own address",
+			Action: run(proxyCommand),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "addr", Usage: "address to serve the proxy on", Value: ":3100"},
+				cli.StringFlag{Name: "adminAddr", Usage: "address to serve /metrics and the cache admin api on, GET and DELETE ?scope=<scope> /api/cache", Value: "127.0.0.1:3101"},
+				cli.StringFlag{Name: "archiveTTL", Usage: "how long proxied archives are cached, 0 disables caching archives", Value: "1h"},
+			},
+		},
+		{
+			Name:      "resolve",
+			Usage:     "print download urls and checksums without downloading",
+			ArgsUsage: "<plugin id>[@<version>]...",
+			Action:    run(resolveCommand),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "explain", Usage: "print why every other version was not chosen"},
+			},
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		logger.Errorf("%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(command func(c *cli.Context) error) func(c *cli.Context) {
+	return func(c *cli.Context) {
+		if err := command(c); err != nil {
+			logger.Errorf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func syncCommand(c *cli.Context) error {
+	dir := c.String("dir")
+	if dir == "" || len(c.Args()) == 0 {
+		return errors.New("usage: pluginrepo sync --dir <mirror dir> <plugin id>...")
+	}
+
+	// an interrupt stops the sync after writing what was mirrored so far,
+	// running it again continues with the archives still missing
+	job := services.NewJob(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupts:
+			status := job.Status()
+			logger.Infof("cancelling sync after %d of %d plugins\n", status.Done, status.Total)
+			job.Cancel()
+		case <-done:
+		}
+	}()
+
+	opts := services.SyncOptions{AllVersions: c.Bool("allVersions"), Job: job}
+	if key := c.String("signingKey"); key != "" {
+		signingKey, err := services.ParseSigningKey(key)
+		if err != nil {
+			return err
+		}
+		opts.SigningKey = signingKey
+	}
+
+	res, err := services.SyncMirror(job.Context(), c.GlobalString("repo"), dir, c.Args(), opts)
+	for _, name := range res.Synced {
+		logger.Infof("synced %s\n", name)
+	}
+	for _, name := range res.Skipped {
+		logger.Debugf("already mirrored %s\n", name)
+	}
+	return err
+}
+
+func signCommand(c *cli.Context) error {
+	if c.String("dir") == "" || c.String("signingKey") == "" {
+		return errors.New("usage: pluginrepo sign --dir <mirror dir> --signingKey <key>")
+	}
+
+	key, err := services.ParseSigningKey(c.String("signingKey"))
+	if err != nil {
+		return err
+	}
+
+	signed, err := services.SignMirror(c.String("dir"), key)
+	for _, name := range signed {
+		logger.Infof("signed %s\n", name)
+	}
+	return err
+}
+
+func exportCommand(c *cli.Context) error {
+	if c.String("dir") == "" || c.String("out") == "" {
+		return errors.New("usage: pluginrepo export --dir <mirror dir> --out <bundle.zip>")
+	}
+
+	f, err := os.Create(c.String("out"))
+	if err != nil {
+		return err
+	}
+
+	if err := services.ExportBundle(f, c.String("dir")); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func proxyCommand(c *cli.Context) error {
+	archiveTTL, err := time.ParseDuration(c.String("archiveTTL"))
+	if err != nil {
+		return fmt.Errorf("invalid archiveTTL: %v", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := services.RegisterMetrics(reg); err != nil {
+		return err
+	}
+
+	admin := http.NewServeMux()
+	admin.Handle("/api/cache", services.CacheAdminHandler())
+	admin.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	servers := []*http.Server{
+		{Addr: c.String("addr"), Handler: services.NewRepoProxy(c.GlobalString("repo"), archiveTTL)},
+		{Addr: c.String("adminAddr"), Handler: admin},
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			logger.Infof("serving on %s\n", srv.Addr)
+			errs <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	select {
+	case err = <-errs:
+	case <-interrupts:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(ctx)
+	}
+	services.Shutdown(ctx)
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func verifyCommand(c *cli.Context) error {
+	if c.String("pluginsDir") == "" {
+		return errors.New("missing pluginsDir flag")
+	}
+
+	reports, err := services.VerifyInstalled(context.Background(), c.GlobalString("repo"), c.String("pluginsDir"))
+	if err != nil {
+		return err
+	}
+
+	drifted := 0
+	for _, r := range reports {
+		switch {
+		case r.Err != nil:
+			logger.Infof("%s@%s unverified: %v\n", r.PluginID, r.InstalledVersion, r.Err)
+		case r.HasDrift():
+			drifted++
+			logger.Infof("%s@%s drifted: modified=%v missing=%v added=%v unknownVersion=%v\n",
+				r.PluginID, r.InstalledVersion, r.Modified, r.Missing, r.Added, r.UnknownVersion)
+		default:
+			logger.Infof("%s@%s ok\n", r.PluginID, r.InstalledVersion)
+		}
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d installed plugins do not match the repository", drifted)
+	}
+	return nil
+}
+
+func resolveCommand(c *cli.Context) error {
+	if len(c.Args()) == 0 {
+		return errors.New("usage: pluginrepo resolve <plugin id>[@<version>]...")
+	}
+
+	var requests []services.PluginRequest
+	for _, arg := range c.Args() {
+		parts := strings.SplitN(arg, "@", 2)
+		req := services.PluginRequest{PluginID: parts[0]}
+		if len(parts) == 2 {
+			req.Version = parts[1]
+		}
+		requests = append(requests, req)
+	}
+
+	res, err := services.ResolveURLs(context.Background(), requests)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range res {
+		fmt.Printf("%s\t%s\t%s\t%s\n", r.QualifiedID(), r.Version.Version, r.URL, r.Checksum)
+		if c.Bool("explain") {
+			for _, line := range strings.Split(strings.TrimSuffix(r.Explain(), "\n"), "\n") {
+				fmt.Printf("\t%s\n", line)
+			}
+		}
+	}
+	return nil
+}
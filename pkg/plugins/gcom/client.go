@@ -0,0 +1,133 @@
+// Package gcom is a client for the grafana.com plugin repository API
+// and mirrors implementing it. It builds the repository urls and requests and
+// types the unsuccessful responses, decoding the metadata is left to callers
+// such as grafana-cli.
+package gcom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultURL is the plugin repository API of grafana.com.
+const DefaultURL = "https://grafana.com/api/plugins"
+
+var ErrNotFound = errors.New("404 not found error")
+
+// StatusError is returned for responses with a non 2xx status other than 404.
+// URL is the requested url without credentials and Body an excerpt of the
+// response, telling why the repository rejected the request.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	URL        string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("Api returned invalid status: %s", e.Status)
+	if e.URL != "" {
+		msg += " from " + e.URL
+	}
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Doer sends HTTP requests, it is implemented by *http.Client.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client calls the repository at BaseURL.
+type Client struct {
+	BaseURL    string
+	HTTPClient Doer
+	// Header is added to every request, e.g. User-Agent.
+	Header http.Header
+}
+
+// New returns a client for the repository at baseURL, an empty baseURL uses
+// grafana.com. A nil client uses http.DefaultClient.
+func New(baseURL string, client Doer) *Client {
+	if baseURL == "" {
+		baseURL = DefaultURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTPClient: client, Header: http.Header{}}
+}
+
+// URL joins subPaths onto the repository url.
+func (c *Client) URL(subPaths ...string) string {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return c.BaseURL + "/" + strings.Join(subPaths, "/")
+	}
+	for _, p := range subPaths {
+		u.Path = path.Join(u.Path, p)
+	}
+	return u.String()
+}
+
+// DownloadURL returns the archive url of a plugin version.
+func (c *Client) DownloadURL(pluginId, version string) string {
+	return fmt.Sprintf("%s/%s/versions/%s/download", c.BaseURL, pluginId, version)
+}
+
+// NewRequest returns a GET request for u carrying the client headers.
+func (c *Client) NewRequest(ctx context.Context, u string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range c.Header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	return req.WithContext(ctx), nil
+}
+
+// Get fetches the repository resource at subPaths.
+func (c *Client) Get(ctx context.Context, subPaths ...string) ([]byte, error) {
+	req, err := c.NewRequest(ctx, c.URL(subPaths...))
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadResponse(c.HTTPClient.Do(req))
+}
+
+// ReadResponse reads the body of a repository response, turning 404s into
+// ErrNotFound and other unsuccessful responses into a StatusError.
+func ReadResponse(res *http.Response, err error) ([]byte, error) {
+	if err != nil {
+		return []byte{}, err
+	}
+	defer res.Body.Close()
+
+	if err := checkStatus(res); err != nil {
+		return []byte{}, err
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+func checkStatus(res *http.Response) error {
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if res.StatusCode/100 != 2 {
+		return NewStatusError(res)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package gcom
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBodyExcerpt is the length in characters of the response excerpt kept
+// in a StatusError.
+const MaxBodyExcerpt = 200
+
+// credentialParams are query parameters carrying credentials, e.g. in
+// pre-signed urls. Their values are redacted from errors.
+var credentialParams = regexp.MustCompile(`(?i)(token|key|secret|signature|sig|password|auth|credential)`)
+
+var (
+	markup     = regexp.MustCompile(`<[^>]*>`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// NewStatusError returns the StatusError of an unsuccessful response. It
+// reads the beginning of the body, closing it is left to the caller.
+func NewStatusError(res *http.Response) *StatusError {
+	e := &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+	if res.Request != nil && res.Request.URL != nil {
+		e.URL = RedactURL(res.Request.URL)
+	}
+	if res.Body != nil {
+		// enough for the excerpt, even if it is wrapped in markup
+		data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 16*MaxBodyExcerpt))
+		e.Body = BodyExcerpt(data)
+	}
+	return e
+}
+
+// RedactURL returns u without user info and with the values of credential
+// query parameters replaced.
+func RedactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+
+	if redacted.RawQuery != "" {
+		q := redacted.Query()
+		for name := range q {
+			if credentialParams.MatchString(name) {
+				q.Set(name, "REDACTED")
+			}
+		}
+		redacted.RawQuery = q.Encode()
+	}
+	return redacted.String()
+}
+
+// BodyExcerpt turns a response body into a single line safe to print to a
+// terminal or log: markup and control characters are dropped, whitespace is
+// collapsed and the text is cut to MaxBodyExcerpt characters.
+func BodyExcerpt(data []byte) string {
+	text := markup.ReplaceAllString(string(data), " ")
+	text = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(whitespace.ReplaceAllString(text, " "))
+
+	if utf8.RuneCountInString(text) > MaxBodyExcerpt {
+		text = string([]rune(text)[:MaxBodyExcerpt]) + "..."
+	}
+	return text
+}
@@ -57,6 +57,9 @@ type PluginBase struct {
 
 	GrafanaNetVersion   string `json:"-"`
 	GrafanaNetHasUpdate bool   `json:"-"`
+	// GrafanaNetDeprecation is the notice of a plugin grafana.com flags as
+	// deprecated or end of life.
+	GrafanaNetDeprecation string `json:"-"`
 }
 
 func (pb *PluginBase) registerPlugin(pluginDir string) error {
@@ -13,10 +13,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/registry"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/grafana/grafana/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
@@ -39,7 +41,10 @@ type PluginScanner struct {
 }
 
 type PluginManager struct {
-	log log.Logger
+	Cfg *setting.Cfg `inject:""`
+
+	log         log.Logger
+	updateStore services.UpdateStore
 }
 
 func init() {
@@ -50,6 +55,10 @@ func (pm *PluginManager) Init() error {
 	pm.log = log.New("plugins")
 	plog = log.New("plugins")
 
+	if err := services.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		pm.log.Warn("Failed to register plugin repository metrics", "error", err)
+	}
+
 	DataSources = map[string]*DataSourcePlugin{}
 	StaticRoutes = []*PluginStaticRoute{}
 	Panels = map[string]*PanelPlugin{}
@@ -110,6 +119,7 @@ func (pm *PluginManager) startBackendPlugins(ctx context.Context) error {
 func (pm *PluginManager) Run(ctx context.Context) error {
 	pm.startBackendPlugins(ctx)
 	pm.updateAppDashboards()
+	pm.startUpdateChecker(ctx)
 	pm.checkForUpdates()
 
 	ticker := time.NewTicker(time.Minute * 10)
@@ -1,12 +1,16 @@
 package plugins
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/hashicorp/go-version"
@@ -16,73 +20,139 @@ var (
 	httpClient = http.Client{Timeout: 10 * time.Second}
 )
 
-type GrafanaNetPlugin struct {
-	Slug    string `json:"slug"`
-	Version string `json:"version"`
-}
-
 type GithubLatest struct {
 	Stable  string `json:"stable"`
 	Testing string `json:"testing"`
 }
 
-func getAllExternalPluginSlugs() string {
-	var result []string
-	for _, plug := range Plugins {
-		if plug.IsCorePlugin {
-			continue
-		}
+// updateStatusFile is where the update checker of the server saves what it
+// found, grafana-cli outdated --statusFile reads it from the data path.
+const updateStatusFile = "plugin-updates.json"
 
-		result = append(result, plug.Id)
-	}
+// updateStore applies the results of the update checker to the plugins as
+// soon as they are saved.
+type updateStore struct {
+	services.FileUpdateStore
+}
 
-	return strings.Join(result, ",")
+func (s updateStore) Save(status services.UpdateStatus) error {
+	err := s.FileUpdateStore.Save(status)
+	applyUpdateStatus(status)
+	return err
 }
 
-func (pm *PluginManager) checkForUpdates() {
-	if !setting.CheckForUpdates {
+// serviceLogger logs the messages of the plugin services with the server
+// logger.
+type serviceLogger struct {
+	log log.Logger
+}
+
+func (l serviceLogger) Debugf(format string, args ...interface{}) {
+	l.log.Debug(serviceMessage(format, args))
+}
+
+func (l serviceLogger) Infof(format string, args ...interface{}) {
+	l.log.Info(serviceMessage(format, args))
+}
+
+func (l serviceLogger) Warnf(format string, args ...interface{}) {
+	l.log.Warn(serviceMessage(format, args))
+}
+
+func (l serviceLogger) Errorf(format string, args ...interface{}) {
+	l.log.Error(serviceMessage(format, args))
+}
+
+func serviceMessage(format string, args []interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+}
+
+// startUpdateChecker polls the repository for updates of the plugins in the
+// plugins path in the background.
+func (pm *PluginManager) startUpdateChecker(ctx context.Context) {
+	if !setting.CheckForUpdates || pm.Cfg == nil {
 		return
 	}
 
-	pm.log.Debug("Checking for updates")
+	repoUrl := strings.TrimSuffix(setting.GrafanaComUrl, "/") + "/api/plugins"
+	services.Init(setting.BuildVersion, false, services.WithRepoURL(repoUrl), services.WithLogger(serviceLogger{pm.log}))
+	services.SetPluginStateFile(filepath.Join(pm.Cfg.DataPath, services.DefaultPluginStateFile))
+	pm.updateStore = updateStore{services.FileUpdateStore{Path: filepath.Join(pm.Cfg.DataPath, updateStatusFile)}}
+	checker := services.NewUpdateChecker(repoUrl, setting.PluginsPath, pm.updateStore)
+	checker.BatchCheck = true
+	go func() {
+		if err := checker.Run(ctx); err != nil && err != context.Canceled {
+			pm.log.Error("Plugin update checker stopped", "error", err)
+		}
+	}()
 
-	pluginSlugs := getAllExternalPluginSlugs()
-	resp, err := httpClient.Get("https://grafana.com/api/plugins/versioncheck?slugIn=" + pluginSlugs + "&grafanaVersion=" + setting.BuildVersion)
+	if pm.Cfg.PluginsWatchNotifications {
+		go pm.watchNotifications(ctx, checker)
+	}
+}
 
-	if err != nil {
-		log.Trace("Failed to get plugins repo from grafana.com, %v", err.Error())
+// watchNotifications checks for updates as soon as the repository announces
+// a new version, in between the polls of the update checker.
+func (pm *PluginManager) watchNotifications(ctx context.Context, checker *services.UpdateChecker) {
+	err := services.WatchNotifications(ctx, checker.RepoURL, func(n services.VersionNotification) {
+		pm.log.Debug("New plugin version published", "plugin", n.PluginID, "version", n.Version)
+		checker.CheckOnce(ctx)
+	})
+	if err != nil && err != context.Canceled {
+		pm.log.Error("Watching plugin notifications stopped", "error", err)
+	}
+}
+
+// applyUpdateStatus flags the plugins the update checker found updates for,
+// and those deprecated or end of life, warning about them once.
+func applyUpdateStatus(status services.UpdateStatus) {
+	if status.CheckedAt.IsZero() {
 		return
 	}
 
-	defer resp.Body.Close()
+	updates := map[string]services.Update{}
+	for _, u := range status.Updates {
+		updates[u.PluginID] = u
+	}
+	deprecations := map[string]services.Deprecation{}
+	for _, d := range status.Deprecations {
+		deprecations[d.PluginID] = d
+	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Trace("Update check failed, reading response from grafana.com, %v", err.Error())
-		return
+	for _, plug := range Plugins {
+		if plug.IsCorePlugin {
+			continue
+		}
+
+		u, ok := updates[plug.Id]
+		plug.GrafanaNetHasUpdate = ok
+		if ok {
+			plug.GrafanaNetVersion = u.Version
+		}
+
+		notice := deprecations[plug.Id].Notice
+		if notice != "" && notice != plug.GrafanaNetDeprecation {
+			log.Warn("Plugin %v", notice)
+		}
+		plug.GrafanaNetDeprecation = notice
 	}
+}
 
-	gNetPlugins := []GrafanaNetPlugin{}
-	err = json.Unmarshal(body, &gNetPlugins)
-	if err != nil {
-		log.Trace("Failed to unmarshal plugin repo, reading response from grafana.com, %v", err.Error())
+func (pm *PluginManager) checkForUpdates() {
+	if !setting.CheckForUpdates {
 		return
 	}
 
-	for _, plug := range Plugins {
-		for _, gplug := range gNetPlugins {
-			if gplug.Slug == plug.Id {
-				plug.GrafanaNetVersion = gplug.Version
-
-				plugVersion, err1 := version.NewVersion(plug.Info.Version)
-				gplugVersion, err2 := version.NewVersion(gplug.Version)
-
-				if err1 != nil || err2 != nil {
-					plug.GrafanaNetHasUpdate = plug.Info.Version != plug.GrafanaNetVersion
-				} else {
-					plug.GrafanaNetHasUpdate = plugVersion.LessThan(gplugVersion)
-				}
-			}
+	pm.log.Debug("Checking for updates")
+
+	// the update checker saves results as it finds them, this picks up those
+	// of an earlier run and of checks made with grafana-cli
+	if pm.updateStore != nil {
+		status, err := pm.updateStore.Load()
+		if err != nil {
+			log.Trace("Failed to load the plugin update status, %v", err.Error())
+		} else {
+			applyUpdateStatus(status)
 		}
 	}
 
@@ -93,7 +163,7 @@ func (pm *PluginManager) checkForUpdates() {
 	}
 
 	defer resp2.Body.Close()
-	body, err = ioutil.ReadAll(resp2.Body)
+	body, err := ioutil.ReadAll(resp2.Body)
 	if err != nil {
 		log.Trace("Update check failed, reading response from github.com, %v", err.Error())
 		return
@@ -0,0 +1,147 @@
+package plugins
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/services"
+	"github.com/grafana/grafana/pkg/infra/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	Convey("Plugins are flagged with the updates the update checker found", t, func() {
+		prevPlugins := Plugins
+		defer func() { Plugins = prevPlugins }()
+		Plugins = map[string]*PluginBase{
+			"outdated-panel": {Id: "outdated-panel", Info: PluginInfo{Version: "1.0.0"}, GrafanaNetHasUpdate: false},
+			"current-panel":  {Id: "current-panel", Info: PluginInfo{Version: "1.0.0"}, GrafanaNetHasUpdate: true},
+			"graph":          {Id: "graph", IsCorePlugin: true},
+		}
+
+		dir, err := ioutil.TempDir("", "update-status")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := updateStore{services.FileUpdateStore{Path: filepath.Join(dir, updateStatusFile)}}
+		So(store.Save(services.UpdateStatus{
+			CheckedAt: time.Now(),
+			Updates:   []services.Update{{PluginID: "outdated-panel", InstalledVersion: "1.0.0", Version: "1.1.0"}},
+		}), ShouldBeNil)
+
+		So(Plugins["outdated-panel"].GrafanaNetHasUpdate, ShouldBeTrue)
+		So(Plugins["outdated-panel"].GrafanaNetVersion, ShouldEqual, "1.1.0")
+		So(Plugins["current-panel"].GrafanaNetHasUpdate, ShouldBeFalse)
+
+		Convey("and read back from the store by later checks", func() {
+			Plugins["outdated-panel"].GrafanaNetHasUpdate = false
+
+			status, err := store.Load()
+			So(err, ShouldBeNil)
+			applyUpdateStatus(status)
+			So(Plugins["outdated-panel"].GrafanaNetHasUpdate, ShouldBeTrue)
+		})
+
+		Convey("but not before the first check", func() {
+			applyUpdateStatus(services.UpdateStatus{})
+			So(Plugins["outdated-panel"].GrafanaNetHasUpdate, ShouldBeTrue)
+		})
+
+		Convey("flagging deprecated plugins until they no longer are", func() {
+			deprecated := services.UpdateStatus{
+				CheckedAt:    time.Now(),
+				Deprecations: []services.Deprecation{{PluginID: "current-panel", Notice: "current-panel is deprecated: use new-panel"}},
+			}
+			applyUpdateStatus(deprecated)
+			So(Plugins["current-panel"].GrafanaNetDeprecation, ShouldEqual, "current-panel is deprecated: use new-panel")
+			So(Plugins["outdated-panel"].GrafanaNetDeprecation, ShouldBeEmpty)
+
+			applyUpdateStatus(services.UpdateStatus{CheckedAt: time.Now()})
+			So(Plugins["current-panel"].GrafanaNetDeprecation, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUpdateCheckDuringOutage(t *testing.T) {
+	Convey("Plugins keep the updates found before the repository went down", t, func() {
+		services.SetRetryPolicy(services.RetryPolicy{MaxAttempts: 1})
+		defer services.SetRetryPolicy(services.DefaultRetryPolicy)
+
+		prevPlugins := Plugins
+		defer func() { Plugins = prevPlugins }()
+		Plugins = map[string]*PluginBase{
+			"outdated-panel": {Id: "outdated-panel", Info: PluginInfo{Version: "1.0.0"}},
+		}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "update-status")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := updateStore{services.FileUpdateStore{Path: filepath.Join(dir, updateStatusFile)}}
+		So(store.FileUpdateStore.Save(services.UpdateStatus{
+			CheckedAt: time.Now(),
+			Updates:   []services.Update{{PluginID: "outdated-panel", InstalledVersion: "1.0.0", Version: "1.1.0"}},
+		}), ShouldBeNil)
+
+		status, err := services.NewUpdateChecker(server.URL, dir, store).CheckOnce(context.Background())
+		So(err, ShouldNotBeNil)
+		So(status.Error, ShouldNotBeEmpty)
+		So(Plugins["outdated-panel"].GrafanaNetHasUpdate, ShouldBeTrue)
+		So(Plugins["outdated-panel"].GrafanaNetVersion, ShouldEqual, "1.1.0")
+	})
+}
+
+func TestWatchNotifications(t *testing.T) {
+	Convey("Updates are checked as soon as a new version is announced", t, func() {
+		services.SetRetryPolicy(services.RetryPolicy{MaxAttempts: 1})
+		defer services.SetRetryPolicy(services.DefaultRetryPolicy)
+
+		checked := make(chan struct{}, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/notifications":
+				if r.URL.Query().Get("since") == "" {
+					w.Write([]byte(`{"cursor": "c1", "notifications": [{"pluginId": "outdated-panel", "version": "1.1.0"}]}`))
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			case "/repo":
+				w.Write([]byte(`{"plugins": []}`))
+				select {
+				case checked <- struct{}{}:
+				default:
+				}
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "update-status")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pm := &PluginManager{log: log.New("plugins")}
+		checker := services.NewUpdateChecker(server.URL, dir, updateStore{services.FileUpdateStore{Path: filepath.Join(dir, updateStatusFile)}})
+		go pm.watchNotifications(ctx, checker)
+
+		select {
+		case <-checked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("notification did not trigger an update check")
+		}
+	})
+}
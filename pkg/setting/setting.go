@@ -244,6 +244,7 @@ type Cfg struct {
 	MetricsEndpointBasicAuthPassword string
 	PluginsEnableAlpha               bool
 	PluginsAppsSkipVerifyTLS         bool
+	PluginsWatchNotifications        bool
 	DisableSanitizeHtml              bool
 	EnterpriseLicensePath            string
 
@@ -934,6 +935,7 @@ func (cfg *Cfg) Load(args *CommandLineArgs) error {
 	pluginsSection := iniFile.Section("plugins")
 	cfg.PluginsEnableAlpha = pluginsSection.Key("enable_alpha").MustBool(false)
 	cfg.PluginsAppsSkipVerifyTLS = pluginsSection.Key("app_tls_skip_verify_insecure").MustBool(false)
+	cfg.PluginsWatchNotifications = pluginsSection.Key("watch_notifications").MustBool(false)
 
 	// check old location for this option
 	if panelsSection.Key("enable_alpha").MustBool(false) {